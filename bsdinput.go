@@ -3,40 +3,30 @@
 
 package liner
 
-import "syscall"
-
-const (
-	getTermios = syscall.TIOCGETA
-	setTermios = syscall.TIOCSETA
+import (
+	"reflect"
+	"syscall"
 )
 
-const (
-	// Input flags
-	inpck  = 0x010
-	istrip = 0x020
-	icrnl  = 0x100
-	ixon   = 0x200
-
-	// Output flags
-	opost = 0x1
-
-	// Control flags
-	cs8 = 0x300
-
-	// Local flags
-	isig   = 0x080
-	icanon = 0x100
-	iexten = 0x400
-)
+const cursorColumn = false
 
-type termios struct {
-	Iflag  uint32
-	Oflag  uint32
-	Cflag  uint32
-	Lflag  uint32
-	Cc     [20]byte
-	Ispeed int32
-	Ospeed int32
+// waitReadable waits for input on the file descriptor fd or on wake, and
+// reports whether fd has input and wake has none.
+func waitReadable(fd, wake int) (bool, error) {
+	var set syscall.FdSet
+	// The array is named X__fds_bits on FreeBSD and Bits elsewhere
+	bits := reflect.ValueOf(&set).Elem().Field(0)
+	w := 8 * int(bits.Type().Elem().Size())
+	if fd/w >= bits.Len() || wake/w >= bits.Len() {
+		// Too high to select; let the read wait
+		return true, nil
+	}
+	setBit := func(fd int) {
+		word := bits.Index(fd / w)
+		word.SetUint(word.Uint() | uint64(1)<<uint(fd%w))
+	}
+	setBit(fd)
+	setBit(wake)
+	err := syscall.Select(max(fd, wake)+1, &set, nil, nil, nil)
+	return bits.Index(wake/w).Uint()&(uint64(1)<<uint(wake%w)) == 0, err
 }
-
-const cursorColumn = false
@@ -0,0 +1,103 @@
+package liner
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Confirm displays prompt, followed by [Y/n] or [y/N], and waits for the
+// user to answer yes or no with a single key: y, n, or Enter for def. The
+// answer is displayed after the prompt. Ctrl-C returns ErrPromptAborted,
+// whether or not SetCtrlCAborts has been called, and Ctrl-D returns io.EOF.
+// If line editing is not supported, a line is read, and its first
+// character is the key.
+func (s *State) Confirm(prompt string, def bool) (bool, error) {
+	hint, enter := "[y/N] ", 1
+	if def {
+		hint, enter = "[Y/n] ", 0
+	}
+	names := []string{"yes", "no"}
+	i, err := s.readChoice(prompt+hint, []rune{'y', 'n'}, names, enter)
+	if err != nil {
+		s.record(prompt+hint, "", err)
+		return false, err
+	}
+	s.record(prompt+hint, names[i], nil)
+	return i == 0, nil
+}
+
+// Choose displays prompt, followed by the options, and waits for the user
+// to choose one with a single key, and returns its index. Each option is
+// chosen by its first character, regardless of case, or, if two options
+// begin with the same character, by its number from 1. Enter chooses the
+// first option. Otherwise Choose behaves as Confirm does.
+func (s *State) Choose(prompt string, options []string) (int, error) {
+	if len(options) == 0 {
+		return -1, errors.New("liner: Choose called without options")
+	}
+	keys, ok := initials(options)
+	shown := options
+	if !ok {
+		if len(options) > 9 {
+			return -1, errors.New("liner: too many options to choose by number")
+		}
+		shown = make([]string, len(options))
+		for i, o := range options {
+			keys[i] = rune('1' + i)
+			shown[i] = fmt.Sprintf("%d:%s", i+1, o)
+		}
+	}
+	prompt += "(" + strings.Join(shown, "/") + ") "
+	i, err := s.readChoice(prompt, keys, options, 0)
+	if err != nil {
+		s.record(prompt, "", err)
+		return -1, err
+	}
+	s.record(prompt, options[i], nil)
+	return i, nil
+}
+
+// initials returns the first character of each option, in lower case, and
+// whether they are all different.
+func initials(options []string) ([]rune, bool) {
+	keys := make([]rune, len(options))
+	ok := true
+	for i, o := range options {
+		r, _ := utf8.DecodeRuneInString(o)
+		keys[i] = unicode.ToLower(r)
+		if o == "" || !unicode.IsPrint(r) {
+			ok = false
+		}
+		for _, k := range keys[:i] {
+			if k == keys[i] {
+				ok = false
+			}
+		}
+	}
+	return keys, ok
+}
+
+// chooseUnsupported reads the answer to Confirm or Choose as a line, when
+// keys cannot be read as they are typed. The line may be the key, or the
+// whole name, of the answer; an empty line is the answer enter.
+func (s *State) chooseUnsupported(prompt string, keys []rune, names []string, enter int) (int, error) {
+	for {
+		line, err := s.promptUnsupported(prompt)
+		if err != nil {
+			return -1, err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			return enter, nil
+		}
+		r, size := utf8.DecodeRuneInString(line)
+		for i, k := range keys {
+			if size == len(line) && unicode.ToLower(r) == k || strings.EqualFold(line, names[i]) {
+				return i, nil
+			}
+		}
+	}
+}
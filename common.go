@@ -8,8 +8,16 @@ package liner
 import (
 	"bufio"
 	"container/ring"
+	"context"
 	"errors"
 	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/mattn/go-runewidth"
 )
 
 type commonState struct {
@@ -17,24 +25,133 @@ type commonState struct {
 	outputRedirected  bool
 	inputRedirected   bool
 	history           History
-	completer         WordCompleter
+	completer         FallibleCompleter
+	completerDone     chan struct{} // closed when the last completer call returns
+	message           []string      // shown below the line until the next key
+	maxCandidates     int
+	queryItems        int
+	noPaging          bool
+	matcher           CompletionMatcher
+	insertPrefix      bool
+	wholeWord         bool
+	wordBreaks        string
+	wordClassifier    WordClassifier
+	quotes            string
+	triggers          string
+	completionPreview bool
+	ghost             string
+	ghostFor          string      // the line that ghost was previewed for
+	previewFor        string      // the line to preview, see updatePreview
+	previewBusy       bool        // the completer is called for a preview
+	previewTimer      *time.Timer // starts the preview once typing pauses
+	hinter            Hinter
+	preInputHook      func(*Buffer) error
+	hint              []string
+	highlighter       Highlighter
+	bufStyles         []Style
+	bracketPairs      string
+	bracketStyle      Style
+	completionSpinner bool
+	spinner           string
+	status            string
 	columns           int
+	rows              int
 	killRing          *ring.Ring
+	killRingMax       int
+	killed            bool
+	clipboard         bool
+	mouse             bool
+	noClipboard       bool
 	ctrlCAborts       bool
 	r                 *bufio.Reader
+	out               io.Writer // the terminal's output
+	stream            bool      // the terminal is not the process's, see NewStream
 	tabStyle          TabStyle
 	multiLineMode     bool
+	lineBreaks        bool // the line has held a newline during this Prompt
+	contPrompt        func(row int) string
+	prompt            []rune       // the prompt displayed by Prompt
+	redraw            func() error // displays the line being edited
+	asyncMu           sync.Mutex
+	async             []func()
+	configs           []func() // settings changed during a Prompt, see configure
+	prompting         bool
+	started           chan struct{} // closed when a Prompt starts, see PromptWithTimeout
+	aborted           error         // ends the Prompt, see PromptContext
+	panicked          bool          // the terminal was restored, see restoreOnPanic
+	endKey            Key           // the key that ended the Prompt, see PromptEx
+	historyUsed       bool          // the Prompt looked up the history, see PromptEx
+	passwordMask      rune
+	passwordReveal    bool
+	lastInput         time.Time // when readNext last returned input
+	transcript        io.Writer
+	transcriptTimes   bool
+	rightPrompt       func() string
+	countdown         func() string // shown in place of rightPrompt, see PromptWithTimeout
 	cursorRows        int
 	maxRows           int
+	lineRows          int
+	rowsBelowCursor   int
+	cursorCol         int
+	belowEnd          int
+	menu              []string
 	shouldRestart     ShouldRestart
+	bindings          map[string]binding
+	unread            []interface{}
+	typed             []interface{} // further keys read in one, see win32Key
+	fed               []rune        // keys queued by Feed, guarded by asyncMu
+	shownLine         []shownRow    // the line as displayed, see Screen; guarded by asyncMu
+	shownBelow        []shownRow    // the rows below it, likewise
+	renderer          Renderer      // see SetRenderer
+	drawn             *drawnRow     // the row of the line, see redrawChanged
+	secret            bool          // the line drawn is a password, not kept in drawn
+	batching          bool          // output is held back, see batch
+	burstGap          time.Duration // see SetPasteBurstGap
+	burstKeys         int           // keys read in a row, each within burstGap
+	burstTimer        *time.Timer   // displays the line when a burst ends
+	chordInputs       int
+	lastChord         []Key
+	recording         bool
+	recorded          []interface{}
+	macro             []Key
 	noBeep            bool
+	historyExpand     bool
+	inputFilter       InputFilter
+	acceptHook        AcceptHook
+	autoPairs         map[rune]rune
+	unfiltered        int  // number of unread runes returned by inputFilter
+	filtered          bool // the last rune read was returned by inputFilter
+	escTimeout        time.Duration
+	escWait           bool
+	overwrite         bool
+	eofBehavior       EOFBehavior
+	eofHandler        EOFHandler
+	clearBehavior     ClearBehavior
+	clearHandler      func()
+	overwriteHook     func(overwrite bool)
+	cursorShapes      [2]CursorShape
+	cursorShapesSet   bool
+	cursorShown       CursorShape
+	bracketedPaste    bool
+	pasteNewline      string
+	pasteNewlineSet   bool
+	tabWidth          int
+	scroll            int
+	layoutColumns     int
+	scrollMargin      int
+	controlDisplay    ControlDisplay
+	noColors          bool
+	noLinks           bool
+	colorDepth        ColorDepth
+	quirks            TerminalQuirks
+	surrogate         rune // first half of a character split across key events
 	needRefresh       bool
 }
 
 // TabStyle is used to select how tab completions are displayed.
 type TabStyle int
 
-// Two tab styles are currently available:
+// Three tab styles are currently available:
 //
 // TabCircular cycles through each completion item and displays it directly on
 // the prompt
@@ -42,13 +159,20 @@ type TabStyle int
 // TabPrints prints the list of completion items to the screen after a second
 // tab key is pressed. This behaves similar to GNU readline and BASH (which
 // uses readline)
+//
+// TabMenu displays the completion items in a grid below the prompt. The
+// arrow keys, Tab and Shift-Tab move the selection, which is displayed in the
+// line and highlighted in the grid if colors are enabled, Enter inserts it,
+// and Esc or Ctrl-G restores the original line. This behaves similar to the
+// menu-select widget of zsh
 const (
 	TabCircular TabStyle = iota
 	TabPrints
+	TabMenu
 )
 
 // ErrPromptAborted is returned from Prompt or PasswordPrompt when the user presses Ctrl-C
-// if SetCtrlCAborts(true) has been called on the State
+// if SetCtrlCAborts(true) has been called on the State, or when AbortPrompt is called
 var ErrPromptAborted = errors.New("prompt aborted")
 
 // ErrNotTerminalOutput is returned from Prompt or PasswordPrompt if the
@@ -56,8 +180,9 @@ var ErrPromptAborted = errors.New("prompt aborted")
 var ErrNotTerminalOutput = errors.New("standard output is not a terminal")
 
 // ErrInvalidPrompt is returned from Prompt or PasswordPrompt if the
-// prompt contains any unprintable runes (including substrings that could
-// be colour codes on some platforms).
+// prompt contains any unprintable runes, other than the ANSI SGR escape
+// sequences that select colors and other Styles, and the hyperlinks made by
+// Link.
 var ErrInvalidPrompt = errors.New("invalid prompt")
 
 // ErrInternal is returned when liner experiences an error that it cannot
@@ -65,18 +190,92 @@ var ErrInvalidPrompt = errors.New("invalid prompt")
 // active call to Prompt
 var ErrInternal = errors.New("liner: internal error")
 
-// KillRingMax is the max number of elements to save on the killring.
+// ExitReason is why a prompt ended, as reported by PromptEx.
+type ExitReason int
+
+// The reasons a prompt ends.
+const (
+	ExitAccepted ExitReason = iota // the line was entered
+	ExitEOF                        // end-of-file was signalled; the error is io.EOF
+	ExitAborted                    // Ctrl-C or AbortPrompt; the error is ErrPromptAborted
+	ExitError                      // any other error
+)
+
+// Result describes a line read by PromptEx, and how the prompt ended.
+type Result struct {
+	Line        string // the line entered, as returned by Prompt
+	Reason      ExitReason
+	Key         Key           // the key that ended the prompt: Enter, Ctrl-D or Ctrl-C
+	Duration    time.Duration // how long the prompt was displayed
+	UsedHistory bool          // the history was browsed, searched or copied from
+}
+
+// PromptEx is like Prompt, but also reports how the prompt ended, for
+// programs that log how their prompts are used. The error is the one Prompt
+// returns, and Result.Reason classifies it. Key is the zero Key if no key
+// ended the prompt, as when AbortPrompt is called or line editing is not
+// supported.
+func (s *State) PromptEx(prompt string) (Result, error) {
+	s.endKey = Key{}
+	s.historyUsed = false
+	start := time.Now()
+	line, err := s.Prompt(prompt)
+	r := Result{
+		Line:        line,
+		Key:         s.endKey,
+		Duration:    time.Since(start),
+		UsedHistory: s.historyUsed,
+	}
+	switch err {
+	case nil:
+		r.Reason = ExitAccepted
+	case io.EOF:
+		r.Reason = ExitEOF
+	case ErrPromptAborted:
+		r.Reason = ExitAborted
+	default:
+		r.Reason = ExitError
+	}
+	return r, err
+}
+
+// KillRingMax is the default maximum number of elements to save on the
+// killring.
 const KillRingMax = 60
 
 // HistoryLimit is the maximum number of entries saved in the scrollback history.
 const HistoryLimit = 1000
 
+// MustClose is Close for a defer statement, which cannot check the error
+// Close returns:
+//
+//	line := liner.NewLiner(nil)
+//	defer line.MustClose()
+//
+// It panics if the terminal cannot be returned to its previous mode. If the
+// program is already panicking, as it may be when a highlighter panics during
+// Prompt, MustClose restores the terminal before the panic goes on, so that
+// the panic's message is readable, and does not replace that panic with its
+// own. Close may be called more than once, so MustClose may follow it.
+func (s *State) MustClose() {
+	r := recover()
+	err := s.Close()
+	if r != nil {
+		panic(r)
+	}
+	if err != nil {
+		panic(err)
+	}
+}
+
 func (s *State) getHistoryByPrefix(prefix string) []string {
+	s.historyUsed = true
 	return s.history.FindByPrefix(prefix)
 }
 
 // Returns the history lines matching the intelligent search
 func (s *State) getHistoryByPattern(pattern string) (ph []string, pos []int) {
+	s.historyUsed = true
 	return s.history.FindByPattern(pattern)
 }
 
@@ -92,35 +291,307 @@ type Completer func(line string) []string
 // to the completer which may returns ("Hello, ", {"world", "Word"}, "!!!") to have "Hello, world!!!".
 type WordCompleter func(line string, pos int) (head string, completions []string, tail string)
 
+// Candidate is a completion candidate. Text is inserted into the line when
+// the candidate is chosen; Description, if not empty, is displayed next to
+// the candidate when the list of candidates is printed, and may hold
+// hyperlinks made by Link (to documentation, for example).
+type Candidate struct {
+	Text        string
+	Description string
+	// Style is applied to Text when the candidates are listed by
+	// TabPrints or TabMenu (for example, blue for directories).
+	Style Style
+	// Suffix is inserted after Text when the candidate is chosen, but
+	// is not displayed in listings. For example, a completer may use a
+	// space after a command name, "=" after a long flag, and nothing
+	// after a directory name ending in "/".
+	Suffix string
+	// Group, if not empty, is the heading under which the candidate is
+	// listed by TabPrints and TabMenu (for example, "flags" or "files").
+	// Candidates are listed in the order in which their groups first
+	// appear.
+	Group string
+}
+
+// CandidateCompleter is like WordCompleter, but returns Candidates so that
+// each completion can carry a description (for example, the help text of a
+// command line flag).
+type CandidateCompleter func(line string, pos int) (head string, completions []Candidate, tail string)
+
 // SetCompleter sets the completion function that Liner will call to
-// fetch completion candidates when the user presses tab.
+// fetch completion candidates when the user presses tab. Like RefreshPrompt,
+// SetCompleter and the other functions that set the completion function may
+// be called from another goroutine while Prompt is in progress; the new one
+// is used from the next key pressed. The completion function is called on its
+// own goroutine, but never while an earlier call, such as one abandoned when
+// the user pressed a key, is still running.
 func (s *State) SetCompleter(f Completer) {
 	if f == nil {
-		s.completer = nil
+		s.setCompleter(nil)
 		return
 	}
-	s.completer = func(line string, pos int) (string, []string, string) {
+	s.SetWordCompleter(func(line string, pos int) (string, []string, string) {
 		return "", f(string([]rune(line)[:pos])), string([]rune(line)[pos:])
-	}
+	})
 }
 
 // SetWordCompleter sets the completion function that Liner will call to
 // fetch completion candidates when the user presses tab.
 func (s *State) SetWordCompleter(f WordCompleter) {
-	s.completer = f
+	if f == nil {
+		s.setCompleter(nil)
+		return
+	}
+	s.SetCandidateCompleter(func(line string, pos int) (string, []Candidate, string) {
+		head, list, tail := f(line, pos)
+		return head, textCandidates(list), tail
+	})
+}
+
+// SetCandidateCompleter sets the completion function that Liner will call to
+// fetch completion candidates when the user presses tab. Descriptions are
+// shown when the TabPrints style lists the candidates.
+func (s *State) SetCandidateCompleter(f CandidateCompleter) {
+	if f == nil {
+		s.setCompleter(nil)
+		return
+	}
+	s.setCompleter(func(ctx context.Context, line string, pos int) (string, []Candidate, string, error) {
+		head, c, tail := f(line, pos)
+		return head, c, tail, nil
+	})
+}
+
+// ContextCompleter is like CandidateCompleter, but is passed a context that
+// is cancelled if the user presses a key before the completer returns.
+// Completers that may be slow (for example, because they query a network
+// service) should return promptly once ctx is done.
+type ContextCompleter func(ctx context.Context, line string, pos int) (head string, completions []Candidate, tail string)
+
+// SetContextCompleter sets the completion function that Liner will call to
+// fetch completion candidates when the user presses tab. The prompt remains
+// responsive while f runs: any key pressed before f returns cancels the
+// completion and is processed normally.
+func (s *State) SetContextCompleter(f ContextCompleter) {
+	if f == nil {
+		s.setCompleter(nil)
+		return
+	}
+	s.setCompleter(func(ctx context.Context, line string, pos int) (string, []Candidate, string, error) {
+		head, c, tail := f(ctx, line, pos)
+		return head, c, tail, nil
+	})
+}
+
+// FallibleCompleter is like ContextCompleter, but may fail (for example,
+// because a network service is unavailable). The error message is displayed
+// below the prompt until the next key is pressed. A panic in any completer
+// is displayed in the same way.
+type FallibleCompleter func(ctx context.Context, line string, pos int) (head string, completions []Candidate, tail string, err error)
+
+// SetFallibleCompleter sets the completion function that Liner will call to
+// fetch completion candidates when the user presses tab. See
+// SetContextCompleter.
+func (s *State) SetFallibleCompleter(f FallibleCompleter) {
+	s.setCompleter(f)
+}
+
+// BufferCompleter is like FallibleCompleter, but is passed the line as a
+// Buffer, which it may edit before completing (for example, to expand an
+// alias). The completions replace the text of the edited buffer from start
+// to the cursor. The edits are kept even if there are no completions.
+type BufferCompleter func(ctx context.Context, b *Buffer) (start int, completions []Candidate, err error)
+
+// SetBufferCompleter sets the completion function that Liner will call to
+// fetch completion candidates when the user presses tab. See
+// SetContextCompleter.
+func (s *State) SetBufferCompleter(f BufferCompleter) {
+	if f == nil {
+		s.setCompleter(nil)
+		return
+	}
+	s.setCompleter(func(ctx context.Context, line string, pos int) (string, []Candidate, string, error) {
+		b := &Buffer{line: []rune(line), pos: pos}
+		start, c, err := f(ctx, b)
+		start = b.clamp(start)
+		if start > b.pos {
+			start = b.pos
+		}
+		head, word, tail := string(b.line[:start]), string(b.line[start:b.pos]), string(b.line[b.pos:])
+		if len(c) == 0 && err == nil && (b.Text() != line || b.pos != pos) {
+			// Complete to the edited word, so that the edits are kept
+			c = []Candidate{{Text: word}}
+		}
+		return head, c, tail, err
+	})
+}
+
+// setCompleter sets the completion function, see configure.
+func (s *State) setCompleter(f FallibleCompleter) {
+	s.configure(func() {
+		s.completer = f
+	})
+}
+
+// SetCompletionSpinner sets whether liner displays a "completing…" indicator
+// below the prompt while waiting for a slow completer. The default is false.
+func (s *State) SetCompletionSpinner(show bool) {
+	s.completionSpinner = show
+}
+
+func textCandidates(list []string) []Candidate {
+	if list == nil {
+		return nil
+	}
+	c := make([]Candidate, len(list))
+	for i, text := range list {
+		c[i].Text = text
+	}
+	return c
+}
+
+func candidateTexts(c []Candidate) []string {
+	list := make([]string, len(c))
+	for i := range c {
+		list[i] = c[i].Text
+	}
+	return list
 }
 
 // SetTabCompletionStyle sets the behvavior when the Tab key is pressed
 // for auto-completion.  TabCircular is the default behavior and cycles
 // through the list of candidates at the prompt.  TabPrints will print
 // the available completion candidates to the screen similar to BASH
-// and GNU Readline
+// and GNU Readline.  TabMenu lets the user select a candidate from a
+// menu displayed below the prompt
 func (s *State) SetTabCompletionStyle(tabStyle TabStyle) {
 	s.tabStyle = tabStyle
 }
 
+// SetInsertCommonPrefix sets whether the first Tab inserts the longest
+// common prefix of the completion candidates, when that prefix is longer than
+// the word being completed. The candidates are then only cycled through or
+// shown in a menu when Tab is pressed again, like GNU readline. The default
+// is false.
+func (s *State) SetInsertCommonPrefix(insert bool) {
+	s.insertPrefix = insert
+}
+
+// SetCompleteWholeWord sets whether a completion replaces the whole word
+// under the cursor. When true, the part of the word to the right of the
+// cursor is removed from the tail returned by the completer, so that
+// completing "mid|dleware" (with the cursor at |) does not leave "dleware"
+// after the completion. The default is false.
+func (s *State) SetCompleteWholeWord(whole bool) {
+	s.wholeWord = whole
+}
+
+// SetCompletionMaxCandidates limits the number of completion candidates
+// that liner cycles through or lists to n. When there are more candidates,
+// the listing ends with a count of the omitted candidates. A limit of 0 (the
+// default) means no limit.
+func (s *State) SetCompletionMaxCandidates(n int) {
+	s.maxCandidates = n
+}
+
+// SetCompletionQueryItems sets the number of candidates above which the
+// TabPrints style asks before listing them. A negative n never asks. The
+// default (and the value used for 0) is 100.
+func (s *State) SetCompletionQueryItems(n int) {
+	s.queryItems = n
+}
+
+// SetCompletionTrigger sets runes that invoke completion, as if Tab had
+// been pressed, immediately after they are typed (for example, '/' or '.').
+// This works best with the TabMenu style. Calling SetCompletionTrigger with
+// no arguments removes all triggers.
+func (s *State) SetCompletionTrigger(runes ...rune) {
+	s.triggers = string(runes)
+}
+
+// SetCompletionPreview sets whether the first completion candidate is
+// previewed in dim text after the cursor as the user types. The completer is
+// called for the preview once typing pauses, and not while it is still
+// running for an earlier preview; the line is not held up meanwhile, and a
+// completer that takes more than 100ms gives no preview. Tab, Right or
+// Ctrl-F accept the previewed completion. Previews are only displayed when
+// the cursor is at the end of the line, in single line mode, and when colors
+// are enabled. The default is false.
+func (s *State) SetCompletionPreview(preview bool) {
+	s.completionPreview = preview
+}
+
+// Hinter takes the currently edited line with the cursor position and
+// returns a hint to display below the prompt, and the style in which to
+// display it. The hint may contain newlines to display several rows. An
+// empty hint displays nothing.
+type Hinter func(line string, pos int) (hint string, style Style)
+
+// PasswordValidator takes the input of PasswordPromptWithValidator as it is
+// typed, and returns a message to display below the prompt, such as a
+// strength meter or "must contain a digit", the style in which to display
+// it, and whether the input may be entered. It must not keep password,
+// which liner zeroes once it is done with it.
+type PasswordValidator func(password []rune) (msg string, style Style, ok bool)
+
+// SetHinter sets the function that Liner will call after every change to
+// the line, to display a hint (such as the arguments expected by a command,
+// or a syntax error) below the prompt. The hint is erased when the line is
+// accepted. Like RefreshPrompt, SetHinter may be called from another
+// goroutine while Prompt is in progress.
+func (s *State) SetHinter(f Hinter) {
+	s.configure(func() {
+		s.hinter = f
+	})
+}
+
+// SetPreInputHook sets a function that Liner will call at the start of each
+// prompt, before any keys are read, with the text passed to
+// PromptWithSuggestion (or an empty line). The hook may edit the Buffer to
+// set the initial text and cursor, for example to offer the previous command
+// for editing or to fill in a command template. If it returns an error, the
+// prompt ends and the error is returned. The hook is not called when the
+// terminal is not supported.
+func (s *State) SetPreInputHook(f func(b *Buffer) error) {
+	s.preInputHook = f
+}
+
+// configure makes a change to the settings, such as a new completer. While
+// a Prompt is in progress, which may be on another goroutine, the change is
+// kept for the Prompt to make, before it handles the next key or displays
+// the line again.
+func (s *State) configure(set func()) {
+	s.asyncMu.Lock()
+	defer s.asyncMu.Unlock()
+	if s.prompting {
+		s.configs = append(s.configs, set)
+		return
+	}
+	set()
+}
+
+// applyConfig makes the changes kept by configure.
+func (s *State) applyConfig() {
+	s.asyncMu.Lock()
+	configs := s.configs
+	s.configs = nil
+	s.asyncMu.Unlock()
+	for _, set := range configs {
+		set()
+	}
+}
+
 // ModeApplier is the interface that wraps a representation of the terminal
 // mode. ApplyMode sets the terminal to this mode.
+//
+// The ModeApplier returned by TerminalMode on Unix and Windows is a
+// *term.Mode (see the term package), which also reports and changes the
+// settings of the mode, such as whether it is raw, and compares modes:
+//
+//	m, _ := liner.TerminalMode()
+//	if tm, ok := m.(*term.Mode); ok && tm.Raw() {
+//		// the terminal is already raw
+//	}
 type ModeApplier interface {
 	ApplyMode() error
 }
@@ -129,8 +600,27 @@ type ModeApplier interface {
 // ErrPromptAborted when Ctrl-C is pressed. The default is false (will not
 // return when Ctrl-C is pressed). Unsupported terminals typically raise SIGINT
 // (and Prompt does not return) regardless of the value passed to SetCtrlCAborts.
+// Like RefreshPrompt, SetCtrlCAborts may be called from another goroutine
+// while Prompt is in progress.
 func (s *State) SetCtrlCAborts(aborts bool) {
-	s.ctrlCAborts = aborts
+	s.configure(func() {
+		s.ctrlCAborts = aborts
+	})
+}
+
+// SetPasswordMask sets the rune PasswordPrompt displays for each rune
+// typed, such as '*' or '•', so that the user sees how much has been typed
+// and erased. The default is 0, which displays nothing.
+func (s *State) SetPasswordMask(mask rune) {
+	s.passwordMask = mask
+}
+
+// SetPasswordReveal sets whether Ctrl-T shows the input of PasswordPrompt
+// until it is pressed again. The input is hidden again before
+// PasswordPrompt returns, so it does not stay on the screen. The default
+// is false: Ctrl-T beeps.
+func (s *State) SetPasswordReveal(enabled bool) {
+	s.passwordReveal = enabled
 }
 
 // SetMultiLineMode sets whether line is auto-wrapped. The default is false (single line).
@@ -138,16 +628,472 @@ func (s *State) SetMultiLineMode(mlmode bool) {
 	s.multiLineMode = mlmode
 }
 
+// DefaultScrollMargin is the number of columns of the line kept visible on
+// either side of the cursor when a line too long for the terminal scrolls.
+const DefaultScrollMargin = 8
+
+// SetScrollMargin sets the number of columns of the line kept visible on
+// either side of the cursor when a line too long for the terminal is
+// displayed in single line mode. The line scrolls only when the cursor moves
+// closer than margin to an edge, which is marked with "<" or ">" if more of
+// the line lies beyond it. The margin is reduced on narrow terminals. The
+// default is DefaultScrollMargin.
+func (s *State) SetScrollMargin(margin int) {
+	if margin <= 0 {
+		margin = -1
+	}
+	s.scrollMargin = margin
+}
+
 // ShouldRestart is passed the error generated by readNext and returns true if
 // the the read should be restarted or false if the error should be returned.
 type ShouldRestart func(err error) bool
 
+// SetBracketedPaste sets whether the terminal's bracketed paste mode is
+// enabled during prompts. Text pasted in bracketed paste mode is inserted as
+// a whole rather than read as typed keys, so it is displayed at once, and the
+// control characters and newlines in it are not run as commands: newlines
+// are replaced with the string set by SetPasteNewline and other control
+// characters are dropped. The default is false. Bracketed paste stays off
+// in terminals with QuirkNoBracketedPaste (see SetTerminalQuirks).
+func (s *State) SetBracketedPaste(enabled bool) {
+	s.bracketedPaste = enabled
+}
+
+// SetPasteNewline sets the string that replaces each newline in text pasted
+// in bracketed paste mode. A visible marker such as "⏎" shows where the
+// pasted lines began; the application can then split the line returned by
+// Prompt at the marker, and "\n" keeps the pasted lines as rows of the line
+// (see SetAcceptHook). The default is a space.
+func (s *State) SetPasteNewline(newline string) {
+	s.pasteNewline = newline
+	s.pasteNewlineSet = true
+}
+
+// defaultPasteBurstGap is the default of SetPasteBurstGap.
+const defaultPasteBurstGap = 5 * time.Millisecond
+
+// burstLength is how many keys in a row, each read within the burst gap,
+// make a burst.
+const burstLength = 8
+
+// SetPasteBurstGap sets how soon after the last key each of a run of keys
+// must be read for them to be taken as a burst of pasted text rather than
+// typed: no one types that fast, but a terminal without bracketed paste
+// (see SetBracketedPaste) sends text pasted into it as keys. During a burst
+// the line is displayed only when the input pauses, and neither Tab, which
+// inserts a tab, nor the runes of SetCompletionTrigger complete, so that a
+// long paste is quick and does not run the completer. A burst ends with the
+// prompt, so keys sent quickly for several prompts, as by a script, each
+// start counting anew. The default is 5ms; d == 0 restores it, and d < 0
+// turns the detection of bursts off.
+func (s *State) SetPasteBurstGap(d time.Duration) {
+	s.burstGap = d
+}
+
+func (s *State) pasteBurstGap() time.Duration {
+	if s.burstGap == 0 {
+		return defaultPasteBurstGap
+	}
+	return s.burstGap
+}
+
+// countBurst counts a key read after waiting for it for wait, toward a
+// burst of keys.
+func (s *State) countBurst(wait time.Duration) {
+	if gap := s.pasteBurstGap(); gap > 0 && wait < gap {
+		s.burstKeys++
+	} else {
+		s.burstKeys = 0
+	}
+}
+
+// inBurst returns true if the keys being read arrive in a burst, as pasted
+// text does.
+func (s *State) inBurst() bool {
+	return s.burstKeys >= burstLength
+}
+
+// DefaultTabWidth is the distance in columns between the tab stops at which
+// tabs in the line are displayed.
+const DefaultTabWidth = 8
+
+// SetTabWidth sets the distance in columns between tab stops, counted from
+// the start of the line (or of each row of a line that holds newlines): a tab
+// inserted with Ctrl-V Tab, or in the text given to PromptWithSuggestion, is
+// displayed as spaces up to the next stop. A width of 0 displays each tab as
+// a single "⇥" instead. The default is DefaultTabWidth.
+func (s *State) SetTabWidth(width int) {
+	if width <= 0 {
+		width = -1
+	}
+	s.tabWidth = width
+}
+
+// ControlDisplay selects how control characters in the line are displayed.
+type ControlDisplay int
+
+const (
+	// ControlCaret displays control characters in caret notation, such
+	// as ^A for Ctrl-A and ^? for Delete. Those without one are displayed
+	// as with ControlHex.
+	ControlCaret ControlDisplay = iota
+	// ControlHex displays control characters as hexadecimal escapes,
+	// such as \x01 for Ctrl-A.
+	ControlHex
+)
+
+// SetControlDisplay sets how control characters in the line, other than tabs
+// and newlines, are displayed. They can reach the line through Ctrl-V,
+// PromptWithSuggestion or an input filter. The default is ControlCaret.
+func (s *State) SetControlDisplay(d ControlDisplay) {
+	s.controlDisplay = d
+}
+
+// WordClassifier reports whether r is part of a word.
+type WordClassifier func(r rune) bool
+
+// SetWordClassifier sets the function that decides which runes make up
+// words for the word commands: moving by word (Alt-B, Alt-F, Ctrl-Left,
+// Ctrl-Right), Alt-D, Alt-Backspace, changing the case of words and
+// transposing them. Ctrl-W always erases back to whitespace. The default is
+// nil, under which any rune other than white space is part of a word, except
+// for Alt-Backspace and transposing words, which see letters and digits.
+func (s *State) SetWordClassifier(f WordClassifier) {
+	s.wordClassifier = f
+}
+
+// SetWordCharacters sets the word commands to treat letters, digits and the
+// runes in chars as parts of words; see SetWordClassifier. For example, with
+// "_" words are identifiers, and with "_-./~" whole file paths.
+func (s *State) SetWordCharacters(chars string) {
+	s.wordClassifier = func(r rune) bool {
+		return unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune(chars, r)
+	}
+}
+
 // SetShouldRestart sets the restart function that Liner will call to determine
 // whether to retry the call to, or return the error returned by, readNext.
 func (s *State) SetShouldRestart(f ShouldRestart) {
 	s.shouldRestart = f
 }
 
+// SetOverwriteMode sets whether typed characters replace the characters
+// under the cursor instead of being inserted. The Insert key toggles the
+// mode, which lasts until it is toggled again. While it is on, the cursor
+// is shown as an underline (or a block on Windows) unless SetCursorShapes
+// says otherwise. The default is false.
+func (s *State) SetOverwriteMode(overwrite bool) {
+	s.overwrite = overwrite
+}
+
+// OverwriteMode reports whether overwrite mode is on.
+func (s *State) OverwriteMode() bool {
+	return s.overwrite
+}
+
+// SetOverwriteModeHook sets a function that Liner will call when the Insert
+// key toggles overwrite mode during a prompt, so that the application can
+// show an indicator of the mode.
+func (s *State) SetOverwriteModeHook(f func(overwrite bool)) {
+	s.overwriteHook = f
+}
+
+// CursorShape is a shape of the terminal's cursor. The shapes other than
+// CursorDefault are displayed only by terminals that support them; the
+// Windows console displays a block or its default cursor.
+type CursorShape int
+
+// The cursor shapes available to SetCursorShapes.
+const (
+	CursorDefault CursorShape = iota // the terminal's default shape
+	CursorBlinkingBlock
+	CursorBlock
+	CursorBlinkingUnderline
+	CursorUnderline
+	CursorBlinkingBar
+	CursorBar
+)
+
+// SetCursorShapes sets the shape of the cursor during a Prompt, in insert
+// mode and in overwrite mode. The cursor changes shape when the Prompt
+// starts and when the mode is toggled, and the terminal's default shape is
+// restored when the Prompt ends or runs another program. By default the
+// cursor keeps its shape in insert mode, and is an underline (or a block on
+// Windows) in overwrite mode.
+func (s *State) SetCursorShapes(insert, overwrite CursorShape) {
+	s.cursorShapes = [2]CursorShape{insert, overwrite}
+	s.cursorShapesSet = true
+}
+
+// EOFBehavior selects what Ctrl-D does on an empty line.
+type EOFBehavior int
+
+// The EOF behaviors available to SetEOFBehavior. Ctrl-D on a line that is
+// not empty always deletes the character under the cursor.
+//
+// EOFOnEmptyLine makes Prompt return io.EOF. This is the default.
+//
+// EOFConfirm asks the user to press Ctrl-D again, and returns io.EOF if the
+// next key is Ctrl-D.
+//
+// EOFDeleteChar never returns io.EOF; Ctrl-D only deletes characters.
+//
+// EOFCallback calls the function set by SetEOFHandler.
+const (
+	EOFOnEmptyLine EOFBehavior = iota
+	EOFConfirm
+	EOFDeleteChar
+	EOFCallback
+)
+
+// EOFHandler is called when Ctrl-D is pressed on an empty line under
+// EOFCallback. If eof is true, Prompt returns io.EOF; otherwise the prompt
+// continues, and message (if any) is displayed below it until the next key,
+// such as `Type "exit" to quit`.
+type EOFHandler func() (eof bool, message string)
+
+// SetEOFBehavior sets what Ctrl-D does in Prompt when the line is empty.
+// PasswordPrompt always returns io.EOF.
+func (s *State) SetEOFBehavior(b EOFBehavior) {
+	s.eofBehavior = b
+}
+
+// SetEOFHandler sets the function called under EOFCallback. Without one,
+// Ctrl-D on an empty line behaves as under EOFDeleteChar.
+func (s *State) SetEOFHandler(f EOFHandler) {
+	s.eofHandler = f
+}
+
+// ClearBehavior selects what Ctrl-L does.
+type ClearBehavior int
+
+// The Ctrl-L behaviors available to SetClearBehavior.
+//
+// ClearScreen erases the screen and draws the prompt again at the top. This
+// is the default.
+//
+// ClearRedraw draws the prompt and the line again in place, without erasing
+// the rest of the screen, to repair a display garbled by other output.
+//
+// ClearCallback erases the prompt and the line, calls the function set by
+// SetClearHandler, and draws them again below whatever it printed.
+const (
+	ClearScreen ClearBehavior = iota
+	ClearRedraw
+	ClearCallback
+)
+
+// SetClearBehavior sets what Ctrl-L (or the key bound to
+// ActionClearScreen) does.
+func (s *State) SetClearBehavior(b ClearBehavior) {
+	s.clearBehavior = b
+}
+
+// SetClearHandler sets the function called under ClearCallback, for example
+// to print a summary of the application's state. Without one, Ctrl-L
+// behaves as under ClearRedraw.
+func (s *State) SetClearHandler(f func()) {
+	s.clearHandler = f
+}
+
+// defaultEscapeTimeout is how long Liner waits after Esc for the rest of an
+// escape sequence unless SetEscapeTimeout is called.
+const defaultEscapeTimeout = 50 * time.Millisecond
+
+// SetEscapeTimeout sets how long Liner waits after Esc for the rest of an
+// escape sequence, such as the one sent by an arrow key, or for the key
+// typed with Alt. If nothing arrives in time, the Esc key itself was
+// pressed. Over slow connections a longer timeout keeps sequences and Alt
+// keys from being split. The default is 50ms; d <= 0 restores it. The
+// timeout is not used on Windows, whose console reports keys whole.
+func (s *State) SetEscapeTimeout(d time.Duration) {
+	s.escTimeout = d
+}
+
+func (s *State) escapeTimeout() time.Duration {
+	if s.escTimeout <= 0 {
+		return defaultEscapeTimeout
+	}
+	return s.escTimeout
+}
+
+// SetEscapeSequenceWait sets whether, once the start of an escape sequence
+// (Esc [ or Esc O) has been read, Liner waits for the rest of it however
+// long it takes, rather than only until the escape timeout. The default is
+// false.
+func (s *State) SetEscapeSequenceWait(wait bool) {
+	s.escWait = wait
+}
+
+// InputFilter is called with each character typed to be inserted into the
+// line, and returns the runes to handle in its place. See SetInputFilter.
+type InputFilter func(r rune) []rune
+
+// SetInputFilter sets a function that Liner will call with each character
+// typed to be inserted into the line (but not with the keys of editing
+// commands, nor with pasted text). The runes it returns are handled as if
+// they had been typed instead, without being passed to the filter again, so
+// they may include editing keys: returning "()\x02" (ending in Ctrl-B)
+// inserts a pair of brackets with the cursor between them, and a filter
+// that remembers the last few characters typed can expand "teh " to "the "
+// by returning three Backspaces ("\x7f") before the replacement. Returning
+// r alone inserts it as usual; returning nothing rejects it with a beep.
+func (s *State) SetInputFilter(f InputFilter) {
+	s.inputFilter = f
+}
+
+// AcceptResult is returned by an AcceptHook to decide what Enter does.
+type AcceptResult int
+
+const (
+	// Accept ends the Prompt, which returns the line.
+	Accept AcceptResult = iota
+	// Continue inserts a newline at the cursor and keeps editing.
+	Continue
+	// Reject keeps editing the line unchanged, and beeps.
+	Reject
+)
+
+// AcceptHook is called with the line when Enter is pressed, and returns
+// what to do with it. Any message is displayed below the line until the
+// next key is pressed. See SetAcceptHook.
+type AcceptHook func(line string) (result AcceptResult, message string)
+
+// SetAcceptHook sets a function that Liner will call when Enter is pressed,
+// to decide whether the line is complete. Returning Continue (for example
+// for an unterminated SQL statement, or a line with an unclosed brace)
+// inserts a newline and lets the user edit the line across several rows;
+// returning Reject (for a line that can never be valid) beeps and explains
+// why in the message. Ctrl-J (newline) is checked the same way as Enter.
+//
+// A line containing newlines is displayed as in multi-line mode, with each
+// row after a newline following a continuation prompt (see
+// SetContinuationPrompt). Up and Down
+// move between its rows before moving through the history, and Home and End
+// move to the start and end of the row.
+func (s *State) SetAcceptHook(f AcceptHook) {
+	s.acceptHook = f
+}
+
+// DefaultContinuationPrompt is displayed before each row of the line after
+// a newline, unless SetContinuationPrompt changes it.
+const DefaultContinuationPrompt = "... "
+
+// SetContinuationPrompt sets the prompt displayed before each row of the
+// line after a newline. A prompt as wide as the Prompt's own (such as
+// "  -> " after "sql> ") lines up the rows; an empty prompt leaves rows that
+// can be copied from the terminal as they were typed. Like the Prompt's
+// prompt, it may select colors with SGR escape sequences; a prompt holding
+// other unprintable runes is not displayed.
+func (s *State) SetContinuationPrompt(prompt string) {
+	s.SetContinuationPromptFunc(func(row int) string { return prompt })
+}
+
+// SetContinuationPromptFunc sets a function that returns the prompt
+// displayed before each row of the line after a newline, numbered from 1
+// for the row after the first newline; for example, to number the rows.
+// See SetContinuationPrompt. A nil f restores DefaultContinuationPrompt.
+// Like RefreshPrompt, both may be called from another goroutine while
+// Prompt is in progress.
+func (s *State) SetContinuationPromptFunc(f func(row int) string) {
+	s.configure(func() {
+		s.contPrompt = f
+	})
+}
+
+// continuationPrompt returns the prompt displayed before the given row.
+func (s *State) continuationPrompt(row int) []rune {
+	if s.contPrompt == nil {
+		return []rune(DefaultContinuationPrompt)
+	}
+	prompt, err := s.checkPrompt(s.contPrompt(row))
+	if err != nil {
+		return nil
+	}
+	return []rune(prompt)
+}
+
+// SetRightPrompt sets a function that returns a prompt to display flush
+// right on the first row of the line, such as a clock, the current git
+// branch or the status of the last command. It is called each time the line
+// is displayed, and its prompt is hidden while the line is too long to leave
+// room for it. It may select colors as the continuation prompt may (see
+// SetContinuationPrompt). A nil f removes the right prompt. Like
+// RefreshPrompt, SetRightPrompt may be called from another goroutine while
+// Prompt is in progress.
+func (s *State) SetRightPrompt(f func() string) {
+	s.configure(func() {
+		s.rightPrompt = f
+	})
+}
+
+// AmbiguousWidth selects how wide liner considers characters whose width
+// depends on the terminal, such as "○" and "…": East Asian terminals display
+// them in two columns, and others in one.
+type AmbiguousWidth int
+
+const (
+	// AmbiguousLocale makes them wide in Chinese, Japanese and Korean
+	// locales (or if $RUNEWIDTH_EASTASIAN is 1), and narrow otherwise.
+	AmbiguousLocale AmbiguousWidth = iota
+	// AmbiguousNarrow makes them one column wide.
+	AmbiguousNarrow
+	// AmbiguousWide makes them two columns wide.
+	AmbiguousWide
+)
+
+// SetAmbiguousWidth sets how wide characters of ambiguous width are, to
+// match the terminal; if it is wrong, the cursor is misplaced on lines (and
+// prompts) containing them. The default is AmbiguousLocale. The setting is
+// global, applying to every State, as a program has one terminal to match;
+// it may be called at any time, and takes effect from the next refresh.
+func SetAmbiguousWidth(w AmbiguousWidth) {
+	c := runewidth.NewCondition()
+	switch w {
+	case AmbiguousNarrow:
+		c.EastAsianWidth = false
+	case AmbiguousWide:
+		c.EastAsianWidth = true
+	default:
+		c.EastAsianWidth = runewidth.EastAsianWidth
+	}
+	widthCondition.Store(c)
+}
+
+// DefaultAutoPairs are the brackets and quotes most languages pair.
+const DefaultAutoPairs = "()[]{}\"\""
+
+// SetAutoPairs sets the characters that Prompt closes as they are typed,
+// given as a sequence of opening and closing characters, such as
+// DefaultAutoPairs. Typing an opening character also inserts its closing
+// character after the cursor; typing the closing character just before an
+// auto-inserted one moves over it, and Backspace between an empty pair
+// deletes both, until the cursor is moved some other way. Quotes (pairs
+// whose opening and closing characters are the same) are not paired after
+// a letter or digit, so that "don't" can be typed with single quotes in the
+// set. The default is "", which disables pairing.
+func (s *State) SetAutoPairs(pairs string) {
+	s.autoPairs = nil
+	runes := []rune(pairs)
+	for i := 0; i+1 < len(runes); i += 2 {
+		if s.autoPairs == nil {
+			s.autoPairs = make(map[rune]rune)
+		}
+		s.autoPairs[runes[i]] = runes[i+1]
+	}
+}
+
+// autoPairCloses reports whether r is the closing character of a pair.
+func (s *State) autoPairCloses(r rune) bool {
+	for _, c := range s.autoPairs {
+		if c == r {
+			return true
+		}
+	}
+	return false
+}
+
 // SetBeep sets whether liner should beep the terminal at various times (output
 // ASCII BEL, 0x07). Default is true (will beep).
 func (s *State) SetBeep(beep bool) {
@@ -155,12 +1101,198 @@ func (s *State) SetBeep(beep bool) {
 }
 
 func (s *State) promptUnsupported(p string) (string, error) {
-	if !s.inputRedirected || !s.terminalSupported {
-		fmt.Print(p)
+	if !s.terminalSupported {
+		p = stripEscapes(p)
+	}
+	for {
+		if !s.inputRedirected || !s.terminalSupported {
+			fmt.Fprint(s.out, p)
+		}
+		linebuf, _, err := s.r.ReadLine()
+		if err != nil {
+			return "", err
+		}
+		line := string(linebuf)
+		if s.terminalSupported || s.inputRedirected || !s.historyExpand || s.history == nil {
+			return line, nil
+		}
+		// Someone is typing without line editing, so the history is
+		// reached with commands
+		expanded, err := expandHistory(s.history, line)
+		if err != nil {
+			fmt.Fprintln(s.out, err)
+			continue
+		}
+		if expanded != line {
+			// Show what the command stood for, as shells do
+			fmt.Fprintln(s.out, expanded)
+			s.historyUsed = true
+		}
+		return expanded, nil
 	}
-	linebuf, _, err := s.r.ReadLine()
+}
+
+// SetTranscript sets a writer to which liner records each prompt and the
+// line entered at it, as they appear on the screen, for a session
+// transcript or the audit log of an operator's console. A prompt that
+// ends without a line is followed by ^C if it was aborted, ^D at
+// end-of-file, or the error in brackets. Passwords are not recorded; nor
+// are the keys read by ReadKey. Errors writing to w are ignored. The
+// default is nil, which records nothing.
+func (s *State) SetTranscript(w io.Writer) {
+	s.transcript = w
+}
+
+// SetTranscriptTimestamps sets whether each entry of the transcript (see
+// SetTranscript) begins with the time it was entered, in RFC 3339 format.
+// The default is false.
+func (s *State) SetTranscriptTimestamps(enabled bool) {
+	s.transcriptTimes = enabled
+}
+
+// record writes prompt, and the line entered at it or why none was, to
+// the transcript.
+func (s *State) record(prompt, line string, err error) {
+	if s.transcript == nil {
+		return
+	}
+	var b strings.Builder
+	if s.transcriptTimes {
+		b.WriteString(time.Now().Format(time.RFC3339))
+		b.WriteByte(' ')
+	}
+	b.WriteString(stripEscapes(prompt))
+	switch err {
+	case nil:
+		b.WriteString(line)
+	case ErrPromptAborted:
+		b.WriteString("^C")
+	case io.EOF:
+		b.WriteString("^D")
+	default:
+		b.WriteString("[" + err.Error() + "]")
+	}
+	b.WriteByte('\n')
+	io.WriteString(s.transcript, b.String())
+}
+
+// readKeyUnsupported reads the next character for ReadKey, when keys
+// cannot be read as they are typed.
+func (s *State) readKeyUnsupported() (Key, error) {
+	r, _, err := s.r.ReadRune()
 	if err != nil {
-		return "", err
+		return Key{}, err
+	}
+	return Key{Rune: r}, nil
+}
+
+// SetHistoryExpansion sets whether history commands are expanded when the
+// terminal does not support line editing, such as TERM=dumb or an Emacs
+// shell buffer. There, a line typed as "!!" is returned as the last history
+// entry, "!n" as entry n, "!-n" as the nth last, "!text" as the last entry
+// starting with text, and "!?text" as the last entry containing text. The
+// default is false, as a line may well start with "!".
+func (s *State) SetHistoryExpansion(enabled bool) {
+	s.historyExpand = enabled
+}
+
+// KillRing returns the text saved on the kill ring, starting with the entry
+// Ctrl-Y inserts, followed by the entries Alt-Y cycles through after it.
+func (s *State) KillRing() []string {
+	if s.killRing == nil {
+		return nil
+	}
+	kills := make([]string, s.killRing.Len())
+	r := s.killRing
+	for i := range kills {
+		kills[i] = string(r.Value.([]rune))
+		r = r.Prev()
+	}
+	return kills
+}
+
+// PushKillRing adds text to the kill ring, as if it had been killed, so that
+// Ctrl-Y inserts it.
+func (s *State) PushKillRing(text string) {
+	s.addToKillRing([]rune(text), 0)
+}
+
+// ClearKillRing removes all text from the kill ring.
+func (s *State) ClearKillRing() {
+	s.killRing = nil
+}
+
+// SetKillRingMax sets the maximum number of entries on the kill ring,
+// discarding the oldest entries if there are more. The default (and the
+// value used for n <= 0) is KillRingMax.
+func (s *State) SetKillRingMax(n int) {
+	s.killRingMax = n
+	if s.killRing != nil && s.killRing.Len() > s.killRingLimit() {
+		// The entries Alt-Y reaches last follow the current one
+		s.killRing.Unlink(s.killRing.Len() - s.killRingLimit())
+	}
+}
+
+func (s *State) killRingLimit() int {
+	if s.killRingMax <= 0 {
+		return KillRingMax
 	}
-	return string(linebuf), nil
+	return s.killRingMax
+}
+
+// addToKillRing adds some text to the kill ring. If mode is 0 it adds it to a
+// new node in the end of the kill ring, and move the current pointer to the new
+// node. If mode is 1 or 2 it appends or prepends the text to the current entry
+// of the killRing.
+func (s *State) addToKillRing(text []rune, mode int) {
+	// Don't use the same underlying array as text
+	killLine := make([]rune, len(text))
+	copy(killLine, text)
+
+	// Point killRing to a newNode, procedure depends on the killring state and
+	// append mode.
+	if mode == 0 { // Add new node to killRing
+		if s.killRing == nil { // if killring is empty, create a new one
+			s.killRing = ring.New(1)
+		} else if s.killRing.Len() >= s.killRingLimit() { // if killring is "full"
+			s.killRing = s.killRing.Next()
+		} else { // Normal case
+			s.killRing.Link(ring.New(1))
+			s.killRing = s.killRing.Next()
+		}
+	} else {
+		if s.killRing == nil { // if killring is empty, create a new one
+			s.killRing = ring.New(1)
+			s.killRing.Value = []rune{}
+		}
+		if mode == 1 { // Append to last entry
+			killLine = append(s.killRing.Value.([]rune), killLine...)
+		} else if mode == 2 { // Prepend to last entry
+			killLine = append(killLine, s.killRing.Value.([]rune)...)
+		}
+	}
+
+	// Save text in the current killring node
+	s.killRing.Value = killLine
+	s.killed = true
+}
+
+// SetMouse sets whether liner reads the mouse during prompts, on terminals
+// that support it: a click in the line moves the cursor there, and in a
+// TabMenu a click selects a candidate (a second click accepts it) and the
+// wheel moves the selection. While liner reads the mouse, the terminal does
+// not select text as usual unless Shift is held. The default is false.
+func (s *State) SetMouse(enabled bool) {
+	s.mouse = enabled
+}
+
+// SetClipboard sets whether the text killed (or copied with
+// ActionCopyRegionAsKill) is also copied to the system clipboard, and
+// ActionPasteClipboard reads from it, through the terminal with OSC 52
+// escape sequences. This works over SSH, and in tmux if its set-clipboard
+// option is on, but not in the Windows console. Many terminals refuse to
+// let the clipboard be read; ActionPasteClipboard then yanks the last kill
+// instead. The default is false.
+func (s *State) SetClipboard(enabled bool) {
+	s.clipboard = enabled
 }
@@ -0,0 +1,62 @@
+//go:build windows || linux || darwin || openbsd || freebsd || netbsd
+// +build windows linux darwin openbsd freebsd netbsd
+
+package liner
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// editor returns the command that runs the user's editor.
+func editor() []string {
+	for _, env := range []string{"VISUAL", "EDITOR"} {
+		if args := strings.Fields(os.Getenv(env)); len(args) > 0 {
+			return args
+		}
+	}
+	return []string{defaultEditor}
+}
+
+// editCommandLine runs the user's editor on line, and returns the edited
+// text as it would be pasted, without the final newline. If the editor
+// fails, the error is returned and line is left unchanged.
+func (s *State) editCommandLine(line []rune) ([]rune, error) {
+	if s.stream {
+		// The editor would run on the process's terminal instead
+		return nil, errors.New("no editor for this terminal")
+	}
+	f, err := os.CreateTemp("", "liner-*.txt")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(string(line) + "\n")
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	args := append(editor(), f.Name())
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = s.in, s.outFile, os.Stderr
+	s.stopReader()
+	s.pauseTerminal()
+	err = cmd.Run()
+	s.resumeTerminal()
+	s.restartPrompt()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", args[0], err)
+	}
+
+	text, err := os.ReadFile(f.Name())
+	if err != nil {
+		return nil, err
+	}
+	return s.pastedText([]rune(strings.TrimRight(string(text), "\r\n"))), nil
+}
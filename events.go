@@ -0,0 +1,314 @@
+//go:build windows || linux || darwin || openbsd || freebsd || netbsd
+// +build windows linux darwin openbsd freebsd netbsd
+
+package liner
+
+import (
+	"errors"
+	"sync"
+)
+
+// EventKind identifies the kind of an Event.
+type EventKind int
+
+// The kinds of Event.
+const (
+	KeyEvent    EventKind = iota + 1 // a key was pressed
+	PasteEvent                       // text was pasted
+	ResizeEvent                      // the terminal changed size
+	ErrorEvent                       // the terminal could not be read
+	OtherEvent                       // any other input, such as a mouse click
+)
+
+// Event is input from the terminal, as delivered by Events.
+type Event struct {
+	Kind   EventKind
+	Key    Key    // the key pressed, for a KeyEvent
+	Text   string // the text pasted, for a PasteEvent
+	Width  int    // the terminal's new size, for a ResizeEvent
+	Height int
+	Err    error // why the terminal could not be read, for an ErrorEvent
+
+	input interface{} // what readNext returns, for an OtherEvent
+}
+
+// eventState is the state of a State once Events has been called.
+type eventState struct {
+	c        chan Event
+	wake     chan struct{} // signalled by runAsync
+	quit     chan struct{} // closed by Close
+	quitOnce sync.Once
+	queued   []interface{} // inputs of the last event, not yet read
+	step     *stepper
+}
+
+// stepper passes events from Step to the Prompt started by BeginPrompt,
+// which runs in a goroutine of its own.
+type stepper struct {
+	in    chan Event
+	ready chan struct{} // the Prompt is waiting for the next event
+	done  chan struct{} // closed when the Prompt returns
+	fed   bool          // an event was passed since ready was last sent
+	line  string
+	err   error
+	panic interface{} // what a function the Prompt called panicked with
+}
+
+// inputs returns the inputs readNext returns for ev.
+func (ev Event) inputs() []interface{} {
+	switch ev.Kind {
+	case KeyEvent:
+		return keyInputs([]Key{ev.Key})
+	case PasteEvent:
+		inputs := []interface{}{pasteStart}
+		for _, r := range ev.Text {
+			inputs = append(inputs, r)
+		}
+		return append(inputs, pasteEnd)
+	case ResizeEvent:
+		return []interface{}{winch}
+	case OtherEvent:
+		if ev.input != nil {
+			return []interface{}{ev.input}
+		}
+	}
+	return nil
+}
+
+// Events returns a channel that delivers the input from the terminal, for a
+// program that waits in a select loop of its own, such as a network REPL,
+// rather than in a goroutine blocked in Prompt. The program starts a prompt
+// with BeginPrompt and passes each Event it receives to Step, which edits
+// the line; it may also act on events itself, such as a key it binds to a
+// command of its own. The channel is closed after an ErrorEvent, or when the
+// State is closed. Events returns nil if line editing is not supported or
+// input is redirected.
+//
+// Once Events has been called, Prompt and PasswordPrompt also read their
+// input from the channel, so Events must not be called while a Prompt is
+// active. Every call returns the same channel.
+func (s *State) Events() <-chan Event {
+	if !s.terminalSupported || s.inputRedirected {
+		return nil
+	}
+	s.asyncMu.Lock()
+	defer s.asyncMu.Unlock()
+	if s.events == nil {
+		s.events = &eventState{
+			c:    make(chan Event),
+			wake: make(chan struct{}, 1),
+			quit: make(chan struct{}),
+		}
+		go s.readEvents(s.events)
+	}
+	return s.events.c
+}
+
+// readEvents reads the terminal and sends what is read to e.c, until the
+// terminal cannot be read or e.quit is closed.
+func (s *State) readEvents(e *eventState) {
+	defer close(e.c)
+	read := func() (interface{}, error) {
+		// The rune reader stops after a line ending
+		s.startReader()
+		return s.readTerminal()
+	}
+	var next interface{} // read after an Esc that did not begin an Alt key
+	for {
+		v := next
+		next = nil
+		if v == nil {
+			var err error
+			if v, err = read(); err != nil {
+				e.send(Event{Kind: ErrorEvent, Err: err})
+				return
+			}
+		}
+		var ev Event
+		switch {
+		case v == wake:
+			select {
+			case <-e.quit:
+				s.haltReader()
+				return
+			default:
+			}
+			continue
+		case v == winch:
+			ev.Kind = ResizeEvent
+			ev.Width, ev.Height, _ = s.terminalSize()
+		case v == pasteStart:
+			var text []rune
+			for {
+				n, err := read()
+				if err != nil {
+					e.send(Event{Kind: ErrorEvent, Err: err})
+					return
+				}
+				if n == pasteEnd {
+					break
+				}
+				if r, ok := n.(rune); ok {
+					text = append(text, r)
+				}
+			}
+			ev = Event{Kind: PasteEvent, Text: string(text)}
+		case v == rune(esc) && s.terminalWaiting():
+			// Alt and a key arrive as Esc followed by the key
+			n, err := read()
+			if err != nil {
+				e.send(Event{Kind: ErrorEvent, Err: err})
+				return
+			}
+			if k, ok := toKey(n); ok && k.Mod&ModAlt == 0 {
+				k.Mod |= ModAlt
+				ev = Event{Kind: KeyEvent, Key: k}
+				break
+			}
+			next = n
+			ev = Event{Kind: KeyEvent, Key: Key{Rune: esc}}
+		default:
+			if k, ok := toKey(v); ok {
+				ev = Event{Kind: KeyEvent, Key: k}
+			} else {
+				ev = Event{Kind: OtherEvent, input: v}
+			}
+		}
+		if !e.send(ev) {
+			s.haltReader()
+			return
+		}
+	}
+}
+
+// send sends ev to the channel returned by Events, and returns false if
+// the State was closed first.
+func (e *eventState) send(ev Event) bool {
+	select {
+	case e.c <- ev:
+		return true
+	case <-e.quit:
+		return false
+	}
+}
+
+// stopEvents ends the goroutine started by Events, if it was.
+func (s *State) stopEvents() {
+	s.asyncMu.Lock()
+	e := s.events
+	s.asyncMu.Unlock()
+	if e == nil {
+		return
+	}
+	e.quitOnce.Do(func() { close(e.quit) })
+	s.wakeReader()
+}
+
+// readEvent returns the next input from the events given to the Prompt,
+// once Events has been called: by Step if the Prompt was started by
+// BeginPrompt, and otherwise from the channel Events returns.
+func (s *State) readEvent() (interface{}, error) {
+	e := s.events
+	for len(e.queued) == 0 {
+		var ev Event
+		var ok bool
+		if st := e.step; st != nil {
+			if st.fed {
+				st.fed = false
+				st.ready <- struct{}{}
+			}
+			select {
+			case ev = <-st.in:
+				st.fed, ok = true, true
+			case <-e.wake:
+				return wake, nil
+			}
+		} else {
+			select {
+			case ev, ok = <-e.c:
+			case <-e.wake:
+				return wake, nil
+			}
+		}
+		if !ok {
+			return nil, ErrInternal
+		}
+		switch ev.Kind {
+		case ErrorEvent:
+			return nil, ev.Err
+		case ResizeEvent:
+			s.columns, s.rows = ev.Width, ev.Height
+		}
+		e.queued = ev.inputs()
+	}
+	v := e.queued[0]
+	e.queued = e.queued[1:]
+	return v, nil
+}
+
+// BeginPrompt displays prompt and starts a Prompt that reads its input from
+// Step rather than from the terminal, for use with Events. It returns an
+// error if Prompt would have returned one at once, such as
+// ErrInvalidPrompt, or if line editing is not supported.
+func (s *State) BeginPrompt(prompt string) error {
+	if s.Events() == nil {
+		return errors.New("liner: function not supported in this terminal")
+	}
+	e := s.events
+	if e.step != nil {
+		return errors.New("liner: BeginPrompt called during a prompt")
+	}
+	st := &stepper{
+		in:    make(chan Event),
+		ready: make(chan struct{}),
+		done:  make(chan struct{}),
+		fed:   true,
+	}
+	e.step = st
+	go func() {
+		defer close(st.done)
+		// A panic goes on in the goroutine calling Step
+		defer func() { st.panic = recover() }()
+		st.line, st.err = s.Prompt(prompt)
+	}()
+	if _, done, err := s.waitStep(); done {
+		return err
+	}
+	return nil
+}
+
+// Step passes ev, usually received from the channel returned by Events, to
+// the prompt started by BeginPrompt, and waits until it has been acted on.
+// Functions the prompt calls, such as completers, run while Step waits; if
+// one panics, so does Step, once the terminal is restored.
+// Once the prompt has ended, Step returns done and what Prompt would have
+// returned: the line entered, or an error such as io.EOF or
+// ErrPromptAborted. If the prompt had already ended, as it does when
+// AbortPrompt is called, ev is not used.
+func (s *State) Step(ev Event) (line string, done bool, err error) {
+	if s.events == nil || s.events.step == nil {
+		return "", true, errors.New("liner: Step called without BeginPrompt")
+	}
+	st := s.events.step
+	select {
+	case st.in <- ev:
+	case <-st.done:
+	}
+	return s.waitStep()
+}
+
+// waitStep waits until the prompt started by BeginPrompt wants another
+// event or has ended.
+func (s *State) waitStep() (line string, done bool, err error) {
+	st := s.events.step
+	select {
+	case <-st.ready:
+		return "", false, nil
+	case <-st.done:
+		s.events.step = nil
+		if st.panic != nil {
+			panic(st.panic)
+		}
+		return st.line, true, st.err
+	}
+}
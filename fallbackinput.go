@@ -5,8 +5,12 @@ package liner
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"errors"
+	"io"
 	"os"
+	"time"
 )
 
 // State represents an open terminal
@@ -17,7 +21,59 @@ type State struct {
 // Prompt displays p, and then waits for user input. Prompt does not support
 // line editing on this operating system.
 func (s *State) Prompt(p string) (string, error) {
-	return s.promptUnsupported(p)
+	line, err := s.promptUnsupported(p)
+	s.record(p, line, err)
+	return line, err
+}
+
+// promptChecked is Prompt: the line is not checked as it is typed on this
+// operating system.
+func (s *State) promptChecked(p string, checks lineChecks) (string, error) {
+	return s.Prompt(p)
+}
+
+// PromptContext is like Prompt, but returns ctx.Err() without prompting if
+// ctx is already done. Input cannot be abandoned on this operating system.
+func (s *State) PromptContext(ctx context.Context, p string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	return s.Prompt(p)
+}
+
+// ReadKey returns the next character read. On this operating system, the
+// terminal only sends it once Enter is pressed.
+func (s *State) ReadKey() (Key, error) {
+	return s.readKeyUnsupported()
+}
+
+// ReadKeyContext is like ReadKey, but returns ctx.Err() without reading if
+// ctx is already done.
+func (s *State) ReadKeyContext(ctx context.Context) (Key, error) {
+	if err := ctx.Err(); err != nil {
+		return Key{}, err
+	}
+	return s.readKeyUnsupported()
+}
+
+// PromptWithTimeout is like Prompt, but returns def for an empty line.
+// The timeout is not supported on this operating system.
+func (s *State) PromptWithTimeout(p, def string, d time.Duration) (string, error) {
+	line, err := s.Prompt(p)
+	if err == nil && line == "" {
+		line = def
+	}
+	return line, err
+}
+
+// Feed queues keys to be read as the start of the input, before the
+// terminal's. It must not be called during a Prompt.
+func (s *State) Feed(keys []byte) {
+	s.r = bufio.NewReader(io.MultiReader(bytes.NewReader(keys), s.r))
+}
+
+// AbortPrompt has no effect on this operating system.
+func (s *State) AbortPrompt() {
 }
 
 // PasswordPrompt is not supported in this OS.
@@ -25,6 +81,28 @@ func (s *State) PasswordPrompt(p string) (string, error) {
 	return "", errors.New("liner: function not supported in this terminal")
 }
 
+// PasswordPromptWithValidator is not supported in this OS.
+func (s *State) PasswordPromptWithValidator(p string, validate PasswordValidator) (string, error) {
+	return "", errors.New("liner: function not supported in this terminal")
+}
+
+// PasswordPromptBytes is not supported in this OS.
+func (s *State) PasswordPromptBytes(p string) ([]byte, error) {
+	return nil, errors.New("liner: function not supported in this terminal")
+}
+
+// readChoice reads the answer to Confirm or Choose as a line: this OS
+// cannot read keys as they are typed.
+func (s *State) readChoice(prompt string, keys []rune, names []string, enter int) (int, error) {
+	return s.chooseUnsupported(prompt, keys, names, enter)
+}
+
+// readMasked reads the input of PromptMasked as a line: this OS cannot
+// read keys as they are typed.
+func (s *State) readMasked(prompt string, mask []rune, valid func(r rune) bool) (string, error) {
+	return s.maskedUnsupported(prompt, mask, valid)
+}
+
 // NewLiner initializes a new *State
 //
 // Note that this operating system uses a fallback mode without line
@@ -32,9 +110,30 @@ func (s *State) PasswordPrompt(p string) (string, error) {
 func NewLiner() *State {
 	var s State
 	s.r = bufio.NewReader(os.Stdin)
+	s.out = os.Stdout
 	return &s
 }
 
+// NewLinerFiles initializes a new *State that reads from in and writes to
+// out, rather than standard input and output. Close does not close in or
+// out.
+func NewLinerFiles(in, out *os.File, h History) *State {
+	var s State
+	if h == nil {
+		h = &sliceHistory{}
+	}
+	s.history = h
+	s.r = bufio.NewReader(in)
+	s.out = out
+	return &s
+}
+
+// Size returns 0, 0, since the size of the terminal is not known on this
+// platform.
+func (s *State) Size() (columns, rows int) {
+	return 0, 0
+}
+
 // Close returns the terminal to its previous mode
 func (s *State) Close() error {
 	return nil
@@ -0,0 +1,112 @@
+package liner
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// FilePathOptions configures CompleteFilePath and FilePathCompleter.
+type FilePathOptions struct {
+	// DirsOnly restricts completions to directories.
+	DirsOnly bool
+	// Hidden includes files whose names begin with a '.', even when the
+	// typed name does not.
+	Hidden bool
+	// ExpandHome expands a leading "~" to the user's home directory.
+	ExpandHome bool
+	// Quote escapes spaces and backslashes in completions with a
+	// backslash, and unescapes them in the typed path.
+	Quote bool
+}
+
+// CompleteFilePath returns the file paths that complete path. Directories
+// are returned with a trailing path separator. The returned paths begin
+// with path as typed (in particular, a leading "~" is kept).
+func CompleteFilePath(path string, opts FilePathOptions) []string {
+	if opts.Quote {
+		path = unquotePath(path)
+	}
+	dir, base := filepath.Split(path)
+	typedDir := dir
+	if opts.ExpandHome && (dir == "~"+string(filepath.Separator) || strings.HasPrefix(dir, "~/")) {
+		if home, err := os.UserHomeDir(); err == nil {
+			dir = filepath.Join(home, dir[2:]) + string(filepath.Separator)
+		}
+	}
+	readDir := dir
+	if readDir == "" {
+		readDir = "."
+	}
+	entries, err := os.ReadDir(readDir)
+	if err != nil {
+		return nil
+	}
+
+	var list []string
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasPrefix(name, base) {
+			continue
+		}
+		if strings.HasPrefix(name, ".") && !strings.HasPrefix(base, ".") && !opts.Hidden {
+			continue
+		}
+		isDir := e.IsDir()
+		if !isDir && e.Type()&os.ModeSymlink != 0 {
+			if fi, err := os.Stat(filepath.Join(readDir, name)); err == nil {
+				isDir = fi.IsDir()
+			}
+		}
+		if opts.DirsOnly && !isDir {
+			continue
+		}
+		c := typedDir + name
+		if opts.Quote {
+			c = quotePath(c)
+		}
+		if isDir {
+			c += string(filepath.Separator)
+		}
+		list = append(list, c)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// FilePathCompleter returns a WordCompleter that completes the word before
+// the cursor as a file path. Words are separated by unescaped whitespace.
+func FilePathCompleter(opts FilePathOptions) WordCompleter {
+	return func(line string, pos int) (string, []string, string) {
+		r := []rune(line)
+		start := pos
+		for start > 0 && r[start-1] != ' ' && r[start-1] != '\t' ||
+			opts.Quote && start > 1 && r[start-2] == '\\' {
+			start--
+		}
+		head := string(r[:start])
+		word := string(r[start:pos])
+		tail := string(r[pos:])
+		return head, CompleteFilePath(word, opts), tail
+	}
+}
+
+func quotePath(path string) string {
+	return strings.NewReplacer(`\`, `\\`, " ", `\ `).Replace(path)
+}
+
+func unquotePath(path string) string {
+	var b strings.Builder
+	for i := 0; i < len(path); {
+		r, n := utf8.DecodeRuneInString(path[i:])
+		if r == '\\' && i+n < len(path) {
+			i += n
+			r, n = utf8.DecodeRuneInString(path[i:])
+		}
+		b.WriteRune(r)
+		i += n
+	}
+	return b.String()
+}
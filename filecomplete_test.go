@@ -0,0 +1,47 @@
+package liner
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCompleteFilePath(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"alpha", "alps", "my file", ".hidden"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "alcove"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	sep := string(filepath.Separator)
+	prefix := dir + sep
+
+	tests := []struct {
+		path string
+		opts FilePathOptions
+		want []string
+	}{
+		{prefix + "al", FilePathOptions{}, []string{prefix + "alcove" + sep, prefix + "alpha", prefix + "alps"}},
+		{prefix + "al", FilePathOptions{DirsOnly: true}, []string{prefix + "alcove" + sep}},
+		{prefix, FilePathOptions{Hidden: true, DirsOnly: true}, []string{prefix + "alcove" + sep}},
+		{prefix + ".", FilePathOptions{}, []string{prefix + ".hidden"}},
+		{prefix + `my\ f`, FilePathOptions{Quote: true}, []string{prefix + `my\ file`}},
+		{prefix + "zz", FilePathOptions{}, nil},
+	}
+	for _, test := range tests {
+		got := CompleteFilePath(test.path, test.opts)
+		if len(got) != len(test.want) {
+			t.Errorf("CompleteFilePath(%q, %+v) = %q, want %q", test.path, test.opts, got, test.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("CompleteFilePath(%q, %+v) = %q, want %q", test.path, test.opts, got, test.want)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package liner
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// PromptInt is like Prompt, but reads a whole number, in decimal. Only
+// digits and signs may be typed, and Enter rejects a line that is not a
+// number, explaining why below it. If line editing is not supported, the
+// line read is parsed as it is, and the error is a *strconv.NumError if it
+// is not a number.
+func (s *State) PromptInt(prompt string) (int, error) {
+	line, err := s.promptNumber(prompt, "+-0123456789", "not a whole number", func(line string) error {
+		_, err := strconv.Atoi(line)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(line)
+}
+
+// PromptFloat is like PromptInt, but reads a decimal number, which may
+// have a fraction and an exponent, such as 1.5 or 2e-3.
+func (s *State) PromptFloat(prompt string) (float64, error) {
+	line, err := s.promptNumber(prompt, "+-.0123456789eE", "not a number", func(line string) error {
+		_, err := strconv.ParseFloat(line, 64)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(line, 64)
+}
+
+// promptNumber reads a line of chars that parse accepts, for PromptInt and
+// PromptFloat, and returns it without surrounding space. Enter rejects
+// other lines with msg.
+func (s *State) promptNumber(prompt, chars, msg string, parse func(string) error) (string, error) {
+	checks := lineChecks{
+		filter: func(r rune) []rune {
+			if strings.ContainsRune(chars, r) {
+				return []rune{r}
+			}
+			return nil
+		},
+		accept: func(line string) (AcceptResult, string) {
+			err := parse(strings.TrimSpace(line))
+			switch {
+			case err == nil:
+				return Accept, ""
+			case errors.Is(err, strconv.ErrRange):
+				return Reject, "number out of range"
+			}
+			return Reject, msg
+		},
+	}
+	line, err := s.promptChecked(prompt, checks)
+	return strings.TrimSpace(line), err
+}
+
+// lineChecks are made on the line of a Prompt, such as that of PromptInt,
+// before those of the input filter and accept hook the program set.
+type lineChecks struct {
+	filter InputFilter
+	accept AcceptHook
+}
+
+// lineFilter returns the input filter of a Prompt made with checks: the
+// program's is called with the runes that checks.filter lets through as
+// they are.
+func (s *State) lineFilter(checks lineChecks) InputFilter {
+	filter := s.inputFilter
+	if checks.filter == nil || filter == nil {
+		if checks.filter != nil {
+			return checks.filter
+		}
+		return filter
+	}
+	return func(r rune) []rune {
+		if out := checks.filter(r); len(out) != 1 || out[0] != r {
+			return out
+		}
+		return filter(r)
+	}
+}
+
+// lineAcceptHook returns the accept hook of a Prompt made with checks: the
+// program's decides what to do with a line that checks.accept accepts.
+func (s *State) lineAcceptHook(checks lineChecks) AcceptHook {
+	accept := s.acceptHook
+	if checks.accept == nil || accept == nil {
+		if checks.accept != nil {
+			return checks.accept
+		}
+		return accept
+	}
+	return func(line string) (AcceptResult, string) {
+		if result, msg := checks.accept(line); result != Accept {
+			return result, msg
+		}
+		return accept(line)
+	}
+}
+
+// maskPlace marks the places of the mask of PromptMasked.
+const maskPlace = '_'
+
+// PromptMasked displays prompt and mask, such as "____-__-__" for a date or
+// "____ ____ ____ ____" for a card number, and reads the characters that
+// fill its places, which are marked by underscores. The other characters of
+// the mask are displayed as they are, and the cursor skips over them, so
+// only the places are typed; typing one of them, or pasting text that
+// includes them, is harmless. Only the characters that valid reports true
+// for fill the places, or only digits if valid is nil. Backspace empties
+// the last place filled, and Enter returns the mask with its places filled,
+// once they all are.
+//
+// If line editing is not supported, the mask is displayed in brackets
+// after the prompt, and a line is read, which may hold either the whole
+// text or only the characters of the places; it is read again until it
+// does.
+func (s *State) PromptMasked(prompt, mask string, valid func(r rune) bool) (string, error) {
+	if !strings.ContainsRune(mask, maskPlace) {
+		return "", errors.New("liner: the mask has no places to fill")
+	}
+	if valid == nil {
+		valid = unicode.IsDigit
+	}
+	line, err := s.readMasked(prompt, []rune(mask), valid)
+	s.record(prompt, line, err)
+	return line, err
+}
+
+// fillMask returns mask with its places filled by the runes of places.
+func fillMask(mask []rune, places []rune) []rune {
+	filled := make([]rune, len(mask))
+	i := 0
+	for j, r := range mask {
+		if r == maskPlace && i < len(places) {
+			r = places[i]
+			i++
+		}
+		filled[j] = r
+	}
+	return filled
+}
+
+// maskedUnsupported reads the input of PromptMasked as a line, when keys
+// cannot be read as they are typed.
+func (s *State) maskedUnsupported(prompt string, mask []rune, valid func(r rune) bool) (string, error) {
+	n := 0
+	for _, r := range mask {
+		if r == maskPlace {
+			n++
+		}
+	}
+	prompt += "[" + string(mask) + "] "
+	for {
+		line, err := s.promptUnsupported(prompt)
+		if err != nil {
+			return "", err
+		}
+		text := []rune(strings.TrimSpace(line))
+		if len(text) == len(mask) {
+			// The whole text, literals included
+			var places []rune
+			for i, r := range mask {
+				if r == maskPlace {
+					places = append(places, text[i])
+				} else if text[i] != r {
+					places = nil
+					break
+				}
+			}
+			text = places
+		}
+		ok := len(text) == n
+		for _, r := range text {
+			ok = ok && valid(r)
+		}
+		if ok {
+			return string(fillMask(mask, text)), nil
+		}
+	}
+}
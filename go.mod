@@ -1,3 +1,5 @@
 module github.com/peterh/liner
 
+go 1.21
+
 require github.com/mattn/go-runewidth v0.0.3
@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"fmt"
 	"io"
+	"strconv"
 	"strings"
 	"sync"
 	"unicode/utf8"
@@ -60,7 +61,7 @@ func (h *sliceHistory) ReadHistory(r io.Reader) (num int, err error) {
 // WriteHistory writes scrollback history to w. Returns the number of lines
 // successfully written, and any write error.
 //
-// Unlike the rest of liner's API, WriteHistory is safe to call
+// Unlike most of liner's API, WriteHistory is safe to call
 // from another goroutine while Prompt is in progress.
 // This exception is to facilitate the saving of the history buffer
 // during an unexpected exit (for example, due to Ctrl-C being invoked)
@@ -124,3 +125,41 @@ func (h *sliceHistory) FindByPattern(pattern string) (ph []string, pos []int) {
 	}
 	return ph, pos
 }
+
+// expandHistory returns the history entry that line stands for if it is a
+// history command, as in csh: "!!" is the last entry, "!n" entry n counting
+// from 1, "!-n" the nth last, "!text" the last entry starting with text, and
+// "!?text" (or "!?text?") the last entry containing text. Other lines are returned as they
+// are.
+func expandHistory(h History, line string) (string, error) {
+	event := strings.TrimPrefix(line, "!")
+	if event == line || event == "" || strings.HasPrefix(event, " ") {
+		return line, nil
+	}
+	var entries []string
+	switch {
+	case event == "!":
+		entries = h.FindByPrefix("")
+	case strings.HasPrefix(event, "?"):
+		entries, _ = h.FindByPattern(strings.TrimSuffix(event[1:], "?"))
+	default:
+		if n, err := strconv.Atoi(event); err == nil {
+			entries = h.FindByPrefix("")
+			if n < 0 {
+				n += len(entries)
+			} else {
+				n--
+			}
+			if n >= 0 && n < len(entries) {
+				return entries[n], nil
+			}
+			entries = nil
+		} else {
+			entries = h.FindByPrefix(event)
+		}
+	}
+	if len(entries) == 0 {
+		return "", fmt.Errorf("%s: event not found", line)
+	}
+	return entries[len(entries)-1], nil
+}
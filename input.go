@@ -5,13 +5,20 @@ package liner
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/base64"
 	"errors"
+	"fmt"
+	"io"
 	"os"
 	"os/signal"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
+
+	"github.com/peterh/liner/term"
 )
 
 type nexter struct {
@@ -22,41 +29,78 @@ type nexter struct {
 // State represents an open terminal
 type State struct {
 	commonState
-	origMode    termios
-	defaultMode termios
+	in          *os.File
+	outFile     *os.File
+	origMode    *term.Mode // nil unless the input is a terminal
+	defaultMode *term.Mode // the mode before the prompt, see startPrompt
 	next        <-chan nexter
 	winch       chan os.Signal
+	wake        chan struct{} // signalled by wakeReader
 	pending     []rune
+	stopped     chan struct{}
+	stop        *os.File // closed to stop the rune reader, see waitInput
 	useCHA      bool
+	ctrlHIsWord bool // Ctrl-H is Ctrl-Backspace, not the erase character
+	terminfo    map[string]string
+	keys        map[string]action // escape sequences of keys, from terminfo
+	keyPrefixes map[string]bool
+	replay      []rune // runes read past a key sequence, to be read again
+	term        string
+	sizeMu      sync.Mutex
+	size        [2]int         // columns and rows of a stream's terminal, or of the process's as last asked
+	sizeKnown   bool           // size holds the size of the process's terminal
+	sizeChanged chan os.Signal // told of SIGWINCH, which makes size unknown
+	afterCR     bool           // the rune reader stopped at a carriage return
+	closed      chan struct{}
+	events      *eventState // see Events
 }
 
 // NewLiner initializes a new *State, and sets the terminal into raw mode. To
 // restore the terminal to its previous state, call State.Close().
 func NewLiner(h History) *State {
+	return NewLinerFiles(os.Stdin, os.Stdout, h)
+}
+
+// NewLinerFiles initializes a new *State that reads from in and writes to
+// out, rather than standard input and output, and sets the terminal into
+// raw mode. A program whose standard input is a pipe can prompt on its
+// controlling terminal by opening /dev/tty (CONIN$ and CONOUT$ on Windows)
+// for both. Close does not close in or out.
+func NewLinerFiles(in, out *os.File, h History) *State {
 	var s State
 	if h == nil {
 		h = &sliceHistory{}
 	}
 	s.history = h
-	s.r = bufio.NewReader(os.Stdin)
+	s.in, s.outFile = in, out
+	s.r = bufio.NewReader(in)
+	s.out = out
+	s.setTerminal(os.Getenv("TERM"))
+	s.noColors = os.Getenv("NO_COLOR") != ""
+	s.colorDepth = terminalColorDepth(s.term, os.Getenv("COLORTERM"))
+	s.quirks = terminalQuirks(s.term, os.Getenv("TMUX"), os.Getenv("STY"))
+	s.wake = make(chan struct{}, 1)
+	s.sizeChanged = make(chan os.Signal, 1)
+	signal.Notify(s.sizeChanged, syscall.SIGWINCH)
 
 	s.terminalSupported = TerminalSupported()
-	if m, err := TerminalMode(); err == nil {
-		s.origMode = *m.(*termios)
+	if m, err := term.GetMode(int(in.Fd())); err == nil {
+		s.origMode = m
 	} else {
 		s.inputRedirected = true
 	}
-	if _, err := getMode(syscall.Stdout); err != 0 {
+	if !term.IsTerminal(int(out.Fd())) {
 		s.outputRedirected = true
 	}
 	if s.inputRedirected && s.outputRedirected {
 		s.terminalSupported = false
 	}
 	if s.terminalSupported && !s.inputRedirected && !s.outputRedirected {
-		mode := s.origMode
-		mode.Iflag &^= icrnl | inpck | istrip | ixon
-		mode.Cflag |= cs8
-		mode.Lflag &^= syscall.ECHO | icanon | iexten
+		// Terminals whose Backspace sends DEL send Ctrl-H for Ctrl-Backspace
+		s.ctrlHIsWord = s.origMode.Erase() == bs
+
+		mode := *s.origMode
+		mode.SetRaw()
 		mode.ApplyMode()
 
 		winch := make(chan os.Signal, 1)
@@ -73,48 +117,363 @@ func NewLiner(h History) *State {
 	return &s
 }
 
+// NewStream initializes a new *State that edits lines over rw, such as a
+// serial port or a network connection, rather than the process's terminal.
+// The terminal at the other end of rw is named term, as $TERM names the
+// process's terminal, and is columns wide and rows high; call SetSize when
+// that changes. The other end must send each key as it is typed and leave
+// echoing to liner: a telnet client must be told that the server will echo
+// and suppress go-ahead. Close does not close rw. NewStream is not available
+// on Windows.
+func NewStream(rw io.ReadWriter, term string, columns, rows int, h History) *State {
+	var s State
+	if h == nil {
+		h = &sliceHistory{}
+	}
+	s.history = h
+	s.stream = true
+	s.r = bufio.NewReader(rw)
+	s.out = crlfWriter{rw}
+	s.setTerminal(term)
+	s.colorDepth = terminalColorDepth(term, "")
+	s.quirks = terminalQuirks(term, "", "")
+	s.wake = make(chan struct{}, 1)
+	s.winch = make(chan os.Signal, 1)
+	s.size = [2]int{columns, rows}
+
+	s.terminalSupported = supportedTerminal(term)
+	s.outputRedirected = columns <= 0
+	s.checkOutput()
+	s.getColumns()
+	return &s
+}
+
+// WithStream makes the State edit lines over rw, as NewStream does, rather
+// than on standard input and output.
+func WithStream(rw io.ReadWriter, term string, columns, rows int) Option {
+	return func(o *options) {
+		o.open = func(h History) *State {
+			return NewStream(rw, term, columns, rows, h)
+		}
+	}
+}
+
+// SetSize tells a State from NewStream that its terminal is now columns wide
+// and rows high, as the process's terminal reports a resize. It may be
+// called from any goroutine. SetSize does nothing to a State from NewLiner,
+// which asks its terminal for its size.
+func (s *State) SetSize(columns, rows int) {
+	if !s.stream {
+		return
+	}
+	s.sizeMu.Lock()
+	s.size = [2]int{columns, rows}
+	s.sizeMu.Unlock()
+	select {
+	case s.winch <- syscall.SIGWINCH:
+	default:
+	}
+}
+
+// WindowSize is the size of a terminal, as an SSH client reports it in its
+// pty-req and window-change requests.
+type WindowSize struct {
+	Width  int // in columns
+	Height int // in rows
+}
+
+// NewLinerFromTerminal initializes a new *State that edits lines over rw,
+// such as the channel of an SSH session with a pseudo-terminal. The
+// client's terminal is width columns wide and height rows high, as its
+// pty-req said, and is resized to each WindowSize received from resize, as
+// its window-change requests say, until resize is closed or Close is
+// called. The client's terminal is taken to be an xterm, and the State has
+// no history: NewStream takes the terminal's name and a History.
+// NewLinerFromTerminal is not available on Windows.
+func NewLinerFromTerminal(rw io.ReadWriter, width, height int, resize <-chan WindowSize) *State {
+	s := NewStream(rw, "xterm", width, height, nil)
+	s.closed = make(chan struct{})
+	go func() {
+		for {
+			select {
+			case size, ok := <-resize:
+				if !ok {
+					return
+				}
+				s.SetSize(size.Width, size.Height)
+			case <-s.closed:
+				return
+			}
+		}
+	}()
+	return s
+}
+
+// crlfWriter writes "\r\n" for each "\n" written to it, as the line
+// discipline of the process's terminal does.
+type crlfWriter struct {
+	w io.Writer
+}
+
+func (c crlfWriter) Write(p []byte) (int, error) {
+	if bytes.IndexByte(p, '\n') < 0 {
+		return c.w.Write(p)
+	}
+	if _, err := c.w.Write(bytes.Replace(p, []byte("\n"), []byte("\r\n"), -1)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// inFd returns the file descriptor of the terminal's input.
+func (s *State) inFd() int {
+	return int(s.in.Fd())
+}
+
+// setTerminal sets the capabilities of the terminal named term.
+func (s *State) setTerminal(term string) {
+	s.term = term
+	s.noLinks = !linkTerminal(term)
+	if caps, err := loadTerminfo(term); err == nil {
+		s.setTerminfo(caps)
+	}
+}
+
 var errTimedOut = errors.New("timeout")
 
 func (s *State) startPrompt() {
 	if s.terminalSupported {
-		if m, err := TerminalMode(); err == nil {
-			s.defaultMode = *m.(*termios)
-			mode := s.defaultMode
-			mode.Lflag &^= isig
+		if m, err := term.GetMode(s.inFd()); err == nil && !s.stream {
+			s.defaultMode = m
+			mode := *m
+			mode.SetSignals(false)
 			mode.ApplyMode()
 		}
+		if s.pasteBracketed() {
+			fmt.Fprint(s.out, enableBracketedPaste)
+		}
+		if s.mouse && mouseTerminal(s.term) {
+			fmt.Fprint(s.out, enableMouse)
+		}
 	}
 	s.restartPrompt()
 }
 
-func (s *State) inputWaiting() bool {
-	return len(s.next) > 0
+// terminalWaiting only returns true if the next call to readTerminal will
+// return immediately.
+func (s *State) terminalWaiting() bool {
+	return len(s.typed) > 0 || len(s.pending) > 0 || len(s.replay) > 0 || len(s.next) > 0 || s.fedWaiting()
 }
 
 func (s *State) restartPrompt() {
+	if s.events != nil {
+		// The goroutine reading events restarts the rune reader
+		return
+	}
+	s.startReader()
+}
+
+// startReader starts the rune reader, unless it is running.
+func (s *State) startReader() {
+	if s.stopped != nil {
+		select {
+		case <-s.stopped:
+		default:
+			// The rune reader is still running
+			return
+		}
+	}
 	next := make(chan nexter, 200)
+	if s.stopped != nil {
+		// A stream's reader runs on after a Prompt ends early, so it
+		// may have read keys for this one
+		for n := range s.next {
+			next <- n
+		}
+	}
+	stopped := make(chan struct{})
+	var wake, stop *os.File
+	if !s.stream {
+		// If the pipe cannot be made, the reader cannot be stopped
+		wake, stop, _ = os.Pipe()
+	}
 	go func() {
+		if wake != nil {
+			defer wake.Close()
+			defer stop.Close()
+		}
 		for {
+			if s.r.Buffered() == 0 && wake != nil && !waitInput(s.inFd(), int(wake.Fd())) {
+				close(stopped)
+				close(next)
+				return
+			}
 			var n nexter
 			n.r, _, n.err = s.r.ReadRune()
-			next <- n
+			if s.stream && s.afterCR && (n.r == '\n' || n.r == 0) && n.err == nil {
+				// Telnet sends Enter as CR LF or CR NUL
+				s.afterCR = false
+				continue
+			}
+			s.afterCR = n.r == '\r'
 			// Shut down nexter loop when an end condition has been reached
 			if n.err != nil || n.r == '\n' || n.r == '\r' || n.r == ctrlC || n.r == ctrlD {
+				close(stopped)
+				next <- n
 				close(next)
 				return
 			}
+			next <- n
 		}
 	}()
 	s.next = next
+	s.stopped = stopped
+	s.stop = stop
+}
+
+// waitInput waits for input on the file descriptor fd, and returns false if
+// the pipe read by wake is closed first, as haltReader closes it.
+func waitInput(fd, wake int) bool {
+	for {
+		ok, err := waitReadable(fd, wake)
+		if err != syscall.EINTR {
+			// Let the read report any error
+			return ok || err != nil
+		}
+	}
+}
+
+// stopReader stops the rune reader, so that another program can read from
+// the terminal. Runes it had already read are kept to be read next.
+// restartPrompt starts it again. The reader of a stream cannot be stopped
+// while it waits for input, so it goes on reading.
+func (s *State) stopReader() {
+	if s.events != nil {
+		// The goroutine reading events owns the rune reader
+		return
+	}
+	s.haltReader()
+}
+
+// haltReader stops the rune reader, keeping the runes it has read to be
+// read again.
+func (s *State) haltReader() {
+	if s.stopped == nil || s.stop == nil {
+		return
+	}
+	select {
+	case <-s.stopped:
+		return
+	default:
+	}
+	s.stop.Close()
+	for n := range s.next {
+		if n.err == nil {
+			s.pending = append(s.pending, n.r)
+		}
+	}
+}
+
+// cookTerminal returns the terminal to its mode before NewLiner, to read a
+// line without line editing, and returns a function that restores its
+// current mode.
+func (s *State) cookTerminal() (restore func()) {
+	if s.stream {
+		return func() {}
+	}
+	m, err := term.GetMode(s.inFd())
+	applyMode(s.origMode)
+	return func() {
+		if err == nil {
+			m.ApplyMode()
+		}
+	}
 }
 
 func (s *State) stopPrompt() {
+	// The rune reader stops itself after the keys that end a prompt, but
+	// not if they were fed, and must not read on in the terminal's
+	// previous mode what is typed for the program
+	s.stopReader()
 	if s.terminalSupported {
-		s.defaultMode.ApplyMode()
+		if s.pasteBracketed() {
+			fmt.Fprint(s.out, disableBracketedPaste)
+		}
+		if s.mouse && mouseTerminal(s.term) {
+			fmt.Fprint(s.out, disableMouse)
+		}
+		if !s.stream {
+			applyMode(s.defaultMode)
+		}
+	}
+}
+
+// suspendable reports whether Ctrl-Z suspends the process: not when the
+// terminal is at the other end of a stream.
+func (s *State) suspendable() bool {
+	return !s.stream
+}
+
+// defaultEditor is the editor run by Ctrl-X Ctrl-E if neither $VISUAL nor
+// $EDITOR is set.
+const defaultEditor = "vi"
+
+// suspend stops the process, as Ctrl-Z does when the terminal is not in raw
+// mode, with the terminal restored to its mode before NewLiner until the
+// process is continued.
+func (s *State) suspend() {
+	s.pauseTerminal()
+	syscall.Kill(0, syscall.SIGTSTP)
+	// Continued by SIGCONT; the shell may have changed the terminal mode
+	s.resumeTerminal()
+}
+
+// pauseTerminal restores the terminal to its mode before NewLiner, for
+// another program to use during a prompt.
+func (s *State) pauseTerminal() {
+	if s.pasteBracketed() {
+		fmt.Fprint(s.out, disableBracketedPaste)
+	}
+	if s.mouse && mouseTerminal(s.term) {
+		fmt.Fprint(s.out, disableMouse)
+	}
+	s.showCursor(CursorDefault)
+	if !s.stream {
+		applyMode(s.origMode)
 	}
 }
 
+// resumeTerminal returns the terminal to the prompt's mode after
+// pauseTerminal.
+func (s *State) resumeTerminal() {
+	// The terminal may have been resized while another process was in
+	// the foreground, which SIGWINCH does not tell
+	s.forgetSize()
+	if !s.stream && s.defaultMode != nil {
+		mode := *s.defaultMode
+		mode.SetSignals(false)
+		mode.ApplyMode()
+	}
+	if s.pasteBracketed() {
+		fmt.Fprint(s.out, enableBracketedPaste)
+	}
+	if s.mouse && mouseTerminal(s.term) {
+		fmt.Fprint(s.out, enableMouse)
+	}
+	s.showCursor(s.modeCursorShape())
+}
+
 func (s *State) nextPending(timeout <-chan time.Time) (rune, error) {
+	if len(s.replay) > 0 {
+		r := s.replay[0]
+		s.replay = s.replay[1:]
+		s.pending = append(s.pending, r)
+		return r, nil
+	}
+	if r, ok := s.nextFed(); ok {
+		s.pending = append(s.pending, r)
+		return r, nil
+	}
 	select {
 	case thing, ok := <-s.next:
 		if !ok {
@@ -132,13 +491,135 @@ func (s *State) nextPending(timeout <-chan time.Time) (rune, error) {
 	}
 }
 
-func (s *State) readNext() (interface{}, error) {
+// replyTimeout is how long readReply waits for the terminal to reply.
+const replyTimeout = 500 * time.Millisecond
+
+// errNoReply is returned by readReply if a key that stops the rune reader,
+// such as Enter, is typed before the terminal replies.
+var errNoReply = errors.New("no reply")
+
+// readReply writes query to the terminal and returns its reply, which
+// starts with prefix, holds runes for which body is true, and ends with a
+// rune for which end is true. Keys typed before the reply are read
+// afterwards. If the terminal does not reply, readReply returns errTimedOut.
+func (s *State) readReply(query, prefix string, body, end func(rune) bool) ([]rune, error) {
+	if s.events != nil {
+		// The goroutine reading events would take the reply for keys
+		return nil, errTimedOut
+	}
+	fmt.Fprint(s.out, query)
+	timeout := time.After(replyTimeout)
+	p := []rune(prefix)
+	var seq []rune
+	for {
+		var r rune
+		select {
+		case thing, ok := <-s.next:
+			if !ok {
+				s.pending = append(s.pending, seq...)
+				return nil, errNoReply
+			}
+			if thing.err != nil {
+				return nil, thing.err
+			}
+			r = thing.r
+		case <-timeout:
+			s.pending = append(s.pending, seq...)
+			return nil, errTimedOut
+		}
+		seq = append(seq, r)
+		n := len(seq)
+		switch {
+		case n <= len(p) && p[n-1] == r:
+		case n > len(p) && end(r):
+			return seq, nil
+		case n > len(p) && body(r):
+		default:
+			// Typed, not part of the reply
+			s.pending = append(s.pending, seq...)
+			seq = nil
+		}
+	}
+}
+
+// readClipboard asks the terminal for the system clipboard with OSC 52, and
+// returns its text. If the terminal does not answer, readClipboard returns
+// errNoClipboard and does not ask again; an empty answer also returns
+// errNoClipboard.
+func (s *State) readClipboard() ([]rune, error) {
+	if s.noClipboard {
+		return nil, errNoClipboard
+	}
+	const prefix = "\x1b]52;"
+	reply, err := s.readReply("\x1b]52;c;?\a", prefix, func(r rune) bool {
+		return r != '\a' && r != '\\' && r >= ' ' || r == esc
+	}, func(r rune) bool {
+		// BEL, or ST (Esc \\)
+		return r == '\a' || r == '\\'
+	})
+	switch err {
+	case nil:
+	case errTimedOut:
+		s.noClipboard = true
+		return nil, errNoClipboard
+	case errNoReply:
+		return nil, errNoClipboard
+	default:
+		return nil, err
+	}
+	// The reply is the selection, ';' and the text in base64
+	body := strings.TrimSuffix(string(reply[len(prefix):len(reply)-1]), "\x1b")
+	if i := strings.IndexByte(body, ';'); i >= 0 {
+		body = body[i+1:]
+	}
+	text, err := base64.StdEncoding.DecodeString(body)
+	if err != nil || len(text) == 0 {
+		return nil, errNoClipboard
+	}
+	return []rune(string(text)), nil
+}
+
+// cursorRow returns the row of the screen where the cursor is, counting
+// from 0, as reported by the terminal.
+func (s *State) cursorRow() (int, error) {
+	reply, err := s.readReply("\x1b[6n", "\x1b[", func(r rune) bool {
+		return r >= '0' && r <= '9' || r == ';'
+	}, func(r rune) bool {
+		return r == 'R'
+	})
+	if err != nil {
+		return 0, err
+	}
+	// The reply is row;columnR
+	fields := strings.Split(string(reply[2:len(reply)-1]), ";")
+	row, err := strconv.Atoi(fields[0])
+	if err != nil || len(fields) != 2 {
+		return 0, errNoReply
+	}
+	return row - 1, nil
+}
+
+// mouseTerminal reports whether the terminal is known to report the mouse
+// in the SGR format.
+func mouseTerminal(term string) bool {
+	for _, prefix := range []string{"xterm", "screen", "tmux", "rxvt", "alacritty", "foot", "wezterm", "vte"} {
+		if strings.HasPrefix(term, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// readLiteral reads the next rune typed, without decoding escape sequences.
+func (s *State) readLiteral() (interface{}, error) {
 	if len(s.pending) > 0 {
 		rv := s.pending[0]
 		s.pending = s.pending[1:]
 		return rv, nil
 	}
-	var r rune
+	if r, ok := s.nextFed(); ok {
+		return r, nil
+	}
 	select {
 	case thing, ok := <-s.next:
 		if !ok {
@@ -147,19 +628,81 @@ func (s *State) readNext() (interface{}, error) {
 		if thing.err != nil {
 			return nil, thing.err
 		}
-		r = thing.r
+		return thing.r, nil
 	case <-s.winch:
+		s.forgetSize()
 		s.getColumns()
 		return winch, nil
+	case <-s.wake:
+		return wake, nil
+	}
+}
+
+// wakeReader makes readTerminal return wake, if it is waiting for a key.
+func (s *State) wakeReader() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *State) readTerminal() (interface{}, error) {
+	if len(s.typed) > 0 {
+		v := s.typed[0]
+		s.typed = s.typed[1:]
+		return v, nil
+	}
+	if len(s.pending) > 0 {
+		rv := s.pending[0]
+		s.pending = s.pending[1:]
+		return rv, nil
+	}
+	r, fed := s.nextFed()
+	if !fed {
+		select {
+		case thing, ok := <-s.next:
+			if !ok {
+				return 0, ErrInternal
+			}
+			if thing.err != nil {
+				return nil, thing.err
+			}
+			r = thing.r
+		case <-s.winch:
+			s.forgetSize()
+			if s.events == nil {
+				// Otherwise the Prompt records the size of the
+				// ResizeEvent
+				s.getColumns()
+			}
+			return winch, nil
+		case <-s.wake:
+			return wake, nil
+		}
+	}
+	if r == ctrlH && s.ctrlHIsWord {
+		return ctrlBs, nil
 	}
 	if r != esc {
 		return r, nil
 	}
 	s.pending = append(s.pending, r)
 
-	// Wait at most 50 ms for the rest of the escape sequence
+	// Wait at most the escape timeout for the rest of the escape sequence
 	// If nothing else arrives, it was an actual press of the esc key
-	timeout := time.After(50 * time.Millisecond)
+	timeout := time.After(s.escapeTimeout())
+	if len(s.keys) > 0 {
+		key, err := s.readTerminfoKey(timeout)
+		if key != nil || err != nil {
+			return key, err
+		}
+		// Not a key in terminfo: decode the runes read as xterm's
+		// keys, and keep any left over for the next read
+		defer func() {
+			s.pending = append(s.pending, s.replay...)
+			s.replay = nil
+		}()
+	}
 	flag, err := s.nextPending(timeout)
 	if err != nil {
 		if err == errTimedOut {
@@ -167,6 +710,10 @@ func (s *State) readNext() (interface{}, error) {
 		}
 		return unknown, err
 	}
+	if s.escWait && (flag == '[' || flag == 'O') {
+		// Wait as long as it takes for the rest of the sequence
+		timeout = nil
+	}
 
 	switch flag {
 	case '[':
@@ -199,6 +746,33 @@ func (s *State) readNext() (interface{}, error) {
 		case 'Z':
 			s.pending = s.pending[:0] // escape code complete
 			return shiftTab, nil
+		case '<':
+			// SGR mouse report: button;x;y, then M if pressed or m if
+			// released
+			var fields [3]int
+			n := 0
+			for {
+				code, err := s.nextPending(timeout)
+				if err != nil {
+					if err == errTimedOut {
+						return code, nil
+					}
+					return nil, err
+				}
+				switch {
+				case code >= '0' && code <= '9':
+					fields[n] = fields[n]*10 + int(code-'0')
+				case code == ';' && n < 2:
+					n++
+				case (code == 'M' || code == 'm') && n == 2:
+					s.pending = s.pending[:0] // escape code complete
+					return mouseEvent{button: fields[0], x: fields[1] - 1, y: fields[2] - 1, release: code == 'm'}, nil
+				default:
+					rv := s.pending[0]
+					s.pending = s.pending[1:]
+					return rv, nil
+				}
+			}
 		case '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
 			num := []rune{code}
 			for {
@@ -217,10 +791,9 @@ func (s *State) readNext() (interface{}, error) {
 					// This only supports Ctrl-left and Ctrl-right for now
 					x, _ := strconv.ParseInt(string(num), 10, 32)
 					if x != 1 {
-						// Can't be left or right
-						rv := s.pending[0]
-						s.pending = s.pending[1:]
-						return rv, nil
+						// Can't be left or right, but may be a key
+						// report in win32-input-mode
+						return s.readWin32Key(int(x), timeout)
 					}
 					num = num[:0]
 					for {
@@ -289,6 +862,10 @@ func (s *State) readNext() (interface{}, error) {
 						return f11, nil
 					case 24:
 						return f12, nil
+					case 200:
+						return pasteStart, nil
+					case 201:
+						return pasteEnd, nil
 					default:
 						return unknown, nil
 					}
@@ -355,11 +932,143 @@ func (s *State) readNext() (interface{}, error) {
 	return r, nil
 }
 
+// readTerminfoKey reads the rest of an escape sequence while it could be
+// one of the keys in the terminal's terminfo entry, and returns the key's
+// action if it is one. Otherwise it returns nil, leaving the escape in
+// s.pending and the runes after it in s.replay.
+func (s *State) readTerminfoKey(timeout <-chan time.Time) (interface{}, error) {
+	for {
+		seq := string(s.pending)
+		if a, ok := s.keys[seq]; ok {
+			s.pending = s.pending[:0] // escape code complete
+			return a, nil
+		}
+		if !s.keyPrefixes[seq] {
+			break
+		}
+		r, err := s.nextPending(timeout)
+		if err != nil {
+			if err == errTimedOut {
+				return r, nil
+			}
+			return nil, err
+		}
+		if s.escWait && len(s.pending) == 2 && (r == '[' || r == 'O') {
+			// Wait as long as it takes for the rest of the sequence
+			timeout = nil
+		}
+	}
+	s.replay = append(append([]rune(nil), s.pending[1:]...), s.replay...)
+	s.pending = s.pending[:1]
+	return nil, nil
+}
+
+// terminfoKeys are the actions of the key capabilities in terminfo.
+var terminfoKeys = map[string]action{
+	"kcuu1": up,
+	"kcud1": down,
+	"kcuf1": right,
+	"kcub1": left,
+	"khome": home,
+	"kend":  end,
+	"kpp":   pageUp,
+	"knp":   pageDown,
+	"kich1": insert,
+	"kdch1": del,
+	"kcbt":  shiftTab,
+	"kf1":   f1,
+	"kf2":   f2,
+	"kf3":   f3,
+	"kf4":   f4,
+	"kf5":   f5,
+	"kf6":   f6,
+	"kf7":   f7,
+	"kf8":   f8,
+	"kf9":   f9,
+	"kf10":  f10,
+	"kf11":  f11,
+	"kf12":  f12,
+}
+
+// setTerminfo sets the capabilities of the terminal, from its terminfo
+// entry. The escape sequences of its keys are decoded before xterm's.
+func (s *State) setTerminfo(caps map[string]string) {
+	s.terminfo = caps
+	s.keys = make(map[string]action)
+	s.keyPrefixes = make(map[string]bool)
+	for name, a := range terminfoKeys {
+		seq := caps[name]
+		if len(seq) < 2 || seq[0] != esc {
+			continue
+		}
+		s.keys[seq] = a
+		runes := []rune(seq)
+		for i := 1; i < len(runes); i++ {
+			s.keyPrefixes[string(runes[:i])] = true
+		}
+	}
+}
+
+// readWin32Key reads the rest of a key report in win32-input-mode,
+// "CSI Vk;Sc;Uc;Kd;Cs;Rc _", whose virtual key code vk has been read.
+// Windows Terminal sends these once a program asks for them, and goes on
+// sending them if that program exits without turning them off.
+func (s *State) readWin32Key(vk int, timeout <-chan time.Time) (interface{}, error) {
+	fields := [6]int{vk}
+	n := 1
+	for {
+		code, err := s.nextPending(timeout)
+		if err != nil {
+			if err == errTimedOut {
+				rv := s.pending[0]
+				s.pending = s.pending[1:]
+				return rv, nil
+			}
+			return nil, err
+		}
+		switch {
+		case code >= '0' && code <= '9':
+			fields[n] = fields[n]*10 + int(code-'0')
+		case code == ';' && n < len(fields)-1:
+			n++
+		case code == '_':
+			s.pending = s.pending[:0] // escape code complete
+			key := s.win32Key(keyRecord{
+				down:   fields[3] != 0,
+				repeat: uint16(fields[5]),
+				vk:     uint16(fields[0]),
+				char:   uint16(fields[2]),
+				state:  uint32(fields[4]),
+			})
+			if key == nil {
+				// A release or a modifier key
+				return s.readTerminal()
+			}
+			return key, nil
+		default:
+			rv := s.pending[0]
+			s.pending = s.pending[1:]
+			return rv, nil
+		}
+	}
+}
+
 // Close returns the terminal to its previous mode
 func (s *State) Close() error {
+	s.stopEvents()
+	if s.closed != nil {
+		select {
+		case <-s.closed:
+		default:
+			close(s.closed)
+		}
+	}
 	signal.Stop(s.winch)
-	if !s.inputRedirected {
-		s.origMode.ApplyMode()
+	if s.sizeChanged != nil {
+		signal.Stop(s.sizeChanged)
+	}
+	if !s.inputRedirected && !s.stream {
+		return applyMode(s.origMode)
 	}
 	return nil
 }
@@ -370,6 +1079,17 @@ func (s *State) Close() error {
 // Note that TerminalSupported does not check all factors that may
 // cause liner to not fully support the terminal (such as stdin redirection)
 func TerminalSupported() bool {
+	// An Emacs shell buffer edits lines itself, and shows the escape
+	// sequences of line editing as text, whatever $TERM says
+	return supportedTerminal(os.Getenv("TERM")) &&
+		!strings.Contains(os.Getenv("INSIDE_EMACS"), "comint")
+}
+
+// supportedTerminal reports whether the terminal named term supports line
+// editing.
+func supportedTerminal(term string) bool {
+	term = strings.ToLower(term)
 	bad := map[string]bool{"": true, "dumb": true, "cons25": true}
-	return !bad[strings.ToLower(os.Getenv("TERM"))]
+	// Emacs names its shell buffers "dumb-emacs-ansi", for one
+	return !bad[term] && !strings.HasPrefix(term, "dumb-")
 }
@@ -3,42 +3,27 @@
 
 package liner
 
-import "syscall"
-
-const (
-	getTermios = syscall.TIOCGETA
-	setTermios = syscall.TIOCSETA
-)
-
-const (
-	// Input flags
-	inpck  = 0x010
-	istrip = 0x020
-	icrnl  = 0x100
-	ixon   = 0x200
-
-	// Output flags
-	opost = 0x1
-
-	// Control flags
-	cs8 = 0x300
-
-	// Local flags
-	isig   = 0x080
-	icanon = 0x100
-	iexten = 0x400
+import (
+	"syscall"
+	"unsafe"
 )
 
-type termios struct {
-	Iflag  uintptr
-	Oflag  uintptr
-	Cflag  uintptr
-	Lflag  uintptr
-	Cc     [20]byte
-	Ispeed uintptr
-	Ospeed uintptr
-}
-
 // Terminal.app needs a column for the cursor when the input line is at the
 // bottom of the window.
 const cursorColumn = true
+
+// waitReadable waits for input on the file descriptor fd or on wake, and
+// reports whether fd has input and wake has none.
+func waitReadable(fd, wake int) (bool, error) {
+	var set syscall.FdSet
+	w := 8 * int(unsafe.Sizeof(set.Bits[0]))
+	if fd/w >= len(set.Bits) || wake/w >= len(set.Bits) {
+		// Too high to select; let the read wait
+		return true, nil
+	}
+	set.Bits[fd/w] |= int32(1) << uint(fd%w)
+	bit := int32(1) << uint(wake%w)
+	set.Bits[wake/w] |= bit
+	err := syscall.Select(max(fd, wake)+1, &set, nil, nil, nil)
+	return set.Bits[wake/w]&bit == 0, err
+}
@@ -3,27 +3,24 @@
 
 package liner
 
-import "syscall"
-
-const (
-	getTermios = syscall.TCGETS
-	setTermios = syscall.TCSETS
+import (
+	"syscall"
+	"unsafe"
 )
 
-const (
-	icrnl  = syscall.ICRNL
-	inpck  = syscall.INPCK
-	istrip = syscall.ISTRIP
-	ixon   = syscall.IXON
-	opost  = syscall.OPOST
-	cs8    = syscall.CS8
-	isig   = syscall.ISIG
-	icanon = syscall.ICANON
-	iexten = syscall.IEXTEN
-)
+const cursorColumn = false
 
-type termios struct {
-	syscall.Termios
+// waitReadable waits for input on the file descriptor fd or on wake, and
+// reports whether fd has input and wake has none.
+func waitReadable(fd, wake int) (bool, error) {
+	var set syscall.FdSet
+	w := 8 * int(unsafe.Sizeof(set.Bits[0]))
+	if fd/w >= len(set.Bits) || wake/w >= len(set.Bits) {
+		// Too high to select; let the read wait
+		return true, nil
+	}
+	set.Bits[fd/w] |= 1 << uint(fd%w)
+	set.Bits[wake/w] |= 1 << uint(wake%w)
+	_, err := syscall.Select(max(fd, wake)+1, &set, nil, nil, nil)
+	return set.Bits[wake/w]&(1<<uint(wake%w)) == 0, err
 }
-
-const cursorColumn = false
@@ -6,7 +6,18 @@ package liner
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
 	"testing"
+	"time"
 )
 
 func (s *State) expectRune(t *testing.T, r rune) {
@@ -37,6 +48,18 @@ func (s *State) expectAction(t *testing.T, a action) {
 	}
 }
 
+// stateWithInput returns a State that reads the keys of input, as the
+// rune reader sends them.
+func stateWithInput(input []byte) *State {
+	var s State
+	next := make(chan nexter, len(input))
+	for _, r := range string(input) {
+		next <- nexter{r: r}
+	}
+	s.next = next
+	return &s
+}
+
 func TestTypes(t *testing.T) {
 	input := []byte{'A', 27, 'B', 27, 91, 68, 27, '[', '1', ';', '5', 'D', 'e'}
 	var s State
@@ -60,3 +83,1255 @@ func TestTypes(t *testing.T) {
 
 	s.expectRune(t, 'e')
 }
+
+func TestBindings(t *testing.T) {
+	s := stateWithInput([]byte{ctrlX, ctrlE, ctrlX, 'q', 27, '.', 27, 'x', 'z'})
+	s.stopped = make(chan struct{}) // the reader never stops
+
+	if err := s.Bind("C-x C-e", ActionEndOfLine); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.BindFunc("M-.", func(b *Buffer) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	resolve := func() interface{} {
+		v, err := s.readNext()
+		if err == nil {
+			v, err = s.resolveBinding(v, true)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	if v := resolve(); v != rune(ctrlE) {
+		t.Errorf("C-x C-e: got %v", v)
+	}
+	// An incomplete chord is replayed as typed
+	if v := resolve(); v != rune(ctrlX) {
+		t.Errorf("C-x q: got %v", v)
+	}
+	if v := resolve(); v != 'q' {
+		t.Errorf("C-x q: got %v", v)
+	}
+	if _, ok := resolve().(boundFunc); !ok {
+		t.Error("M-. was not bound")
+	}
+	if v := resolve(); v != rune(27) {
+		t.Errorf("M-x: got %v", v)
+	}
+	if v := resolve(); v != 'x' {
+		t.Errorf("M-x: got %v", v)
+	}
+	if v := resolve(); v != 'z' {
+		t.Errorf("got %v", v)
+	}
+}
+
+func TestMacro(t *testing.T) {
+	s := stateWithInput([]byte{'a', 27, '[', 'A', 27, 'x'})
+
+	s.recording = true
+	for range []int{1, 2, 3, 4} {
+		if _, err := s.readNext(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(s.recorded) != 4 {
+		t.Fatalf("recorded %v", s.recorded)
+	}
+
+	s.recording = false
+	for _, v := range s.recorded {
+		k, _ := toKey(v)
+		s.macro = append(s.macro, k)
+	}
+	s.PlayMacro(s.LastMacro())
+	s.expectRune(t, 'a')
+	s.expectAction(t, up)
+	s.expectRune(t, 27)
+	s.expectRune(t, 'x')
+}
+
+func TestBracketedPaste(t *testing.T) {
+	s := stateWithInput([]byte("\x1b[200~a\tb\r\nc\x03\x1b[201~"))
+	s.stopped = make(chan struct{}) // the reader never stops
+
+	s.expectAction(t, pasteStart)
+	s.SetPasteNewline("⏎")
+	line, pos, err := s.readPaste([]rune("[]"), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(line) != "[a b⏎c]" || pos != 6 {
+		t.Errorf("got %q at %d", string(line), pos)
+	}
+}
+
+func TestQuotedInsert(t *testing.T) {
+	s := stateWithInput([]byte{27, '[', 'D', 'x'})
+
+	r, err := s.readQuoted()
+	if err != nil || r != 27 {
+		t.Fatalf("got %q, %v", r, err)
+	}
+	// The rest of the escape sequence is read as typed
+	s.expectRune(t, '[')
+	s.expectRune(t, 'D')
+	s.expectRune(t, 'x')
+}
+
+func TestEscapeSequenceWait(t *testing.T) {
+	var s State
+	next := make(chan nexter, 3)
+	s.next = next
+	s.SetEscapeTimeout(time.Millisecond)
+	s.SetEscapeSequenceWait(true)
+
+	next <- nexter{r: 27}
+	next <- nexter{r: '['}
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		next <- nexter{r: 'A'}
+	}()
+	s.expectAction(t, up)
+}
+
+func TestMouseReport(t *testing.T) {
+	s := stateWithInput([]byte("\x1b[<0;12;3M\x1b[<65;1;1mx"))
+
+	for _, want := range []mouseEvent{{0, 11, 2, false}, {65, 0, 0, true}} {
+		v, err := s.readNext()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v != want {
+			t.Errorf("got %v, want %v", v, want)
+		}
+	}
+	s.expectRune(t, 'x')
+}
+
+func TestWin32InputMode(t *testing.T) {
+	input := "\x1b[65;30;97;1;0;1_\x1b[65;30;97;0;0;1_" + // a, pressed and released
+		"\x1b[0;0;55357;1;0;1_\x1b[0;0;56832;1;0;1_" + // a surrogate pair
+		"\x1b[81;16;64;1;9;1_" + // AltGr-Q on a German layout
+		"\x1b[88;45;120;1;2;2_" // Alt-x, repeated
+	s := stateWithInput([]byte(input))
+
+	for _, r := range []rune{'a', '😀', '@', esc, 'x', esc, 'x'} {
+		s.expectRune(t, r)
+	}
+}
+
+func TestIMEComposition(t *testing.T) {
+	var s State
+	// Keys typed into a composition are reported with VK_PROCESSKEY
+	for _, c := range "nihao" {
+		if key := s.win32Key(keyRecord{down: true, repeat: 1, vk: 0xe5, char: uint16(c)}); key != nil {
+			t.Errorf("composing %c typed %v", c, key)
+		}
+	}
+	for _, c := range "你好" {
+		if key := s.win32Key(keyRecord{down: true, repeat: 1, char: uint16(c)}); key != c {
+			t.Errorf("committed %c, typed %v", c, key)
+		}
+	}
+}
+
+func TestTerminfoKeys(t *testing.T) {
+	s := stateWithInput([]byte("\x1b[[A\x1b[11~\x1b[1;5Dx\x1b[A\x1b[200~"))
+	// The Linux console's F1, and rxvt's
+	s.setTerminfo(map[string]string{"kf1": "\x1b[[A", "kf2": "\x1b[12~", "khome": "\x1b[1~", "kf3": "\x1b[11~"})
+
+	s.expectAction(t, f1)
+	s.expectAction(t, f3)
+	// Keys missing from terminfo are decoded as xterm sends them
+	s.expectAction(t, wordLeft)
+	s.expectRune(t, 'x')
+	s.expectAction(t, up)
+	s.expectAction(t, pasteStart)
+}
+
+func TestSizeCached(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+	// A pipe has no size, so only a cached one is returned
+	s := &State{outFile: w, size: [2]int{100, 40}, sizeKnown: true}
+	if columns, rows := s.Size(); columns != 100 || rows != 40 {
+		t.Errorf("Size() = %d, %d, want the cached 100, 40", columns, rows)
+	}
+	s.sizeChanged = make(chan os.Signal, 1)
+	s.sizeChanged <- syscall.SIGWINCH
+	if columns, rows := s.Size(); columns != 0 || rows != 0 {
+		t.Errorf("Size() = %d, %d after SIGWINCH, want 0, 0", columns, rows)
+	}
+}
+
+// newTestStream returns a State editing lines over a pipe, for an xterm 80
+// columns wide and 24 rows high, and the end of the pipe that keys are
+// written to. What is displayed is discarded. Both are closed when the test
+// ends.
+func newTestStream(t *testing.T) (*State, net.Conn) {
+	conn, remote := net.Pipe()
+	go io.Copy(io.Discard, remote)
+	s := NewStream(conn, "xterm", 80, 24, nil)
+	t.Cleanup(func() {
+		s.Close()
+		remote.Close()
+	})
+	return s, remote
+}
+
+func TestStream(t *testing.T) {
+	conn, remote := net.Pipe()
+	defer remote.Close()
+	var out bytes.Buffer
+	copied := make(chan struct{})
+	go func() {
+		io.Copy(&out, remote)
+		close(copied)
+	}()
+	go remote.Write([]byte("ab\x1b[Dc\r\nx\r\x00"))
+
+	s := NewStream(conn, "xterm", 80, 24, nil)
+	defer s.Close()
+	for _, want := range []string{"acb", "x"} {
+		line, err := s.Prompt("> ")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line != want {
+			t.Errorf("got %q, want %q", line, want)
+		}
+	}
+	s.SetSize(40, 10)
+	if s.getColumns(); s.columns != 40 || s.rows != 10 {
+		t.Errorf("size %dx%d after SetSize(40, 10)", s.columns, s.rows)
+	}
+	if columns, rows := s.Size(); columns != 40 || rows != 10 {
+		t.Errorf("Size() = %d, %d after SetSize(40, 10)", columns, rows)
+	}
+
+	conn.Close()
+	<-copied
+	if bytes.Count(out.Bytes(), []byte("\n")) != bytes.Count(out.Bytes(), []byte("\r\n")) {
+		t.Errorf("newline without carriage return in %q", out.Bytes())
+	}
+}
+
+func TestLinerFromTerminal(t *testing.T) {
+	conn, remote := net.Pipe()
+	defer remote.Close()
+	go io.Copy(io.Discard, remote)
+	resize := make(chan WindowSize)
+	s := NewLinerFromTerminal(conn, 80, 24, resize)
+	defer s.Close()
+
+	resize <- WindowSize{Width: 100, Height: 30}
+	select {
+	case <-s.winch:
+	case <-time.After(time.Second):
+		t.Fatal("no resize")
+	}
+	if s.getColumns(); s.columns != 100 || s.rows != 30 {
+		t.Errorf("size %dx%d after resize to 100x30", s.columns, s.rows)
+	}
+}
+
+func TestLinerFiles(t *testing.T) {
+	inR, inW, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	outR, outW, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inR.Close()
+	defer outR.Close()
+	inW.WriteString("hello\n")
+	inW.Close()
+
+	s := NewLinerFiles(inR, outW, nil)
+	line, err := s.Prompt("> ")
+	s.Close()
+	outW.Close()
+	if err != nil || line != "hello" {
+		t.Errorf("got %q, %v", line, err)
+	}
+	if out, _ := io.ReadAll(outR); string(out) != "> " {
+		t.Errorf("wrote %q, want the prompt", out)
+	}
+}
+
+func TestPromptContext(t *testing.T) {
+	s, remote := newTestStream(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	go remote.Write([]byte("abc"))
+	if _, err := s.PromptContext(ctx, "> "); err != context.DeadlineExceeded {
+		t.Fatalf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+	if _, err := s.PromptContext(ctx, "> "); err != context.DeadlineExceeded {
+		t.Errorf("got %v with a done context", err)
+	}
+
+	// The State is still usable after an abandoned prompt
+	go remote.Write([]byte("x\r"))
+	line, err := s.PromptContext(context.Background(), "> ")
+	if err != nil || line != "x" {
+		t.Errorf("got %q, %v; want \"x\"", line, err)
+	}
+}
+
+func TestAbortPrompt(t *testing.T) {
+	conn, remote := net.Pipe()
+	defer remote.Close()
+	s := NewStream(conn, "xterm", 80, 24, nil)
+	defer s.Close()
+
+	s.AbortPrompt() // no Prompt is active
+	go func() {
+		// The Prompt has started once its prompt is written
+		buf := make([]byte, 2)
+		io.ReadFull(remote, buf)
+		s.AbortPrompt()
+		io.Copy(io.Discard, remote)
+	}()
+	if _, err := s.Prompt("> "); err != ErrPromptAborted {
+		t.Fatalf("got %v, want %v", err, ErrPromptAborted)
+	}
+	go remote.Write([]byte("y\r"))
+	if line, err := s.Prompt("> "); err != nil || line != "y" {
+		t.Errorf("got %q, %v after AbortPrompt", line, err)
+	}
+}
+
+func TestEvents(t *testing.T) {
+	s, remote := newTestStream(t)
+	events := s.Events()
+
+	go remote.Write([]byte("hi\x1b[D!\x1bb\x1b[200~x\ny\x1b[201~"))
+	want := []Event{
+		{Kind: KeyEvent, Key: Key{Rune: 'h'}},
+		{Kind: KeyEvent, Key: Key{Rune: 'i'}},
+		{Kind: KeyEvent, Key: Key{Code: KeyLeft}},
+		{Kind: KeyEvent, Key: Key{Rune: '!'}},
+		{Kind: KeyEvent, Key: Key{Rune: 'b', Mod: ModAlt}},
+		{Kind: PasteEvent, Text: "x\ny"},
+	}
+	if err := s.BeginPrompt("> "); err != nil {
+		t.Fatal(err)
+	}
+	for _, w := range want {
+		ev := <-events
+		if ev != w {
+			t.Errorf("got %+v, want %+v", ev, w)
+		}
+		if _, done, err := s.Step(ev); done {
+			t.Fatalf("prompt ended early: %v", err)
+		}
+	}
+	s.SetSize(50, 20)
+	if ev := <-events; ev.Kind != ResizeEvent || ev.Width != 50 || ev.Height != 20 {
+		t.Errorf("got %+v after SetSize(50, 20)", ev)
+	} else {
+		s.Step(ev)
+	}
+	line, done, err := s.Step(Event{Kind: KeyEvent, Key: Key{Rune: cr}})
+	if !done || err != nil || line != "x yh!i" {
+		t.Errorf("got %q, %v, %v; want \"x yh!i\"", line, done, err)
+	}
+	if s.columns != 50 {
+		t.Errorf("%d columns after a ResizeEvent for 50", s.columns)
+	}
+	if _, _, err := s.Step(Event{Kind: KeyEvent, Key: Key{Rune: 'x'}}); err == nil {
+		t.Error("Step succeeded without BeginPrompt")
+	}
+
+	s.Close()
+	if _, ok := <-events; ok {
+		t.Error("events after Close")
+	}
+}
+
+func TestConfigureDuringPrompt(t *testing.T) {
+	s, remote := newTestStream(t)
+
+	result := make(chan error)
+	go func() {
+		_, err := s.Prompt("> ")
+		result <- err
+	}()
+	for {
+		s.asyncMu.Lock()
+		prompting := s.prompting
+		s.asyncMu.Unlock()
+		if prompting {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	s.SetCtrlCAborts(true)
+	s.SetCompleter(func(line string) []string { return nil })
+	s.SetHinter(func(line string, pos int) (string, Style) { return "", Style{} })
+	s.SetRightPrompt(func() string { return "" })
+	remote.Write([]byte{ctrlC})
+	if err := <-result; err != ErrPromptAborted {
+		t.Errorf("got %v after SetCtrlCAborts(true) during the prompt", err)
+	}
+	if s.completer == nil || s.hinter == nil || s.rightPrompt == nil {
+		t.Error("settings lost")
+	}
+}
+
+func TestRefreshPrompt(t *testing.T) {
+	s, remote := newTestStream(t)
+
+	done := make(chan string)
+	go func() {
+		line, _ := s.Prompt("12:00> ")
+		done <- line
+	}()
+	remote.Write([]byte("ab"))
+	waitForLine(t, s, "12:00> ab")
+	if err := s.RefreshPrompt("12:01> "); err != nil {
+		t.Fatal(err)
+	}
+	waitForLine(t, s, "12:01> ab")
+	if err := s.RefreshPrompt("bad\x07> "); err != ErrInvalidPrompt {
+		t.Errorf("got %v for a prompt with a control character, want %v", err, ErrInvalidPrompt)
+	}
+	// The line is edited on as before
+	remote.Write([]byte("c\r"))
+	if line := <-done; line != "abc" {
+		t.Errorf("got %q, want \"abc\"", line)
+	}
+	if err := s.RefreshPrompt("12:02> "); err != nil {
+		t.Errorf("got %v after the prompt", err)
+	}
+}
+
+func TestWriteDuringPrompt(t *testing.T) {
+	conn, remote := net.Pipe()
+	var out bytes.Buffer
+	copied := make(chan struct{})
+	go func() {
+		io.Copy(&out, remote)
+		close(copied)
+	}()
+	s := NewStream(conn, "xterm", 80, 24, nil)
+
+	if _, err := s.Write([]byte("before\n")); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan string)
+	go func() {
+		line, _ := s.Prompt("> ")
+		done <- line
+	}()
+	remote.Write([]byte("ab"))
+	waitForLine(t, s, "> ab")
+	// From other goroutines, as log messages arrive
+	var wg sync.WaitGroup
+	for i := 1; i <= 2; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := s.Printf("log %d", i); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	remote.Write([]byte("c\r"))
+	if line := <-done; line != "abc" {
+		t.Errorf("got %q, want \"abc\"", line)
+	}
+	s.Close()
+	conn.Close()
+	<-copied
+
+	shown := out.String()
+	if !strings.HasPrefix(shown, "before\r\n> ") {
+		t.Errorf("output without a prompt not written first in %q", shown)
+	}
+	// Each message is ended by a newline and followed by the line again
+	for _, msg := range []string{"log 1", "log 2"} {
+		i := strings.Index(shown, msg)
+		if i < 0 || !strings.HasPrefix(shown[i+len(msg):], "\r\n") ||
+			!strings.Contains(shown[i:], "> ab") {
+			t.Errorf("%q not written above the line in %q", msg, shown)
+		}
+	}
+}
+
+func TestAltScreen(t *testing.T) {
+	conn, remote := net.Pipe()
+	var out bytes.Buffer
+	var outMu sync.Mutex
+	copied := make(chan struct{})
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, err := remote.Read(buf)
+			outMu.Lock()
+			out.Write(buf[:n])
+			outMu.Unlock()
+			if err != nil {
+				close(copied)
+				return
+			}
+		}
+	}()
+	// waitShown waits for the output to hold want, followed by then
+	waitShown := func(want, then string) {
+		t.Helper()
+		for deadline := time.Now().Add(2 * time.Second); ; {
+			outMu.Lock()
+			shown := out.String()
+			outMu.Unlock()
+			if i := strings.Index(shown, want); i >= 0 && strings.Contains(shown[i:], then) {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("%q then %q not displayed in %q", want, then, shown)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	s := NewStream(conn, "xterm", 80, 24, nil)
+
+	errQuit := errors.New("quit")
+	err := s.AltScreen(func() error {
+		fmt.Fprint(conn, "finder")
+		return errQuit
+	})
+	if err != errQuit {
+		t.Errorf("got error %v, want %v", err, errQuit)
+	}
+	waitShown("\x1b[?1049hfinder\x1b[?1049l", "")
+
+	s.BindFunc("C-g", func(b *Buffer) error {
+		return s.AltScreen(func() error {
+			fmt.Fprint(conn, "menu")
+			return nil
+		})
+	})
+	done := make(chan string)
+	go func() {
+		line, _ := s.Prompt("> ")
+		done <- line
+	}()
+	remote.Write([]byte("ab"))
+	waitForLine(t, s, "> ab")
+	remote.Write([]byte("\x07"))
+	// The line is displayed again once f returns
+	waitShown("\x1b[?1049hmenu\x1b[?1049l", "> ab")
+	remote.Write([]byte("c\r"))
+	if line := <-done; line != "abc" {
+		t.Errorf("got %q, want \"abc\"", line)
+	}
+	s.Close()
+	conn.Close()
+	<-copied
+}
+
+func TestPanicDuringPrompt(t *testing.T) {
+	s, _ := newTestStream(t)
+	defer s.MustClose()
+	s.Events()
+
+	if err := s.BeginPrompt("> "); err != nil {
+		t.Fatal(err)
+	}
+	s.SetHighlighter(func(line string) []StyledSegment {
+		panic("boom")
+	})
+	func() {
+		defer func() {
+			if r := recover(); r != "boom" {
+				t.Errorf("Step panicked with %v, want boom", r)
+			}
+		}()
+		s.Step(Event{Kind: KeyEvent, Key: Key{Rune: 'x'}})
+	}()
+	if !s.panicked {
+		t.Error("terminal not restored after a panic")
+	}
+
+	s.SetHighlighter(nil)
+	if err := s.BeginPrompt("> "); err != nil {
+		t.Fatal(err)
+	}
+	s.Step(Event{Kind: KeyEvent, Key: Key{Rune: 'y'}})
+	line, done, err := s.Step(Event{Kind: KeyEvent, Key: Key{Rune: cr}})
+	if !done || err != nil || line != "y" {
+		t.Errorf("got %q, %v, %v after the panic; want \"y\"", line, done, err)
+	}
+	if s.panicked {
+		t.Error("terminal not resumed after a panic")
+	}
+}
+
+func TestPanicDuringRefresh(t *testing.T) {
+	conn, remote := net.Pipe()
+	var out bytes.Buffer
+	copied := make(chan struct{})
+	go func() {
+		io.Copy(&out, remote)
+		close(copied)
+	}()
+	s := NewStream(conn, "xterm", 80, 24, nil)
+	s.SetBracketedPaste(true)
+	s.SetHighlighter(func(line string) []StyledSegment {
+		panic("boom")
+	})
+	go remote.Write([]byte("x"))
+	func() {
+		defer func() {
+			if r := recover(); r != "boom" {
+				t.Errorf("Prompt panicked with %v, want boom", r)
+			}
+		}()
+		s.Prompt("> ")
+	}()
+
+	s.SetHighlighter(nil)
+	go remote.Write([]byte("y\r"))
+	line, err := s.Prompt("again> ")
+	if err != nil || line != "y" {
+		t.Errorf("got %q, %v after the panic; want \"y\"", line, err)
+	}
+	s.Close()
+	conn.Close()
+	<-copied
+	// Bracketed paste is turned off when the panic goes on, and the next
+	// prompt is drawn on the terminal
+	shown := out.String()
+	if !strings.Contains(shown, disableBracketedPaste) {
+		t.Errorf("bracketed paste left on in %q", shown)
+	}
+	if !strings.Contains(shown, "again> y") {
+		t.Errorf("prompt after the panic not drawn in %q", shown)
+	}
+}
+
+// waitForLine waits for the Prompt in progress to display want as its line.
+func waitForLine(t *testing.T, s *State, want string) {
+	t.Helper()
+	var rows []ScreenRow
+	for i := 0; i < 100; i++ {
+		if rows = s.Screen(); len(rows) > 0 && rows[0].String() == want {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("got %v, want the line %q", rows, want)
+}
+
+func TestPromptEx(t *testing.T) {
+	conn, remote := net.Pipe()
+	defer remote.Close()
+	go io.Copy(io.Discard, remote)
+	h := &sliceHistory{}
+	h.AppendHistory("old")
+	s := NewStream(conn, "xterm", 80, 24, h)
+	defer s.Close()
+	s.SetCtrlCAborts(true)
+
+	tests := []struct {
+		input string
+		want  Result
+		err   error
+	}{
+		{"\x1b[A\r", Result{Line: "old", Reason: ExitAccepted, Key: Key{Rune: cr}, UsedHistory: true}, nil},
+		{"new\r", Result{Line: "new", Reason: ExitAccepted, Key: Key{Rune: cr}}, nil},
+		{"\x04", Result{Reason: ExitEOF, Key: Key{Rune: ctrlD}}, io.EOF},
+		{"abc\x03", Result{Reason: ExitAborted, Key: Key{Rune: ctrlC}}, ErrPromptAborted},
+	}
+	for _, test := range tests {
+		go remote.Write([]byte(test.input))
+		r, err := s.PromptEx("> ")
+		if err != test.err {
+			t.Errorf("%q: got error %v, want %v", test.input, err, test.err)
+		}
+		if r.Duration <= 0 {
+			t.Errorf("%q: no duration", test.input)
+		}
+		r.Duration = 0
+		if r != test.want {
+			t.Errorf("%q: got %+v, want %+v", test.input, r, test.want)
+		}
+	}
+}
+
+func TestTranscript(t *testing.T) {
+	s, remote := newTestStream(t)
+	s.SetCtrlCAborts(true)
+	var transcript strings.Builder
+	s.SetTranscript(&transcript)
+
+	go remote.Write([]byte("ls\r"))
+	s.Prompt("\x1b[1m$\x1b[0m ")
+	go remote.Write([]byte("secret\r"))
+	s.PasswordPrompt("Password: ")
+	go remote.Write([]byte("y"))
+	s.Confirm("Go on? ", false)
+	go remote.Write([]byte("abc\x03"))
+	s.Prompt("$ ")
+	go remote.Write([]byte("\x04"))
+	s.Prompt("$ ")
+
+	want := "$ ls\nPassword: \nGo on? [y/N] yes\n$ ^C\n$ ^D\n"
+	if got := transcript.String(); got != want {
+		t.Errorf("got transcript %q, want %q", got, want)
+	}
+
+	transcript.Reset()
+	s.SetTranscriptTimestamps(true)
+	go remote.Write([]byte("pwd\r"))
+	s.Prompt("$ ")
+	stamp, rest, _ := strings.Cut(transcript.String(), " ")
+	if _, err := time.Parse(time.RFC3339, stamp); err != nil || rest != "$ pwd\n" {
+		t.Errorf("got transcript %q, want a timestamp and %q", transcript.String(), "$ pwd\n")
+	}
+}
+
+func TestFeed(t *testing.T) {
+	s, remote := newTestStream(t)
+
+	// Fed keys are read before those typed
+	s.Feed([]byte("ls\x1b[D\x1b[Dx\r"))
+	go remote.Write([]byte("typed\r"))
+	for _, want := range []string{"xls", "typed"} {
+		line, err := s.Prompt("> ")
+		if err != nil || line != want {
+			t.Errorf("got %q, %v, want %q", line, err, want)
+		}
+	}
+
+	// Keys fed during a Prompt wake it
+	go s.Feed([]byte("later\r"))
+	if line, err := s.Prompt("> "); err != nil || line != "later" {
+		t.Errorf("got %q, %v, want %q", line, err, "later")
+	}
+}
+
+func TestFeedRedirected(t *testing.T) {
+	conn, remote := net.Pipe()
+	defer remote.Close()
+	go io.Copy(io.Discard, remote)
+	s := NewStream(conn, "dumb", 80, 24, nil)
+	defer s.Close()
+
+	s.Feed([]byte("fed\n"))
+	go remote.Write([]byte("typed\n"))
+	for _, want := range []string{"fed", "typed"} {
+		line, err := s.Prompt("> ")
+		if err != nil || line != want {
+			t.Errorf("got %q, %v, want %q", line, err, want)
+		}
+	}
+}
+
+// pipeTerminal returns a State reading from and writing to pipes, as if
+// they were a terminal, and the pipe to which to write what is typed.
+func pipeTerminal(t *testing.T) (*State, *os.File) {
+	in, typed, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	shown, out, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go io.Copy(io.Discard, shown)
+	s := NewLinerFiles(in, out, nil)
+	s.inputRedirected, s.outputRedirected, s.terminalSupported = false, false, true
+	s.columns = 80
+	t.Cleanup(func() {
+		s.Close()
+		in.Close()
+		typed.Close()
+		out.Close()
+	})
+	return s, typed
+}
+
+func TestFeedStopsReader(t *testing.T) {
+	s, _ := pipeTerminal(t)
+
+	s.Feed([]byte("abc\r"))
+	if line, err := s.Prompt("> "); err != nil || line != "abc" {
+		t.Errorf("got %q, %v, want \"abc\"", line, err)
+	}
+	// What is typed after the prompt is left for the program
+	select {
+	case <-s.stopped:
+	default:
+		t.Error("the rune reader still runs after the prompt")
+	}
+
+	// A terminal too narrow to edit on reads fed keys too
+	s.columns = 0
+	s.Feed([]byte("narrow\rnext\r"))
+	for _, want := range []string{"narrow", "next"} {
+		if line, err := s.Prompt("> "); err != nil || line != want {
+			t.Errorf("got %q, %v, want %q", line, err, want)
+		}
+	}
+}
+
+func TestStopReader(t *testing.T) {
+	s, typed := pipeTerminal(t)
+	s.startReader()
+	typed.Write([]byte("ab"))
+	for len(s.next) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	start := time.Now()
+	s.stopReader()
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("stopping the reader took %v", elapsed)
+	}
+	select {
+	case <-s.stopped:
+	default:
+		t.Fatal("the rune reader still runs")
+	}
+	if string(s.pending) != "ab" {
+		t.Errorf("got %q pending, want \"ab\"", string(s.pending))
+	}
+
+	// What is typed meanwhile is left for another program, until the
+	// reader starts again
+	typed.Write([]byte("c"))
+	time.Sleep(10 * time.Millisecond)
+	if s.r.Buffered() != 0 {
+		t.Error("the stopped reader read on")
+	}
+	s.startReader()
+	if n := <-s.next; n.r != 'c' || n.err != nil {
+		t.Errorf("got %q, %v after restarting, want 'c'", n.r, n.err)
+	}
+	s.stopReader()
+}
+
+func TestEditCommandLine(t *testing.T) {
+	s, typed := pipeTerminal(t)
+	// The editor reads the terminal, so the prompt must not
+	dir := t.TempDir()
+	ready := dir + "/ready"
+	script := dir + "/editor"
+	err := os.WriteFile(script, []byte("#!/bin/sh\ntouch "+ready+
+		"\nread line\nprintf '%s\\n' \"$line\" >\"$1\"\n"), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("VISUAL", script)
+
+	done := make(chan string)
+	go func() {
+		line, _ := s.Prompt("> ")
+		done <- line
+	}()
+	typed.Write([]byte("echo hi\x18\x05"))
+	for {
+		if _, err := os.Stat(ready); err == nil {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	typed.Write([]byte("echo edited\n"))
+	typed.Write([]byte("\r"))
+	select {
+	case line := <-done:
+		if line != "echo edited" {
+			t.Errorf("got %q, want \"echo edited\"", line)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the prompt did not return")
+	}
+}
+
+func TestScreen(t *testing.T) {
+	s, remote := newTestStream(t)
+	kw := Style{Fg: ColorBlue}
+	s.SetHighlighter(func(line string) []StyledSegment {
+		if strings.HasPrefix(line, "if") {
+			return []StyledSegment{{"if", kw}, {line[2:], Style{}}}
+		}
+		return []StyledSegment{{line, Style{}}}
+	})
+	s.SetHinter(func(line string, pos int) (string, Style) {
+		return "then what?", Style{Dim: true}
+	})
+	s.SetRightPrompt(func() string { return "12:00" })
+	s.SetStatus("ready")
+
+	if rows := s.Screen(); rows != nil {
+		t.Errorf("got %v before the Prompt, want nil", rows)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.Prompt("> ")
+	}()
+	remote.Write([]byte("if x"))
+	var rows []ScreenRow
+	for i := 0; i < 100; i++ {
+		rows = s.Screen()
+		if len(rows) > 0 && rows[0].String() == "> if x" {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	want := []ScreenRow{
+		{
+			Kind:     LineRow,
+			Segments: []StyledSegment{{"> ", Style{}}, {"if", kw}, {" x", Style{}}},
+			Right:    []StyledSegment{{"12:00", Style{}}},
+		},
+		{Kind: HintRow, Segments: []StyledSegment{{"then what?", Style{Dim: true}}}},
+		{Kind: StatusRow, Segments: []StyledSegment{{"ready", Style{}}}},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("got %#v, want %#v", rows, want)
+	}
+	remote.Write([]byte("\r"))
+	<-done
+	if rows := s.Screen(); rows != nil {
+		t.Errorf("got %v after the Prompt, want nil", rows)
+	}
+}
+
+// fakeRenderer records what it is asked to draw.
+type fakeRenderer struct {
+	mu    sync.Mutex
+	drawn []string
+}
+
+func (r *fakeRenderer) draw(format string, a ...interface{}) {
+	r.mu.Lock()
+	r.drawn = append(r.drawn, fmt.Sprintf(format, a...))
+	r.mu.Unlock()
+}
+
+func (r *fakeRenderer) Write(p []byte) (int, error) {
+	r.draw("%s", p)
+	return len(p), nil
+}
+
+func (r *fakeRenderer) MoveCursor(column int)            { r.draw("[col %d]", column) }
+func (r *fakeRenderer) MoveUp(rows int)                  { r.draw("[up %d]", rows) }
+func (r *fakeRenderer) MoveDown(rows int)                { r.draw("[down %d]", rows) }
+func (r *fakeRenderer) EraseLine()                       { r.draw("[erase line]") }
+func (r *fakeRenderer) EraseBelow()                      { r.draw("[erase below]") }
+func (r *fakeRenderer) EraseScreen()                     { r.draw("[erase screen]") }
+func (r *fakeRenderer) SetCursorShape(shape CursorShape) { r.draw("[cursor %d]", shape) }
+
+func TestRenderer(t *testing.T) {
+	conn, remote := net.Pipe()
+	defer remote.Close()
+	var out strings.Builder
+	copied := make(chan struct{})
+	go func() {
+		io.Copy(&out, remote)
+		close(copied)
+	}()
+	s := NewStream(conn, "xterm", 80, 24, nil)
+	var r fakeRenderer
+	s.SetRenderer(&r)
+
+	go remote.Write([]byte("ab\x1b[D\r"))
+	if line, err := s.Prompt("> "); err != nil || line != "ab" {
+		t.Errorf("got %q, %v, want %q", line, err, "ab")
+	}
+	s.Close()
+	conn.Close()
+	<-copied
+
+	drawn := strings.Join(r.drawn, "")
+	want := "[col 0]> ab[erase line][col 3]"
+	if !strings.Contains(drawn, want) {
+		t.Errorf("drew %q, want it to hold %q", drawn, want)
+	}
+	if strings.Contains(out.String(), "> ") {
+		t.Errorf("the terminal was drawn on: %q", out.String())
+	}
+}
+
+func TestReadKey(t *testing.T) {
+	s, remote := newTestStream(t)
+
+	go remote.Write([]byte("a\x1b[A\x1bx\r"))
+	for _, want := range []Key{{Rune: 'a'}, {Code: KeyUp}, {Rune: 'x', Mod: ModAlt}, {Rune: cr}} {
+		if k, err := s.ReadKey(); k != want || err != nil {
+			t.Errorf("got %v, %v; want %v", k, err, want)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := s.ReadKeyContext(ctx); err != context.DeadlineExceeded {
+		t.Errorf("got %v after the deadline", err)
+	}
+	s.SetCtrlCAborts(true)
+	go remote.Write([]byte("\x03"))
+	if _, err := s.ReadKey(); err != ErrPromptAborted {
+		t.Errorf("got %v for Ctrl-C", err)
+	}
+}
+
+func TestPromptWithTimeout(t *testing.T) {
+	conn, remote := net.Pipe()
+	defer remote.Close()
+	var out bytes.Buffer
+	var outMu sync.Mutex
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, err := remote.Read(buf)
+			outMu.Lock()
+			out.Write(buf[:n])
+			outMu.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}()
+	s := NewStream(conn, "xterm", 80, 24, nil)
+	defer s.Close()
+
+	go remote.Write([]byte("abc"))
+	start := time.Now()
+	line, err := s.PromptWithTimeout("> ", "yes", 1500*time.Millisecond)
+	if err != nil || line != "yes" {
+		t.Errorf("got %q, %v; want the default", line, err)
+	}
+	if elapsed := time.Since(start); elapsed < 1500*time.Millisecond {
+		t.Errorf("timed out after %v", elapsed)
+	}
+	outMu.Lock()
+	shown := out.String()
+	outMu.Unlock()
+	for _, want := range []string{"default in 2s", "default in 1s", "> yes"} {
+		if !strings.Contains(shown, want) {
+			t.Errorf("%q not displayed", want)
+		}
+	}
+
+	go remote.Write([]byte("no\r"))
+	if line, err := s.PromptWithTimeout("> ", "yes", time.Minute); line != "no" || err != nil {
+		t.Errorf("got %q, %v; want no", line, err)
+	}
+	go remote.Write([]byte("\r"))
+	if line, err := s.PromptWithTimeout("> ", "yes", time.Minute); line != "yes" || err != nil {
+		t.Errorf("got %q, %v for an empty line; want the default", line, err)
+	}
+
+	// A right prompt set during the countdown is kept after it
+	done := make(chan struct{})
+	go func() {
+		s.PromptWithTimeout("> ", "yes", time.Minute)
+		close(done)
+	}()
+	waitForLine(t, s, "> ")
+	s.SetRightPrompt(func() string { return "[rp]" })
+	remote.Write([]byte("\r"))
+	<-done
+	go remote.Write([]byte("a\r"))
+	if _, err := s.Prompt("> "); err != nil {
+		t.Fatal(err)
+	}
+	outMu.Lock()
+	shown = out.String()
+	outMu.Unlock()
+	if !strings.Contains(shown, "[rp]") {
+		t.Errorf("right prompt set during the countdown lost in %q", shown)
+	}
+}
+
+func TestRefreshChanged(t *testing.T) {
+	conn, remote := net.Pipe()
+	defer remote.Close()
+	go io.Copy(io.Discard, remote)
+	s := NewStream(conn, "xterm", 20, 24, nil)
+	defer s.Close()
+	var r fakeRenderer
+	s.SetRenderer(&r)
+	s.resetDisplay()
+
+	tests := []struct {
+		buf  string
+		pos  int
+		want string
+	}{
+		{"a", 1, "[col 0]> a[erase line][col 3]"},
+		{"ab", 2, "b"},
+		{"abc", 3, "c"},
+		{"abc", 1, "[col 3]"},
+		{"aXbc", 2, "Xbc[col 4]"},
+		{"abc", 1, "[col 3]bc[erase line][col 3]"},
+		{"ab́c", 3, "b́c[col 4]"},
+		{"ab́c", 1, "[col 3]"},
+		{"", 0, "[col 2][erase line]"},
+	}
+	for _, test := range tests {
+		r.drawn = nil
+		if err := s.refresh([]rune("> "), []rune(test.buf), test.pos); err != nil {
+			t.Fatal(err)
+		}
+		if drawn := strings.Join(r.drawn, ""); drawn != test.want {
+			t.Errorf("%q at %d: drew %q, want %q", test.buf, test.pos, drawn, test.want)
+		}
+	}
+
+	// A row drawn by something else is drawn again in full
+	s.emitNewLine()
+	r.drawn = nil
+	s.refresh([]rune("> "), []rune("ab"), 2)
+	if drawn, want := strings.Join(r.drawn, ""), "[col 0]> ab[erase line][col 4]"; drawn != want {
+		t.Errorf("after a newline, drew %q, want %q", drawn, want)
+	}
+}
+
+func TestReadClipboard(t *testing.T) {
+	tests := []struct {
+		name    string
+		reply   string
+		want    string
+		pending string
+		err     error
+	}{
+		{"BEL", "\x1b]52;c;aGVsbG8=\a", "hello", "", nil},
+		{"ST", "\x1b]52;c;aGVsbG8=\x1b\\", "hello", "", nil},
+		{"no selection", "\x1b]52;aGk=\a", "hi", "", nil},
+		{"typed first", "x\x1b]52;c;aGk=\a", "hi", "x", nil},
+		{"malformed", "\x1b]52;c;!!!\a", "", "", errNoClipboard},
+		{"empty", "\x1b]52;c;\a", "", "", errNoClipboard},
+		{"timeout", "", "", "", errNoClipboard},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, remote := newTestStream(t)
+			s.startReader()
+			if test.reply != "" {
+				go remote.Write([]byte(test.reply))
+			}
+			text, err := s.readClipboard()
+			if string(text) != test.want || err != test.err || string(s.pending) != test.pending {
+				t.Errorf("got %q, %v, %q pending; want %q, %v, %q pending",
+					string(text), err, string(s.pending), test.want, test.err, test.pending)
+			}
+			// Only a terminal that does not reply is not asked again
+			if s.noClipboard != (test.reply == "") {
+				t.Errorf("noClipboard is %v", s.noClipboard)
+			}
+		})
+	}
+}
+
+// writes records each write to it.
+type writes [][]byte
+
+func (w *writes) Write(p []byte) (int, error) {
+	*w = append(*w, append([]byte(nil), p...))
+	return len(p), nil
+}
+
+func TestRefreshBatched(t *testing.T) {
+	s, _ := newTestStream(t)
+	var w writes
+	s.out = &w
+	s.resetDisplay()
+
+	tests := []struct {
+		quirks TerminalQuirks
+		buf    string
+		want   string
+	}{
+		{0, "ab", "\x1b[?2026h\x1b[1G> ab\x1b[0K\x1b[5G\x1b[?2026l"},
+		{QuirkNoSyncUpdate, "abc", "c"},
+		{QuirkNoSyncUpdate, "", "\x1b[3G\x1b[0K"},
+	}
+	for _, test := range tests {
+		w = nil
+		s.SetTerminalQuirks(test.quirks)
+		if err := s.refresh([]rune("> "), []rune(test.buf), len(test.buf)); err != nil {
+			t.Fatal(err)
+		}
+		if len(w) != 1 || string(w[0]) != test.want {
+			t.Errorf("%q: wrote %q, want %q in one write", test.buf, w, test.want)
+		}
+	}
+}
+
+func TestPasteBurst(t *testing.T) {
+	s, remote := newTestStream(t)
+	// Long enough for a slow machine to read each key in time
+	s.SetPasteBurstGap(100 * time.Millisecond)
+	var completed sync.Once
+	called := make(chan struct{})
+	s.SetCompleter(func(line string) []string {
+		completed.Do(func() { close(called) })
+		return []string{line + "!"}
+	})
+	var r fakeRenderer
+	s.SetRenderer(&r)
+
+	const pasted = "0123456789\tabcdefghij"
+	go func() {
+		// One key at a time, as the terminal sends pasted text
+		for i := 0; i < len(pasted); i++ {
+			remote.Write([]byte{pasted[i]})
+		}
+		// The line is displayed when the burst ends
+		for {
+			rows := s.Screen()
+			if len(rows) > 0 && strings.Contains(rows[0].String(), "abcdefghij") {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+		remote.Write([]byte("\r"))
+	}()
+	line, err := s.Prompt("> ")
+	if err != nil || line != pasted {
+		t.Errorf("got %q, %v, want %q", line, err, pasted)
+	}
+	select {
+	case <-called:
+		t.Error("the pasted tab completed")
+	default:
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.drawn) >= len(pasted) {
+		t.Errorf("drew %d times for %d keys: %q", len(r.drawn), len(pasted), r.drawn)
+	}
+}
+
+func TestPasteBurstEndsWithPrompt(t *testing.T) {
+	s, remote := newTestStream(t)
+	s.SetPasteBurstGap(time.Second)
+	s.SetCompleter(func(line string) []string {
+		return []string{"done"}
+	})
+
+	// Seven keys for each prompt, all sent at once
+	go remote.Write([]byte("abcdef\rabcdef\r\t\r"))
+	for _, want := range []string{"abcdef", "abcdef", "done"} {
+		if line, err := s.Prompt("> "); err != nil || line != want {
+			t.Errorf("got %q, %v, want %q", line, err, want)
+		}
+	}
+}
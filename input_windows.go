@@ -4,8 +4,9 @@ import (
 	"bufio"
 	"os"
 	"syscall"
-	"unicode/utf16"
 	"unsafe"
+
+	"github.com/peterh/liner/term"
 )
 
 var (
@@ -13,12 +14,15 @@ var (
 
 	procGetStdHandle                  = kernel32.NewProc("GetStdHandle")
 	procReadConsoleInput              = kernel32.NewProc("ReadConsoleInputW")
+	procWriteConsoleInput             = kernel32.NewProc("WriteConsoleInputW")
 	procGetNumberOfConsoleInputEvents = kernel32.NewProc("GetNumberOfConsoleInputEvents")
 	procGetConsoleMode                = kernel32.NewProc("GetConsoleMode")
 	procSetConsoleMode                = kernel32.NewProc("SetConsoleMode")
 	procSetConsoleCursorPosition      = kernel32.NewProc("SetConsoleCursorPosition")
 	procGetConsoleScreenBufferInfo    = kernel32.NewProc("GetConsoleScreenBufferInfo")
 	procFillConsoleOutputCharacter    = kernel32.NewProc("FillConsoleOutputCharacterW")
+	procGetConsoleCursorInfo          = kernel32.NewProc("GetConsoleCursorInfo")
+	procSetConsoleCursorInfo          = kernel32.NewProc("SetConsoleCursorInfo")
 )
 
 // These names are from the Win32 api, so they use underscores (contrary to
@@ -39,8 +43,12 @@ type State struct {
 	hOut        syscall.Handle
 	origMode    inputMode
 	defaultMode inputMode
-	key         interface{}
-	repeat      uint16
+	cursorSize  uint32 // cursor size before a block cursor was shown
+	origOutMode uint32
+	in          *os.File
+	outFile     *os.File
+	vt          bool        // the console interprets VT escape sequences
+	events      *eventState // see Events
 }
 
 const (
@@ -53,32 +61,63 @@ const (
 	enableWindowInput    = 0x8
 )
 
+const (
+	enableVirtualTerminalProcessing = 0x4
+	disableNewlineAutoReturn        = 0x8
+)
+
 // NewLiner initializes a new *State, and sets the terminal into raw mode. To
 // restore the terminal to its previous state, call State.Close().
 func NewLiner(h History) *State {
+	return NewLinerFiles(os.Stdin, os.Stdout, h)
+}
+
+// NewLinerFiles initializes a new *State that reads from in and writes to
+// out, rather than standard input and output, and sets the terminal into
+// raw mode. A program whose standard input is a pipe can prompt on its
+// controlling terminal by opening /dev/tty (CONIN$ and CONOUT$ on Windows)
+// for both. Close does not close in or out.
+func NewLinerFiles(in, out *os.File, h History) *State {
 	var s State
 	if h == nil {
 		h = &sliceHistory{}
 	}
 	s.history = h
-	hIn, _, _ := procGetStdHandle.Call(uintptr(std_input_handle))
-	s.handle = syscall.Handle(hIn)
-	hOut, _, _ := procGetStdHandle.Call(uintptr(std_output_handle))
-	s.hOut = syscall.Handle(hOut)
+	s.in, s.outFile = in, out
+	s.out = out
+	s.handle = syscall.Handle(in.Fd())
+	s.hOut = syscall.Handle(out.Fd())
+
+	if ok, _, _ := procGetConsoleMode.Call(uintptr(s.hOut), uintptr(unsafe.Pointer(&s.origOutMode))); ok != 0 {
+		s.vt = s.enableVT()
+	}
+	if s.vt {
+		// Windows 10 and later render the same escape sequences as
+		// a Unix terminal
+		s.noColors = os.Getenv("NO_COLOR") != ""
+		s.noLinks = os.Getenv("WT_SESSION") == ""
+		s.colorDepth = ColorsTrue
+	} else {
+		// The legacy console API does not interpret ANSI escape
+		// sequences
+		s.noColors = true
+		s.noLinks = true
+		s.colorDepth = Colors16
+	}
 
 	s.terminalSupported = true
-	if m, err := TerminalMode(); err == nil {
-		s.origMode = m.(inputMode)
+	if m, err := getMode(s.handle); err == nil {
+		s.origMode = m
 		mode := s.origMode
 		mode &^= enableEchoInput
 		mode &^= enableInsertMode
 		mode &^= enableLineInput
 		mode &^= enableMouseInput
 		mode |= enableWindowInput
-		mode.ApplyMode()
+		mode.applyMode(s.handle)
 	} else {
 		s.inputRedirected = true
-		s.r = bufio.NewReader(os.Stdin)
+		s.r = bufio.NewReader(in)
 	}
 
 	s.getColumns()
@@ -112,52 +151,12 @@ type key_event_record struct {
 	ControlKeyState uint32
 }
 
-// These names are from the Win32 api, so they use underscores (contrary to
-// what golint suggests)
-const (
-	vk_back   = 0x08
-	vk_tab    = 0x09
-	vk_menu   = 0x12 // ALT key
-	vk_prior  = 0x21
-	vk_next   = 0x22
-	vk_end    = 0x23
-	vk_home   = 0x24
-	vk_left   = 0x25
-	vk_up     = 0x26
-	vk_right  = 0x27
-	vk_down   = 0x28
-	vk_insert = 0x2d
-	vk_delete = 0x2e
-	vk_f1     = 0x70
-	vk_f2     = 0x71
-	vk_f3     = 0x72
-	vk_f4     = 0x73
-	vk_f5     = 0x74
-	vk_f6     = 0x75
-	vk_f7     = 0x76
-	vk_f8     = 0x77
-	vk_f9     = 0x78
-	vk_f10    = 0x79
-	vk_f11    = 0x7a
-	vk_f12    = 0x7b
-	bKey      = 0x42
-	dKey      = 0x44
-	fKey      = 0x46
-	yKey      = 0x59
-)
-
-const (
-	shiftPressed     = 0x0010
-	leftAltPressed   = 0x0002
-	leftCtrlPressed  = 0x0008
-	rightAltPressed  = 0x0001
-	rightCtrlPressed = 0x0004
-
-	modKeys = shiftPressed | leftAltPressed | rightAltPressed | leftCtrlPressed | rightCtrlPressed
-)
-
-// inputWaiting only returns true if the next call to readNext will return immediately.
-func (s *State) inputWaiting() bool {
+// terminalWaiting only returns true if the next call to readTerminal will
+// return immediately.
+func (s *State) terminalWaiting() bool {
+	if len(s.typed) > 0 || s.fedWaiting() {
+		return true
+	}
 	var num uint32
 	ok, _, _ := procGetNumberOfConsoleInputEvents.Call(uintptr(s.handle), uintptr(unsafe.Pointer(&num)))
 	if ok == 0 {
@@ -171,19 +170,26 @@ func (s *State) inputWaiting() bool {
 	return num > 1
 }
 
-func (s *State) readNext() (interface{}, error) {
-	if s.repeat > 0 {
-		s.repeat--
-		return s.key, nil
-	}
+// readLiteral reads the next key typed. The console has already decoded it,
+// so this is the same as readTerminal.
+func (s *State) readLiteral() (interface{}, error) {
+	return s.readTerminal()
+}
 
+func (s *State) readTerminal() (interface{}, error) {
 	var input input_record
 	pbuf := uintptr(unsafe.Pointer(&input))
 	var rv uint32
 	prv := uintptr(unsafe.Pointer(&rv))
 
-	var surrogate uint16
-
+	if len(s.typed) > 0 {
+		v := s.typed[0]
+		s.typed = s.typed[1:]
+		return v, nil
+	}
+	if r, ok := s.nextFed(); ok {
+		return r, nil
+	}
 	for {
 		ok, _, err := procReadConsoleInput.Call(uintptr(s.handle), pbuf, 1, prv)
 
@@ -191,147 +197,151 @@ func (s *State) readNext() (interface{}, error) {
 			return nil, err
 		}
 
+		if input.eventType == menu_event {
+			// Menu events are otherwise ignored, so wakeReader sends one
+			return wake, nil
+		}
 		if input.eventType == window_buffer_size_event {
-			xy := (*coord)(unsafe.Pointer(&input.blob[0]))
-			s.columns = int(xy.x)
+			if s.events == nil {
+				// Otherwise the Prompt records the size of the
+				// ResizeEvent
+				xy := (*coord)(unsafe.Pointer(&input.blob[0]))
+				s.columns = int(xy.x)
+			}
 			return winch, nil
 		}
 		if input.eventType != key_event {
 			continue
 		}
 		ke := (*key_event_record)(unsafe.Pointer(&input.blob[0]))
-		if ke.KeyDown == 0 {
-			if ke.VirtualKeyCode == vk_menu && ke.Char > 0 {
-				// paste of unicode (eg. via ALT-numpad)
-				if surrogate > 0 {
-					return utf16.DecodeRune(rune(surrogate), rune(ke.Char)), nil
-				} else if utf16.IsSurrogate(rune(ke.Char)) {
-					surrogate = ke.Char
-					continue
-				} else {
-					return rune(ke.Char), nil
-				}
-			}
-			continue
+		key := s.win32Key(keyRecord{
+			down:   ke.KeyDown != 0,
+			repeat: ke.RepeatCount,
+			vk:     ke.VirtualKeyCode,
+			char:   ke.Char,
+			state:  ke.ControlKeyState,
+		})
+		if key != nil {
+			return key, nil
 		}
+	}
+}
 
-		if ke.VirtualKeyCode == vk_tab && ke.ControlKeyState&modKeys == shiftPressed {
-			s.key = shiftTab
-		} else if ke.VirtualKeyCode == vk_back && (ke.ControlKeyState&modKeys == leftAltPressed ||
-			ke.ControlKeyState&modKeys == rightAltPressed) {
-			s.key = altBs
-		} else if ke.VirtualKeyCode == bKey && (ke.ControlKeyState&modKeys == leftAltPressed ||
-			ke.ControlKeyState&modKeys == rightAltPressed) {
-			s.key = altB
-		} else if ke.VirtualKeyCode == dKey && (ke.ControlKeyState&modKeys == leftAltPressed ||
-			ke.ControlKeyState&modKeys == rightAltPressed) {
-			s.key = altD
-		} else if ke.VirtualKeyCode == fKey && (ke.ControlKeyState&modKeys == leftAltPressed ||
-			ke.ControlKeyState&modKeys == rightAltPressed) {
-			s.key = altF
-		} else if ke.VirtualKeyCode == yKey && (ke.ControlKeyState&modKeys == leftAltPressed ||
-			ke.ControlKeyState&modKeys == rightAltPressed) {
-			s.key = altY
-		} else if ke.Char > 0 {
-			if surrogate > 0 {
-				s.key = utf16.DecodeRune(rune(surrogate), rune(ke.Char))
-			} else if utf16.IsSurrogate(rune(ke.Char)) {
-				surrogate = ke.Char
-				continue
-			} else {
-				s.key = rune(ke.Char)
-			}
-		} else {
-			switch ke.VirtualKeyCode {
-			case vk_prior:
-				s.key = pageUp
-			case vk_next:
-				s.key = pageDown
-			case vk_end:
-				s.key = end
-			case vk_home:
-				s.key = home
-			case vk_left:
-				s.key = left
-				if ke.ControlKeyState&(leftCtrlPressed|rightCtrlPressed) != 0 {
-					if ke.ControlKeyState&modKeys == ke.ControlKeyState&(leftCtrlPressed|rightCtrlPressed) {
-						s.key = wordLeft
-					}
-				}
-			case vk_right:
-				s.key = right
-				if ke.ControlKeyState&(leftCtrlPressed|rightCtrlPressed) != 0 {
-					if ke.ControlKeyState&modKeys == ke.ControlKeyState&(leftCtrlPressed|rightCtrlPressed) {
-						s.key = wordRight
-					}
-				}
-			case vk_up:
-				s.key = up
-			case vk_down:
-				s.key = down
-			case vk_insert:
-				s.key = insert
-			case vk_delete:
-				s.key = del
-			case vk_f1:
-				s.key = f1
-			case vk_f2:
-				s.key = f2
-			case vk_f3:
-				s.key = f3
-			case vk_f4:
-				s.key = f4
-			case vk_f5:
-				s.key = f5
-			case vk_f6:
-				s.key = f6
-			case vk_f7:
-				s.key = f7
-			case vk_f8:
-				s.key = f8
-			case vk_f9:
-				s.key = f9
-			case vk_f10:
-				s.key = f10
-			case vk_f11:
-				s.key = f11
-			case vk_f12:
-				s.key = f12
-			default:
-				// Eat modifier keys
-				// TODO: return Action(Unknown) if the key isn't a
-				// modifier.
-				continue
-			}
-		}
+// wakeReader makes readTerminal return wake, if it is waiting for a key.
+func (s *State) wakeReader() {
+	input := input_record{eventType: menu_event}
+	var n uint32
+	procWriteConsoleInput.Call(uintptr(s.handle), uintptr(unsafe.Pointer(&input)), 1,
+		uintptr(unsafe.Pointer(&n)))
+}
 
-		if ke.RepeatCount > 1 {
-			s.repeat = ke.RepeatCount - 1
-		}
-		return s.key, nil
-	}
+// enableVT asks the console to interpret VT escape sequences, and reports
+// whether it does. Consoles before Windows 10 refuse.
+func (s *State) enableVT() bool {
+	mode := s.origOutMode | enableVirtualTerminalProcessing | disableNewlineAutoReturn
+	ok, _, _ := procSetConsoleMode.Call(uintptr(s.hOut), uintptr(mode))
+	return ok != 0
 }
 
 // Close returns the terminal to its previous mode
 func (s *State) Close() error {
-	s.origMode.ApplyMode()
-	return nil
+	s.stopEvents()
+	err := s.origMode.applyMode(s.handle)
+	if s.vt {
+		procSetConsoleMode.Call(uintptr(s.hOut), uintptr(s.origOutMode))
+	}
+	return err
 }
 
 func (s *State) startPrompt() {
-	if m, err := TerminalMode(); err == nil {
-		s.defaultMode = m.(inputMode)
+	if m, err := getMode(s.handle); err == nil {
+		s.defaultMode = m
 		mode := s.defaultMode
 		mode &^= enableProcessedInput
-		mode.ApplyMode()
+		mode.applyMode(s.handle)
 	}
 }
 
 func (s *State) restartPrompt() {
 }
 
+// suspendable reports false: Windows has no job control, so Ctrl-Z only
+// beeps.
+func (s *State) suspendable() bool {
+	return false
+}
+
+func (s *State) suspend() {
+}
+
+// defaultEditor is the editor run by Ctrl-X Ctrl-E if neither $VISUAL nor
+// $EDITOR is set.
+const defaultEditor = "notepad"
+
+// stopReader does nothing, since the console is only read when input is
+// needed.
+func (s *State) stopReader() {
+}
+
+// startReader does nothing, for the same reason.
+func (s *State) startReader() {
+}
+
+// haltReader does nothing, for the same reason.
+func (s *State) haltReader() {
+}
+
+// cursorRow returns the row of the console window where the cursor is,
+// counting from 0.
+func (s *State) cursorRow() (int, error) {
+	var sbi consoleScreenBufferInfo
+	procGetConsoleScreenBufferInfo.Call(uintptr(s.hOut), uintptr(unsafe.Pointer(&sbi)))
+	return int(sbi.dwCursorPosition.y - sbi.srWindow.top), nil
+}
+
+// readClipboard returns errNoClipboard: the console does not answer
+// OSC 52 queries.
+func (s *State) readClipboard() ([]rune, error) {
+	return nil, errNoClipboard
+}
+
+// pauseTerminal restores the console to its mode before NewLiner, for
+// another program to use during a prompt.
+func (s *State) pauseTerminal() {
+	s.showCursor(CursorDefault)
+	s.origMode.applyMode(s.handle)
+	if s.vt {
+		procSetConsoleMode.Call(uintptr(s.hOut), uintptr(s.origOutMode))
+	}
+}
+
+// resumeTerminal returns the console to the prompt's mode after
+// pauseTerminal.
+func (s *State) resumeTerminal() {
+	mode := s.defaultMode
+	mode &^= enableProcessedInput
+	mode.applyMode(s.handle)
+	if s.vt {
+		s.enableVT()
+	}
+	s.showCursor(s.modeCursorShape())
+}
+
 func (s *State) stopPrompt() {
-	s.defaultMode.ApplyMode()
+	s.defaultMode.applyMode(s.handle)
+}
+
+// cookTerminal returns the console to its mode before NewLiner, to read a
+// line without line editing, and returns a function that restores its
+// current mode.
+func (s *State) cookTerminal() (restore func()) {
+	m, err := getMode(s.handle)
+	s.origMode.applyMode(s.handle)
+	return func() {
+		if err == nil {
+			m.applyMode(s.handle)
+		}
+	}
 }
 
 // TerminalSupported returns true because line editing is always
@@ -340,12 +350,9 @@ func TerminalSupported() bool {
 	return true
 }
 
-func (mode inputMode) ApplyMode() error {
-	hIn, _, err := procGetStdHandle.Call(uintptr(std_input_handle))
-	if hIn == invalid_handle_value || hIn == 0 {
-		return err
-	}
-	ok, _, err := procSetConsoleMode.Call(hIn, uintptr(mode))
+// applyMode sets the mode of the console input h.
+func (mode inputMode) applyMode(h syscall.Handle) error {
+	ok, _, err := procSetConsoleMode.Call(uintptr(h), uintptr(mode))
 	if ok != 0 {
 		err = nil
 	}
@@ -355,14 +362,20 @@ func (mode inputMode) ApplyMode() error {
 // TerminalMode returns the current terminal input mode as an InputModeSetter.
 //
 // This function is provided for convenience, and should
-// not be necessary for most users of liner.
+// not be necessary for most users of liner. It is term.TerminalMode, so the
+// ModeApplier is a *term.Mode.
 func TerminalMode() (ModeApplier, error) {
-	var mode inputMode
-	hIn, _, err := procGetStdHandle.Call(uintptr(std_input_handle))
-	if hIn == invalid_handle_value || hIn == 0 {
+	mode, err := term.TerminalMode()
+	if err != nil {
 		return nil, err
 	}
-	ok, _, err := procGetConsoleMode.Call(hIn, uintptr(unsafe.Pointer(&mode)))
+	return mode, nil
+}
+
+// getMode returns the mode of the console input h.
+func getMode(h syscall.Handle) (inputMode, error) {
+	var mode inputMode
+	ok, _, err := procGetConsoleMode.Call(uintptr(h), uintptr(unsafe.Pointer(&mode)))
 	if ok != 0 {
 		err = nil
 	}
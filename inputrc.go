@@ -0,0 +1,529 @@
+package liner
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// inputrcFunctions maps the readline command names to Actions.
+var inputrcFunctions = map[string]Action{
+	"beginning-of-line":       ActionBeginningOfLine,
+	"end-of-line":             ActionEndOfLine,
+	"backward-char":           ActionBackwardChar,
+	"forward-char":            ActionForwardChar,
+	"backward-word":           ActionBackwardWord,
+	"forward-word":            ActionForwardWord,
+	"delete-char":             ActionDeleteChar,
+	"backward-delete-char":    ActionBackwardDeleteChar,
+	"kill-line":               ActionKillLine,
+	"unix-line-discard":       ActionUnixLineDiscard,
+	"unix-word-rubout":        ActionUnixWordRubout,
+	"kill-word":               ActionKillWord,
+	"backward-kill-word":      ActionBackwardKillWord,
+	"yank":                    ActionYank,
+	"yank-pop":                ActionYankPop,
+	"previous-history":        ActionPreviousHistory,
+	"next-history":            ActionNextHistory,
+	"reverse-search-history":  ActionReverseSearchHistory,
+	"transpose-chars":         ActionTransposeChars,
+	"clear-screen":            ActionClearScreen,
+	"complete":                ActionComplete,
+	"menu-complete":           ActionComplete,
+	"menu-complete-backward":  ActionCompletePrevious,
+	"accept-line":             ActionAcceptLine,
+	"start-kbd-macro":         ActionStartKbdMacro,
+	"end-kbd-macro":           ActionEndKbdMacro,
+	"call-last-kbd-macro":     ActionCallLastKbdMacro,
+	"undo":                    ActionUndo,
+	"digit-argument":          ActionDigitArgument,
+	"universal-argument":      ActionUniversalArgument,
+	"yank-last-arg":           ActionYankLastArg,
+	"yank-nth-arg":            ActionYankNthArg,
+	"upcase-word":             ActionUpcaseWord,
+	"downcase-word":           ActionDowncaseWord,
+	"capitalize-word":         ActionCapitalizeWord,
+	"transpose-words":         ActionTransposeWords,
+	"quoted-insert":           ActionQuotedInsert,
+	"overwrite-mode":          ActionOverwriteMode,
+	"kill-whole-line":         ActionKillWholeLine,
+	"edit-command-line":       ActionEditCommandLine,
+	"set-mark":                ActionSetMark,
+	"exchange-point-and-mark": ActionExchangePointAndMark,
+	"kill-region":             ActionKillRegion,
+	"copy-region-as-kill":     ActionCopyRegionAsKill,
+	"paste-from-clipboard":    ActionPasteClipboard,
+}
+
+// inputrcKeyNames are the key names readline accepts in bindings such as
+// "Control-u: unix-line-discard".
+var inputrcKeyNames = map[string]rune{
+	"del":     '\x7f',
+	"rubout":  '\x7f',
+	"esc":     '\x1b',
+	"escape":  '\x1b',
+	"lfd":     '\n',
+	"newline": '\n',
+	"ret":     '\r',
+	"return":  '\r',
+	"space":   ' ',
+	"spc":     ' ',
+	"tab":     '\t',
+}
+
+// inputrcSequences are the escape sequences sent by special keys, as they
+// are written in quoted key sequences such as "\e[A".
+var inputrcSequences = map[string]Key{
+	"[A":    {Code: KeyUp},
+	"OA":    {Code: KeyUp},
+	"[B":    {Code: KeyDown},
+	"OB":    {Code: KeyDown},
+	"[C":    {Code: KeyRight},
+	"OC":    {Code: KeyRight},
+	"[D":    {Code: KeyLeft},
+	"OD":    {Code: KeyLeft},
+	"[H":    {Code: KeyHome},
+	"OH":    {Code: KeyHome},
+	"[1~":   {Code: KeyHome},
+	"[7~":   {Code: KeyHome},
+	"[F":    {Code: KeyEnd},
+	"OF":    {Code: KeyEnd},
+	"[4~":   {Code: KeyEnd},
+	"[8~":   {Code: KeyEnd},
+	"[2~":   {Code: KeyInsert},
+	"[3~":   {Code: KeyDelete},
+	"[5~":   {Code: KeyPageUp},
+	"[6~":   {Code: KeyPageDown},
+	"[Z":    {Rune: '\t', Mod: ModShift},
+	"[1;5C": {Code: KeyRight, Mod: ModCtrl},
+	"Oc":    {Code: KeyRight, Mod: ModCtrl},
+	"[1;5D": {Code: KeyLeft, Mod: ModCtrl},
+	"Od":    {Code: KeyLeft, Mod: ModCtrl},
+	"OP":    {Code: KeyF1},
+	"OQ":    {Code: KeyF2},
+	"OR":    {Code: KeyF3},
+	"OS":    {Code: KeyF4},
+	"[15~":  {Code: KeyF5},
+	"[17~":  {Code: KeyF6},
+	"[18~":  {Code: KeyF7},
+	"[19~":  {Code: KeyF8},
+	"[20~":  {Code: KeyF9},
+	"[21~":  {Code: KeyF10},
+	"[23~":  {Code: KeyF11},
+	"[24~":  {Code: KeyF12},
+}
+
+// LoadInputrc reads the user's readline init file: the file named by the
+// INPUTRC environment variable, ~/.inputrc, or /etc/inputrc, whichever is
+// found first. It is not an error for none of them to exist. See ReadInputrc
+// for the meaning of app.
+func (s *State) LoadInputrc(app string) error {
+	var names []string
+	if name := os.Getenv("INPUTRC"); name != "" {
+		names = append(names, name)
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		names = append(names, filepath.Join(home, ".inputrc"))
+	}
+	names = append(names, "/etc/inputrc")
+	for _, name := range names {
+		f, err := os.Open(name)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return s.readInputrc(f, app, 0)
+	}
+	return nil
+}
+
+// ReadInputrc configures s from a readline init file, so that the
+// customizations users have made for readline also apply to liner.
+//
+// Key bindings to the readline commands liner implements and to macros are
+// supported, in the emacs keymaps. The variables bell-style,
+// completion-ignore-case, completion-query-items, editing-mode, keymap and
+// page-completions are supported, as are the $if, $else, $endif and
+// $include directives. app is the application name matched by $if; it may
+// be empty.
+//
+// Other commands and variables are ignored, as they are by readline when it
+// does not recognize them. If a line cannot be parsed, the rest of the file
+// is still applied, and the first such error returned.
+func (s *State) ReadInputrc(r io.Reader, app string) error {
+	return s.readInputrc(r, app, 0)
+}
+
+// maxInputrcDepth limits the nesting of $include directives.
+const maxInputrcDepth = 10
+
+func (s *State) readInputrc(r io.Reader, app string, depth int) error {
+	var firstErr error
+	fail := func(n int, format string, args ...interface{}) {
+		if firstErr == nil {
+			firstErr = fmt.Errorf("liner: inputrc line %d: %s", n, fmt.Sprintf(format, args...))
+		}
+	}
+
+	keymap := "emacs"
+	// skip[i] is true when the lines in the i'th nested $if are skipped
+	var skip []bool
+	skipping := func() bool {
+		return len(skip) > 0 && skip[len(skip)-1]
+	}
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		if line[0] == '$' {
+			directive, arg := cutSpace(line[1:])
+			switch directive {
+			case "if":
+				skip = append(skip, skipping() || !s.inputrcTest(arg, app, keymap))
+			case "else":
+				if len(skip) == 0 {
+					fail(n, "$else without $if")
+					continue
+				}
+				outer := len(skip) > 1 && skip[len(skip)-2]
+				skip[len(skip)-1] = outer || !skip[len(skip)-1]
+			case "endif":
+				if len(skip) == 0 {
+					fail(n, "$endif without $if")
+					continue
+				}
+				skip = skip[:len(skip)-1]
+			case "include":
+				if skipping() {
+					continue
+				}
+				if depth >= maxInputrcDepth {
+					fail(n, "$include nested too deeply")
+					continue
+				}
+				err := s.includeInputrc(expandHome(arg), app, depth+1)
+				if err != nil && firstErr == nil {
+					firstErr = err
+				}
+			default:
+				fail(n, "unknown directive $%s", directive)
+			}
+			continue
+		}
+		if skipping() {
+			continue
+		}
+
+		if cmd, rest := cutSpace(line); cmd == "set" {
+			name, value := cutSpace(rest)
+			if name == "keymap" || name == "editing-mode" {
+				keymap = value
+			} else {
+				s.setInputrcVariable(strings.ToLower(name), value)
+			}
+			continue
+		}
+
+		keys, rhs, err := parseInputrcBinding(line)
+		if err != nil {
+			fail(n, "%v", err)
+			continue
+		}
+		switch keymap {
+		case "emacs", "emacs-standard":
+		case "emacs-meta":
+			keys = append([]Key{{Rune: '\x1b'}}, keys...)
+		case "emacs-ctlx":
+			keys = append([]Key{{Rune: '\x18'}}, keys...)
+		default:
+			// vi keymaps are not supported
+			continue
+		}
+		keys = metaKeys(keys)
+
+		if rhs != "" && (rhs[0] == '"' || rhs[0] == '\'') {
+			macro, _, err := parseInputrcString(rhs)
+			if err != nil {
+				fail(n, "%v", err)
+				continue
+			}
+			s.bindKeys(keys, binding{macro: metaKeys(macro)})
+		} else if a, ok := inputrcFunctions[strings.ToLower(firstField(rhs))]; ok {
+			s.bindKeys(keys, binding{action: a})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if len(skip) > 0 && firstErr == nil {
+		firstErr = fmt.Errorf("liner: inputrc: missing $endif")
+	}
+	return firstErr
+}
+
+func (s *State) includeInputrc(name, app string, depth int) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return s.readInputrc(f, app, depth)
+}
+
+// inputrcTest evaluates the condition of an $if directive.
+func (s *State) inputrcTest(cond, app, keymap string) bool {
+	cond = strings.TrimSpace(cond)
+	if name, value, ok := strings.Cut(cond, "="); ok {
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		switch name {
+		case "mode":
+			return value == "emacs" && strings.HasPrefix(keymap, "emacs")
+		case "term":
+			term := os.Getenv("TERM")
+			short, _, _ := strings.Cut(term, "-")
+			return value == term || value == short
+		}
+		return false
+	}
+	return app != "" && strings.EqualFold(cond, app)
+}
+
+func (s *State) setInputrcVariable(name, value string) {
+	on := strings.EqualFold(value, "on") || value == "1"
+	switch name {
+	case "bell-style":
+		s.noBeep = strings.ToLower(value) != "audible"
+	case "prefer-visible-bell":
+		s.noBeep = on
+	case "completion-ignore-case":
+		if on {
+			s.matcher = PrefixFoldMatcher
+		} else {
+			s.matcher = nil
+		}
+	case "completion-query-items":
+		if n, err := strconv.Atoi(value); err == nil {
+			s.SetCompletionQueryItems(n)
+		}
+	case "page-completions":
+		s.noPaging = !on
+	}
+}
+
+// parseInputrcBinding splits a key binding line into its keys and the
+// command or macro they are bound to.
+func parseInputrcBinding(line string) ([]Key, string, error) {
+	if line[0] == '"' {
+		keys, rest, err := parseInputrcString(line)
+		if err != nil {
+			return nil, "", err
+		}
+		rest = strings.TrimSpace(rest)
+		if !strings.HasPrefix(rest, ":") {
+			return nil, "", fmt.Errorf("missing colon after key sequence")
+		}
+		if len(keys) == 0 {
+			return nil, "", fmt.Errorf("empty key sequence")
+		}
+		return keys, strings.TrimSpace(rest[1:]), nil
+	}
+	// The key may itself be a colon, as in ":: self-insert"
+	i := strings.IndexByte(line[1:], ':') + 1
+	if i == 0 {
+		return nil, "", fmt.Errorf("missing colon after key name")
+	}
+	k, ok := parseInputrcKeyName(line[:i])
+	if !ok {
+		return nil, "", fmt.Errorf("unknown key name %q", line[:i])
+	}
+	return []Key{k}, strings.TrimSpace(line[i+1:]), nil
+}
+
+// parseInputrcKeyName parses a key name such as "Control-u" or "Meta-Rubout".
+func parseInputrcKeyName(name string) (Key, bool) {
+	var k Key
+	ctrl := false
+	for {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "control-") {
+			ctrl, name = true, name[len("control-"):]
+		} else if strings.HasPrefix(lower, "meta-") {
+			k.Mod, name = ModAlt, name[len("meta-"):]
+		} else if len(name) > 2 && (lower[:2] == "c-" || lower[:2] == "m-") {
+			if lower[0] == 'c' {
+				ctrl = true
+			} else {
+				k.Mod = ModAlt
+			}
+			name = name[2:]
+		} else {
+			break
+		}
+	}
+	if r, ok := inputrcKeyNames[strings.ToLower(name)]; ok && len(name) > 1 {
+		k.Rune = r
+	} else if utf8.RuneCountInString(name) == 1 {
+		k.Rune, _ = utf8.DecodeRuneInString(name)
+	} else {
+		return k, false
+	}
+	if ctrl {
+		k.Rune = controlRune(k.Rune)
+	}
+	return k, true
+}
+
+// controlRune returns the character typed with Ctrl and r.
+func controlRune(r rune) rune {
+	if r == '?' {
+		return '\x7f'
+	}
+	if r >= 'a' && r <= 'z' {
+		r -= 'a' - 'A'
+	}
+	return r & 0x1f
+}
+
+// parseInputrcString parses a quoted key sequence or macro at the start of
+// s, returning its keys and the text after the closing quote. Esc followed
+// by another key is returned as those two keys; see metaKeys.
+func parseInputrcString(s string) ([]Key, string, error) {
+	quote := s[0]
+	var keys []Key
+	i := 1
+	for i < len(s) && s[i] != quote {
+		var k Key
+		k, i = parseInputrcChar(s, i)
+		keys = append(keys, k)
+	}
+	if i >= len(s) {
+		return nil, "", fmt.Errorf("unterminated string")
+	}
+	return keys, s[i+1:], nil
+}
+
+// parseInputrcChar parses a possibly escaped character at s[i], returning
+// it and the index following it.
+func parseInputrcChar(s string, i int) (Key, int) {
+	if s[i] != '\\' || i+1 >= len(s) {
+		r, n := utf8.DecodeRuneInString(s[i:])
+		return Key{Rune: r}, i + n
+	}
+	i++
+	if strings.HasPrefix(s[i:], "C-") && i+2 < len(s) {
+		k, j := parseInputrcChar(s, i+2)
+		k.Rune = controlRune(k.Rune)
+		return k, j
+	}
+	if strings.HasPrefix(s[i:], "M-") && i+2 < len(s) {
+		k, j := parseInputrcChar(s, i+2)
+		k.Mod |= ModAlt
+		return k, j
+	}
+	switch c := s[i]; c {
+	case 'e':
+		return Key{Rune: '\x1b'}, i + 1
+	case 'a':
+		return Key{Rune: '\a'}, i + 1
+	case 'b':
+		return Key{Rune: '\b'}, i + 1
+	case 'd':
+		return Key{Rune: '\x7f'}, i + 1
+	case 'f':
+		return Key{Rune: '\f'}, i + 1
+	case 'n':
+		return Key{Rune: '\n'}, i + 1
+	case 'r':
+		return Key{Rune: '\r'}, i + 1
+	case 't':
+		return Key{Rune: '\t'}, i + 1
+	case 'v':
+		return Key{Rune: '\v'}, i + 1
+	case 'x':
+		j := i + 1
+		for j < len(s) && j < i+3 && isHexDigit(s[j]) {
+			j++
+		}
+		v, _ := strconv.ParseUint(s[i+1:j], 16, 8)
+		return Key{Rune: rune(v)}, j
+	case '0', '1', '2', '3', '4', '5', '6', '7':
+		j := i
+		for j < len(s) && j < i+3 && s[j] >= '0' && s[j] <= '7' {
+			j++
+		}
+		v, _ := strconv.ParseUint(s[i:j], 8, 8)
+		return Key{Rune: rune(v)}, j
+	}
+	r, n := utf8.DecodeRuneInString(s[i:])
+	return Key{Rune: r}, i + n
+}
+
+func isHexDigit(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
+
+// metaKeys decodes the escape sequences of special keys in keys, and
+// combines Esc with the key following it into an Alt key, the way the
+// prompt reads them from the terminal.
+func metaKeys(keys []Key) []Key {
+	var out []Key
+	for i := 0; i < len(keys); i++ {
+		if keys[i] != (Key{Rune: '\x1b'}) || i+1 == len(keys) {
+			out = append(out, keys[i])
+			continue
+		}
+		var seq strings.Builder
+		found := false
+		for j := i + 1; j < len(keys) && j < i+6 && keys[j].Mod == 0; j++ {
+			seq.WriteRune(keys[j].Rune)
+			if k, ok := inputrcSequences[seq.String()]; ok {
+				out = append(out, k)
+				i, found = j, true
+				break
+			}
+		}
+		if !found {
+			k := keys[i+1]
+			k.Mod |= ModAlt
+			out = append(out, k)
+			i++
+		}
+	}
+	return out
+}
+
+// cutSpace splits s at the first run of white space.
+func cutSpace(s string) (string, string) {
+	i := strings.IndexAny(s, " \t")
+	if i < 0 {
+		return s, ""
+	}
+	return s[:i], strings.TrimSpace(s[i:])
+}
+
+func firstField(s string) string {
+	f, _ := cutSpace(s)
+	return f
+}
+
+// expandHome replaces a leading ~ in name with the user's home directory.
+func expandHome(name string) string {
+	if name == "~" || strings.HasPrefix(name, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, name[1:])
+		}
+	}
+	return name
+}
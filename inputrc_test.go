@@ -0,0 +1,67 @@
+package liner
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReadInputrc(t *testing.T) {
+	const inputrc = `
+# comment
+set bell-style none
+set completion-ignore-case on
+set completion-query-items 50
+"\C-xx": kill-line
+Control-o: "\C-e; ls\r"
+Meta-Rubout: backward-kill-word
+"\e[A": reverse-search-history
+"\M-.": yank
+"\C-t": no-such-function
+$if mode=emacs
+"\C-xe": end-of-line
+$else
+"\C-xe": beginning-of-line
+$endif
+$if myapp
+"\C-xm": accept-line
+$endif
+$if otherapp
+"\C-xo": accept-line
+$endif
+set keymap emacs-ctlx
+"\C-u": unix-line-discard
+set keymap vi-insert
+"\C-a": accept-line
+"bad
+`
+	var s State
+	err := s.ReadInputrc(strings.NewReader(inputrc), "myapp")
+	if err == nil || !strings.Contains(err.Error(), "line 27") {
+		t.Errorf("expected error on line 27, got %v", err)
+	}
+	if !s.noBeep || s.matcher == nil || s.queryItems != 50 {
+		t.Error("variables were not set")
+	}
+
+	want := map[string]binding{
+		"C-x x":       {action: ActionKillLine},
+		"M-Backspace": {action: ActionBackwardKillWord},
+		"Up":          {action: ActionReverseSearchHistory},
+		"M-.":         {action: ActionYank},
+		"C-x e":       {action: ActionEndOfLine},
+		"C-x m":       {action: ActionAcceptLine},
+		"C-x C-u":     {action: ActionUnixLineDiscard},
+	}
+	for c, b := range want {
+		if got, ok := s.bindings[c]; !ok || got.action != b.action {
+			t.Errorf("%s: got %v, want %v", c, got, b)
+		}
+	}
+	macro := s.bindings["C-o"].macro
+	if chordString(macro) != "C-e ; Space l s Enter" {
+		t.Errorf("got macro %q", chordString(macro))
+	}
+	if len(s.bindings) != len(want)+1 {
+		t.Errorf("got %d bindings, want %d", len(s.bindings), len(want)+1)
+	}
+}
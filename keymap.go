@@ -0,0 +1,465 @@
+package liner
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// KeyCode identifies a special (non-character) key.
+type KeyCode int
+
+// The special keys. The zero value means the Key is a character key.
+const (
+	KeyUp KeyCode = iota + 1
+	KeyDown
+	KeyLeft
+	KeyRight
+	KeyHome
+	KeyEnd
+	KeyInsert
+	KeyDelete
+	KeyPageUp
+	KeyPageDown
+	KeyF1
+	KeyF2
+	KeyF3
+	KeyF4
+	KeyF5
+	KeyF6
+	KeyF7
+	KeyF8
+	KeyF9
+	KeyF10
+	KeyF11
+	KeyF12
+)
+
+// Modifier is a set of modifier keys held down with a Key.
+type Modifier uint8
+
+// Ctrl and Shift are only reported for special keys and Tab, and Ctrl for
+// Backspace; for other character keys they are already part of the rune (Ctrl-A is rune 1,
+// Shift-a is 'A').
+const (
+	ModAlt Modifier = 1 << iota
+	ModCtrl
+	ModShift
+)
+
+// Key is a single keystroke.
+type Key struct {
+	Rune rune    // the character typed, if Code is zero
+	Code KeyCode // the special key pressed
+	Mod  Modifier
+}
+
+var keyCodeNames = map[KeyCode]string{
+	KeyUp:       "Up",
+	KeyDown:     "Down",
+	KeyLeft:     "Left",
+	KeyRight:    "Right",
+	KeyHome:     "Home",
+	KeyEnd:      "End",
+	KeyInsert:   "Insert",
+	KeyDelete:   "Delete",
+	KeyPageUp:   "PageUp",
+	KeyPageDown: "PageDown",
+	KeyF1:       "F1",
+	KeyF2:       "F2",
+	KeyF3:       "F3",
+	KeyF4:       "F4",
+	KeyF5:       "F5",
+	KeyF6:       "F6",
+	KeyF7:       "F7",
+	KeyF8:       "F8",
+	KeyF9:       "F9",
+	KeyF10:      "F10",
+	KeyF11:      "F11",
+	KeyF12:      "F12",
+}
+
+var keyRuneNames = map[rune]string{
+	9:   "Tab",
+	13:  "Enter",
+	27:  "Esc",
+	32:  "Space",
+	127: "Backspace",
+}
+
+// String returns the key in the notation accepted by KeyChord, such as
+// "C-a", "M-." or "S-Tab".
+func (k Key) String() string {
+	var b strings.Builder
+	if k.Mod&ModCtrl != 0 {
+		b.WriteString("C-")
+	}
+	if k.Mod&ModAlt != 0 {
+		b.WriteString("M-")
+	}
+	if k.Mod&ModShift != 0 {
+		b.WriteString("S-")
+	}
+	switch name, ok := keyRuneNames[k.Rune]; {
+	case k.Code != 0:
+		b.WriteString(keyCodeNames[k.Code])
+	case ok:
+		b.WriteString(name)
+	case k.Rune == 0:
+		b.WriteString("C-@")
+	case k.Rune < 27:
+		b.WriteString("C-")
+		b.WriteRune('a' + k.Rune - 1)
+	case k.Rune < 32:
+		b.WriteString("C-")
+		b.WriteRune('@' + k.Rune)
+	default:
+		b.WriteRune(k.Rune)
+	}
+	return b.String()
+}
+
+// KeyChord is a sequence of one or more keys separated by spaces, written
+// in Emacs notation: "C-x C-e" is Ctrl-X followed by Ctrl-E, "M-." is
+// Alt-. and "S-Tab" is Shift-Tab. Special keys are named Up, Down, Left,
+// Right, Home, End, Insert, Delete, PageUp, PageDown and F1 to F12;
+// Tab, Enter, Esc, Space and Backspace name the corresponding characters.
+type KeyChord string
+
+// Keys parses the chord into its keys.
+func (c KeyChord) Keys() ([]Key, error) {
+	fields := strings.Fields(string(c))
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("liner: empty key chord")
+	}
+	keys := make([]Key, len(fields))
+	for i, f := range fields {
+		k, ok := parseKey(f)
+		if !ok {
+			return nil, fmt.Errorf("liner: invalid key %q in chord %q", f, c)
+		}
+		keys[i] = k
+	}
+	return keys, nil
+}
+
+func parseKey(s string) (Key, bool) {
+	var k Key
+	for len(s) > 2 && s[1] == '-' {
+		switch s[0] {
+		case 'C':
+			k.Mod |= ModCtrl
+		case 'M':
+			k.Mod |= ModAlt
+		case 'S':
+			k.Mod |= ModShift
+		default:
+			return k, false
+		}
+		s = s[2:]
+	}
+	if utf8.RuneCountInString(s) == 1 {
+		k.Rune, _ = utf8.DecodeRuneInString(s)
+	} else {
+		found := false
+		for code, name := range keyCodeNames {
+			if strings.EqualFold(s, name) {
+				k.Code, found = code, true
+			}
+		}
+		for r, name := range keyRuneNames {
+			if strings.EqualFold(s, name) {
+				k.Rune, found = r, true
+			}
+		}
+		if !found {
+			return k, false
+		}
+	}
+	if k.Code != 0 || k.Rune == 9 || k.Rune == 127 && k.Mod&ModShift == 0 {
+		return k, true
+	}
+	if k.Mod&ModShift != 0 {
+		return k, false
+	}
+	if k.Mod&ModCtrl != 0 {
+		k.Mod &^= ModCtrl
+		switch r := k.Rune; {
+		case r >= 'a' && r <= 'z', r >= '@' && r <= '_':
+			k.Rune = r & 0x1f
+		case r == ' ':
+			k.Rune = 0
+		case r == '?':
+			k.Rune = 127
+		default:
+			return k, false
+		}
+	}
+	return k, true
+}
+
+// chordString returns the canonical form of keys, used to look up bindings.
+func chordString(keys []Key) string {
+	names := make([]string, len(keys))
+	for i, k := range keys {
+		names[i] = k.String()
+	}
+	return strings.Join(names, " ")
+}
+
+// Action is an editing command that can be bound to a KeyChord.
+type Action int
+
+// The editing commands available to Bind. ActionNone does nothing and
+// can be bound to disable a key. ActionDigitArgument must be bound to a
+// chord ending in a digit or '-'; it and ActionUniversalArgument begin a
+// numeric argument, which repeats the next command. ActionKillWholeLine and
+// ActionKillRegion are not bound by default; Emacs users may want to bind
+// ActionKillRegion to C-w. The region is the text between the mark, set by
+// ActionSetMark, and the cursor. ActionPasteClipboard inserts the system
+// clipboard (see SetClipboard).
+const (
+	ActionNone Action = iota
+	ActionBeginningOfLine
+	ActionEndOfLine
+	ActionBackwardChar
+	ActionForwardChar
+	ActionBackwardWord
+	ActionForwardWord
+	ActionDeleteChar
+	ActionBackwardDeleteChar
+	ActionKillLine
+	ActionUnixLineDiscard
+	ActionUnixWordRubout
+	ActionKillWord
+	ActionBackwardKillWord
+	ActionYank
+	ActionYankPop
+	ActionPreviousHistory
+	ActionNextHistory
+	ActionReverseSearchHistory
+	ActionTransposeChars
+	ActionClearScreen
+	ActionComplete
+	ActionCompletePrevious
+	ActionAcceptLine
+	ActionAbort
+	ActionStartKbdMacro
+	ActionEndKbdMacro
+	ActionCallLastKbdMacro
+	ActionUndo
+	ActionRedo
+	ActionDigitArgument
+	ActionUniversalArgument
+	ActionYankLastArg
+	ActionYankNthArg
+	ActionUpcaseWord
+	ActionDowncaseWord
+	ActionCapitalizeWord
+	ActionTransposeWords
+	ActionQuotedInsert
+	ActionOverwriteMode
+	ActionKillWholeLine
+	ActionEditCommandLine
+	ActionSetMark
+	ActionExchangePointAndMark
+	ActionKillRegion
+	ActionCopyRegionAsKill
+	ActionPasteClipboard
+)
+
+type binding struct {
+	action Action
+	fn     func(*Buffer) error
+	macro  []Key // keys typed in place of the chord
+}
+
+// defaultBindings holds the multi-key chords bound by default. Single keys
+// are handled directly by the prompt.
+var defaultBindings = map[string]Action{
+	"C-x (":   ActionStartKbdMacro,
+	"C-x )":   ActionEndKbdMacro,
+	"C-x e":   ActionCallLastKbdMacro,
+	"C-x C-e": ActionEditCommandLine,
+	"C-@":     ActionSetMark,
+	"C-x C-x": ActionExchangePointAndMark,
+	"M-w":     ActionCopyRegionAsKill,
+	"C-x C-y": ActionPasteClipboard,
+	"C-_":     ActionUndo,
+	"C-x C-u": ActionUndo,
+	"M-_":     ActionRedo,
+	"M-0":     ActionDigitArgument,
+	"M-1":     ActionDigitArgument,
+	"M-2":     ActionDigitArgument,
+	"M-3":     ActionDigitArgument,
+	"M-4":     ActionDigitArgument,
+	"M-5":     ActionDigitArgument,
+	"M-6":     ActionDigitArgument,
+	"M-7":     ActionDigitArgument,
+	"M-8":     ActionDigitArgument,
+	"M-9":     ActionDigitArgument,
+	"M--":     ActionDigitArgument,
+	"M-.":     ActionYankLastArg,
+	"M-C-y":   ActionYankNthArg,
+	"M-u":     ActionUpcaseWord,
+	"M-l":     ActionDowncaseWord,
+	"M-c":     ActionCapitalizeWord,
+	"M-t":     ActionTransposeWords,
+}
+
+// Bind binds chord to action, replacing any previous binding of chord,
+// including the default one.
+func (s *State) Bind(chord KeyChord, action Action) error {
+	return s.bind(chord, binding{action: action})
+}
+
+// BindFunc binds chord to f. When the chord is typed, f is called with the
+// line being edited; any changes it makes to the Buffer are displayed. If f
+// returns an error, the prompt is ended and the error returned from it.
+func (s *State) BindFunc(chord KeyChord, f func(*Buffer) error) error {
+	return s.bind(chord, binding{fn: f})
+}
+
+// Unbind removes the binding of chord made with Bind or BindFunc, restoring
+// the default behaviour of the keys.
+func (s *State) Unbind(chord KeyChord) error {
+	keys, err := chord.Keys()
+	if err != nil {
+		return err
+	}
+	delete(s.bindings, chordString(keys))
+	return nil
+}
+
+func (s *State) bind(chord KeyChord, b binding) error {
+	keys, err := chord.Keys()
+	if err != nil {
+		return err
+	}
+	s.bindKeys(keys, b)
+	return nil
+}
+
+func (s *State) bindKeys(keys []Key, b binding) {
+	if s.bindings == nil {
+		s.bindings = make(map[string]binding)
+	}
+	s.bindings[chordString(keys)] = b
+}
+
+// binding returns the binding for the canonical chord c.
+func (s *State) binding(c string) (binding, bool) {
+	if b, ok := s.bindings[c]; ok {
+		return b, true
+	}
+	if a, ok := defaultBindings[c]; ok {
+		return binding{action: a}, true
+	}
+	return binding{}, false
+}
+
+// isChordPrefix reports whether the canonical chord c begins a longer
+// bound chord.
+func (s *State) isChordPrefix(c string) bool {
+	c += " "
+	for k := range s.bindings {
+		if strings.HasPrefix(k, c) {
+			return true
+		}
+	}
+	for k := range defaultBindings {
+		if strings.HasPrefix(k, c) {
+			return true
+		}
+	}
+	return false
+}
+
+// PlayMacro queues keys to be read by the prompt as if they had been typed.
+// Keys left over when a prompt ends are read by the next one.
+func (s *State) PlayMacro(keys []Key) {
+	s.playMacro(keys)
+}
+
+func (s *State) playMacro(keys []Key) {
+	unread := make([]interface{}, len(keys), len(keys)+len(s.unread))
+	for i, k := range keys {
+		unread[i] = k
+	}
+	s.unread = append(unread, s.unread...)
+}
+
+// LastMacro returns the keys recorded by the last keyboard macro, which is
+// recorded between Ctrl-X ( and Ctrl-X ) and played with Ctrl-X e.
+func (s *State) LastMacro() []Key {
+	return append([]Key(nil), s.macro...)
+}
+
+// Buffer is the line being edited, as seen by functions bound with
+// BindFunc and by a BufferCompleter. Positions are counted in runes, and
+// are clamped to the line.
+type Buffer struct {
+	line []rune
+	pos  int
+}
+
+// Text returns the contents of the line.
+func (b *Buffer) Text() string {
+	return string(b.line)
+}
+
+// Cursor returns the cursor position.
+func (b *Buffer) Cursor() int {
+	return b.pos
+}
+
+// SetCursor moves the cursor to pos.
+func (b *Buffer) SetCursor(pos int) {
+	b.pos = b.clamp(pos)
+}
+
+// Insert inserts text at the cursor and moves the cursor past it.
+func (b *Buffer) Insert(text string) {
+	r := []rune(text)
+	line := make([]rune, 0, len(b.line)+len(r))
+	line = append(line, b.line[:b.pos]...)
+	line = append(line, r...)
+	b.line = append(line, b.line[b.pos:]...)
+	b.pos += len(r)
+}
+
+// Delete deletes the text from start to end.
+func (b *Buffer) Delete(start, end int) {
+	b.Replace(start, end, "")
+}
+
+// Replace replaces the text from start to end with text. A cursor after
+// the replaced text stays with the text following it; a cursor within it
+// moves to the end of the new text.
+func (b *Buffer) Replace(start, end int, text string) {
+	start, end = b.clamp(start), b.clamp(end)
+	if start > end {
+		start, end = end, start
+	}
+	r := []rune(text)
+	line := make([]rune, 0, len(b.line)-(end-start)+len(r))
+	line = append(line, b.line[:start]...)
+	line = append(line, r...)
+	b.line = append(line, b.line[end:]...)
+	switch {
+	case b.pos >= end:
+		b.pos += len(r) - (end - start)
+	case b.pos > start:
+		b.pos = start + len(r)
+	}
+}
+
+func (b *Buffer) clamp(pos int) int {
+	if pos < 0 {
+		return 0
+	}
+	if pos > len(b.line) {
+		return len(b.line)
+	}
+	return pos
+}
@@ -0,0 +1,89 @@
+package liner
+
+import "testing"
+
+func TestKeyChord(t *testing.T) {
+	for _, c := range []struct {
+		chord KeyChord
+		want  string
+	}{
+		{"a", "a"},
+		{"C-a", "C-a"},
+		{"C-X c-e", ""},
+		{"C-X C-E", "C-x C-e"},
+		{"M-.", "M-."},
+		{"M--", "M--"},
+		{"C-M-h", "M-C-h"},
+		{"S-Tab", "S-Tab"},
+		{"tab", "Tab"},
+		{"C-i", "Tab"},
+		{"C-m", "Enter"},
+		{"C-[", "Esc"},
+		{"C-?", "Backspace"},
+		{"C-Backspace", "C-Backspace"},
+		{"S-Backspace", ""},
+		{"C-_", "C-_"},
+		{"C-Left", "C-Left"},
+		{"M-pageup", "M-PageUp"},
+		{"F12", "F12"},
+		{"  Esc   b ", "Esc b"},
+		{"é", "é"},
+		{"S-a", ""},
+		{"C-1", ""},
+		{"Foo", ""},
+		{"", ""},
+	} {
+		keys, err := c.chord.Keys()
+		if c.want == "" {
+			if err == nil {
+				t.Errorf("%q: expected error, got %v", c.chord, keys)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", c.chord, err)
+			continue
+		}
+		if got := chordString(keys); got != c.want {
+			t.Errorf("%q: got %q, want %q", c.chord, got, c.want)
+		}
+	}
+}
+
+func TestBuffer(t *testing.T) {
+	b := Buffer{line: []rune("hello"), pos: 5}
+	b.SetCursor(0)
+	b.Insert("¡")
+	b.SetCursor(99)
+	b.Insert("!")
+	if b.Text() != "¡hello!" || b.Cursor() != 7 {
+		t.Errorf("got %q at %d", b.Text(), b.Cursor())
+	}
+}
+
+func TestBufferReplace(t *testing.T) {
+	for _, c := range []struct {
+		pos, start, end int
+		text            string
+		want            string
+		wantPos         int
+	}{
+		{9, 0, 2, "git", "git commit", 10},
+		{1, 0, 2, "git", "git commit", 3},
+		{0, 0, 2, "git", "git commit", 0},
+		{9, 2, 0, "", " commit", 7},
+		{9, -5, 99, "x", "x", 1},
+	} {
+		b := Buffer{line: []rune("gc commit"), pos: c.pos}
+		b.Replace(c.start, c.end, c.text)
+		if b.Text() != c.want || b.Cursor() != c.wantPos {
+			t.Errorf("Replace(%d, %d, %q) at %d: got %q at %d, want %q at %d",
+				c.start, c.end, c.text, c.pos, b.Text(), b.Cursor(), c.want, c.wantPos)
+		}
+	}
+	b := Buffer{line: []rune("hello, world"), pos: 12}
+	b.Delete(5, 12)
+	if b.Text() != "hello" || b.Cursor() != 5 {
+		t.Errorf("Delete: got %q at %d", b.Text(), b.Cursor())
+	}
+}
@@ -5,12 +5,14 @@ package liner
 
 import (
 	"bufio"
-	"container/ring"
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
-	"os"
+	"sort"
 	"strings"
+	"time"
 	"unicode"
 	"unicode/utf8"
 )
@@ -41,13 +43,37 @@ const (
 	f11
 	f12
 	altB
-	altBs // Alt+Backspace
+	altBs  // Alt+Backspace
+	ctrlBs // Ctrl+Backspace
 	altD
 	altF
 	altY
 	shiftTab
 	wordLeft
 	wordRight
+	startMacro
+	endMacro
+	callMacro
+	undo
+	redo
+	digitArg
+	universalArg
+	yankLastArg
+	yankNthArg
+	upcaseWord
+	downcaseWord
+	capitalizeWord
+	transposeWords
+	killWholeLine
+	editCommandLine
+	setMark
+	exchangeMark
+	killRegion
+	copyRegion
+	pasteClipboard
+	pasteStart
+	pasteEnd
+	wake // another goroutine queued a change with runAsync
 	winch
 	unknown
 )
@@ -85,8 +111,46 @@ const (
 
 const (
 	beep = "\a"
+
+	enableBracketedPaste  = "\x1b[?2004h"
+	disableBracketedPaste = "\x1b[?2004l"
+
+	// Report mouse buttons in the SGR format
+	enableMouse  = "\x1b[?1000h\x1b[?1006h"
+	disableMouse = "\x1b[?1006l\x1b[?1000l"
+)
+
+// mouseEvent is the input produced by a mouse report. The buttons are
+// numbered as in the SGR report, and x and y count from 0 at the top left
+// of the screen.
+type mouseEvent struct {
+	button  int
+	x, y    int
+	release bool
+}
+
+const (
+	mouseWheel   = 64 // added to wheelUp and wheelDown
+	mouseMotion  = 32
+	mouseButtons = 3
 )
 
+// click reports whether e is a press of the left button.
+func (e mouseEvent) click() bool {
+	return !e.release && e.button&(mouseWheel|mouseMotion|mouseButtons) == 0
+}
+
+// wheel returns -1 or 1 if e is the wheel turned up or down, and 0 otherwise.
+func (e mouseEvent) wheel() int {
+	switch e.button &^ 0x1c { // without Shift, Alt and Ctrl
+	case mouseWheel:
+		return -1
+	case mouseWheel + 1:
+		return 1
+	}
+	return 0
+}
+
 type tabDirection int
 
 const (
@@ -94,50 +158,346 @@ const (
 	tabReverse
 )
 
+// refresh draws the prompt and the line, with the cursor at pos, and the
+// rows below them. What it draws reaches the terminal in one write.
 func (s *State) refresh(prompt []rune, buf []rune, pos int) error {
+	return s.batch(func() error {
+		return s.draw(prompt, buf, pos)
+	})
+}
+
+func (s *State) draw(prompt []rune, buf []rune, pos int) error {
+	s.applyConfig()
 	if s.columns == 0 {
 		return ErrInternal
 	}
 
 	s.needRefresh = false
-	if s.multiLineMode {
-		return s.refreshMultiLine(prompt, buf, pos)
+	s.layoutColumns = s.columns
+	if s.hinter != nil {
+		hint, style := s.hinter(string(buf), pos)
+		s.hint = s.fitLines(hint, style)
+	}
+	s.bufStyles = s.runeStyles(buf, pos)
+	buf, pos, s.bufStyles = s.displayed(buf, pos, s.bufStyles)
+	var err error
+	if !s.lineBreaks && containsRune(buf, '\n') {
+		s.lineBreaks = true
+	}
+	if s.multiLine() {
+		err = s.refreshMultiLine(prompt, buf, pos)
+	} else {
+		err = s.refreshSingleLine(prompt, buf, pos)
 	}
-	return s.refreshSingleLine(prompt, buf, pos)
-}
-
-func (s *State) refreshSingleLine(prompt []rune, buf []rune, pos int) error {
-	s.cursorPos(0)
-	_, err := fmt.Print(string(prompt))
 	if err != nil {
 		return err
 	}
+	s.refreshBelow(s.belowLines())
+	return nil
+}
+
+// isControl reports whether r is a control character that the terminal
+// would not display, other than a newline.
+func isControl(r rune) bool {
+	return r < ' ' && r != '\n' || r >= 0x7f && r < 0xa0
+}
+
+// controlText returns the text displayed for the control character r.
+func (s *State) controlText(r rune) string {
+	switch {
+	case s.controlDisplay == ControlHex || r >= 0x80:
+		return fmt.Sprintf("\\x%02x", r)
+	case r == 0x7f:
+		return "^?"
+	}
+	return "^" + string(r+'@')
+}
+
+// displayed returns buf as it is displayed, with tabs expanded and control
+// characters made visible, along with the position of pos and the styles of
+// the displayed runes.
+func (s *State) displayed(buf []rune, pos int, styles []Style) ([]rune, int, []Style) {
+	plain := true
+	for _, r := range buf {
+		if isControl(r) {
+			plain = false
+			break
+		}
+	}
+	if plain {
+		return buf, pos, styles
+	}
+	width := s.tabWidth
+	if width == 0 {
+		width = DefaultTabWidth
+	}
+	var out []rune
+	var outStyles []Style
+	outPos := pos
+	col, seg := 0, 0 // the column at which out[seg:] starts
+	for i, r := range buf {
+		if i == pos {
+			outPos = len(out)
+		}
+		n := len(out)
+		switch {
+		case r == '\n':
+			out = append(out, r)
+			col, seg = 0, len(out)
+		case r == tab && width < 0:
+			out = append(out, '⇥')
+		case r == tab:
+			col += countGlyphs(out[seg:])
+			spaces := width - col%width
+			for j := 0; j < spaces; j++ {
+				out = append(out, ' ')
+			}
+			col, seg = col+spaces, len(out)
+		case isControl(r):
+			out = append(out, []rune(s.controlText(r))...)
+		default:
+			out = append(out, r)
+		}
+		if styles != nil {
+			for ; n < len(out); n++ {
+				outStyles = append(outStyles, styles[i])
+			}
+		}
+	}
+	if pos >= len(buf) {
+		outPos = len(out)
+	}
+	return out, outPos, outStyles
+}
+
+// multiLine reports whether the line is displayed across several rows,
+// either in multi-line mode or because it has held a newline. Once it has,
+// it keeps being displayed that way until the Prompt ends.
+func (s *State) multiLine() bool {
+	return s.multiLineMode || s.lineBreaks
+}
+
+// containsRune reports whether r is in buf.
+func containsRune(buf []rune, r rune) bool {
+	for _, c := range buf {
+		if c == r {
+			return true
+		}
+	}
+	return false
+}
+
+// printRightPrompt prints the right prompt flush right on the current row,
+// unless it would reach the first used columns (or the cursor just after
+// them), and returns what it printed. The last column is left empty, so
+// that the terminal does not wrap.
+func (s *State) printRightPrompt(used int) string {
+	rp, col := s.rightPromptText(used)
+	if rp != "" {
+		s.cursorPos(col)
+		fmt.Fprint(s.display(), rp)
+	}
+	return rp
+}
+
+// rightPromptText returns the right prompt that printRightPrompt prints
+// after used columns, and the column where it starts, or "" if it prints
+// none.
+func (s *State) rightPromptText(used int) (string, int) {
+	right := s.rightPrompt
+	if s.countdown != nil {
+		right = s.countdown
+	}
+	if right == nil {
+		return "", 0
+	}
+	rp, err := s.checkPrompt(right())
+	if err != nil || rp == "" {
+		return "", 0
+	}
+	col := s.columns - 1 - countGlyphs(visibleRunes([]rune(rp)))
+	if col <= used {
+		return "", 0
+	}
+	return rp, col
+}
+
+// belowLines returns the rows to display below the edited line, and what
+// each holds.
+func (s *State) belowLines() ([]string, []RowKind) {
+	var lines []string
+	var kinds []RowKind
+	add := func(kind RowKind, rows ...string) {
+		lines = append(lines, rows...)
+		for range rows {
+			kinds = append(kinds, kind)
+		}
+	}
+	add(MessageRow, s.message...)
+	add(HintRow, s.hint...)
+	add(MenuRow, s.menu...)
+	if s.spinner != "" {
+		add(SpinnerRow, s.spinner)
+	}
+	add(StatusRow, s.fitLines(s.status, Style{})...)
+	return lines, kinds
+}
+
+// fitLines splits text into lines, truncates each line to fit in the
+// terminal, and displays the lines in style.
+func (s *State) fitLines(text string, style Style) []string {
+	if text == "" {
+		return nil
+	}
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		line = string(getPrefixGlyphs([]rune(line), s.columns-1))
+		lines[i] = s.styled(style, line)
+	}
+	return lines
+}
+
+// refreshBelow draws lines, of the given kinds, below the edited line,
+// erases any rows left over from a previous call, and returns the cursor to
+// where refresh placed it. Each line must fit within the terminal width.
+func (s *State) refreshBelow(lines []string, kinds []RowKind) {
+	// The row of the line is left as it is
+	drawn := s.drawn
+	defer func() { s.drawn = drawn }()
+
+	shown := make([]shownRow, len(lines))
+	for i, line := range lines {
+		shown[i] = shownRow{kind: kinds[i], text: line}
+	}
+	s.asyncMu.Lock()
+	s.shownBelow = shown
+	s.asyncMu.Unlock()
+
+	n := len(lines)
+	if stale := s.belowEnd - s.lineRows; stale > n {
+		n = stale
+	}
+	s.belowEnd = s.lineRows + len(lines)
+	if n <= 0 {
+		return
+	}
+	if s.rowsBelowCursor > 0 {
+		s.moveDown(s.rowsBelowCursor)
+	}
+	for i := 0; i < n; i++ {
+		fmt.Fprint(s.display(), "\n")
+		s.cursorPos(0)
+		s.eraseLine()
+		if i < len(lines) {
+			fmt.Fprint(s.display(), lines[i])
+		}
+	}
+	s.moveUp(n + s.rowsBelowCursor)
+	s.cursorPos(s.cursorCol)
+}
+
+// clearBelow erases everything drawn below the edited line.
+func (s *State) clearBelow() {
+	s.message = nil
+	s.menu = nil
+	s.hint = nil
+	s.refreshBelow(nil, nil)
+}
 
-	pLen := countGlyphs(prompt)
+func (s *State) refreshSingleLine(prompt []rune, buf []rune, pos int) error {
+	drawn := s.drawn
+	pLen := countGlyphs(visibleRunes(prompt))
 	bLen := countGlyphs(buf)
 	// on some OS / terminals extra column is needed to place the cursor char
 	if cursorColumn {
 		bLen++
 	}
 	pos = countGlyphs(buf[:pos])
+	s.lineRows = 1
+	s.rowsBelowCursor = 0
+	text := string(prompt) + renderStyled(buf, s.bufStyles)
+	var right string
+	var err error
 	if pLen+bLen < s.columns {
-		_, err = fmt.Print(string(buf))
-		s.eraseLine()
-		s.cursorPos(pLen + pos)
+		s.scroll = 0
+		row := drawnRow{prompt: string(prompt), columns: s.columns}
+		row.text = append(row.text, buf...)
+		row.styles = append(row.styles, s.bufStyles...)
+		for len(row.styles) < len(row.text) {
+			row.styles = append(row.styles, Style{})
+		}
+		used := pLen + bLen
+		if s.ghost != "" && pLen+bLen+countGlyphs([]rune(s.ghost)) < s.columns {
+			text += Style{Dim: true}.render(s.ghost)
+			for _, r := range s.ghost {
+				row.text = append(row.text, r)
+				row.styles = append(row.styles, Style{Dim: true})
+			}
+			used += countGlyphs([]rune(s.ghost))
+		}
+		var col int
+		right, col = s.rightPromptText(used)
+		row.right = right
+		if drawn != nil && drawn.prompt == row.prompt && drawn.right == right &&
+			drawn.columns == row.columns {
+			s.redrawChanged(drawn, &row, pLen, col, pLen+pos)
+		} else {
+			s.cursorPos(0)
+			if _, err = fmt.Fprint(s.display(), text); err != nil {
+				return err
+			}
+			s.eraseLine()
+			if right != "" {
+				s.cursorPos(col)
+				fmt.Fprint(s.display(), right)
+			}
+			s.cursorCol = pLen + pos
+			s.cursorPos(s.cursorCol)
+		}
+		if s.secret {
+			wipeRunes(row.text)
+		} else {
+			s.drawn = &row
+		}
 	} else {
+		s.cursorPos(0)
+		if _, err = fmt.Fprint(s.display(), string(prompt)); err != nil {
+			return err
+		}
 		// Find space available
 		space := s.columns - pLen
 		space-- // space for cursor
-		start := pos - space/2
-		end := start + space
-		if end > bLen {
-			end = bLen
-			start = end - space
+
+		// Scroll only as far as needed to keep the margin around the
+		// cursor, beside the markers
+		margin := s.scrollMargin
+		if margin == 0 {
+			margin = DefaultScrollMargin
+		}
+		if max := (space - 3) / 2; margin > max {
+			margin = max
+		}
+		if margin < 0 {
+			margin = 0
+		}
+		start := s.scroll
+		if start > 0 && pos < start+1+margin {
+			start = pos - 1 - margin
+		}
+		if pos > start+space-2-margin {
+			start = pos + 2 + margin - space
+		}
+		if start > bLen-space {
+			start = bLen - space
 		}
 		if start < 0 {
 			start = 0
-			end = space
 		}
+		end := start + space
+		if end > bLen {
+			end = bLen
+		}
+		s.scroll = start
 		pos -= start
 
 		// Leave space for markers
@@ -147,28 +507,114 @@ func (s *State) refreshSingleLine(prompt []rune, buf []rune, pos int) error {
 		if end < bLen {
 			end--
 		}
-		startRune := len(getPrefixGlyphs(buf, start))
-		line := getPrefixGlyphs(buf[startRune:], end-start)
+		startRune := len(getPrefixColumns(buf, start))
+		shown := countGlyphs(buf[:startRune])
+		if shown < start {
+			// Skip a wide character cut by the start, leaving a space
+			startRune += graphemeLen(buf[startRune:])
+			shown = countGlyphs(buf[:startRune])
+		}
+		line := getPrefixColumns(buf[startRune:], end-shown)
+		var styles []Style
+		if s.bufStyles != nil {
+			styles = s.bufStyles[startRune : startRune+len(line)]
+		}
 
 		// Output
 		if start > 0 {
-			fmt.Print("{")
+			fmt.Fprint(s.display(), "<")
 		}
-		fmt.Print(string(line))
+		fmt.Fprint(s.display(), strings.Repeat(" ", shown-start))
+		fmt.Fprint(s.display(), renderStyled(line, styles))
 		if end < bLen {
-			fmt.Print("}")
+			fmt.Fprint(s.display(), ">")
 		}
 
 		// Set cursor position
 		s.eraseLine()
-		s.cursorPos(pLen + pos)
+		s.cursorCol = pLen + pos
+		s.cursorPos(s.cursorCol)
+		s.drawn = nil
+	}
+	if s.secret {
+		// Screen shows the prompt alone
+		text = string(prompt)
 	}
+	s.recordLine([]shownRow{{kind: LineRow, text: text, right: right}})
 	return err
 }
 
+// recordLine keeps rows, the rows of the line as they are displayed, for
+// Screen.
+func (s *State) recordLine(rows []shownRow) {
+	s.asyncMu.Lock()
+	s.shownLine = rows
+	s.asyncMu.Unlock()
+}
+
+// redrawChanged draws row, whose prompt is pLen columns wide and right
+// prompt starts at column col, over drawn, which has the same prompt and
+// right prompt: it moves the cursor to the first glyph that changed,
+// draws the text from there, and erases what is left of drawn, so that a
+// key typed on a long line does not draw it all again over a slow link.
+// It then moves the cursor to column cursor.
+func (s *State) redrawChanged(drawn, row *drawnRow, pLen, col, cursor int) {
+	k := 0
+	for k < len(drawn.text) && k < len(row.text) &&
+		drawn.text[k] == row.text[k] && drawn.styles[k] == row.styles[k] {
+		k++
+	}
+	// A combining mark changes the glyph it follows
+	for {
+		start := min(graphemeStart(drawn.text, k), graphemeStart(row.text, k))
+		if start == k {
+			break
+		}
+		k = start
+	}
+
+	at := s.cursorCol
+	if k < len(row.text) || k < len(drawn.text) {
+		from := pLen + countGlyphs(row.text[:k])
+		if from != at {
+			s.cursorPos(from)
+		}
+		fmt.Fprint(s.display(), renderStyled(row.text[k:], row.styles[k:]))
+		at = from + countGlyphs(row.text[k:])
+		if countGlyphs(row.text) < countGlyphs(drawn.text) {
+			s.eraseLine()
+			if row.right != "" {
+				s.cursorPos(col)
+				fmt.Fprint(s.display(), row.right)
+				at = -1
+			}
+		}
+	}
+	s.cursorCol = cursor
+	if at != cursor {
+		s.cursorPos(cursor)
+	}
+}
+
+// graphemeStart returns the start of the grapheme of buf that holds the
+// rune at i, or len(buf) if i is past its end.
+func graphemeStart(buf []rune, i int) int {
+	if i >= len(buf) {
+		return len(buf)
+	}
+	start := 0
+	for {
+		n := graphemeLen(buf[start:])
+		if start+n > i {
+			return start
+		}
+		start += n
+	}
+}
+
 func (s *State) refreshMultiLine(prompt []rune, buf []rune, pos int) error {
-	promptColumns := countMultiLineGlyphs(prompt, s.columns, 0)
-	totalColumns := countMultiLineGlyphs(buf, s.columns, promptColumns)
+	promptColumns := countMultiLineGlyphs(visibleRunes(prompt), s.columns, 0)
+	totalColumns := s.rowsColumns(buf, promptColumns)
 	// on some OS / terminals extra column is needed to place the cursor char
 	// if cursorColumn {
 	//	totalColumns++
@@ -178,6 +624,11 @@ func (s *State) refreshMultiLine(prompt []rune, buf []rune, pos int) error {
 	// and always emit a newline if we are at the screen end, so no worarounds needed there
 
 	totalRows := (totalColumns + s.columns - 1) / s.columns
+	// a final newline starts a row of its own
+	endsRow := len(buf) > 0 && buf[len(buf)-1] == '\n' && totalColumns%s.columns == 0
+	if endsRow {
+		totalRows++
+	}
 	maxRows := s.maxRows
 	if totalRows > s.maxRows {
 		s.maxRows = totalRows
@@ -205,17 +656,17 @@ func (s *State) refreshMultiLine(prompt []rune, buf []rune, pos int) error {
 	s.eraseLine()
 
 	/* Write the prompt and the current buffer content */
-	if _, err := fmt.Print(string(prompt)); err != nil {
-		return err
-	}
-	if _, err := fmt.Print(string(buf)); err != nil {
+	if _, err := fmt.Fprint(s.display(), string(prompt)); err != nil {
 		return err
 	}
+	rows := s.printRows(buf, promptColumns)
+	rows[0].text = string(prompt) + rows[0].text
+	s.recordLine(rows)
 
 	/* If we are at the very end of the screen with our prompt, we need to
 	 * emit a newline and move the prompt to the first column. */
-	cursorColumns := countMultiLineGlyphs(buf[:pos], s.columns, promptColumns)
-	if cursorColumns == totalColumns && totalColumns%s.columns == 0 {
+	cursorColumns := s.rowsColumns(buf[:pos], promptColumns)
+	if cursorColumns == totalColumns && totalColumns%s.columns == 0 && !endsRow {
 		s.emitNewLine()
 		s.cursorPos(0)
 		totalRows++
@@ -226,24 +677,88 @@ func (s *State) refreshMultiLine(prompt []rune, buf []rune, pos int) error {
 
 	/* Move cursor to right position. */
 	cursorRows = (cursorColumns + s.columns) / s.columns
+	s.lineRows = totalRows
+	s.rowsBelowCursor = 0
 	if s.cursorRows > 0 && totalRows-cursorRows > 0 {
 		s.moveUp(totalRows - cursorRows)
+		s.rowsBelowCursor = totalRows - cursorRows
 	}
 	/* Set column. */
-	s.cursorPos(cursorColumns % s.columns)
+	s.cursorCol = cursorColumns % s.columns
+	s.cursorPos(s.cursorCol)
 
 	s.cursorRows = cursorRows
 	return nil
 }
 
+// rowsColumns returns the column (counted across rows) reached by
+// displaying buf from column start, with the continuation prompt after each
+// newline.
+func (s *State) rowsColumns(buf []rune, start int) int {
+	if !containsRune(buf, '\n') {
+		return countMultiLineGlyphs(buf, s.columns, start)
+	}
+	var rows []rune
+	row := 0
+	for _, r := range buf {
+		rows = append(rows, r)
+		if r == '\n' {
+			row++
+			rows = append(rows, visibleRunes(s.continuationPrompt(row))...)
+		}
+	}
+	return countMultiLineGlyphs(rows, s.columns, start)
+}
+
+// printRows prints buf from column start (counted across rows), with the
+// continuation prompt after each newline, erasing the rest of each row it
+// ends, which may hold text displayed below a shorter line. It returns the
+// rows it printed, for Screen.
+func (s *State) printRows(buf []rune, start int) []shownRow {
+	var rows []shownRow
+	var cont string // the continuation prompt of the row
+	columns := start
+	for i, row := 0, 0; ; row++ {
+		j := i
+		for j < len(buf) && buf[j] != '\n' {
+			j++
+		}
+		var styles []Style
+		if s.bufStyles != nil {
+			styles = s.bufStyles[i:j]
+		}
+		text := renderStyled(buf[i:j], styles)
+		fmt.Fprint(s.display(), text)
+		end := countMultiLineGlyphs(buf[i:j], s.columns, columns)
+		if j == i || end%s.columns != 0 {
+			s.eraseLine()
+		}
+		var right string
+		if row == 0 && end < s.columns {
+			right = s.printRightPrompt(end)
+		}
+		rows = append(rows, shownRow{kind: LineRow, text: cont + text, right: right})
+		columns = end
+		if j == len(buf) {
+			return rows
+		}
+		fmt.Fprint(s.display(), "\n")
+		columns = countMultiLineGlyphs(buf[j:j+1], s.columns, columns)
+		cont = string(s.continuationPrompt(row + 1))
+		fmt.Fprint(s.display(), cont)
+		columns = countMultiLineGlyphs(visibleRunes([]rune(cont)), s.columns, columns)
+		i = j + 1
+	}
+}
+
 func (s *State) resetMultiLine(prompt []rune, buf []rune, pos int) {
-	columns := countMultiLineGlyphs(prompt, s.columns, 0)
-	columns = countMultiLineGlyphs(buf[:pos], s.columns, columns)
+	columns := countMultiLineGlyphs(visibleRunes(prompt), s.columns, 0)
+	columns = s.rowsColumns(buf[:pos], columns)
 	columns += 2 // ^C
 	cursorRows := (columns + s.columns) / s.columns
 	if s.maxRows-cursorRows > 0 {
 		for i := 0; i < s.maxRows-cursorRows; i++ {
-			fmt.Println() // always moves the cursor down or scrolls the window up as needed
+			fmt.Fprintln(s.display()) // always moves the cursor down or scrolls the window up as needed
 		}
 	}
 	s.maxRows = 1
@@ -288,11 +803,12 @@ func (s *State) circularTabs(items []string) func(tabDirection) (string, error)
 
 func calculateColumns(screenWidth int, items []string) (numColumns, numRows, maxWidth int) {
 	for _, item := range items {
-		if len(item) >= screenWidth {
+		width := countGlyphs([]rune(item))
+		if width >= screenWidth {
 			return 1, len(items), screenWidth - 1
 		}
-		if len(item) >= maxWidth {
-			maxWidth = len(item) + 1
+		if width >= maxWidth {
+			maxWidth = width + 1
 		}
 	}
 
@@ -309,17 +825,34 @@ func calculateColumns(screenWidth int, items []string) (numColumns, numRows, max
 	return
 }
 
-func (s *State) printedTabs(items []string) func(tabDirection) (string, error) {
+// printedTabs returns the tab printer of the TabPrints style, which inserts
+// prefix, the prefix of all the candidates, and then lists cands, those of
+// them that are shown.
+func (s *State) printedTabs(cands []Candidate, prefix string, more int) func(tabDirection) (string, error) {
 	numTabs := 1
-	prefix := longestCommonPrefix(items)
+	items := candidateTexts(cands)
 	return func(direction tabDirection) (string, error) {
-		if len(items) == 1 {
-			return items[0], nil
-		}
-
 		if numTabs == 2 {
-			if len(items) > 100 {
-				fmt.Printf("\nDisplay all %d possibilities? (y or n) ", len(items))
+			var rows []string
+			for _, g := range candidateGroups(cands) {
+				if g.name != "" {
+					rows = append(rows, s.styled(Style{Bold: true}, g.name))
+				}
+				if hasDescriptions(cands[g.start:g.end]) {
+					rows = append(rows, s.describedRows(cands[g.start:g.end])...)
+				} else {
+					rows = append(rows, s.gridRows(cands[g.start:g.end])...)
+				}
+			}
+			if more > 0 {
+				rows = append(rows, moreCandidates(more))
+			}
+			query := s.queryItems
+			if query == 0 {
+				query = completionQueryItems
+			}
+			if query > 0 && (len(items) > query || s.rows > 0 && len(rows) >= s.rows && !s.noPaging) {
+				fmt.Fprintf(s.display(), "\nDisplay all %d possibilities? (y or n) ", len(items))
 			prompt:
 				for {
 					next, err := s.readNext()
@@ -329,7 +862,7 @@ func (s *State) printedTabs(items []string) func(tabDirection) (string, error) {
 
 					if key, ok := next.(rune); ok {
 						switch key {
-						case 'n', 'N':
+						case 'n', 'N', esc, ctrlG:
 							return prefix, nil
 						case 'y', 'Y':
 							break prompt
@@ -339,74 +872,735 @@ func (s *State) printedTabs(items []string) func(tabDirection) (string, error) {
 					}
 				}
 			}
-			fmt.Println("")
+			fmt.Fprintln(s.display(), "")
+			return prefix, s.pageRows(rows)
+		}
+		numTabs++
+		return prefix, nil
+	}
+}
 
-			numColumns, numRows, maxWidth := calculateColumns(s.columns, items)
+// moreCandidates describes the number of candidates that were not listed.
+func moreCandidates(more int) string {
+	return fmt.Sprintf("… and %d more", more)
+}
 
-			for i := 0; i < numRows; i++ {
-				for j := 0; j < numColumns*numRows; j += numRows {
-					if i+j < len(items) {
-						if maxWidth > 0 {
-							fmt.Printf("%-*.[1]*s", maxWidth, items[i+j])
-						} else {
-							fmt.Printf("%v ", items[i+j])
-						}
-					}
+// completionQueryItems is the default number of candidates above which
+// TabPrints asks before listing them.
+const completionQueryItems = 100
+
+// gridRows formats the candidates in columns, sorted down the columns.
+func (s *State) gridRows(cands []Candidate) []string {
+	items := candidateTexts(cands)
+	numColumns, numRows, maxWidth := calculateColumns(s.columns, items)
+
+	rows := make([]string, numRows)
+	for i := 0; i < numRows; i++ {
+		var row strings.Builder
+		for j := 0; j < numColumns*numRows; j += numRows {
+			if i+j < len(items) {
+				c := cands[i+j]
+				if maxWidth > 0 {
+					text := getPrefixGlyphs([]rune(c.Text), maxWidth)
+					row.WriteString(s.styled(c.Style, string(text)))
+					row.WriteString(strings.Repeat(" ", maxWidth-countGlyphs(text)))
+				} else {
+					row.WriteString(s.styled(c.Style, c.Text) + " ")
 				}
-				fmt.Println("")
 			}
-		} else {
-			numTabs++
 		}
-		return prefix, nil
+		rows[i] = row.String()
 	}
+	return rows
 }
 
-func (s *State) tabComplete(p []rune, line []rune, pos int) ([]rune, int, interface{}, error) {
-	if s.completer == nil {
-		return line, pos, rune(esc), nil
+// pageRows prints rows, pausing with a --More-- prompt (like GNU readline)
+// whenever a screenful has been printed. Space displays the next screen,
+// Enter the next row, and q (or n, Esc, Ctrl-C) stops the listing.
+func (s *State) pageRows(rows []string) error {
+	const more = "--More--"
+	page := s.rows - 1
+	if page < 1 || s.noPaging {
+		page = len(rows)
 	}
-	head, list, tail := s.completer(string(line), pos)
-	if len(list) <= 0 {
-		return line, pos, rune(esc), nil
+	shown := 0
+	for _, row := range rows {
+		if shown == page {
+			fmt.Fprint(s.display(), more)
+			next, err := s.readNext()
+			s.cursorPos(0)
+			s.eraseLine()
+			if err != nil {
+				return err
+			}
+			key, _ := next.(rune)
+			switch key {
+			case ' ':
+				shown = 0
+			case cr, lf:
+				s.restartPrompt()
+				shown--
+			case ctrlC, ctrlD:
+				s.restartPrompt()
+				return nil
+			default:
+				// q, n, Esc and anything else stop the listing
+				return nil
+			}
+		}
+		fmt.Fprintln(s.display(), row)
+		shown++
 	}
-	hl := utf8.RuneCountInString(head)
-	if len(list) == 1 {
-		err := s.refresh(p, []rune(head+list[0]+tail), hl+utf8.RuneCountInString(list[0]))
-		return []rune(head + list[0] + tail), hl + utf8.RuneCountInString(list[0]), rune(esc), err
+	return nil
+}
+
+// sortByGroup returns cands with the candidates of each Group adjacent,
+// with the groups in the order in which they first appear.
+func sortByGroup(cands []Candidate) []Candidate {
+	order := make(map[string]int)
+	for _, c := range cands {
+		if _, ok := order[c.Group]; !ok {
+			order[c.Group] = len(order)
+		}
+	}
+	if len(order) < 2 {
+		return cands
 	}
+	sorted := make([]Candidate, len(cands))
+	copy(sorted, cands)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return order[sorted[i].Group] < order[sorted[j].Group]
+	})
+	return sorted
+}
 
-	direction := tabForward
-	tabPrinter := s.circularTabs(list)
-	if s.tabStyle == TabPrints {
-		tabPrinter = s.printedTabs(list)
+// candidateGroups returns the groups of cands, which must be sorted by group.
+func candidateGroups(cands []Candidate) []menuGroup {
+	var groups []menuGroup
+	for i, c := range cands {
+		if i == 0 || c.Group != cands[i-1].Group {
+			groups = append(groups, menuGroup{name: c.Group, start: i})
+		}
+		groups[len(groups)-1].end = i + 1
+	}
+	return groups
+}
+
+// insertTexts returns the text inserted into the line for each candidate.
+func insertTexts(cands []Candidate) []string {
+	list := make([]string, len(cands))
+	for i, c := range cands {
+		list[i] = c.Text + c.Suffix
+	}
+	return list
+}
+
+func hasDescriptions(cands []Candidate) bool {
+	for _, c := range cands {
+		if c.Description != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// describedRows formats one candidate per row, with the descriptions aligned
+// in a column to the right of the candidates (similar to zsh).
+func (s *State) describedRows(cands []Candidate) []string {
+	width := 0
+	for _, c := range cands {
+		if w := countGlyphs([]rune(c.Text)); w > width {
+			width = w
+		}
+	}
+	rows := make([]string, len(cands))
+	for i, c := range cands {
+		if s.noLinks {
+			c.Description = stripLinks(c.Description)
+		}
+		rows[i] = s.styled(c.Style, c.Text) + describedRow(c, width, s.columns)[len(c.Text):]
+	}
+	return rows
+}
+
+// describedRow formats c with its text padded to width glyphs, truncating the
+// description so that the row fits in columns. The returned row always
+// begins with c.Text.
+func describedRow(c Candidate, width, columns int) string {
+	text := []rune(c.Text)
+	if c.Description == "" {
+		return string(text)
+	}
+	row := make([]rune, 0, columns)
+	row = append(row, text...)
+	for n := countGlyphs(text); n < width; n++ {
+		row = append(row, ' ')
+	}
+	row = append(row, []rune("  -- ")...)
+	space := columns - 1 - countGlyphs(row)
+	if space <= 0 {
+		return string(text)
+	}
+	return string(row) + prefixVisible(c.Description, space)
+}
+
+// menuMaxRows is the maximum number of rows of candidates displayed at once
+// by TabMenu. Longer menus scroll to keep the selection visible.
+const menuMaxRows = 10
+
+// menuGroup is a group of candidates laid out in a TabMenu grid.
+type menuGroup struct {
+	name       string
+	start, end int // index range of the group's candidates
+	numRows    int
+	firstLine  int // index of the group's first grid row in the menu lines
+}
+
+// menuLayout arranges cands (which must be sorted by group) in columns of
+// width glyphs, numColumns to a row.
+func menuLayout(cands []Candidate, columns int) (groups []menuGroup, numColumns, width int) {
+	for _, c := range cands {
+		if w := countGlyphs([]rune(c.Text)) + 1; w > width {
+			width = w
+		}
+	}
+	if width > columns-1 {
+		width = columns - 1
+	}
+	numColumns = (columns - 1) / width
+	line := 0
+	for _, g := range candidateGroups(cands) {
+		if g.name != "" {
+			line++ // header
+		}
+		n := g.end - g.start
+		cols := numColumns
+		if cols > n {
+			cols = n
+		}
+		g.numRows = (n + cols - 1) / cols
+		g.firstLine = line
+		line += g.numRows
+		groups = append(groups, g)
+	}
+	return groups, numColumns, width
+}
+
+// menuLines formats a TabMenu. It returns the lines and the index of the line
+// containing the selected candidate. When colors is true, the selected
+// candidate is highlighted with reverse video and candidate styles are
+// applied; otherwise the menu is not styled at all.
+func menuLines(cands []Candidate, sel int, groups []menuGroup, numColumns, width int, colors bool) (lines []string, selLine int) {
+	for _, g := range groups {
+		if g.name != "" {
+			header := string(getPrefixGlyphs([]rune(g.name), numColumns*width))
+			if colors {
+				header = Style{Bold: true}.render(header)
+			}
+			lines = append(lines, header)
+		}
+		for i := 0; i < g.numRows; i++ {
+			var row strings.Builder
+			for j := 0; j < numColumns; j++ {
+				n := g.start + i + j*g.numRows
+				if n >= g.end {
+					break
+				}
+				text := getPrefixGlyphs([]rune(cands[n].Text), width-1)
+				pad := strings.Repeat(" ", width-1-countGlyphs(text))
+				if n == sel {
+					selLine = len(lines)
+				}
+				switch {
+				case n == sel && colors:
+					row.WriteString(Style{Reverse: true}.render(string(text) + pad))
+				case colors:
+					row.WriteString(cands[n].Style.render(string(text)) + pad)
+				default:
+					row.WriteString(string(text) + pad)
+				}
+				row.WriteByte(' ')
+			}
+			lines = append(lines, row.String())
+		}
+	}
+	return lines, selLine
+}
+
+// menuComplete implements TabMenu. The candidates are displayed below the
+// prompt, and the selected candidate is displayed in the line.
+func (s *State) menuComplete(p []rune, line []rune, pos int, head string, cands []Candidate, tail string, direction tabDirection, more int) ([]rune, int, interface{}, error) {
+	hl := utf8.RuneCountInString(head)
+	groups, numColumns, width := menuLayout(cands, s.columns)
+	listed := make([]Candidate, len(cands))
+	for i, c := range cands {
+		c.Style = s.fitColors(c.Style)
+		listed[i] = c
+	}
+	groupOf := func(n int) menuGroup {
+		for _, g := range groups {
+			if n < g.end {
+				return g
+			}
+		}
+		return groups[len(groups)-1]
 	}
 
+	sel, top := 0, 0
+	if direction == tabReverse {
+		sel = len(cands) - 1
+	}
 	for {
-		pick, err := tabPrinter(direction)
-		if err != nil {
-			return line, pos, rune(esc), err
+		lines, selLine := menuLines(listed, sel, groups, numColumns, width, !s.noColors)
+		if selLine < top {
+			top = selLine
+			if g := groupOf(sel); g.name != "" && top == g.firstLine {
+				top-- // keep the group's header visible
+			}
+		} else if selLine >= top+menuMaxRows {
+			top = selLine - menuMaxRows + 1
 		}
-		err = s.refresh(p, []rune(head+pick+tail), hl+utf8.RuneCountInString(pick))
+		if top+menuMaxRows < len(lines) {
+			lines = lines[:top+menuMaxRows]
+		}
+		s.menu = lines[top:]
+		if more > 0 {
+			s.menu = append(s.menu, moreCandidates(more))
+		}
+
+		pick := cands[sel].Text + cands[sel].Suffix
+		err := s.refresh(p, []rune(head+pick+tail), hl+utf8.RuneCountInString(pick))
 		if err != nil {
+			s.clearBelow()
 			return line, pos, rune(esc), err
 		}
 
 		next, err := s.readNext()
 		if err != nil {
+			s.clearBelow()
 			return line, pos, rune(esc), err
 		}
-		if key, ok := next.(rune); ok {
-			if key == tab {
-				direction = tabForward
+		g := groupOf(sel)
+		if v, ok := next.(mouseEvent); ok {
+			if d := v.wheel(); d != 0 {
+				if sel+d >= 0 && sel+d < len(cands) {
+					sel += d
+				}
 				continue
 			}
-			if key == esc {
-				return line, pos, rune(esc), nil
+			n, err := s.menuClick(v, p, []rune(head+pick+tail), hl+utf8.RuneCountInString(pick), groups, top, width)
+			if err == nil && n == sel {
+				s.menu = nil
+				line = []rune(head + pick + tail)
+				pos = hl + utf8.RuneCountInString(pick)
+				return line, pos, rune(esc), s.refresh(p, line, pos)
+			}
+			if err == nil && n >= 0 {
+				sel = n
+				continue
+			}
+			if !v.click() {
+				continue
 			}
 		}
-		if a, ok := next.(action); ok && a == shiftTab {
-			direction = tabReverse
+		switch v := next.(type) {
+		case rune:
+			switch v {
+			case tab:
+				sel = (sel + 1) % len(cands)
+				continue
+			case cr, lf:
+				// cr and lf shut down the rune reader
+				s.restartPrompt()
+				s.menu = nil
+				line = []rune(head + pick + tail)
+				pos = hl + utf8.RuneCountInString(pick)
+				return line, pos, rune(esc), s.refresh(p, line, pos)
+			case esc, ctrlG:
+				s.menu = nil
+				return line, pos, rune(esc), s.refresh(p, line, pos)
+			}
+		case action:
+			switch v {
+			case shiftTab:
+				sel = (sel + len(cands) - 1) % len(cands)
+				continue
+			case down:
+				if sel+1 < len(cands) {
+					sel++
+				}
+				continue
+			case up:
+				if sel > 0 {
+					sel--
+				}
+				continue
+			case right:
+				if sel+g.numRows < g.end {
+					sel += g.numRows
+				}
+				continue
+			case left:
+				if sel-g.numRows >= g.start {
+					sel -= g.numRows
+				}
+				continue
+			}
+		}
+		s.clearBelow()
+		return []rune(head + pick + tail), hl + utf8.RuneCountInString(pick), next, nil
+	}
+}
+
+// menuClick returns the candidate of a TabMenu that e clicks, or -1 if it
+// clicks none, when the menu lines are displayed from top.
+func (s *State) menuClick(e mouseEvent, p []rune, line []rune, pos int, groups []menuGroup, top, width int) (int, error) {
+	if !e.click() {
+		return -1, nil
+	}
+	row, err := s.mouseRow(e, p, line, pos)
+	if err != nil {
+		return -1, err
+	}
+	menuRow := row - s.lineRows - len(s.message) - len(s.hint)
+	if menuRow < 0 || menuRow >= len(s.menu) {
+		return -1, nil
+	}
+	l := top + menuRow
+	for _, g := range groups {
+		if l < g.firstLine || l >= g.firstLine+g.numRows {
+			continue
+		}
+		n := g.start + l - g.firstLine + e.x/width*g.numRows
+		if n >= g.end {
+			return -1, nil
+		}
+		return n, nil
+	}
+	return -1, nil
+}
+
+const (
+	// completionPoll is how often liner checks for a keypress while
+	// waiting for the completer.
+	completionPoll = 20 * time.Millisecond
+	// spinnerDelay is how long liner waits for the completer before
+	// displaying the completion spinner.
+	spinnerDelay = 250 * time.Millisecond
+)
+
+var spinnerFrames = []rune(`|/-\`)
+
+type completion struct {
+	head, tail string
+	cands      []Candidate
+	err        error
+}
+
+// runCompleter calls the completer in its own goroutine and sends what it
+// returns on the channel. A panic in the completer is sent as its error, to
+// be displayed like any other, since no caller could recover it there. Calls
+// are made one at a time: each waits for the one before it, which may have
+// been abandoned but not yet returned, and is skipped, sending ctx's error, if
+// ctx is done by then.
+func (s *State) runCompleter(ctx context.Context, line []rune, pos int) <-chan completion {
+	done := make(chan completion, 1)
+	prev := s.completerDone
+	finished := make(chan struct{})
+	s.completerDone = finished
+	f := s.completer
+	go func() {
+		defer close(finished)
+		if prev != nil {
+			<-prev
+		}
+		if err := ctx.Err(); err != nil {
+			done <- completion{err: err}
+			return
+		}
+		var c completion
+		defer func() {
+			if r := recover(); r != nil {
+				done <- completion{err: fmt.Errorf("completer panicked: %v", r)}
+			}
+		}()
+		c.head, c.cands, c.tail, c.err = f(ctx, string(line), pos)
+		done <- c
+	}()
+	return done
+}
+
+// restoreOnPanic is deferred by the prompts, which call the program's
+// functions, such as the highlighter: if one panics, the terminal is returned
+// to its mode before NewLiner, so that the panic's message is readable and the
+// shell usable, and the panic goes on.
+func (s *State) restoreOnPanic() {
+	if r := recover(); r != nil {
+		s.pauseTerminal()
+		s.panicked = true
+		panic(r)
+	}
+}
+
+// resumeAfterPanic returns the terminal to the prompt's mode if the program
+// recovered from a panic that restoreOnPanic let go on.
+func (s *State) resumeAfterPanic() {
+	if s.panicked {
+		s.panicked = false
+		s.resumeTerminal()
+	}
+}
+
+// complete runs the completer in its own goroutine. If the user presses a key
+// before the completer returns, the completer's context is cancelled and
+// complete returns false.
+func (s *State) complete(p []rune, line []rune, pos int) (completion, bool, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := s.runCompleter(ctx, line, pos)
+
+	ticker := time.NewTicker(completionPoll)
+	defer ticker.Stop()
+	start := time.Now()
+	frame := 0
+	defer func() {
+		if s.spinner != "" {
+			s.spinner = ""
+			s.refreshBelow(s.belowLines())
+		}
+	}()
+	for {
+		select {
+		case c := <-done:
+			return c, true, nil
+		case <-ticker.C:
+		}
+		if s.inputWaiting() {
+			return completion{}, false, nil
+		}
+		if s.completionSpinner && time.Since(start) >= spinnerDelay {
+			s.spinner = string(spinnerFrames[frame%len(spinnerFrames)]) + " completing…"
+			frame++
+			if err := s.refresh(p, line, pos); err != nil {
+				return completion{}, false, err
+			}
+		}
+	}
+}
+
+const (
+	// previewDelay is how long typing must pause before liner calls the
+	// completer for the inline completion preview.
+	previewDelay = 50 * time.Millisecond
+	// previewTimeout is how long the completer may take to return a
+	// candidate for the preview.
+	previewTimeout = 100 * time.Millisecond
+)
+
+// updatePreview arranges for the inline completion preview of line to be
+// displayed once typing pauses. Previews are only offered when the cursor is
+// at the end of a single line. Meanwhile, what is left of the previous
+// preview after the runes typed since is still displayed.
+func (s *State) updatePreview(line []rune, pos int) {
+	if s.completer == nil || s.noColors || s.multiLine() || len(line) == 0 || pos != len(line) {
+		s.previewFor = ""
+		if s.ghost != "" {
+			s.ghost = ""
+			s.needRefresh = true
+		}
+		return
+	}
+	text := string(line)
+	if text == s.ghostFor && s.ghost != "" {
+		return
+	}
+	s.previewFor = text
+	ghost := ""
+	if strings.HasPrefix(text, s.ghostFor) && strings.HasPrefix(s.ghost, text[len(s.ghostFor):]) {
+		ghost = s.ghost[len(text)-len(s.ghostFor):]
+	}
+	if ghost != s.ghost {
+		s.ghost = ghost
+		s.needRefresh = true
+	}
+	s.ghostFor = text
+	if s.previewTimer == nil {
+		s.previewTimer = time.AfterFunc(previewDelay, func() {
+			s.runAsync(s.startPreview)
+		})
+		return
+	}
+	s.previewTimer.Reset(previewDelay)
+}
+
+// startPreview calls the completer for the preview of the line, unless the
+// call for an earlier line has not returned, in which case finishPreview
+// starts it once it does. A preview that takes longer than previewTimeout is
+// not displayed.
+func (s *State) startPreview() {
+	if s.previewFor == "" || s.previewBusy || s.completer == nil {
+		return
+	}
+	s.previewBusy = true
+	text := s.previewFor
+	line := []rune(text)
+	ctx, cancel := context.WithTimeout(context.Background(), previewTimeout)
+	done := s.runCompleter(ctx, line, len(line))
+	go func() {
+		c := <-done
+		if err := ctx.Err(); err != nil {
+			c.err = err
+		}
+		cancel()
+		s.runAsync(func() { s.finishPreview(text, c) })
+	}()
+}
+
+// finishPreview displays the preview that the completer returned for text,
+// if it is still the line.
+func (s *State) finishPreview(text string, c completion) {
+	s.previewBusy = false
+	if text != s.previewFor {
+		s.startPreview()
+		return
+	}
+	if ghost := s.previewCompletion([]rune(text), c); ghost != s.ghost {
+		s.ghost = ghost
+		s.ghostFor = text
+		s.redisplay()
+	}
+}
+
+// previewCompletion returns the remainder of the first completion candidate
+// in c for the word at the end of line, to be displayed after the cursor.
+func (s *State) previewCompletion(line []rune, c completion) string {
+	if c.err != nil {
+		return ""
+	}
+	pos := len(line)
+	hl := utf8.RuneCountInString(c.head)
+	if hl > pos || c.tail != "" {
+		return ""
+	}
+	word := string(line[hl:pos])
+	cands := c.cands
+	if s.matcher != nil {
+		cands = matchCandidates(s.matcher, word, cands)
+	}
+	if len(cands) == 0 {
+		return ""
+	}
+	text := cands[0].Text + cands[0].Suffix
+	if !strings.HasPrefix(text, word) {
+		return ""
+	}
+	return text[len(word):]
+}
+
+// acceptGhost appends the inline completion preview to line.
+func (s *State) acceptGhost(line []rune) ([]rune, int) {
+	line = append(line, []rune(s.ghost)...)
+	s.ghost = ""
+	s.needRefresh = true
+	return line, len(line)
+}
+
+func (s *State) tabComplete(p []rune, line []rune, pos int, direction tabDirection) ([]rune, int, interface{}, error) {
+	s.ghost = ""
+	if s.completer == nil {
+		return line, pos, rune(esc), nil
+	}
+	c, ok, err := s.complete(p, line, pos)
+	if err != nil || !ok {
+		return line, pos, rune(esc), err
+	}
+	if c.err != nil {
+		s.message = s.fitLines(c.err.Error(), Style{Fg: ColorRed})
+		s.doBeep()
+		return line, pos, rune(esc), s.refresh(p, line, pos)
+	}
+	head, cands, tail := c.head, c.cands, c.tail
+	if s.wholeWord {
+		tail = strings.TrimLeftFunc(tail, func(r rune) bool { return !unicode.IsSpace(r) })
+	}
+	hl := utf8.RuneCountInString(head)
+	word := ""
+	if hl <= pos && hl <= len(line) {
+		word = string(line[hl:pos])
+	}
+	typed := ""
+	if s.matcher != nil {
+		typed = word
+		cands = matchCandidates(s.matcher, typed, cands)
+	}
+	if len(cands) <= 0 {
+		return line, pos, rune(esc), nil
+	}
+	if len(cands) == 1 {
+		pick := cands[0].Text + cands[0].Suffix
+		err := s.refresh(p, []rune(head+pick+tail), hl+utf8.RuneCountInString(pick))
+		return []rune(head + pick + tail), hl + utf8.RuneCountInString(pick), rune(esc), err
+	}
+
+	// The prefix is shared by all the candidates, not only those shown
+	prefix := longestCommonPrefix(candidateTexts(cands))
+	// TabPrints inserts the prefix anyway, and lists the candidates at the
+	// next Tab (see printedTabs)
+	if s.insertPrefix && s.tabStyle != TabPrints {
+		if len(prefix) > len(word) && strings.HasPrefix(prefix, word) {
+			line = []rune(head + prefix + tail)
+			pos = hl + utf8.RuneCountInString(prefix)
+			return line, pos, rune(esc), s.refresh(p, line, pos)
+		}
+	}
+
+	more := 0
+	if s.maxCandidates > 0 && len(cands) > s.maxCandidates {
+		more = len(cands) - s.maxCandidates
+		cands = cands[:s.maxCandidates]
+	}
+	cands = sortByGroup(cands)
+
+	if s.tabStyle == TabMenu {
+		return s.menuComplete(p, line, pos, head, cands, tail, direction, more)
+	}
+
+	tabPrinter := s.circularTabs(insertTexts(cands))
+	if s.tabStyle == TabPrints {
+		if !strings.HasPrefix(prefix, typed) {
+			// Candidates matched by a CompletionMatcher need not
+			// begin with the typed text; don't throw it away.
+			prefix = typed
+		}
+		tabPrinter = s.printedTabs(cands, prefix, more)
+	}
+
+	for {
+		pick, err := tabPrinter(direction)
+		if err != nil {
+			return line, pos, rune(esc), err
+		}
+		err = s.refresh(p, []rune(head+pick+tail), hl+utf8.RuneCountInString(pick))
+		if err != nil {
+			return line, pos, rune(esc), err
+		}
+
+		next, err := s.readNext()
+		if err != nil {
+			return line, pos, rune(esc), err
+		}
+		if key, ok := next.(rune); ok {
+			if key == tab {
+				direction = tabForward
+				continue
+			}
+			if key == esc || key == ctrlG {
+				return line, pos, rune(esc), nil
+			}
+		}
+		if a, ok := next.(action); ok && a == shiftTab {
+			direction = tabReverse
 			continue
 		}
 		return []rune(head + pick + tail), hl + utf8.RuneCountInString(pick), next, nil
@@ -431,6 +1625,8 @@ func (s *State) reverseISearch(origLine []rune, origPos int) ([]rune, int, inter
 		prompt := "(reverse-i-search)`%s': "
 		return []rune(fmt.Sprintf(prompt, search)), []rune(foundLine), foundPos
 	}
+	defer func(redraw func() error) { s.redraw = redraw }(s.redraw)
+	s.redraw = func() error { return s.refresh(getLine()) }
 
 	history, positions := s.getHistoryByPattern(string(line))
 	historyPos := len(history) - 1
@@ -481,11 +1677,21 @@ func (s *State) reverseISearch(origLine []rune, origPos int) ([]rune, int, inter
 				}
 			case ctrlG: // Cancel
 				return origLine, origPos, rune(esc), err
+			case esc: // Esc Esc cancels; Esc and another key end the search
+				next, err = s.readNext()
+				if err != nil {
+					return []rune(foundLine), foundPos, rune(esc), err
+				}
+				if next == rune(esc) {
+					return origLine, origPos, rune(esc), nil
+				}
+				s.unread = append([]interface{}{next}, s.unread...)
+				return []rune(foundLine), foundPos, rune(esc), nil
 
 			case tab, cr, lf, ctrlA, ctrlB, ctrlD, ctrlE, ctrlF, ctrlK,
 				ctrlL, ctrlN, ctrlO, ctrlP, ctrlQ, ctrlT, ctrlU, ctrlV, ctrlW, ctrlX, ctrlY, ctrlZ:
 				fallthrough
-			case 0, ctrlC, esc, 28, 29, 30, 31:
+			case 0, ctrlC, 28, 29, 30, 31:
 				return []rune(foundLine), foundPos, next, err
 			default:
 				line = append(line[:pos], append([]rune{v}, line[pos:]...)...)
@@ -503,7 +1709,10 @@ func (s *State) reverseISearch(origLine []rune, origPos int) ([]rune, int, inter
 				}
 			}
 		case action:
-			return []rune(foundLine), foundPos, next, err
+			if v != winch {
+				return []rune(foundLine), foundPos, next, err
+			}
+			s.resized()
 		}
 		err = s.refresh(getLine())
 		if err != nil {
@@ -512,88 +1721,1069 @@ func (s *State) reverseISearch(origLine []rune, origPos int) ([]rune, int, inter
 	}
 }
 
-// addToKillRing adds some text to the kill ring. If mode is 0 it adds it to a
-// new node in the end of the kill ring, and move the current pointer to the new
-// node. If mode is 1 or 2 it appends or prepends the text to the current entry
-// of the killRing.
-func (s *State) addToKillRing(text []rune, mode int) {
-	// Don't use the same underlying array as text
-	killLine := make([]rune, len(text))
-	copy(killLine, text)
+// boundFunc is the input produced by a key chord bound with BindFunc.
+type boundFunc func(*Buffer) error
+
+// actionKeys maps the special keys decoded by readNext to their Key.
+var actionKeys = map[action]Key{
+	left:      {Code: KeyLeft},
+	right:     {Code: KeyRight},
+	up:        {Code: KeyUp},
+	down:      {Code: KeyDown},
+	home:      {Code: KeyHome},
+	end:       {Code: KeyEnd},
+	insert:    {Code: KeyInsert},
+	del:       {Code: KeyDelete},
+	pageUp:    {Code: KeyPageUp},
+	pageDown:  {Code: KeyPageDown},
+	f1:        {Code: KeyF1},
+	f2:        {Code: KeyF2},
+	f3:        {Code: KeyF3},
+	f4:        {Code: KeyF4},
+	f5:        {Code: KeyF5},
+	f6:        {Code: KeyF6},
+	f7:        {Code: KeyF7},
+	f8:        {Code: KeyF8},
+	f9:        {Code: KeyF9},
+	f10:       {Code: KeyF10},
+	f11:       {Code: KeyF11},
+	f12:       {Code: KeyF12},
+	altB:      {Rune: 'b', Mod: ModAlt},
+	altBs:     {Rune: bs, Mod: ModAlt},
+	ctrlBs:    {Rune: bs, Mod: ModCtrl},
+	altD:      {Rune: 'd', Mod: ModAlt},
+	altF:      {Rune: 'f', Mod: ModAlt},
+	altY:      {Rune: 'y', Mod: ModAlt},
+	shiftTab:  {Rune: tab, Mod: ModShift},
+	wordLeft:  {Code: KeyLeft, Mod: ModCtrl},
+	wordRight: {Code: KeyRight, Mod: ModCtrl},
+}
+
+// commandKeys maps each Action to the input the prompt handles it as.
+var commandKeys = map[Action]interface{}{
+	ActionNone:                 rune(esc),
+	ActionBeginningOfLine:      rune(ctrlA),
+	ActionEndOfLine:            rune(ctrlE),
+	ActionBackwardChar:         rune(ctrlB),
+	ActionForwardChar:          rune(ctrlF),
+	ActionBackwardWord:         altB,
+	ActionForwardWord:          altF,
+	ActionDeleteChar:           del,
+	ActionBackwardDeleteChar:   rune(bs),
+	ActionKillLine:             rune(ctrlK),
+	ActionUnixLineDiscard:      rune(ctrlU),
+	ActionUnixWordRubout:       rune(ctrlW),
+	ActionKillWord:             altD,
+	ActionBackwardKillWord:     altBs,
+	ActionYank:                 rune(ctrlY),
+	ActionYankPop:              altY,
+	ActionPreviousHistory:      rune(ctrlP),
+	ActionNextHistory:          rune(ctrlN),
+	ActionReverseSearchHistory: rune(ctrlR),
+	ActionTransposeChars:       rune(ctrlT),
+	ActionClearScreen:          rune(ctrlL),
+	ActionComplete:             rune(tab),
+	ActionCompletePrevious:     shiftTab,
+	ActionAcceptLine:           rune(cr),
+	ActionAbort:                rune(ctrlC),
+	ActionStartKbdMacro:        startMacro,
+	ActionEndKbdMacro:          endMacro,
+	ActionCallLastKbdMacro:     callMacro,
+	ActionUndo:                 undo,
+	ActionRedo:                 redo,
+	ActionDigitArgument:        digitArg,
+	ActionUniversalArgument:    universalArg,
+	ActionYankLastArg:          yankLastArg,
+	ActionYankNthArg:           yankNthArg,
+	ActionUpcaseWord:           upcaseWord,
+	ActionDowncaseWord:         downcaseWord,
+	ActionCapitalizeWord:       capitalizeWord,
+	ActionTransposeWords:       transposeWords,
+	ActionQuotedInsert:         rune(ctrlV),
+	ActionOverwriteMode:        insert,
+	ActionKillWholeLine:        killWholeLine,
+	ActionEditCommandLine:      editCommandLine,
+	ActionSetMark:              setMark,
+	ActionExchangePointAndMark: exchangeMark,
+	ActionKillRegion:           killRegion,
+	ActionCopyRegionAsKill:     copyRegion,
+	ActionPasteClipboard:       pasteClipboard,
+}
+
+// toKey returns the Key for an input returned by readNext.
+func toKey(next interface{}) (Key, bool) {
+	switch v := next.(type) {
+	case rune:
+		return Key{Rune: v}, true
+	case action:
+		k, ok := actionKeys[v]
+		return k, ok
+	}
+	return Key{}, false
+}
+
+// keyInputs returns the inputs readNext would return for keys.
+func keyInputs(keys []Key) []interface{} {
+	var inputs []interface{}
+	for _, k := range keys {
+		if a, ok := keyAction(k); ok {
+			inputs = append(inputs, a)
+			continue
+		}
+		if k.Mod&ModAlt != 0 {
+			inputs = append(inputs, rune(esc))
+			k.Mod &^= ModAlt
+		}
+		if a, ok := keyAction(k); ok {
+			inputs = append(inputs, a)
+		} else if k.Code == 0 {
+			inputs = append(inputs, k.Rune)
+		}
+	}
+	return inputs
+}
+
+// keyAction returns the action readNext returns for the special key k.
+func keyAction(k Key) (action, bool) {
+	for a, ak := range actionKeys {
+		if k == ak {
+			return a, true
+		}
+	}
+	return 0, false
+}
+
+// resolveBinding looks next up in the key bindings, reading further keys
+// while they form the start of a bound chord. If alt is set, Esc followed
+// immediately by another key is read as Alt and that key. It returns the
+// input the prompt should act on: the input implementing the bound Action,
+// the bound function, or next itself if no chord matched, in which case
+// any keys read after it are pushed back to be read again.
+func (s *State) resolveBinding(next interface{}, alt bool) (interface{}, error) {
+	if len(s.bindings) == 0 && len(defaultBindings) == 0 {
+		return next, nil
+	}
+	k, ok := toKey(next)
+	if !ok {
+		return next, nil
+	}
+	keys := []Key{k}
+	var read []interface{}
+	if alt && next == rune(esc) && s.inputWaiting() {
+		n, err := s.readNext()
+		if err != nil {
+			return nil, err
+		}
+		read = append(read, n)
+		k, ok := toKey(n)
+		if !ok || k.Mod&ModAlt != 0 {
+			s.unread = append(read, s.unread...)
+			return next, nil
+		}
+		k.Mod |= ModAlt
+		keys[0] = k
+	}
+	for {
+		c := chordString(keys)
+		if b, ok := s.binding(c); ok {
+			// The rune reader stops after keys that might end the
+			// prompt, but the bound command might not.
+			s.restartPrompt()
+			switch {
+			case b.fn != nil:
+				return boundFunc(b.fn), nil
+			case b.macro != nil:
+				s.playMacro(b.macro)
+				return rune(esc), nil
+			}
+			s.chordInputs = len(read) + 1
+			s.lastChord = keys
+			return commandKeys[b.action], nil
+		}
+		if !s.isChordPrefix(c) {
+			break
+		}
+		n, err := s.readNext()
+		if err != nil {
+			return nil, err
+		}
+		read = append(read, n)
+		k, ok := toKey(n)
+		if !ok {
+			break
+		}
+		keys = append(keys, k)
+	}
+	s.unread = append(read, s.unread...)
+	return next, nil
+}
+
+// readNext returns the next input: a key pushed back by the prompt or a
+// playing macro, or else the next key typed at the terminal.
+func (s *State) readNext() (interface{}, error) {
+	for len(s.unread) > 0 {
+		v := s.unread[0]
+		s.unread = s.unread[1:]
+		if k, ok := v.(Key); ok {
+			s.unread = append(keyInputs([]Key{k}), s.unread...)
+			continue
+		}
+		s.filtered = s.unfiltered > 0
+		if s.filtered {
+			s.unfiltered--
+		}
+		s.lastInput = time.Now()
+		return v, nil
+	}
+	s.filtered = false
+	for {
+		waited := time.Now()
+		v, err := s.readInput()
+		// Settings may have changed while waiting
+		s.applyConfig()
+		if err == nil && v == wake {
+			s.runQueued()
+			if s.aborted != nil {
+				return nil, s.aborted
+			}
+			if len(s.unread) > 0 {
+				// Queued by a function that was run
+				return s.readNext()
+			}
+			continue
+		}
+		if err == nil && s.recording && v != winch {
+			s.recorded = append(s.recorded, v)
+		}
+		if v != winch {
+			s.lastInput = time.Now()
+			s.countBurst(s.lastInput.Sub(waited))
+		}
+		return v, err
+	}
+}
+
+// readInput returns the next input from the terminal, or from the events
+// given to the Prompt once Events has been called.
+func (s *State) readInput() (interface{}, error) {
+	if s.events != nil {
+		return s.readEvent()
+	}
+	return s.readTerminal()
+}
+
+// inputWaiting only returns true if the next call to readNext will return
+// immediately.
+func (s *State) inputWaiting() bool {
+	if len(s.unread) > 0 {
+		return true
+	}
+	if s.events != nil {
+		return len(s.events.queued) > 0
+	}
+	return s.terminalWaiting()
+}
+
+// Feed queues keys, the bytes a terminal sends as keys are typed, to be
+// read as if they had been typed, before any further input from the
+// terminal. Escape sequences, such as "\x1b[A" for the up arrow, are
+// decoded as the terminal's own are; on Windows, where the console decodes
+// keys, each character is a key of its own. Feed lets a program replay a
+// session as a demonstration, or test itself from end to end. It may be
+// called from any goroutine, during a Prompt or before one. If line editing
+// is not supported, keys are read as the start of the input instead, and
+// Feed must not be called during a Prompt.
+func (s *State) Feed(keys []byte) {
+	if s.inputRedirected || !s.terminalSupported {
+		s.r = bufio.NewReader(io.MultiReader(bytes.NewReader(keys), s.r))
+		return
+	}
+	s.asyncMu.Lock()
+	s.fed = append(s.fed, []rune(string(keys))...)
+	s.asyncMu.Unlock()
+	s.wakeReader()
+}
+
+// nextFed returns the next key queued by Feed, if there is one.
+func (s *State) nextFed() (rune, bool) {
+	s.asyncMu.Lock()
+	defer s.asyncMu.Unlock()
+	if len(s.fed) == 0 {
+		return 0, false
+	}
+	r := s.fed[0]
+	s.fed = s.fed[1:]
+	return r, true
+}
+
+// fedWaiting reports whether keys queued by Feed are waiting to be read.
+func (s *State) fedWaiting() bool {
+	s.asyncMu.Lock()
+	defer s.asyncMu.Unlock()
+	return len(s.fed) > 0
+}
+
+// runAsync queues f to be run by the goroutine in Prompt, while it waits for
+// a key, and returns false if no Prompt is active.
+func (s *State) runAsync(f func()) bool {
+	s.asyncMu.Lock()
+	if !s.prompting {
+		s.asyncMu.Unlock()
+		return false
+	}
+	s.async = append(s.async, f)
+	e := s.events
+	s.asyncMu.Unlock()
+	if e != nil {
+		select {
+		case e.wake <- struct{}{}:
+		default:
+		}
+	} else {
+		s.wakeReader()
+	}
+	return true
+}
+
+// runQueued runs the functions queued by runAsync.
+func (s *State) runQueued() {
+	s.asyncMu.Lock()
+	queued := s.async
+	s.async = nil
+	s.asyncMu.Unlock()
+	for _, f := range queued {
+		f()
+	}
+}
+
+// setPrompting records whether a Prompt is active, for runAsync and
+// configure.
+func (s *State) setPrompting(prompting bool) {
+	s.asyncMu.Lock()
+	defer s.asyncMu.Unlock()
+	s.prompting = prompting
+	if prompting && s.started != nil {
+		close(s.started)
+		s.started = nil
+	}
+	if !prompting {
+		s.shownLine, s.shownBelow = nil, nil
+		// Settings changed since the last key are kept no longer
+		for _, set := range s.configs {
+			set()
+		}
+		s.configs = nil
+	}
+}
+
+// refreshAfterBurst displays the line once no key has been read for the
+// burst gap, rather than after each key of a burst.
+func (s *State) refreshAfterBurst() {
+	if s.burstTimer == nil {
+		s.burstTimer = time.AfterFunc(s.pasteBurstGap(), func() {
+			s.runAsync(s.redisplay)
+		})
+		return
+	}
+	s.burstTimer.Reset(s.pasteBurstGap())
+}
+
+// redisplay displays the line again after a change made by runAsync.
+func (s *State) redisplay() {
+	if s.redraw != nil {
+		s.redraw()
+	}
+}
+
+// cursorAt returns the row, counted from the prompt's, and the column where
+// refresh displays the cursor when it is at pos in buf.
+func (s *State) cursorAt(prompt, buf []rune, pos int) (row, col int) {
+	d, dpos, _ := s.displayed(buf, pos, nil)
+	if s.multiLine() {
+		promptColumns := countMultiLineGlyphs(visibleRunes(prompt), s.columns, 0)
+		c := s.rowsColumns(d[:dpos], promptColumns)
+		return c / s.columns, c % s.columns
+	}
+	return 0, countGlyphs(visibleRunes(prompt)) + countGlyphs(d[:dpos]) - s.scroll
+}
+
+// posAt returns the position in buf nearest before the given row and column
+// of the display.
+func (s *State) posAt(prompt, buf []rune, row, col int) int {
+	n := sort.Search(len(buf)+1, func(i int) bool {
+		r, c := s.cursorAt(prompt, buf, i)
+		return r > row || r == row && c > col
+	})
+	if n > 0 {
+		n--
+	}
+	return n
+}
+
+// mouseRow returns the row of e, counted from the prompt's, when the cursor
+// is displayed at pos in buf.
+func (s *State) mouseRow(e mouseEvent, prompt, buf []rune, pos int) (int, error) {
+	y, err := s.cursorRow()
+	if err != nil {
+		return 0, err
+	}
+	row, _ := s.cursorAt(prompt, buf, pos)
+	return row + e.y - y, nil
+}
+
+// errNoClipboard is returned by readClipboard if the terminal does not give
+// the clipboard's contents.
+var errNoClipboard = errors.New("clipboard not available")
+
+// resized erases the display after the terminal is resized, so that it can
+// be drawn again at the new width. Terminals (such as tmux) that rewrap their
+// rows to the new width move the rows above the cursor, so the start of the
+// prompt is found again by rewrapping its distance to the cursor.
+func (s *State) resized() {
+	old := s.layoutColumns
+	if old == 0 || s.columns == 0 {
+		return
+	}
+	row := s.lineRows - s.rowsBelowCursor - 1
+	if above := (row*old + s.cursorCol) / s.columns; above > 0 {
+		s.moveUp(above)
+	}
+	s.cursorPos(0)
+	s.eraseBelow()
+	s.layoutColumns = s.columns
+	s.resetDisplay()
+}
+
+// resetDisplay records that nothing is displayed below the cursor, which is
+// at the start of the row where the prompt is to be drawn.
+func (s *State) resetDisplay() {
+	s.drawn = nil
+	s.lineRows, s.rowsBelowCursor, s.belowEnd = 1, 0, 1
+	s.maxRows, s.cursorRows = 1, 1
+}
+
+// modeCursorShape returns the cursor shape for the current mode.
+func (s *State) modeCursorShape() CursorShape {
+	if !s.cursorShapesSet {
+		if s.overwrite {
+			return defaultOverwriteCursor
+		}
+		return CursorDefault
+	}
+	if s.overwrite {
+		return s.cursorShapes[1]
+	}
+	return s.cursorShapes[0]
+}
+
+// clearScreen does what SetClearBehavior selects for Ctrl-L, leaving the
+// prompt and the line to be drawn again.
+func (s *State) clearScreen() {
+	switch {
+	case s.clearBehavior == ClearScreen:
+		s.eraseScreen()
+		s.resetDisplay()
+	case s.clearBehavior == ClearCallback && s.clearHandler != nil:
+		s.clearDisplay()
+		s.clearHandler()
+	default:
+		s.clearDisplay()
+	}
+}
+
+// clearDisplay erases the prompt, the line being edited and the rows below
+// it, and leaves the cursor at the start of the prompt's row.
+func (s *State) clearDisplay() {
+	if above := s.lineRows - s.rowsBelowCursor - 1; above > 0 {
+		s.moveUp(above)
+	}
+	rows := s.lineRows
+	if s.belowEnd > rows {
+		rows = s.belowEnd
+	}
+	for i := 0; i < rows; i++ {
+		if i > 0 {
+			s.moveDown(1)
+		}
+		s.cursorPos(0)
+		s.eraseLine()
+	}
+	if rows > 1 {
+		s.moveUp(rows - 1)
+	}
+	s.resetDisplay()
+}
+
+// Write writes p to standard output. Unlike the rest of liner's API, Write
+// may be called from another goroutine while Prompt is in progress, for
+// example to display log or chat messages as they arrive: the prompt and the
+// line being edited are erased, p is written in their place (followed by a
+// newline, if it does not end with one), and they are displayed again below
+// it. Write waits until Prompt has done so, which it does when it next waits
+// for a key, so it must not be called by the functions Prompt calls, such as
+// completers and hooks.
+func (s *State) Write(p []byte) (int, error) {
+	var n int
+	var err error
+	done := make(chan struct{})
+	write := func() {
+		defer close(done)
+		if s.redraw == nil {
+			n, err = s.display().Write(p)
+			return
+		}
+		s.clearDisplay()
+		n, err = s.display().Write(p)
+		if len(p) > 0 && p[len(p)-1] != '\n' {
+			fmt.Fprintln(s.display())
+		}
+		s.redraw()
+	}
+	if !s.runAsync(write) {
+		return s.display().Write(p)
+	}
+	<-done
+	return n, err
+}
+
+// Redraw draws the prompt and the line being edited again, for use after
+// the application has written to the terminal itself rather than with Write.
+// They are drawn from the start of the row where the cursor is, so the output
+// should end with a newline. Like Write, Redraw may be called from another
+// goroutine while Prompt is in progress; it does nothing if no Prompt is.
+func (s *State) Redraw() {
+	s.runAsync(func() {
+		s.resetDisplay()
+		s.redisplay()
+	})
+}
+
+// Size returns the number of columns and rows of the terminal, or 0, 0 if
+// they are not known, as when the output is not a terminal. It may be called
+// from any goroutine.
+func (s *State) Size() (columns, rows int) {
+	columns, rows, ok := s.terminalSize()
+	if !ok {
+		return 0, 0
+	}
+	return columns, rows
+}
+
+// AltScreen calls f with the terminal switched to its alternate screen, as
+// full-screen programs such as editors do, for interactions such as a fuzzy
+// history finder or a large menu that should not be left in the terminal's
+// scrollback. The normal screen is restored as it was when f returns. During
+// a Prompt (in a function bound with BindFunc, for example), f runs with the
+// terminal in its normal mode, so it may read standard input or run another
+// program, and the line is displayed again afterwards. The Windows console
+// has no alternate screen, so there f uses the normal screen.
+func (s *State) AltScreen(f func() error) error {
+	if !s.terminalSupported || s.outputRedirected {
+		return f()
+	}
+	s.asyncMu.Lock()
+	prompting := s.prompting
+	s.asyncMu.Unlock()
+	if prompting {
+		s.stopReader()
+		s.pauseTerminal()
+	}
+	s.enterAltScreen()
+	err := f()
+	s.leaveAltScreen()
+	if prompting {
+		s.resumeTerminal()
+		s.restartPrompt()
+		s.getColumns()
+		// Drawn in full, not just where it changed since before f
+		s.drawn = nil
+		s.needRefresh = true
+	}
+	return err
+}
+
+// Printf formats according to a format specifier and writes to standard
+// output as Write does, so that it may be called while Prompt is in
+// progress.
+func (s *State) Printf(format string, a ...interface{}) (int, error) {
+	return s.Write([]byte(fmt.Sprintf(format, a...)))
+}
+
+// SetStatus sets a status line, displayed below the line being edited (and
+// below any completions) until it is changed, for feedback such as
+// "3 results" or "reconnecting…". Only the first line of status is
+// displayed, truncated to fit in the terminal; an empty status removes the
+// status line. Like RefreshPrompt, SetStatus may be called from another
+// goroutine while Prompt is in progress.
+func (s *State) SetStatus(status string) {
+	if i := strings.IndexByte(status, '\n'); i >= 0 {
+		status = status[:i]
+	}
+	set := func() {
+		s.status = status
+		s.redisplay()
+	}
+	if !s.runAsync(set) {
+		set()
+	}
+}
+
+// RefreshPrompt replaces the prompt of the active Prompt and displays it,
+// without disturbing the line being edited; for example, to update a clock
+// or connection status in the prompt. Unlike most of liner's API,
+// RefreshPrompt may be called from another goroutine while Prompt is in
+// progress; the prompt is changed when Prompt next waits for a key. It
+// returns ErrInvalidPrompt as Prompt would, and has no effect if no Prompt
+// is active.
+func (s *State) RefreshPrompt(prompt string) error {
+	prompt, err := s.checkPrompt(prompt)
+	if err != nil {
+		return err
+	}
+	s.runAsync(func() {
+		s.prompt = []rune(prompt)
+		s.redisplay()
+	})
+	return nil
+}
+
+// AbortPrompt makes the active Prompt or PasswordPrompt return
+// ErrPromptAborted, leaving the terminal as it found it, for example when
+// the connection the prompt is for has been lost. Like RefreshPrompt,
+// AbortPrompt may be called from another goroutine while Prompt is in
+// progress; it has no effect if no Prompt is active.
+func (s *State) AbortPrompt() {
+	s.runAsync(func() {
+		s.aborted = ErrPromptAborted
+	})
+}
+
+// readQuoted reads the next character typed for quoted-insert, without
+// decoding escape sequences, so that Ctrl-V Esc inserts the escape itself.
+// It returns -1 if the key typed is not a character.
+func (s *State) readQuoted() (rune, error) {
+	var v interface{}
+	var err error
+	if len(s.unread) > 0 || s.events != nil {
+		v, err = s.readNext()
+	} else {
+		for v = winch; err == nil && (v == winch || v == wake); {
+			v, err = s.readLiteral()
+			if err == nil && v == wake {
+				s.runQueued()
+			}
+		}
+		if err == nil && s.recording {
+			s.recorded = append(s.recorded, v)
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+	r, ok := v.(rune)
+	if !ok {
+		return -1, nil
+	}
+	switch r {
+	case cr, lf, ctrlC, ctrlD:
+		// The rune reader stops after these
+		s.restartPrompt()
+	}
+	return r, nil
+}
+
+// numericArg is a numeric argument typed before a command, which repeats
+// the command, as Alt-4 Ctrl-D deletes four characters.
+type numericArg struct {
+	active bool
+	n      int
+	neg    bool
+	digits bool // a digit has been typed
+	closed bool // the digits were ended by universal-argument
+}
+
+// maxNumericArg limits the number of times a command is repeated.
+const maxNumericArg = 100000
+
+// add adds next to the argument if it is part of one, reporting whether it
+// was. chord is the chord next was bound to.
+func (a *numericArg) add(next interface{}, chord []Key) bool {
+	var r rune
+	switch next {
+	case digitArg:
+		r = chord[len(chord)-1].Rune
+	case universalArg:
+		if a.digits {
+			// Ends the digits, so that Ctrl-U 1 2 Ctrl-U 0 inserts 12 zeros
+			a.closed = true
+			return true
+		}
+		if !a.active {
+			a.n = 1
+		}
+		a.active = true
+		if a.n < maxNumericArg {
+			a.n *= 4
+		}
+		return true
+	default:
+		if !a.active || a.closed {
+			return false
+		}
+		r, _ = next.(rune)
+	}
+	switch {
+	case r == '-' && !a.digits:
+		a.neg = !a.neg
+		a.n = 0
+	case r >= '0' && r <= '9':
+		if !a.digits {
+			a.n = 0
+		}
+		if a.n < maxNumericArg {
+			a.n = a.n*10 + int(r-'0')
+		}
+		a.digits = true
+	default:
+		return false
+	}
+	a.active = true
+	return true
+}
+
+// prompt is displayed in place of the prompt while the argument is typed.
+func (a *numericArg) prompt() []rune {
+	if a.neg && !a.digits {
+		return []rune("(arg: -1) ")
+	}
+	n := a.n
+	if a.neg {
+		n = -n
+	}
+	return []rune(fmt.Sprintf("(arg: %d) ", n))
+}
+
+// reverseInputs pairs the commands that a negative argument reverses.
+var reverseInputs = map[interface{}]interface{}{
+	rune(ctrlF): rune(ctrlB),
+	rune(ctrlB): rune(ctrlF),
+	right:       left,
+	left:        right,
+	altF:        altB,
+	altB:        altF,
+	wordRight:   wordLeft,
+	wordLeft:    wordRight,
+	altD:        altBs,
+	altBs:       altD,
+	ctrlBs:      altD,
+	rune(ctrlW): altD,
+	rune(ctrlD): rune(bs),
+	del:         rune(bs),
+	rune(bs):    del,
+	rune(ctrlH): del,
+	rune(ctrlK): rune(ctrlU),
+	rune(ctrlU): rune(ctrlK),
+	rune(ctrlP): rune(ctrlN),
+	rune(ctrlN): rune(ctrlP),
+	up:          down,
+	down:        up,
+}
 
-	// Point killRing to a newNode, procedure depends on the killring state and
-	// append mode.
-	if mode == 0 { // Add new node to killRing
-		if s.killRing == nil { // if killring is empty, create a new one
-			s.killRing = ring.New(1)
-		} else if s.killRing.Len() >= KillRingMax { // if killring is "full"
-			s.killRing = s.killRing.Next()
-		} else { // Normal case
-			s.killRing.Link(ring.New(1))
-			s.killRing = s.killRing.Next()
-		}
-	} else {
-		if s.killRing == nil { // if killring is empty, create a new one
-			s.killRing = ring.New(1)
-			s.killRing.Value = []rune{}
+// apply returns the number of times to run next, and the command to run.
+func (a *numericArg) apply(next interface{}) (int, interface{}) {
+	n := a.n
+	if a.neg {
+		if !a.digits {
+			n = 1
 		}
-		if mode == 1 { // Append to last entry
-			killLine = append(s.killRing.Value.([]rune), killLine...)
-		} else if mode == 2 { // Prepend to last entry
-			killLine = append(killLine, s.killRing.Value.([]rune)...)
+		if r, ok := reverseInputs[next]; ok {
+			next = r
 		}
 	}
+	if next == rune(ctrlG) {
+		// Ctrl-G cancels the argument
+		return 1, rune(esc)
+	}
+	if n < 1 && next != yankLastArg && next != yankNthArg {
+		// An argument of zero does nothing
+		return 1, rune(esc)
+	}
+	return n, next
+}
+
+// yanked is the text inserted by a yank command, which is replaced by a
+// following yank-pop or yank-last-arg.
+type yanked struct {
+	start, end int
+	back       int // for yank-last-arg, the history entry counting back from the newest
+}
 
-	// Save text in the current killring node
-	s.killRing.Value = killLine
+// insert inserts text at pos, or in place of the text yanked last if again
+// is set, and returns the new line and position.
+func (y *yanked) insert(line []rune, pos int, text []rune, again bool) ([]rune, int) {
+	if again {
+		line = append(line[:y.start:y.start], line[y.end:]...)
+		pos = y.start
+	}
+	nl := make([]rune, 0, len(line)+len(text))
+	nl = append(nl, line[:pos]...)
+	nl = append(nl, text...)
+	nl = append(nl, line[pos:]...)
+	y.start, y.end = pos, pos+len(text)
+	return nl, y.end
 }
 
-func (s *State) yank(p []rune, text []rune, pos int) ([]rune, int, interface{}, error) {
+// yank inserts the current entry of the kill ring. If again is set, it
+// replaces the text inserted by the last yank with the entry before it.
+func (s *State) yank(line []rune, pos int, y *yanked, again bool) ([]rune, int, bool) {
 	if s.killRing == nil {
-		return text, pos, rune(esc), nil
+		return line, pos, false
+	}
+	if again {
+		s.killRing = s.killRing.Prev()
 	}
+	line, pos = y.insert(line, pos, s.killRing.Value.([]rune), again)
+	return line, pos, true
+}
 
-	lineStart := text[:pos]
-	lineEnd := text[pos:]
-	var line []rune
+// yankArg inserts word n (or the last word, if n is negative) of the
+// previous history entry. If again is set, the text inserted by the last
+// call is replaced with the word from the entry before that one.
+func (s *State) yankArg(line []rune, pos int, y *yanked, n int, again bool) ([]rune, int, bool) {
+	hist := s.getHistoryByPrefix("")
+	back := 1
+	if again {
+		back = y.back + 1
+	}
+	if back > len(hist) {
+		return line, pos, false
+	}
+	breaks, quotes := s.wordChars()
+	words := historyWords([]rune(hist[len(hist)-back]), breaks, quotes)
+	if n < 0 {
+		n = len(words) - 1
+	}
+	if n < 0 || n >= len(words) {
+		return line, pos, false
+	}
+	y.back = back
+	line, pos = y.insert(line, pos, []rune(words[n]), again)
+	return line, pos, true
+}
 
-	for {
-		value := s.killRing.Value.([]rune)
-		line = make([]rune, 0)
-		line = append(line, lineStart...)
-		line = append(line, value...)
-		line = append(line, lineEnd...)
+// Prompt displays p and returns a line of user input, not including a trailing
+// newline character. An io.EOF error is returned if the user signals end-of-file
+// by pressing Ctrl-D. Prompt allows line editing if the terminal supports it.
+//
+// The prompt may select colors and other attributes with ANSI SGR escape
+// sequences (such as "\x1b[32m"), which take up no columns; they are removed
+// if colors are disabled (see SetColors). Likewise, it may hold hyperlinks
+// made by Link.
+func (s *State) Prompt(prompt string) (string, error) {
+	return s.PromptWithSuggestion(prompt, "", 0)
+}
 
-		pos = len(lineStart) + len(value)
-		err := s.refresh(p, line, pos)
-		if err != nil {
-			return line, pos, 0, err
+// PromptContext is like Prompt, but if ctx is cancelled or its deadline
+// passes before a line is entered, it abandons the line, leaves the terminal
+// as Prompt found it and returns ctx.Err(). Without line editing (see
+// TerminalSupported) ctx is only checked before prompting.
+func (s *State) PromptContext(ctx context.Context, prompt string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	defer s.abortWhenDone(ctx)()
+	return s.Prompt(prompt)
+}
+
+// abortWhenDone ends the prompt in progress, or the next to start, with
+// ctx.Err() once ctx is done, until stop is called.
+func (s *State) abortWhenDone(ctx context.Context) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+			return
+		}
+		// The Prompt may not have started waiting for keys yet
+		for !s.runAsync(func() { s.aborted = ctx.Err() }) {
+			select {
+			case <-done:
+				return
+			case <-time.After(10 * time.Millisecond):
+			}
 		}
+	}()
+	return func() { close(done) }
+}
 
+// ReadKey waits for a key to be pressed, without displaying anything, and
+// returns it, for a program that acts on single keys, such as "Press any
+// key to continue" or a pager. Keys are decoded as they are for Prompt, on
+// every platform, with Alt and a key read as one Key. If SetCtrlCAborts
+// has been called, Ctrl-C returns ErrPromptAborted; otherwise it is
+// returned as a Key, as are Enter and Ctrl-D.
+//
+// If line editing is not supported, ReadKey returns the next character
+// read, which the terminal only sends once Enter is pressed.
+func (s *State) ReadKey() (Key, error) {
+	if s.inputRedirected || !s.terminalSupported {
+		return s.readKeyUnsupported()
+	}
+
+	s.resumeAfterPanic()
+	defer s.restoreOnPanic()
+	defer s.stopPrompt()
+	s.setPrompting(true)
+	defer func() {
+		s.setPrompting(false)
+		s.runQueued()
+		s.aborted = nil
+	}()
+
+restart:
+	s.startPrompt()
+	for {
 		next, err := s.readNext()
+		if err == nil && next == rune(esc) && s.terminalWaiting() {
+			// Alt and a key arrive as Esc followed by the key
+			var n interface{}
+			n, err = s.readNext()
+			if k, ok := toKey(n); ok && k.Mod&ModAlt == 0 && err == nil {
+				k.Mod |= ModAlt
+				s.stopReader()
+				return k, nil
+			}
+			s.unread = append([]interface{}{n}, s.unread...)
+		}
 		if err != nil {
-			return line, pos, next, err
+			if s.aborted != nil {
+				s.stopReader()
+				return Key{}, err
+			}
+			if s.shouldRestart != nil && s.shouldRestart(err) {
+				goto restart
+			}
+			return Key{}, err
 		}
-
-		switch v := next.(type) {
-		case rune:
-			return line, pos, next, nil
-		case action:
-			switch v {
-			case altY:
-				s.killRing = s.killRing.Prev()
-			default:
-				return line, pos, next, nil
+		k, ok := toKey(next)
+		if !ok {
+			// Such as a paste marker or a change of size
+			continue
+		}
+		switch k.Rune {
+		case ctrlC:
+			if s.ctrlCAborts {
+				return Key{}, ErrPromptAborted
 			}
+		case cr, lf, ctrlD:
+		default:
+			// The reader only stops by itself after a line ending
+			s.stopReader()
 		}
+		return k, nil
 	}
 }
 
-// Prompt displays p and returns a line of user input, not including a trailing
-// newline character. An io.EOF error is returned if the user signals end-of-file
-// by pressing Ctrl-D. Prompt allows line editing if the terminal supports it.
-func (s *State) Prompt(prompt string) (string, error) {
-	return s.PromptWithSuggestion(prompt, "", 0)
+// ReadKeyContext is like ReadKey, but if ctx is cancelled or its deadline
+// passes before a key is pressed, it returns ctx.Err(). Without line
+// editing ctx is only checked before reading.
+func (s *State) ReadKeyContext(ctx context.Context) (Key, error) {
+	if err := ctx.Err(); err != nil {
+		return Key{}, err
+	}
+	defer s.abortWhenDone(ctx)()
+	return s.ReadKey()
+}
+
+// PromptWithTimeout is like Prompt, but if no key is pressed for d, it
+// enters def in place of the line, and returns it, for a program such as
+// an installer that must go on unattended. The time left is counted down
+// in place of the right prompt (see SetRightPrompt). An empty line is also
+// returned as def. Without line editing (see TerminalSupported) there is
+// no timeout, and only an empty line is def.
+func (s *State) PromptWithTimeout(prompt, def string, d time.Duration) (string, error) {
+	if s.inputRedirected || !s.terminalSupported {
+		line, err := s.Prompt(prompt)
+		if err == nil && line == "" {
+			line = def
+		}
+		return line, err
+	}
+
+	s.lastInput = time.Now()
+	left := func() time.Duration {
+		return d - time.Since(s.lastInput)
+	}
+	secs := func() int {
+		return int((left() + time.Second - 1) / time.Second)
+	}
+	// The countdown is displayed over the right prompt, which is left as
+	// it is, so that a SetRightPrompt made meanwhile is kept
+	s.countdown = func() string {
+		return fmt.Sprintf("default in %ds", secs())
+	}
+	defer func() { s.countdown = nil }()
+
+	// tick runs on the Prompt's goroutine, and sends how long to wait
+	// until the countdown next changes, unless the time is up
+	ticks := make(chan time.Duration, 1)
+	tick := func() {
+		if s.redraw == nil {
+			// The Prompt has ended
+			return
+		}
+		if left() <= 0 {
+			s.unread = append(s.unread, boundFunc(func(b *Buffer) error {
+				b.Replace(0, len(b.line), def)
+				return nil
+			}), rune(cr))
+			return
+		}
+		s.redisplay()
+		ticks <- left() - time.Duration(secs()-1)*time.Second
+	}
+	started := make(chan struct{})
+	s.asyncMu.Lock()
+	s.started = started
+	s.asyncMu.Unlock()
+	done := make(chan struct{})
+	defer func() {
+		close(done)
+		s.asyncMu.Lock()
+		s.started = nil
+		s.asyncMu.Unlock()
+	}()
+	delay := left() - time.Duration(secs()-1)*time.Second
+	go func() {
+		// Functions can only be queued for the Prompt once it has started
+		select {
+		case <-done:
+			return
+		case <-started:
+		}
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(delay):
+			}
+			if !s.runAsync(tick) {
+				return
+			}
+			select {
+			case <-done:
+				return
+			case delay = <-ticks:
+			}
+		}
+	}()
+
+	line, err := s.Prompt(prompt)
+	if err == nil && line == "" {
+		line = def
+	}
+	return line, err
 }
 
 // PromptWithSuggestion displays prompt and an editable text with cursor at
@@ -602,24 +2792,41 @@ func (s *State) Prompt(prompt string) (string, error) {
 // including a trailing newline character. An io.EOF error is returned if the user
 // signals end-of-file by pressing Ctrl-D.
 func (s *State) PromptWithSuggestion(prompt string, text string, pos int) (string, error) {
-	for _, r := range prompt {
-		if unicode.Is(unicode.C, r) {
-			return "", ErrInvalidPrompt
-		}
+	line, err := s.promptWithSuggestion(prompt, text, pos, lineChecks{})
+	s.record(prompt, line, err)
+	return line, err
+}
+
+// promptChecked is like Prompt, but the line is also checked by checks.
+func (s *State) promptChecked(prompt string, checks lineChecks) (string, error) {
+	line, err := s.promptWithSuggestion(prompt, "", 0, checks)
+	s.record(prompt, line, err)
+	return line, err
+}
+
+// promptWithSuggestion reads the line for PromptWithSuggestion, checked by
+// checks as well as by the program's input filter and accept hook.
+func (s *State) promptWithSuggestion(prompt string, text string, pos int, checks lineChecks) (string, error) {
+	prompt, err := s.checkPrompt(prompt)
+	if err != nil {
+		return "", err
 	}
 	if s.inputRedirected || !s.terminalSupported {
 		return s.promptUnsupported(prompt)
 	}
 	p := []rune(prompt)
 	const minWorkingSpace = 10
-	if s.columns < countGlyphs(p)+minWorkingSpace {
+	if s.columns < countGlyphs(visibleRunes(p))+minWorkingSpace {
 		return s.tooNarrow(prompt)
 	}
 	if s.outputRedirected {
 		return "", ErrNotTerminalOutput
 	}
 
-	fmt.Print(prompt)
+	fmt.Fprint(s.display(), prompt)
+	s.lineBreaks = false
+	s.scroll = 0
+	s.lineRows, s.rowsBelowCursor, s.belowEnd = 1, 0, 1
 	var line = []rune(text)
 	historyEnd := ""
 	var historyPrefix []string
@@ -627,13 +2834,60 @@ func (s *State) PromptWithSuggestion(prompt string, text string, pos int) (strin
 	historyStale := true
 	historyAction := false // used to mark history related actions
 	killAction := 0        // used to mark kill related actions
+	fresh := false         // next was just read from the terminal
+	var numArg numericArg  // numeric argument being typed
+	count := 1             // number of times to repeat the command
+	argGiven := false      // count was given as a numeric argument
+	var lastYank yanked    // used by yank-pop
+	yankAction := 0        // used to mark yank related actions
+	var lastArg yanked     // used by yank-last-arg
+	lastArgAction := 0     // used to mark yank-last-arg actions
+	eofAction := 0         // used to mark a Ctrl-D that asked for confirmation
+	mark := -1             // the other end of the region, if set
+	paired := 0            // auto-inserted closing characters after pos
+	pairAction := false    // used to mark actions that keep them paired
 
+	s.resumeAfterPanic()
+	defer s.restoreOnPanic()
 	defer s.stopPrompt()
+	defer s.showCursor(CursorDefault)
+
+	s.prompt = p
+	s.redraw = func() error {
+		if numArg.active {
+			return s.refresh(numArg.prompt(), line, pos)
+		}
+		return s.refresh(s.prompt, line, pos)
+	}
+	s.setPrompting(true)
+	defer func() {
+		s.redraw = nil
+		if s.burstTimer != nil {
+			s.burstTimer.Stop()
+		}
+		s.burstKeys = 0
+		if s.previewTimer != nil {
+			s.previewTimer.Stop()
+		}
+		s.ghost, s.ghostFor, s.previewFor, s.previewBusy = "", "", "", false
+		s.setPrompting(false)
+		// Anything queued since the last key is done without the line
+		s.runQueued()
+		s.aborted = nil
+	}()
 
 	if pos < 0 || len(line) < pos {
 		pos = len(line)
 	}
-	if len(line) > 0 {
+	if s.preInputHook != nil {
+		b := Buffer{line: line, pos: pos}
+		if err := s.preInputHook(&b); err != nil {
+			return "", err
+		}
+		line, pos = b.line, b.pos
+	}
+	changes := newUndoHistory(line, pos)
+	if len(line) > 0 || s.hinter != nil || s.status != "" {
 		err := s.refresh(p, line, pos)
 		if err != nil {
 			return "", err
@@ -643,39 +2897,103 @@ func (s *State) PromptWithSuggestion(prompt string, text string, pos int) (strin
 restart:
 	s.startPrompt()
 	s.getColumns()
+	s.layoutColumns = s.columns
+	s.showCursor(s.modeCursorShape())
 
 mainLoop:
 	for {
 		next, err := s.readNext()
+		fresh = true
 	haveNext:
 		if err != nil {
+			if s.aborted != nil {
+				s.stopReader()
+				s.clearBelow()
+				fmt.Fprintln(s.display())
+				return "", err
+			}
 			if s.shouldRestart != nil && s.shouldRestart(err) {
 				goto restart
 			}
 			return "", err
 		}
+		p = s.prompt // RefreshPrompt may have changed it
+		changes.update(line, pos)
+		// Editing functions return Esc when they consumed the key that
+		// ended them, so it is only looked up when typed.
+		if fresh || next != rune(esc) {
+			next, err = s.resolveBinding(next, fresh)
+			fresh = false
+			if err != nil {
+				goto haveNext
+			}
+		}
+
+		if numArg.add(next, s.lastChord) {
+			err := s.refresh(numArg.prompt(), line, pos)
+			if err != nil {
+				return "", err
+			}
+			continue
+		}
+		count, argGiven = 1, false
+		if numArg.active {
+			argGiven = true
+			count, next = numArg.apply(next)
+			numArg = numericArg{}
+			s.needRefresh = true
+		}
 
+	dispatch:
 		historyAction = false
+		pairAction = false
+		if s.message != nil {
+			s.message = nil
+			s.needRefresh = true
+		}
 		switch v := next.(type) {
 		case rune:
 			switch v {
 			case cr, lf:
-				if s.needRefresh {
+				if accept := s.lineAcceptHook(checks); accept != nil {
+					result, msg := accept(string(line))
+					if result != Accept {
+						// The reader stops after a line ending
+						s.restartPrompt()
+					}
+					if result == Continue {
+						line = append(line[:pos], append([]rune{'\n'}, line[pos:]...)...)
+						pos++
+						s.message = s.fitLines(msg, Style{})
+						s.needRefresh = true
+						break
+					}
+					if result == Reject {
+						s.message = s.fitLines(msg, Style{Fg: ColorRed})
+						s.doBeep()
+						s.needRefresh = true
+						break
+					}
+				}
+				if s.needRefresh || s.ghost != "" {
+					s.ghost = ""
 					err := s.refresh(p, line, pos)
 					if err != nil {
 						return "", err
 					}
 				}
-				if s.multiLineMode {
+				s.clearBelow()
+				if s.multiLine() {
 					s.resetMultiLine(p, line, pos)
 				}
-				fmt.Println()
+				fmt.Fprintln(s.display())
+				s.endKey = Key{Rune: v}
 				break mainLoop
 			case ctrlA: // Start of line
-				pos = 0
+				pos, _ = rowBounds(line, pos)
 				s.needRefresh = true
 			case ctrlE: // End of line
-				pos = len(line)
+				_, pos = rowBounds(line, pos)
 				s.needRefresh = true
 			case ctrlB: // left
 				if pos > 0 {
@@ -688,13 +3006,38 @@ mainLoop:
 				if pos < len(line) {
 					pos += len(getPrefixGlyphs(line[pos:], 1))
 					s.needRefresh = true
+				} else if s.ghost != "" {
+					line, pos = s.acceptGhost(line)
 				} else {
 					s.doBeep()
 				}
 			case ctrlD: // del
 				if pos == 0 && len(line) == 0 {
-					// exit
-					return "", io.EOF
+					var eof bool
+					switch s.eofBehavior {
+					case EOFOnEmptyLine:
+						eof = true
+					case EOFConfirm:
+						eof = eofAction > 0
+						s.message = s.fitLines("Press Ctrl-D again to exit", Style{})
+						eofAction = 2
+					case EOFCallback:
+						var msg string
+						if s.eofHandler != nil {
+							eof, msg = s.eofHandler()
+						}
+						s.message = s.fitLines(msg, Style{})
+					}
+					if eof {
+						s.clearBelow()
+						s.endKey = Key{Rune: ctrlD}
+						return "", io.EOF
+					}
+					if s.message != nil {
+						s.needRefresh = true
+						s.restartPrompt()
+						break
+					}
 				}
 
 				// ctrlD is a potential EOF, so the rune reader shuts down.
@@ -709,6 +3052,7 @@ mainLoop:
 					s.needRefresh = true
 				}
 			case ctrlK: // delete remainder of line
+				count = 1
 				if pos >= len(line) {
 					s.doBeep()
 				} else {
@@ -723,6 +3067,11 @@ mainLoop:
 					s.needRefresh = true
 				}
 			case ctrlP: // up
+				if p, ok := rowMove(line, pos, -1); ok {
+					pos = p
+					s.needRefresh = true
+					break
+				}
 				historyAction = true
 				if historyStale {
 					historyPrefix = s.getHistoryByPrefix(string(line))
@@ -741,6 +3090,11 @@ mainLoop:
 					s.doBeep()
 				}
 			case ctrlN: // down
+				if p, ok := rowMove(line, pos, 1); ok {
+					pos = p
+					s.needRefresh = true
+					break
+				}
 				historyAction = true
 				if historyStale {
 					historyPrefix = s.getHistoryByPrefix(string(line))
@@ -776,23 +3130,35 @@ mainLoop:
 					s.needRefresh = true
 				}
 			case ctrlL: // clear screen
-				s.eraseScreen()
+				s.clearScreen()
 				s.needRefresh = true
 			case ctrlC: // reset
-				fmt.Println("^C")
-				if s.multiLineMode {
+				s.clearBelow()
+				if s.ghost != "" {
+					s.ghost = ""
+					s.eraseLine()
+				}
+				fmt.Fprintln(s.display(), "^C")
+				if s.multiLine() {
 					s.resetMultiLine(p, line, pos)
 				}
 				if s.ctrlCAborts {
+					s.endKey = Key{Rune: ctrlC}
 					return "", ErrPromptAborted
 				}
 				line = line[:0]
 				pos = 0
-				fmt.Print(prompt)
+				fmt.Fprint(s.display(), string(p))
 				s.restartPrompt()
 			case ctrlH, bs: // Backspace
 				if pos <= 0 {
 					s.doBeep()
+				} else if paired > 0 && pos < len(line) && s.autoPairs[line[pos-1]] == line[pos] {
+					line = append(line[:pos-1], line[pos+1:]...)
+					pos--
+					paired--
+					pairAction = true
+					s.needRefresh = true
 				} else {
 					n := len(getSuffixGlyphs(line[:pos], 1))
 					line = append(line[:pos-n], line[pos:]...)
@@ -800,6 +3166,7 @@ mainLoop:
 					s.needRefresh = true
 				}
 			case ctrlU: // Erase line before cursor
+				count = 1
 				if killAction > 0 {
 					s.addToKillRing(line[:pos], 2) // Add in prepend mode
 				} else {
@@ -811,38 +3178,124 @@ mainLoop:
 				pos = 0
 				s.needRefresh = true
 			case ctrlW: // Erase word
-				pos, line, killAction = s.eraseWord(pos, line, killAction)
+				pos, line, killAction = s.eraseWord(pos, line, killAction, unicode.IsSpace)
 			case ctrlY: // Paste from Yank buffer
-				line, pos, next, err = s.yank(p, line, pos)
-				goto haveNext
+				s.ghost = ""
+				var ok bool
+				if line, pos, ok = s.yank(line, pos, &lastYank, false); !ok {
+					s.doBeep()
+				}
+				yankAction = 2
+				s.needRefresh = true
 			case ctrlR: // Reverse Search
+				s.ghost = ""
 				line, pos, next, err = s.reverseISearch(line, pos)
 				s.needRefresh = true
 				goto haveNext
 			case tab: // Tab completion
-				line, pos, next, err = s.tabComplete(p, line, pos)
+				if s.inBurst() {
+					// Pasted rather than typed
+					line = append(line[:pos], append([]rune{tab}, line[pos:]...)...)
+					pos++
+					s.needRefresh = true
+					break
+				}
+				if s.ghost != "" {
+					line, pos = s.acceptGhost(line)
+					break
+				}
+				line, pos, next, err = s.tabComplete(p, line, pos, tabForward)
 				goto haveNext
 			// Catch keys that do nothing, but you don't want them to beep
 			case esc:
 				// DO NOTHING
 			// Unused keys
-			case ctrlG, ctrlO, ctrlQ, ctrlS, ctrlV, ctrlX, ctrlZ:
+			case ctrlV: // Insert the next character literally
+				var r rune
+				r, err = s.readQuoted()
+				if err != nil {
+					goto haveNext
+				}
+				if r < 0 {
+					s.doBeep()
+					break
+				}
+				for ; count > 0; count-- {
+					line = append(line[:pos], append([]rune{r}, line[pos:]...)...)
+					pos++
+				}
+				count = 1
+				s.needRefresh = true
+			case ctrlZ: // Suspend
+				if !s.suspendable() {
+					s.doBeep()
+					break
+				}
+				s.clearBelow()
+				if s.ghost != "" {
+					s.ghost = ""
+					s.eraseLine()
+				}
+				fmt.Fprintln(s.display(), "^Z")
+				if s.multiLine() {
+					s.resetMultiLine(p, line, pos)
+				}
+				s.suspend()
+				s.getColumns()
+				s.needRefresh = true
+			case ctrlG, ctrlO, ctrlQ, ctrlS, ctrlX:
 				fallthrough
 			// Catch unhandled control codes (anything <= 31)
 			case 0, 28, 29, 30, 31:
 				s.doBeep()
 			default:
-				if pos == len(line) && !s.multiLineMode &&
-					len(p)+len(line) < s.columns*4 && // Avoid countGlyphs on large lines
-					countGlyphs(p)+countGlyphs(line) < s.columns-1 {
-					line = append(line, v)
-					fmt.Printf("%c", v)
+				if filter := s.lineFilter(checks); filter != nil && !s.filtered {
+					out := filter(v)
+					if len(out) == 0 {
+						s.doBeep()
+						break
+					}
+					if len(out) != 1 || out[0] != v {
+						unread := make([]interface{}, len(out), len(out)+len(s.unread))
+						for i, r := range out {
+							unread[i] = r
+						}
+						s.unread = append(unread, s.unread...)
+						s.unfiltered += len(out)
+						break
+					}
+				}
+				changes.inserting = true
+				pairAction = true
+				if s.autoPairs != nil && !s.overwrite {
+					if paired > 0 && pos < len(line) && line[pos] == v && s.autoPairCloses(v) {
+						pos++
+						paired--
+						s.needRefresh = true
+						break
+					}
+					if c, ok := s.autoPairs[v]; ok && (c != v || pos == 0 || !isWordRune(line[pos-1])) {
+						line = append(line[:pos], append([]rune{v, c}, line[pos:]...)...)
+						pos++
+						paired++
+						s.needRefresh = true
+						break
+					}
+				}
+				if s.overwrite && pos < len(line) {
+					n := len(getPrefixGlyphs(line[pos:], 1))
+					line = append(line[:pos], append([]rune{v}, line[pos+n:]...)...)
 					pos++
+					s.needRefresh = true
 				} else {
 					line = append(line[:pos], append([]rune{v}, line[pos:]...)...)
 					pos++
 					s.needRefresh = true
 				}
+				if s.completer != nil && strings.ContainsRune(s.triggers, v) && !s.inBurst() {
+					line, pos, next, err = s.tabComplete(p, line, pos, tabForward)
+					goto haveNext
+				}
 			}
 		case action:
 			switch v {
@@ -870,9 +3323,9 @@ mainLoop:
 						if leftKnown {
 							spaceHere = spaceLeft
 						} else {
-							spaceHere = unicode.IsSpace(line[pos])
+							spaceHere = s.isWordBreak(line[pos])
 						}
-						spaceLeft, leftKnown = unicode.IsSpace(line[pos-1]), true
+						spaceLeft, leftKnown = s.isWordBreak(line[pos-1]), true
 						if !spaceHere && spaceLeft {
 							break
 						}
@@ -883,6 +3336,8 @@ mainLoop:
 			case right:
 				if pos < len(line) {
 					pos += len(getPrefixGlyphs(line[pos:], 1))
+				} else if s.ghost != "" {
+					line, pos = s.acceptGhost(line)
 				} else {
 					s.doBeep()
 				}
@@ -897,9 +3352,9 @@ mainLoop:
 						if hereKnown {
 							spaceLeft = spaceHere
 						} else {
-							spaceLeft = unicode.IsSpace(line[pos-1])
+							spaceLeft = s.isWordBreak(line[pos-1])
 						}
-						spaceHere, hereKnown = unicode.IsSpace(line[pos]), true
+						spaceHere, hereKnown = s.isWordBreak(line[pos]), true
 						if spaceHere && !spaceLeft {
 							break
 						}
@@ -908,6 +3363,10 @@ mainLoop:
 					s.doBeep()
 				}
 			case up:
+				if p, ok := rowMove(line, pos, -1); ok {
+					pos = p
+					break
+				}
 				historyAction = true
 				if historyStale {
 					historyPrefix = s.getHistoryByPrefix(string(line))
@@ -925,6 +3384,10 @@ mainLoop:
 					s.doBeep()
 				}
 			case down:
+				if p, ok := rowMove(line, pos, 1); ok {
+					pos = p
+					break
+				}
 				historyAction = true
 				if historyStale {
 					historyPrefix = s.getHistoryByPrefix(string(line))
@@ -942,202 +3405,850 @@ mainLoop:
 				} else {
 					s.doBeep()
 				}
-			case home: // Start of line
-				pos = 0
-			case end: // End of line
-				pos = len(line)
-			case altD: // Delete next word
-				if pos == len(line) {
+			case home: // Start of line
+				pos, _ = rowBounds(line, pos)
+			case end: // End of line
+				_, pos = rowBounds(line, pos)
+			case insert: // Toggle overwrite mode
+				s.overwrite = !s.overwrite
+				s.showCursor(s.modeCursorShape())
+				if s.overwriteHook != nil {
+					s.overwriteHook(s.overwrite)
+				}
+			case altD: // Delete next word
+				if pos == len(line) {
+					s.doBeep()
+					break
+				}
+				// Remove whitespace to the right
+				var buf []rune // Store the deleted chars in a buffer
+				for {
+					if pos == len(line) || !s.isWordBreak(line[pos]) {
+						break
+					}
+					buf = append(buf, line[pos])
+					line = append(line[:pos], line[pos+1:]...)
+				}
+				// Remove non-whitespace to the right
+				for {
+					if pos == len(line) || s.isWordBreak(line[pos]) {
+						break
+					}
+					buf = append(buf, line[pos])
+					line = append(line[:pos], line[pos+1:]...)
+				}
+				// Save the result on the killRing
+				if killAction > 0 {
+					s.addToKillRing(buf, 2) // Add in prepend mode
+				} else {
+					s.addToKillRing(buf, 0) // Add in normal mode
+				}
+				killAction = 2 // Mark that there was some killing
+			case altBs, ctrlBs: // Erase word
+				pos, line, killAction = s.eraseWord(pos, line, killAction, s.isKillWordBreak)
+			case shiftTab: // Tab completion, starting from the last candidate
+				line, pos, next, err = s.tabComplete(p, line, pos, tabReverse)
+				goto haveNext
+			case undo, redo:
+				f := changes.undo
+				if v == redo {
+					f = changes.redo
+				}
+				if l, p, ok := f(); ok {
+					line, pos = l, p
+				} else {
+					s.doBeep()
+				}
+			case altY: // Replace the text just yanked with the previous kill
+				if yankAction == 0 {
+					s.doBeep()
+					break
+				}
+				line, pos, _ = s.yank(line, pos, &lastYank, true)
+				yankAction = 2
+			case yankLastArg, yankNthArg:
+				n := -1
+				if v == yankNthArg {
+					n = 1
+				}
+				if argGiven {
+					n, count = count, 1
+				}
+				again := v == yankLastArg && lastArgAction > 0
+				var ok bool
+				line, pos, ok = s.yankArg(line, pos, &lastArg, n, again)
+				if !ok {
+					s.doBeep()
+				}
+				lastArgAction = 2
+			case upcaseWord:
+				pos = s.changeWordCase(line, pos, func(r rune, first bool) rune {
+					return unicode.ToUpper(r)
+				})
+			case downcaseWord:
+				pos = s.changeWordCase(line, pos, func(r rune, first bool) rune {
+					return unicode.ToLower(r)
+				})
+			case capitalizeWord:
+				pos = s.changeWordCase(line, pos, func(r rune, first bool) rune {
+					if first {
+						return unicode.ToTitle(r)
+					}
+					return unicode.ToLower(r)
+				})
+			case editCommandLine:
+				count = 1
+				s.ghost = ""
+				s.clearBelow()
+				if l, err := s.editCommandLine(line); err != nil {
+					s.message = s.fitLines(err.Error(), Style{Fg: ColorRed})
+					s.doBeep()
+				} else {
+					line, pos = l, len(l)
+				}
+				s.getColumns()
+				s.needRefresh = true
+			case setMark:
+				count = 1
+				mark = pos
+			case exchangeMark:
+				count = 1
+				if mark < 0 {
+					s.doBeep()
+					break
+				}
+				if mark > len(line) {
+					mark = len(line)
+				}
+				mark, pos = pos, mark
+			case killRegion, copyRegion:
+				count = 1
+				if mark < 0 {
+					s.doBeep()
+					break
+				}
+				start, end := regionBounds(mark, pos, len(line))
+				if v == copyRegion {
+					s.addToKillRing(line[start:end], 0)
+					break
+				}
+				if killAction > 0 {
+					s.addToKillRing(line[start:end], 1) // Add in append mode
+				} else {
+					s.addToKillRing(line[start:end], 0) // Add in normal mode
+				}
+				killAction = 2
+				line = append(line[:start], line[end:]...)
+				pos, mark = start, start
+				s.needRefresh = true
+			case killWholeLine:
+				count = 1
+				if len(line) == 0 {
+					s.doBeep()
+					break
+				}
+				if killAction > 0 {
+					s.addToKillRing(line, 1) // Add in append mode
+				} else {
+					s.addToKillRing(line, 0) // Add in normal mode
+				}
+				killAction = 2
+				line = line[:0]
+				pos = 0
+				s.needRefresh = true
+			case transposeWords:
+				var ok bool
+				inWord := func(r rune) bool { return !s.isKillWordBreak(r) }
+				if line, pos, ok = transposeWordsAt(line, pos, inWord); !ok {
+					s.doBeep()
+				}
+			case pasteStart:
+				count = 1
+				s.ghost = ""
+				line, pos, err = s.readPaste(line, pos)
+				if err != nil {
+					goto haveNext
+				}
+			case pasteClipboard:
+				s.ghost = ""
+				s.needRefresh = true
+				var text []rune
+				if s.clipboard {
+					text, err = s.readClipboard()
+				}
+				if !s.clipboard || err == errNoClipboard {
+					var ok bool
+					if line, pos, ok = s.yank(line, pos, &lastYank, false); !ok {
+						s.doBeep()
+					}
+					yankAction = 2
+					err = nil
+					break
+				}
+				if err != nil {
+					return "", err
+				}
+				text = s.pastedText(text)
+				line = append(line[:pos], append(text, line[pos:]...)...)
+				pos += len(text)
+			case startMacro:
+				s.recording = true
+				s.recorded = nil
+			case endMacro:
+				if !s.recording {
 					s.doBeep()
 					break
 				}
-				// Remove whitespace to the right
-				var buf []rune // Store the deleted chars in a buffer
-				for {
-					if pos == len(line) || !unicode.IsSpace(line[pos]) {
-						break
-					}
-					buf = append(buf, line[pos])
-					line = append(line[:pos], line[pos+1:]...)
+				// Leave out the keys that ended the recording
+				n := len(s.recorded) - s.chordInputs
+				if n < 0 {
+					n = 0
 				}
-				// Remove non-whitespace to the right
-				for {
-					if pos == len(line) || unicode.IsSpace(line[pos]) {
-						break
+				s.recording = false
+				s.macro = s.macro[:0]
+				for _, v := range s.recorded[:n] {
+					if k, ok := toKey(v); ok {
+						s.macro = append(s.macro, k)
 					}
-					buf = append(buf, line[pos])
-					line = append(line[:pos], line[pos+1:]...)
 				}
-				// Save the result on the killRing
-				if killAction > 0 {
-					s.addToKillRing(buf, 2) // Add in prepend mode
-				} else {
-					s.addToKillRing(buf, 0) // Add in normal mode
+			case callMacro:
+				if s.recording {
+					s.doBeep()
+					break
 				}
-				killAction = 2 // Mark that there was some killing
-			case altBs: // Erase word
-				pos, line, killAction = s.eraseWord(pos, line, killAction)
+				s.playMacro(s.macro)
 			case winch: // Window change
-				if s.multiLineMode {
-					if s.maxRows-s.cursorRows > 0 {
-						s.moveDown(s.maxRows - s.cursorRows)
-					}
-					for i := 0; i < s.maxRows-1; i++ {
-						s.cursorPos(0)
-						s.eraseLine()
-						s.moveUp(1)
-					}
-					s.maxRows = 1
-					s.cursorRows = 1
-				}
+				s.resized()
+			}
+			s.needRefresh = true
+		case mouseEvent:
+			if !v.click() {
+				break
+			}
+			row, err := s.mouseRow(v, p, line, pos)
+			if err == nil && row >= 0 && row < s.lineRows {
+				s.ghost = ""
+				pos = s.posAt(p, line, row, v.x)
+				s.needRefresh = true
+			}
+		case boundFunc:
+			s.ghost = ""
+			b := Buffer{line: line, pos: pos}
+			if err := v(&b); err != nil {
+				return "", err
 			}
+			line, pos = b.line, b.pos
 			s.needRefresh = true
 		}
-		if s.needRefresh && !s.inputWaiting() {
-			err := s.refresh(p, line, pos)
-			if err != nil {
+		if s.killed {
+			s.killed = false
+			if s.clipboard {
+				s.copyToClipboard(string(s.killRing.Value.([]rune)))
+			}
+		}
+		if s.completionPreview {
+			s.updatePreview(line, pos)
+		}
+		// A command repeated by a numeric argument is displayed once,
+		// after its last repetition
+		if s.needRefresh && count <= 1 && !s.inputWaiting() {
+			if s.inBurst() {
+				s.refreshAfterBurst()
+			} else if err := s.refresh(p, line, pos); err != nil {
 				return "", err
 			}
 		}
 		if !historyAction {
 			historyStale = true
 		}
+		if !pairAction {
+			paired = 0
+		}
 		if killAction > 0 {
 			killAction--
 		}
+		if yankAction > 0 {
+			yankAction--
+		}
+		if lastArgAction > 0 {
+			lastArgAction--
+		}
+		if eofAction > 0 {
+			eofAction--
+		}
+		if count > 1 {
+			count--
+			goto dispatch
+		}
 	}
 	return string(line), nil
 }
 
+// readChoice displays prompt and reads one of keys, in lower case, for
+// Confirm and Choose, and returns its index. Enter is the key at index
+// enter, and the name of the key read is displayed.
+func (s *State) readChoice(prompt string, keys []rune, names []string, enter int) (int, error) {
+	prompt, err := s.checkPrompt(prompt)
+	if err != nil {
+		return -1, err
+	}
+	if s.inputRedirected || !s.terminalSupported {
+		return s.chooseUnsupported(prompt, keys, names, enter)
+	}
+	if s.outputRedirected {
+		return -1, ErrNotTerminalOutput
+	}
+
+	s.resumeAfterPanic()
+	defer s.restoreOnPanic()
+	defer s.stopPrompt()
+	s.setPrompting(true)
+	defer func() {
+		s.setPrompting(false)
+		s.runQueued()
+		s.aborted = nil
+	}()
+
+restart:
+	s.startPrompt()
+	fmt.Fprint(s.display(), prompt)
+	for {
+		next, err := s.readNext()
+		if err != nil {
+			if s.aborted != nil {
+				s.stopReader()
+				fmt.Fprintln(s.display())
+				return -1, err
+			}
+			if s.shouldRestart != nil && s.shouldRestart(err) {
+				goto restart
+			}
+			return -1, err
+		}
+		r, ok := next.(rune)
+		if !ok {
+			s.doBeep()
+			continue
+		}
+		choice := -1
+		switch r {
+		case cr, lf:
+			choice = enter
+		case ctrlC:
+			fmt.Fprintln(s.display(), "^C")
+			return -1, ErrPromptAborted
+		case ctrlD:
+			fmt.Fprintln(s.display())
+			return -1, io.EOF
+		default:
+			for i, k := range keys {
+				if unicode.ToLower(r) == k {
+					choice = i
+				}
+			}
+			if choice >= 0 {
+				// The reader only stops by itself after a line
+				// ending
+				s.stopReader()
+			}
+		}
+		if choice < 0 {
+			s.doBeep()
+			continue
+		}
+		fmt.Fprintln(s.display(), names[choice])
+		return choice, nil
+	}
+}
+
+// readMasked reads the input of PromptMasked.
+func (s *State) readMasked(prompt string, mask []rune, valid func(r rune) bool) (string, error) {
+	prompt, err := s.checkPrompt(prompt)
+	if err != nil {
+		return "", err
+	}
+	if s.inputRedirected || !s.terminalSupported {
+		return s.maskedUnsupported(prompt, mask, valid)
+	}
+	p := []rune(prompt)
+	if s.columns < countGlyphs(visibleRunes(p))+len(mask)+1 {
+		defer s.cookTerminal()()
+		return s.maskedUnsupported(prompt, mask, valid)
+	}
+	if s.outputRedirected {
+		return "", ErrNotTerminalOutput
+	}
+
+	s.resumeAfterPanic()
+	defer s.restoreOnPanic()
+	defer s.stopPrompt()
+	s.setPrompting(true)
+	defer func() {
+		s.setPrompting(false)
+		s.runQueued()
+		s.aborted = nil
+	}()
+
+	n := 0 // the number of places
+	for _, r := range mask {
+		if r == maskPlace {
+			n++
+		}
+	}
+	var places []rune // the characters typed
+	// refresh displays the mask with its places filled so far, and the
+	// cursor at the next place
+	refresh := func() error {
+		buf := fillMask(mask, places)
+		pos := len(buf)
+		s.bufStyles = nil
+		for i, j := 0, 0; i < len(mask); i++ {
+			if mask[i] != maskPlace {
+				continue
+			}
+			if j == len(places) {
+				pos = i
+			}
+			if j >= len(places) && !s.noColors {
+				if s.bufStyles == nil {
+					s.bufStyles = make([]Style, len(buf))
+				}
+				s.bufStyles[i] = Style{Dim: true}
+			}
+			j++
+		}
+		return s.refreshSingleLine(p, buf, pos)
+	}
+
+restart:
+	s.startPrompt()
+	s.getColumns()
+	s.scroll = 0
+	if err := refresh(); err != nil {
+		return "", err
+	}
+	for {
+		next, err := s.readNext()
+		if err != nil {
+			if s.aborted != nil {
+				s.stopReader()
+				fmt.Fprintln(s.display())
+				return "", err
+			}
+			if s.shouldRestart != nil && s.shouldRestart(err) {
+				goto restart
+			}
+			return "", err
+		}
+		switch v := next.(type) {
+		case rune:
+			switch {
+			case v == cr || v == lf:
+				if len(places) == n {
+					fmt.Fprintln(s.display())
+					return string(fillMask(mask, places)), nil
+				}
+				// The reader stops after a line ending
+				s.restartPrompt()
+				s.doBeep()
+			case v == ctrlC:
+				fmt.Fprintln(s.display(), "^C")
+				if s.ctrlCAborts {
+					return "", ErrPromptAborted
+				}
+				places = places[:0]
+				s.restartPrompt()
+			case v == ctrlD:
+				if len(places) == 0 {
+					fmt.Fprintln(s.display())
+					return "", io.EOF
+				}
+				s.restartPrompt()
+			case v == ctrlH || v == bs:
+				if len(places) == 0 {
+					s.doBeep()
+				} else {
+					places = places[:len(places)-1]
+				}
+			case v == ctrlU:
+				places = places[:0]
+			case v == ctrlL:
+				s.clearScreen()
+			case valid(v) && len(places) < n:
+				places = append(places, v)
+			case containsRune(mask, v) && v != maskPlace:
+				// A literal of the mask, which the cursor skips
+			default:
+				s.doBeep()
+			}
+		case action:
+			if v == ctrlBs && len(places) > 0 { // Ctrl-H read as Ctrl-Backspace
+				places = places[:len(places)-1]
+			}
+		}
+		if err := refresh(); err != nil {
+			return "", err
+		}
+	}
+}
+
 // PasswordPrompt displays p, and then waits for user input. The input typed by
-// the user is not displayed in the terminal.
+// the user is not displayed in the terminal, unless SetPasswordMask or
+// SetPasswordReveal says otherwise.
 func (s *State) PasswordPrompt(prompt string) (string, error) {
-	for _, r := range prompt {
-		if unicode.Is(unicode.C, r) {
-			return "", ErrInvalidPrompt
-		}
+	line, err := s.passwordPrompt(prompt, nil)
+	defer wipeRunes(line)
+	return string(line), err
+}
+
+// PasswordPromptWithValidator is like PasswordPrompt, but calls validate
+// whenever the input changes, and displays its message below the prompt,
+// as a Hinter's hint is displayed. Enter only accepts input that validate
+// reports ok; otherwise it beeps.
+func (s *State) PasswordPromptWithValidator(prompt string, validate PasswordValidator) (string, error) {
+	line, err := s.passwordPrompt(prompt, validate)
+	defer wipeRunes(line)
+	return string(line), err
+}
+
+// PasswordPromptBytes is like PasswordPrompt, but returns the input as
+// UTF-8 bytes, which the program can clear once it has used them, unlike a
+// string:
+//
+//	pw, err := line.PasswordPromptBytes("Password: ")
+//	...
+//	clear(pw)
+//
+// Liner zeroes its own copies of the input before PasswordPromptBytes
+// returns, and never adds the input to the history, the kill ring or a
+// keyboard macro. It cannot wipe copies made elsewhere, such as by the
+// operating system, nor the text written to the terminal while the input is
+// revealed (see SetPasswordReveal), nor the input read without line editing
+// when input is redirected.
+func (s *State) PasswordPromptBytes(prompt string) ([]byte, error) {
+	line, err := s.passwordPrompt(prompt, nil)
+	defer wipeRunes(line)
+	if err != nil {
+		return nil, err
+	}
+	n := 0
+	for _, r := range line {
+		n += utf8.RuneLen(r)
+	}
+	b := make([]byte, 0, n)
+	for _, r := range line {
+		b = utf8.AppendRune(b, r)
+	}
+	return b, nil
+}
+
+// passwordPrompt reads the input of PasswordPrompt, validated by validate
+// if it is not nil. When it returns an error, it has already wiped the
+// input, and the line is nil.
+func (s *State) passwordPrompt(prompt string, validate PasswordValidator) ([]rune, error) {
+	line, err := s.readPassword(prompt, validate)
+	// The transcript never holds the password
+	s.record(prompt, "", err)
+	return line, err
+}
+
+// readPassword reads the input of passwordPrompt.
+func (s *State) readPassword(prompt string, validate PasswordValidator) ([]rune, error) {
+	prompt, err := s.checkPrompt(prompt)
+	if err != nil {
+		return nil, err
 	}
 	if !s.terminalSupported || s.columns == 0 {
-		return "", errors.New("liner: function not supported in this terminal")
+		return nil, errors.New("liner: function not supported in this terminal")
 	}
 	if s.inputRedirected {
-		return s.promptUnsupported(prompt)
+		line, err := s.promptUnsupported(prompt)
+		return []rune(line), err
 	}
 	if s.outputRedirected {
-		return "", ErrNotTerminalOutput
+		return nil, ErrNotTerminalOutput
 	}
 
 	p := []rune(prompt)
 
+	// A keyboard macro being recorded is discarded rather than left to
+	// record the input
+	s.discardRecording()
+	defer s.discardRecording()
+	s.resumeAfterPanic()
+	defer s.restoreOnPanic()
 	defer s.stopPrompt()
+	s.setPrompting(true)
+	defer func() {
+		s.setPrompting(false)
+		s.runQueued()
+		s.aborted = nil
+	}()
+	var line []rune
+	defer func() {
+		if err != nil {
+			wipeRunes(line)
+		}
+	}()
 
 restart:
 	s.startPrompt()
 	s.getColumns()
 
-	fmt.Print(prompt)
-	var line []rune
+	fmt.Fprint(s.display(), prompt)
+	s.scroll = 0
+	wipeRunes(line)
+	line = line[:0]
 	pos := 0
+	revealed := false // Ctrl-T was pressed, see SetPasswordReveal
+	valid := true     // validate accepts the input
+	// hide stops revealing the input, and erases the message of validate,
+	// before the input is accepted or discarded
+	hide := func() {
+		if revealed {
+			revealed = false
+			s.refreshPassword(p, line, pos, false)
+		}
+		if validate != nil {
+			s.clearBelow()
+		}
+	}
+	changed := validate != nil // the message of validate is out of date
 
 mainLoop:
 	for {
-		next, err := s.readNext()
+		if changed && validate != nil {
+			var msg string
+			var style Style
+			msg, style, valid = validate(line)
+			s.hint = s.fitLines(msg, style)
+		}
+		if s.passwordMask != 0 || s.passwordReveal || validate != nil {
+			if err = s.refreshPassword(p, line, pos, revealed); err != nil {
+				return nil, err
+			}
+		}
+		if changed {
+			changed = false
+			s.refreshBelow(s.belowLines())
+		}
+
+		var next interface{}
+		next, err = s.readNext()
 		if err != nil {
+			if s.aborted != nil {
+				s.stopReader()
+				hide()
+				fmt.Fprintln(s.display())
+				return nil, err
+			}
 			if s.shouldRestart != nil && s.shouldRestart(err) {
 				goto restart
 			}
-			return "", err
+			return nil, err
 		}
 
 		switch v := next.(type) {
 		case rune:
 			switch v {
 			case cr, lf:
-				fmt.Println()
+				if !valid {
+					// The reader stops after a line ending
+					s.restartPrompt()
+					s.doBeep()
+					break
+				}
+				hide()
+				fmt.Fprintln(s.display())
 				break mainLoop
 			case ctrlD: // del
 				if pos == 0 && len(line) == 0 {
 					// exit
-					return "", io.EOF
+					hide()
+					err = io.EOF
+					return nil, err
 				}
 
 				// ctrlD is a potential EOF, so the rune reader shuts down.
 				// Therefore, if it isn't actually an EOF, we must re-startPrompt.
 				s.restartPrompt()
 			case ctrlL: // clear screen
-				s.eraseScreen()
-				err := s.refresh(p, []rune{}, 0)
+				s.clearScreen()
+				err = s.refreshPassword(p, line, pos, revealed)
 				if err != nil {
-					return "", err
+					return nil, err
+				}
+				changed = validate != nil
+			case ctrlT: // reveal
+				if !s.passwordReveal {
+					s.doBeep()
+					break
 				}
+				revealed = !revealed
 			case ctrlH, bs: // Backspace
 				if pos <= 0 {
 					s.doBeep()
 				} else {
 					n := len(getSuffixGlyphs(line[:pos], 1))
-					line = append(line[:pos-n], line[pos:]...)
+					line = deleteSecret(line, pos-n, n)
 					pos -= n
+					changed = true
 				}
 			case ctrlC:
-				fmt.Println("^C")
+				hide()
+				fmt.Fprintln(s.display(), "^C")
 				if s.ctrlCAborts {
-					return "", ErrPromptAborted
+					err = ErrPromptAborted
+					return nil, err
 				}
+				wipeRunes(line)
 				line = line[:0]
 				pos = 0
-				fmt.Print(prompt)
+				changed = validate != nil
+				fmt.Fprint(s.display(), prompt)
 				s.restartPrompt()
 			// Unused keys
 			case esc, tab, ctrlA, ctrlB, ctrlE, ctrlF, ctrlG, ctrlK, ctrlN, ctrlO, ctrlP, ctrlQ, ctrlR, ctrlS,
-				ctrlT, ctrlU, ctrlV, ctrlW, ctrlX, ctrlY, ctrlZ:
+				ctrlU, ctrlV, ctrlW, ctrlX, ctrlY, ctrlZ:
 				fallthrough
 			// Catch unhandled control codes (anything <= 31)
 			case 0, 28, 29, 30, 31:
 				s.doBeep()
 			default:
-				line = append(line[:pos], append([]rune{v}, line[pos:]...)...)
+				line = insertSecret(line, pos, v)
 				pos++
+				changed = true
+			}
+		case action:
+			if v == ctrlBs && pos > 0 { // Ctrl-H read as Ctrl-Backspace
+				n := len(getSuffixGlyphs(line[:pos], 1))
+				line = deleteSecret(line, pos-n, n)
+				pos -= n
+				changed = true
 			}
 		}
 	}
-	return string(line), nil
+	return line, nil
+}
+
+// discardRecording stops recording a keyboard macro, and forgets the keys
+// recorded.
+func (s *State) discardRecording() {
+	s.recording = false
+	for i := range s.recorded {
+		s.recorded[i] = nil
+	}
+	s.recorded = nil
+}
+
+// insertSecret inserts r in line at pos. If line has to grow, its old
+// array is zeroed, so that no copy of a secret is left behind.
+func insertSecret(line []rune, pos int, r rune) []rune {
+	if len(line) == cap(line) {
+		grown := make([]rune, len(line), 2*cap(line)+16)
+		copy(grown, line)
+		wipeRunes(line)
+		line = grown
+	}
+	line = line[:len(line)+1]
+	copy(line[pos+1:], line[pos:])
+	line[pos] = r
+	return line
+}
+
+// deleteSecret deletes the n runes at pos from line, zeroing the runes
+// left beyond its end.
+func deleteSecret(line []rune, pos, n int) []rune {
+	copy(line[pos:], line[pos+n:])
+	wipeRunes(line[len(line)-n:])
+	return line[:len(line)-n]
+}
+
+// wipeRunes zeroes the runes of a secret.
+func wipeRunes(line []rune) {
+	for i := range line {
+		line[i] = 0
+	}
+}
+
+// refreshPassword displays the input of PasswordPrompt: as typed if
+// revealed, and otherwise as a mask for each rune, if there is a mask.
+func (s *State) refreshPassword(prompt []rune, line []rune, pos int, revealed bool) error {
+	var buf []rune
+	switch {
+	case revealed:
+		buf, pos, _ = s.displayed(line, pos, nil)
+		if len(buf) != len(line) {
+			// Control characters were made visible in a copy
+			defer wipeRunes(buf)
+		}
+	case s.passwordMask != 0:
+		buf = make([]rune, len(line))
+		for i := range buf {
+			buf[i] = s.passwordMask
+		}
+	default:
+		pos = 0
+	}
+	// The highlighter and hinter are not shown the input
+	s.bufStyles = nil
+	s.secret = true
+	defer func() { s.secret = false }()
+	s.drawn = nil
+	return s.refreshSingleLine(prompt, buf, pos)
 }
 
 func (s *State) tooNarrow(prompt string) (string, error) {
 	// Docker and OpenWRT and etc sometimes return 0 column width
 	// Reset mode temporarily. Restore baked mode in case the terminal
 	// is wide enough for the next Prompt attempt.
-	m, merr := TerminalMode()
-	s.origMode.ApplyMode()
-	if merr == nil {
-		defer m.ApplyMode()
-	}
+	defer s.cookTerminal()()
 	if s.r == nil {
 		// Windows does not always set s.r
-		s.r = bufio.NewReader(os.Stdin)
+		s.r = bufio.NewReader(s.in)
 		defer func() { s.r = nil }()
 	}
+	s.asyncMu.Lock()
+	fed := s.fed
+	s.fed = nil
+	s.asyncMu.Unlock()
+	if len(fed) > 0 {
+		// Keys fed are read first, with Enter as the terminal's mode
+		// reads it, and those left are kept for the next prompt
+		r, rest := s.r, strings.NewReader(strings.Replace(string(fed), "\r", "\n", -1))
+		s.r = bufio.NewReader(io.MultiReader(rest, r))
+		defer func() {
+			left, _ := s.r.Peek(s.r.Buffered())
+			more, _ := io.ReadAll(rest)
+			s.r = r
+			s.asyncMu.Lock()
+			s.fed = append([]rune(string(left)+string(more)), s.fed...)
+			s.asyncMu.Unlock()
+		}()
+	}
 	return s.promptUnsupported(prompt)
 }
 
-func (s *State) eraseWord(pos int, line []rune, killAction int) (int, []rune, int) {
+// eraseWord kills the word before pos, and the word breaks between it and
+// pos, as reported by isBreak.
+func (s *State) eraseWord(pos int, line []rune, killAction int, isBreak func(rune) bool) (int, []rune, int) {
 	if pos == 0 {
 		s.doBeep()
 		return pos, line, killAction
 	}
-	// Remove whitespace to the left
+	// Remove word breaks to the left
 	var buf []rune // Store the deleted chars in a buffer
 	for {
-		if pos == 0 || !unicode.IsSpace(line[pos-1]) {
+		if pos == 0 || !isBreak(line[pos-1]) {
 			break
 		}
 		buf = append(buf, line[pos-1])
 		line = append(line[:pos-1], line[pos:]...)
 		pos--
 	}
-	// Remove non-whitespace to the left
+	// Remove the word to the left
 	for {
-		if pos == 0 || unicode.IsSpace(line[pos-1]) {
+		if pos == 0 || isBreak(line[pos-1]) {
 			break
 		}
 		buf = append(buf, line[pos-1])
@@ -1160,8 +4271,185 @@ func (s *State) eraseWord(pos int, line []rune, killAction int) (int, []rune, in
 	return pos, line, killAction
 }
 
+// readPaste reads text pasted in bracketed paste mode and inserts it at pos.
+// The text is inserted literally, except that newlines are replaced with the
+// string set by SetPasteNewline and other control characters are dropped.
+func (s *State) readPaste(line []rune, pos int) ([]rune, int, error) {
+	var text []rune
+	for {
+		next, err := s.readNext()
+		if err != nil {
+			return line, pos, err
+		}
+		if next == pasteEnd {
+			break
+		}
+		r, ok := next.(rune)
+		if !ok {
+			continue
+		}
+		switch r {
+		case cr, lf, ctrlC, ctrlD:
+			// The rune reader stops after these
+			s.restartPrompt()
+		}
+		text = append(text, r)
+	}
+	var y yanked
+	line, pos = y.insert(line, pos, s.pastedText(text), false)
+	s.needRefresh = true
+	return line, pos, nil
+}
+
+// pastedText returns text as inserted by a paste: newlines are replaced with
+// the string set by SetPasteNewline, tabs with spaces, and other control
+// characters are dropped.
+func (s *State) pastedText(text []rune) []rune {
+	newline := " "
+	if s.pasteNewlineSet {
+		newline = s.pasteNewline
+	}
+	var out []rune
+	var prev rune
+	for _, r := range text {
+		switch {
+		case r == lf && prev == cr:
+		case r == cr || r == lf:
+			out = append(out, []rune(newline)...)
+		case r == tab:
+			out = append(out, ' ')
+		case r >= ' ' && r != bs:
+			out = append(out, r)
+		}
+		prev = r
+	}
+	return out
+}
+
+// isWordBreak reports whether r separates words for the word commands.
+func (s *State) isWordBreak(r rune) bool {
+	if s.wordClassifier != nil {
+		return !s.wordClassifier(r)
+	}
+	return unicode.IsSpace(r)
+}
+
+// rowBounds returns the start and end of the row of line (the text between
+// newlines) that holds pos.
+func rowBounds(line []rune, pos int) (start, end int) {
+	start, end = pos, pos
+	for start > 0 && line[start-1] != '\n' {
+		start--
+	}
+	for end < len(line) && line[end] != '\n' {
+		end++
+	}
+	return start, end
+}
+
+// rowMove returns the position in the row of line above (dir < 0) or below
+// (dir > 0) the row that holds pos, at the same column if the row is long
+// enough and at its end if not. ok is false if there is no such row.
+func rowMove(line []rune, pos, dir int) (p int, ok bool) {
+	start, end := rowBounds(line, pos)
+	column := countGlyphs(line[start:pos])
+	if dir < 0 {
+		if start == 0 {
+			return pos, false
+		}
+		start, end = rowBounds(line, start-1)
+	} else {
+		if end == len(line) {
+			return pos, false
+		}
+		start, end = rowBounds(line, end+1)
+	}
+	return start + len(getPrefixGlyphs(line[start:end], column)), true
+}
+
+// regionBounds returns the start and end of the region between mark and
+// pos, with mark clamped to a line of length n.
+func regionBounds(mark, pos, n int) (int, int) {
+	if mark > n {
+		mark = n
+	}
+	if mark < pos {
+		return mark, pos
+	}
+	return pos, mark
+}
+
+// isKillWordBreak reports whether r separates words for backward-kill-word
+// and transpose-words, which like readline treat punctuation as a break.
+func (s *State) isKillWordBreak(r rune) bool {
+	if s.wordClassifier != nil {
+		return !s.wordClassifier(r)
+	}
+	return !isWordRune(r)
+}
+
+// changeWordCase applies change to each rune of the word at or after pos,
+// and returns the position after the word. first is set for the first rune
+// of the word.
+func (s *State) changeWordCase(line []rune, pos int, change func(r rune, first bool) rune) int {
+	for pos < len(line) && s.isWordBreak(line[pos]) {
+		pos++
+	}
+	for first := true; pos < len(line) && !s.isWordBreak(line[pos]); pos++ {
+		line[pos] = change(line[pos], first)
+		first = false
+	}
+	return pos
+}
+
+// isWordRune reports whether r is part of a word for backward-kill-word and
+// transpose-words, which like readline only see letters and digits as words.
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r)
+}
+
+// transposeWordsAt swaps the word before pos with the word at or after it
+// (or the last two words, if there is no word after pos), and moves pos to
+// the end of the second word. isWordRune reports which runes are in words.
+func transposeWordsAt(line []rune, pos int, isWordRune func(rune) bool) ([]rune, int, bool) {
+	end2 := pos
+	for end2 < len(line) && !isWordRune(line[end2]) {
+		end2++
+	}
+	if end2 == len(line) {
+		for end2 > 0 && !isWordRune(line[end2-1]) {
+			end2--
+		}
+	}
+	for end2 < len(line) && isWordRune(line[end2]) {
+		end2++
+	}
+	start2 := end2
+	for start2 > 0 && isWordRune(line[start2-1]) {
+		start2--
+	}
+	end1 := start2
+	for end1 > 0 && !isWordRune(line[end1-1]) {
+		end1--
+	}
+	start1 := end1
+	for start1 > 0 && isWordRune(line[start1-1]) {
+		start1--
+	}
+	if start1 == end1 || start2 == end2 {
+		return line, pos, false
+	}
+	swapped := make([]rune, 0, len(line))
+	swapped = append(swapped, line[:start1]...)
+	swapped = append(swapped, line[start2:end2]...)
+	swapped = append(swapped, line[end1:start2]...)
+	swapped = append(swapped, line[start1:end1]...)
+	swapped = append(swapped, line[end2:]...)
+	return swapped, end2, true
+}
+
 func (s *State) doBeep() {
 	if !s.noBeep {
-		fmt.Print(beep)
+		fmt.Fprint(s.display(), beep)
 	}
 }
@@ -2,9 +2,12 @@ package liner
 
 import (
 	"bytes"
+	"context"
 	"fmt"
+	"reflect"
 	"strings"
 	"testing"
+	"unicode"
 )
 
 func TestAppend(t *testing.T) {
@@ -132,7 +135,7 @@ func TestColumns(t *testing.T) {
 
 // This example demonstrates a way to retrieve the current
 // history buffer without using a file.
-func ExampleState_WriteHistory() {
+func Example_writeHistory() {
 	var s State
 	h := &sliceHistory{}
 	s.history = h
@@ -151,3 +154,365 @@ func ExampleState_WriteHistory() {
 	// History entry 0 : foo
 	// History entry 1 : bar
 }
+
+func TestDescribedRow(t *testing.T) {
+	tests := []struct {
+		c       Candidate
+		width   int
+		columns int
+		row     string
+	}{
+		{Candidate{Text: "-v", Description: "verbose output"}, 4, 80, "-v    -- verbose output"},
+		{Candidate{Text: "--help", Description: ""}, 6, 80, "--help"},
+		{Candidate{Text: "-v", Description: "verbose output"}, 2, 14, "-v  -- verbos"},
+		{Candidate{Text: "-v", Description: "verbose output"}, 8, 10, "-v"},
+	}
+	for _, test := range tests {
+		row := describedRow(test.c, test.width, test.columns)
+		if row != test.row {
+			t.Errorf("describedRow(%+v, %d, %d) = %q, want %q",
+				test.c, test.width, test.columns, row, test.row)
+		}
+	}
+}
+
+func TestMenuLines(t *testing.T) {
+	cands := textCandidates([]string{"a", "bb", "ccc", "d", "e"})
+	cands[4].Style.Fg = ColorBlue
+	groups, numColumns, width := menuLayout(cands, 14)
+	lines, selLine := menuLines(cands, 3, groups, numColumns, width, true)
+	want := []string{"a   ccc \x1b[34me\x1b[0m   ", "bb  \x1b[7md  \x1b[0m "}
+	if len(lines) != len(want) || selLine != 1 {
+		t.Fatalf("got %q (selected %d), want %q", lines, selLine, want)
+	}
+	for i := range lines {
+		if lines[i] != want[i] {
+			t.Errorf("line %d = %q, want %q", i, lines[i], want[i])
+		}
+	}
+
+	cands[0].Group = "x"
+	cands[1].Group = "y"
+	cands[3].Group = "x"
+	cands = sortByGroup(cands)
+	groups, numColumns, width = menuLayout(cands, 14)
+	lines, selLine = menuLines(cands, 0, groups, numColumns, width, false)
+	// Without colors, not even the selection is highlighted
+	want = []string{"x", "a   d   ", "y", "bb  ", "ccc e   "}
+	if len(lines) != len(want) || selLine != 1 {
+		t.Fatalf("got %q (selected %d), want %q", lines, selLine, want)
+	}
+	for i := range lines {
+		if lines[i] != want[i] {
+			t.Errorf("line %d = %q, want %q", i, lines[i], want[i])
+		}
+	}
+}
+
+func TestNumericArg(t *testing.T) {
+	for _, c := range []struct {
+		chord  rune          // the Alt key that began the argument
+		keys   []interface{} // the keys typed after it
+		cmd    interface{}
+		prompt string
+		count  int
+		next   interface{}
+	}{
+		{'4', []interface{}{'2'}, rune(ctrlD), "(arg: 42) ", 42, rune(ctrlD)},
+		{'-', nil, rune(ctrlD), "(arg: -1) ", 1, rune(bs)},
+		{'-', []interface{}{'3'}, altF, "(arg: -3) ", 3, altB},
+		{'0', nil, rune(ctrlD), "(arg: 0) ", 1, rune(esc)},
+		{'4', []interface{}{'2'}, rune(ctrlG), "(arg: 42) ", 1, rune(esc)},
+		{0, []interface{}{universalArg}, 'x', "(arg: 16) ", 16, 'x'},
+		{0, []interface{}{'1', '2', universalArg}, '0', "(arg: 12) ", 12, '0'},
+	} {
+		var a numericArg
+		chord := []Key{{Rune: c.chord, Mod: ModAlt}}
+		first := interface{}(digitArg)
+		if c.chord == 0 {
+			first = universalArg
+		}
+		for _, k := range append([]interface{}{first}, c.keys...) {
+			if !a.add(k, chord) {
+				t.Fatalf("%q %v: %v was not part of the argument", c.chord, c.keys, k)
+			}
+		}
+		if p := string(a.prompt()); p != c.prompt {
+			t.Errorf("%q %v: got prompt %q, want %q", c.chord, c.keys, p, c.prompt)
+		}
+		if a.add(c.cmd, chord) {
+			t.Fatalf("%q %v: %v was part of the argument", c.chord, c.keys, c.cmd)
+		}
+		count, next := a.apply(c.cmd)
+		if count != c.count || next != c.next {
+			t.Errorf("%q %v: got %d %v, want %d %v", c.chord, c.keys, count, next, c.count, c.next)
+		}
+	}
+}
+
+func TestYankArg(t *testing.T) {
+	var s State
+	s.history = &sliceHistory{}
+	s.history.AppendHistory("ls -l /tmp")
+	s.history.AppendHistory("cd /usr")
+
+	var y yanked
+	line, pos, ok := s.yankArg([]rune("vi "), 3, &y, -1, false)
+	if !ok || string(line) != "vi /usr" || pos != 7 {
+		t.Fatalf("got %q at %d", string(line), pos)
+	}
+	line, pos, ok = s.yankArg(line, pos, &y, -1, true)
+	if !ok || string(line) != "vi /tmp" || pos != 7 {
+		t.Fatalf("again: got %q at %d", string(line), pos)
+	}
+	if _, _, ok = s.yankArg(line, pos, &y, -1, true); ok {
+		t.Fatal("yanked past the oldest entry")
+	}
+	line, _, ok = s.yankArg([]rune("x"), 0, &y, 0, false)
+	if !ok || string(line) != "cdx" {
+		t.Fatalf("word 0: got %q", string(line))
+	}
+}
+
+func TestChangeWordCase(t *testing.T) {
+	var s State
+	line := []rune("  héllo wORLD")
+	upper := func(r rune, first bool) rune { return unicode.ToUpper(r) }
+	capital := func(r rune, first bool) rune {
+		if first {
+			return unicode.ToTitle(r)
+		}
+		return unicode.ToLower(r)
+	}
+	pos := s.changeWordCase(line, 0, upper)
+	pos = s.changeWordCase(line, pos, capital)
+	if string(line) != "  HÉLLO World" || pos != len(line) {
+		t.Errorf("got %q at %d", string(line), pos)
+	}
+	if s.changeWordCase(line, pos, upper) != pos {
+		t.Error("moved past the end of the line")
+	}
+}
+
+func TestTransposeWords(t *testing.T) {
+	for _, c := range []struct {
+		line   string
+		pos    int
+		want   string
+		wantAt int
+	}{
+		{"foo bar", 4, "bar foo", 7},
+		{"foo bar", 5, "bar foo", 7},
+		{"foo bar", 3, "bar foo", 7},
+		{"foo bar  ", 9, "bar foo  ", 7},
+		{"a, b; c", 2, "b, a; c", 4},
+		{"a, b; c", 4, "a, c; b", 7},
+		{"élan über", 5, "über élan", 9},
+		{"foo", 1, "foo", 1},
+		{"foo bar", 0, "foo bar", 0},
+	} {
+		line, pos, ok := transposeWordsAt([]rune(c.line), c.pos, isWordRune)
+		if string(line) != c.want || pos != c.wantAt || ok != (c.want != c.line) {
+			t.Errorf("%q at %d: got %q at %d", c.line, c.pos, string(line), pos)
+		}
+	}
+}
+
+func TestKillRing(t *testing.T) {
+	var s State
+	for _, text := range []string{"one", "two", "three"} {
+		s.PushKillRing(text)
+	}
+	if got := s.KillRing(); !reflect.DeepEqual(got, []string{"three", "two", "one"}) {
+		t.Errorf("got %q", got)
+	}
+
+	var y yanked
+	line, pos, _ := s.yank([]rune("ab"), 1, &y, false)
+	line, pos, _ = s.yank(line, pos, &y, true)
+	if string(line) != "atwob" || pos != 4 {
+		t.Errorf("yank-pop: got %q at %d", string(line), pos)
+	}
+
+	s.SetKillRingMax(2)
+	if got := s.KillRing(); !reflect.DeepEqual(got, []string{"two", "one"}) {
+		t.Errorf("after SetKillRingMax: got %q", got)
+	}
+	s.PushKillRing("four")
+	if got := s.KillRing(); len(got) != 2 || got[0] != "four" {
+		t.Errorf("after push: got %q", got)
+	}
+	s.ClearKillRing()
+	if _, _, ok := s.yank(nil, 0, &y, false); ok || s.KillRing() != nil {
+		t.Error("kill ring not cleared")
+	}
+}
+
+func TestWordCharacters(t *testing.T) {
+	var s State
+	upper := func(r rune, first bool) rune { return unicode.ToUpper(r) }
+	line := []rune("foo-bar_baz qux")
+	if pos := s.changeWordCase(line, 0, upper); pos != 11 {
+		t.Errorf("default: word ended at %d", pos)
+	}
+	s.SetWordCharacters("_")
+	line = []rune("foo-bar_baz qux")
+	pos := s.changeWordCase(line, 0, upper)
+	pos = s.changeWordCase(line, pos, upper)
+	if string(line) != "FOO-BAR_BAZ qux" || pos != 11 {
+		t.Errorf("got %q at %d", string(line), pos)
+	}
+}
+
+func TestEraseWord(t *testing.T) {
+	var s State
+	for _, c := range []struct {
+		isBreak func(rune) bool
+		want    string
+	}{
+		{unicode.IsSpace, "git log "},
+		{s.isKillWordBreak, "git log --format="},
+	} {
+		line := []rune("git log --format=oneline ")
+		pos, line, _ := s.eraseWord(len(line), line, 0, c.isBreak)
+		if string(line) != c.want || pos != len(line) {
+			t.Errorf("got %q at %d, want %q", string(line), pos, c.want)
+		}
+	}
+	if got := s.KillRing()[0]; got != "oneline " {
+		t.Errorf("killed %q", got)
+	}
+}
+
+func TestBufferCompleter(t *testing.T) {
+	var s State
+	s.SetBufferCompleter(func(ctx context.Context, b *Buffer) (int, []Candidate, error) {
+		if strings.HasPrefix(b.Text(), "gc") {
+			b.Replace(0, 2, "git commit")
+		}
+		return 0, nil, nil
+	})
+	head, c, tail, err := s.completer(context.Background(), "gc -m", 2)
+	if err != nil || head != "" || tail != " -m" || len(c) != 1 || c[0].Text != "git commit" {
+		t.Errorf("got %q %v %q %v", head, c, tail, err)
+	}
+	_, c, _, _ = s.completer(context.Background(), "ls", 2)
+	if len(c) != 0 {
+		t.Errorf("unedited line: got %v", c)
+	}
+}
+
+func TestRegionBounds(t *testing.T) {
+	for _, c := range []struct{ mark, pos, n, start, end int }{
+		{2, 5, 9, 2, 5},
+		{5, 2, 9, 2, 5},
+		{12, 3, 9, 3, 9},
+		{4, 4, 9, 4, 4},
+	} {
+		if start, end := regionBounds(c.mark, c.pos, c.n); start != c.start || end != c.end {
+			t.Errorf("regionBounds(%d, %d, %d) = %d, %d", c.mark, c.pos, c.n, start, end)
+		}
+	}
+}
+
+func TestRowMove(t *testing.T) {
+	line := []rune("select\na,\nbcdef")
+	for _, c := range []struct {
+		pos, dir, want int
+		ok             bool
+	}{
+		{15, -1, 9, true}, // to the end of a shorter row
+		{9, -1, 2, true},
+		{3, -1, 3, false},
+		{3, 1, 9, true},
+		{8, 1, 11, true},
+		{12, 1, 12, false},
+	} {
+		if got, ok := rowMove(line, c.pos, c.dir); got != c.want || ok != c.ok {
+			t.Errorf("rowMove(%d, %d) = %d, %v", c.pos, c.dir, got, ok)
+		}
+	}
+}
+
+func TestDisplayed(t *testing.T) {
+	var s State
+	for _, c := range []struct {
+		width int
+		in    string
+		pos   int
+		out   string
+		at    int
+	}{
+		{0, "a\tb", 2, "a       b", 8},
+		{4, "ab\tc\td", 3, "ab  c   d", 4},
+		{4, "日\tx\n\ty", 6, "日  x\n    y", 10},
+		{-1, "a\tb", 3, "a⇥b", 3},
+		{0, "a\x01b\x7f", 2, "a^Ab^?", 3},
+		{0, "\x1b\u0085", 2, "^[\\x85", 6},
+	} {
+		s.tabWidth = c.width
+		out, at, styles := s.displayed([]rune(c.in), c.pos, make([]Style, len([]rune(c.in))))
+		if string(out) != c.out || at != c.at || len(styles) != len(out) {
+			t.Errorf("%q width %d: got %q at %d, want %q at %d", c.in, c.width, string(out), at, c.out, c.at)
+		}
+	}
+}
+
+func TestExpandHistory(t *testing.T) {
+	h := &sliceHistory{}
+	for _, line := range []string{"ls -l", "cd /tmp", "ls /tmp", "echo hi"} {
+		h.AppendHistory(line)
+	}
+	tests := []struct {
+		line, want string
+	}{
+		{"!!", "echo hi"},
+		{"!2", "cd /tmp"},
+		{"!-2", "ls /tmp"},
+		{"!ls", "ls /tmp"},
+		{"!?tmp", "ls /tmp"},
+		{"!?-l?", "ls -l"},
+		{"hello!", "hello!"},
+		{"!", "!"},
+		{"! ls", "! ls"},
+	}
+	for _, test := range tests {
+		got, err := expandHistory(h, test.line)
+		if err != nil || got != test.want {
+			t.Errorf("expandHistory(%q) = %q, %v, want %q", test.line, got, err, test.want)
+		}
+	}
+	for _, line := range []string{"!5", "!-5", "!0", "!rm", "!?rm"} {
+		if got, err := expandHistory(h, line); err == nil {
+			t.Errorf("expandHistory(%q) = %q, want an error", line, got)
+		}
+	}
+}
+
+func TestSecretBuffer(t *testing.T) {
+	var line []rune
+	var arrays [][]rune // every array line has used
+	for i, r := range []rune("correct horse battery") {
+		if len(line) == cap(line) && line != nil {
+			arrays = append(arrays, line[:cap(line)])
+		}
+		line = insertSecret(line, i, r)
+	}
+	line = insertSecret(line, 0, '!')
+	arrays = append(arrays, line[:cap(line)])
+	if string(line) != "!correct horse battery" {
+		t.Errorf("got %q, want \"!correct horse battery\"", string(line))
+	}
+	line = deleteSecret(line, 1, 3)
+	if string(line) != "!rect horse battery" {
+		t.Errorf("got %q after deleting, want \"!rect horse battery\"", string(line))
+	}
+	wipeRunes(line)
+	for _, a := range arrays {
+		for _, r := range a {
+			if r != 0 {
+				t.Fatalf("%q left behind", string(a))
+			}
+		}
+	}
+}
@@ -0,0 +1,852 @@
+//go:build !windows
+// +build !windows
+
+package liner
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestInputFilter(t *testing.T) {
+	s, remote := newTestStream(t)
+
+	var seen []rune
+	var last []rune
+	s.SetInputFilter(func(r rune) []rune {
+		seen = append(seen, r)
+		last = append(last, r)
+		switch {
+		case r >= '0' && r <= '9':
+			return nil
+		case r == '(':
+			return []rune("()\x02")
+		case r == 'a':
+			// Not passed to the filter again, so not doubled again
+			return []rune("aa")
+		case r == ' ' && strings.HasSuffix(string(last), "teh "):
+			return []rune("\x7f\x7f\x7fthe ")
+		}
+		return []rune{r}
+	})
+
+	go remote.Write([]byte("teh 1(x\x01a\r"))
+	line, err := s.Prompt("> ")
+	if err != nil || line != "aathe (x)" {
+		t.Errorf("got %q, %v, want \"aathe (x)\"", line, err)
+	}
+	// Neither the filter's runes nor editing keys are filtered
+	if want := "teh 1(xa"; string(seen) != want {
+		t.Errorf("filter called with %q, want %q", string(seen), want)
+	}
+}
+
+func TestOverwriteMode(t *testing.T) {
+	conn, remote := net.Pipe()
+	var out bytes.Buffer
+	copied := make(chan struct{})
+	go func() {
+		io.Copy(&out, remote)
+		close(copied)
+	}()
+	s := NewStream(conn, "xterm", 80, 24, nil)
+	var toggled []bool
+	s.SetOverwriteModeHook(func(overwrite bool) {
+		toggled = append(toggled, overwrite)
+	})
+
+	// Insert turns it on, and it lasts into the next prompt, where
+	// Insert turns it off again
+	for _, test := range []struct {
+		keys, want string
+		overwrite  bool
+	}{
+		{"abc\x1b[D\x1b[D\x1b[2~XY\r", "aXY", true},
+		{"ab\x1b[D\x1b[2~c\r", "acb", false},
+	} {
+		go remote.Write([]byte(test.keys))
+		line, err := s.Prompt("> ")
+		if err != nil || line != test.want || s.OverwriteMode() != test.overwrite {
+			t.Errorf("%q: got %q, %v, overwrite %v; want %q, overwrite %v", test.keys,
+				line, err, s.OverwriteMode(), test.want, test.overwrite)
+		}
+	}
+	if want := []bool{true, false}; !reflect.DeepEqual(toggled, want) {
+		t.Errorf("hook called with %v, want %v", toggled, want)
+	}
+	s.Close()
+	conn.Close()
+	<-copied
+	if underline := fmt.Sprintf("\x1b[%d q", CursorUnderline); !strings.Contains(out.String(), underline) {
+		t.Errorf("cursor not made an underline in %q", out.String())
+	}
+}
+
+func TestEOFBehavior(t *testing.T) {
+	handled := 0
+	exitHint := func() (bool, string) {
+		handled++
+		return false, "type exit to quit"
+	}
+	quit := func() (bool, string) {
+		handled++
+		return true, ""
+	}
+	tests := []struct {
+		behavior EOFBehavior
+		handler  EOFHandler
+		keys     string
+		want     string
+		err      error
+		handled  int
+	}{
+		{EOFOnEmptyLine, nil, "\x04", "", io.EOF, 0},
+		{EOFConfirm, nil, "\x04\x04", "", io.EOF, 0},
+		{EOFConfirm, nil, "\x04x\r", "x", nil, 0},
+		{EOFDeleteChar, nil, "\x04ab\x01\x04\r", "b", nil, 0},
+		{EOFCallback, exitHint, "\x04exit\r", "exit", nil, 1},
+		{EOFCallback, quit, "\x04", "", io.EOF, 1},
+		{EOFCallback, nil, "\x04y\r", "y", nil, 0},
+	}
+	for _, test := range tests {
+		s, remote := newTestStream(t)
+		s.SetEOFBehavior(test.behavior)
+		s.SetEOFHandler(test.handler)
+		handled = 0
+		go remote.Write([]byte(test.keys))
+		line, err := s.Prompt("> ")
+		if line != test.want || err != test.err || handled != test.handled {
+			t.Errorf("%d %q: got %q, %v, handled %d times; want %q, %v, %d times", test.behavior,
+				test.keys, line, err, handled, test.want, test.err, test.handled)
+		}
+		s.Close()
+		remote.Close()
+	}
+}
+
+func TestAutoPairs(t *testing.T) {
+	s, remote := newTestStream(t)
+	s.SetAutoPairs(DefaultAutoPairs + "''")
+
+	tests := []struct {
+		keys, want string
+	}{
+		{"f(\r", "f()"},
+		{"f(x)\r", "f(x)"},
+		{"[{\r", "[{}]"},
+		{"[{}]\r", "[{}]"},
+		{"(\x7f\r", ""},
+		// Moving the cursor ends the pairing
+		{"(\x1b[D\x1b[C)\r", "())"},
+		{"(\x1b[D\x1b[C\x7f\r", ")"},
+		{"'a\r", "'a'"},
+		{"don't\r", "don't"},
+	}
+	for _, test := range tests {
+		go remote.Write([]byte(test.keys))
+		if line, err := s.Prompt("> "); err != nil || line != test.want {
+			t.Errorf("%q: got %q, %v, want %q", test.keys, line, err, test.want)
+		}
+	}
+}
+
+func TestCompleterError(t *testing.T) {
+	tests := []struct {
+		name string
+		f    FallibleCompleter
+		want string
+	}{
+		{"error", func(ctx context.Context, line string, pos int) (string, []Candidate, string, error) {
+			return "", nil, "", errors.New("offline")
+		}, "offline"},
+		{"panic", func(ctx context.Context, line string, pos int) (string, []Candidate, string, error) {
+			panic("boom")
+		}, "completer panicked: boom"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conn, remote := net.Pipe()
+			var out bytes.Buffer
+			copied := make(chan struct{})
+			go func() {
+				io.Copy(&out, remote)
+				close(copied)
+			}()
+			s := NewStream(conn, "xterm", 80, 24, nil)
+			s.SetFallibleCompleter(test.f)
+			go remote.Write([]byte("x\ty\r"))
+			line, err := s.Prompt("> ")
+			if err != nil || line != "xy" {
+				t.Errorf("got %q, %v; want \"xy\"", line, err)
+			}
+			s.Close()
+			conn.Close()
+			<-copied
+			if !strings.Contains(out.String(), test.want) {
+				t.Errorf("%q not displayed in %q", test.want, out.String())
+			}
+		})
+	}
+}
+
+func TestCompletionCancelled(t *testing.T) {
+	s, remote := newTestStream(t)
+	started := make(chan struct{})
+	cancelled := make(chan struct{})
+	s.SetContextCompleter(func(ctx context.Context, line string, pos int) (string, []Candidate, string) {
+		close(started)
+		<-ctx.Done()
+		close(cancelled)
+		return "", textCandidates([]string{"xyz"}), ""
+	})
+	go func() {
+		remote.Write([]byte("x\t"))
+		<-started
+		remote.Write([]byte("y\r"))
+	}()
+	line, err := s.Prompt("> ")
+	if err != nil || line != "xy" {
+		t.Errorf("got %q, %v; want \"xy\"", line, err)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Error("completion not cancelled by a key")
+	}
+}
+
+func TestCompletionAbandoned(t *testing.T) {
+	s, remote := newTestStream(t)
+	var mu sync.Mutex
+	var calls []string
+	running := 0
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+	// The completer ignores its context, as the older ones cannot see it
+	s.SetCompleter(func(line string) []string {
+		mu.Lock()
+		calls = append(calls, line)
+		running++
+		if running > 1 {
+			t.Errorf("completer called for %q while another call ran", line)
+		}
+		mu.Unlock()
+		started <- struct{}{}
+		<-release
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return []string{line + "c"}
+	})
+	go func() {
+		remote.Write([]byte("a\t"))
+		<-started
+		// Abandons the first call, then completes again while it runs
+		remote.Write([]byte("b\t"))
+		time.Sleep(50 * time.Millisecond)
+		close(release)
+		<-started
+		time.Sleep(50 * time.Millisecond)
+		remote.Write([]byte("\r"))
+	}()
+	line, err := s.Prompt("> ")
+	if err != nil || line != "abc" {
+		t.Errorf("got %q, %v; want \"abc\"", line, err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []string{"a", "ab"}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("completer called for %q, want %q", calls, want)
+	}
+}
+
+// previewCompleter completes "hello", recording the lines it is called for;
+// each call waits for a value on release if it is not nil.
+type previewCompleter struct {
+	mu      sync.Mutex
+	calls   []string
+	release chan struct{}
+}
+
+func (c *previewCompleter) complete(line string) []string {
+	c.mu.Lock()
+	c.calls = append(c.calls, line)
+	c.mu.Unlock()
+	if c.release != nil {
+		<-c.release
+	}
+	if strings.HasPrefix("hello", line) {
+		return []string{"hello"}
+	}
+	return nil
+}
+
+func (c *previewCompleter) called() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.calls...)
+}
+
+func TestCompletionPreview(t *testing.T) {
+	s, remote := newTestStream(t)
+	var c previewCompleter
+	s.SetCompleter(c.complete)
+	s.SetCompletionPreview(true)
+
+	done := make(chan string)
+	go func() {
+		line, _ := s.Prompt("> ")
+		done <- line
+	}()
+	// Typing that does not pause calls the completer once
+	for _, k := range "hel" {
+		remote.Write([]byte(string(k)))
+		time.Sleep(5 * time.Millisecond)
+	}
+	waitForLine(t, s, "> hello")
+	if got, want := c.called(), []string{"hel"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("completer called for %q, want %q", got, want)
+	}
+	// The rest of the preview is displayed until the completer runs again
+	remote.Write([]byte("l"))
+	for len(c.called()) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	waitForLine(t, s, "> hello")
+	remote.Write([]byte("\x06\r"))
+	if line := <-done; line != "hello" {
+		t.Errorf("got %q, want \"hello\"", line)
+	}
+}
+
+func TestCompletionPreviewBusy(t *testing.T) {
+	s, remote := newTestStream(t)
+	c := previewCompleter{release: make(chan struct{})}
+	s.SetCompleter(c.complete)
+	s.SetCompletionPreview(true)
+
+	done := make(chan string)
+	go func() {
+		line, _ := s.Prompt("> ")
+		done <- line
+	}()
+	remote.Write([]byte("h"))
+	for len(c.called()) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	// No preview is started while the completer runs
+	remote.Write([]byte("e"))
+	time.Sleep(2 * previewDelay)
+	remote.Write([]byte("l"))
+	time.Sleep(2 * previewDelay)
+	if got, want := c.called(), []string{"h"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("completer called for %q while it ran, want %q", got, want)
+	}
+	close(c.release)
+	waitForLine(t, s, "> hello")
+	if got, want := c.called(), []string{"h", "hel"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("completer called for %q, want %q", got, want)
+	}
+	remote.Write([]byte("\r"))
+	if line := <-done; line != "hel" {
+		t.Errorf("got %q, want \"hel\"", line)
+	}
+}
+
+func TestHistoryExpansion(t *testing.T) {
+	conn, remote := net.Pipe()
+	defer remote.Close()
+	go io.Copy(io.Discard, remote)
+	h := &sliceHistory{}
+	h.AppendHistory("echo hi")
+	s := NewStream(conn, "dumb", 80, 24, h)
+	defer s.Close()
+
+	// Off by default, as a line may start with "!"
+	go remote.Write([]byte("!!\n"))
+	if line, err := s.Prompt("> "); err != nil || line != "!!" {
+		t.Errorf("got %q, %v, want \"!!\"", line, err)
+	}
+	s.SetHistoryExpansion(true)
+	go remote.Write([]byte("!!\n"))
+	if line, err := s.Prompt("> "); err != nil || line != "echo hi" {
+		t.Errorf("got %q, %v, want \"echo hi\"", line, err)
+	}
+}
+
+func TestNumericArgRefresh(t *testing.T) {
+	s, remote := newTestStream(t)
+	var mu sync.Mutex
+	refreshes := 0
+	s.SetHighlighter(func(line string) []StyledSegment {
+		mu.Lock()
+		refreshes++
+		mu.Unlock()
+		return []StyledSegment{{line, Style{}}}
+	})
+	done := make(chan string)
+	go func() {
+		line, _ := s.Prompt("> ")
+		done <- line
+	}()
+	remote.Write([]byte("abcdef"))
+	waitForLine(t, s, "> abcdef")
+	mu.Lock()
+	refreshes = 0
+	mu.Unlock()
+	// Alt-4 Backspace
+	remote.Write([]byte("\x1b4\x7f"))
+	waitForLine(t, s, "> ab")
+	mu.Lock()
+	// Once for the argument, and once after the repeated command
+	if refreshes != 2 {
+		t.Errorf("line displayed %d times, want 2", refreshes)
+	}
+	mu.Unlock()
+	remote.Write([]byte("\r"))
+	if line := <-done; line != "ab" {
+		t.Errorf("got %q, want \"ab\"", line)
+	}
+}
+
+func TestAcceptHook(t *testing.T) {
+	s, remote := newTestStream(t)
+	s.SetAcceptHook(func(line string) (AcceptResult, string) {
+		switch {
+		case line == "":
+			return Reject, "nothing to run"
+		case !strings.HasSuffix(line, ";"):
+			return Continue, "ends with ;"
+		}
+		return Accept, ""
+	})
+	done := make(chan string)
+	go func() {
+		line, _ := s.Prompt("> ")
+		done <- line
+	}()
+	for _, step := range []struct{ keys, msg string }{
+		{"\r", "nothing to run"},
+		{"select 1\r", "ends with ;"},
+	} {
+		remote.Write([]byte(step.keys))
+		var rows []ScreenRow
+		for i := 0; i < 100; i++ {
+			rows = s.Screen()
+			if n := len(rows); n > 0 && rows[n-1].Kind == MessageRow && rows[n-1].String() == step.msg {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if n := len(rows); n == 0 || rows[n-1].String() != step.msg {
+			t.Errorf("got %v after %q, want the message %q", rows, step.keys, step.msg)
+		}
+	}
+	remote.Write([]byte(";\r"))
+	if line := <-done; line != "select 1\n;" {
+		t.Errorf("got %q, want \"select 1\\n;\"", line)
+	}
+}
+
+func TestPromptNumbers(t *testing.T) {
+	s, remote := newTestStream(t)
+
+	// Letters are refused, as is Enter after only a sign
+	go remote.Write([]byte("1a2\r"))
+	if n, err := s.PromptInt("> "); n != 12 || err != nil {
+		t.Errorf("got %d, %v; want 12", n, err)
+	}
+	go remote.Write([]byte("-\r5\r"))
+	if n, err := s.PromptInt("> "); n != -5 || err != nil {
+		t.Errorf("got %d, %v; want -5", n, err)
+	}
+	go remote.Write([]byte("1.5e2x\r"))
+	if f, err := s.PromptFloat("> "); f != 150 || err != nil {
+		t.Errorf("got %v, %v; want 150", f, err)
+	}
+	if s.inputFilter != nil || s.acceptHook != nil {
+		t.Error("settings left changed")
+	}
+
+	// The program's filter and hook are used as well
+	s.SetInputFilter(func(r rune) []rune {
+		if r == '9' {
+			return nil
+		}
+		return []rune{r}
+	})
+	s.SetAcceptHook(func(line string) (AcceptResult, string) {
+		if strings.HasPrefix(line, "-") {
+			return Reject, "not negative"
+		}
+		return Accept, ""
+	})
+	go remote.Write([]byte("-\r-1\r\x15x1923\r"))
+	if n, err := s.PromptInt("> "); n != 123 || err != nil {
+		t.Errorf("got %d, %v; want 123", n, err)
+	}
+	if s.inputFilter == nil || s.acceptHook == nil {
+		t.Error("the program's settings replaced")
+	}
+}
+
+func TestPromptMasked(t *testing.T) {
+	s, remote := newTestStream(t)
+
+	tests := []struct {
+		input, mask, want string
+	}{
+		{"20240131\r", "____-__-__", "2024-01-31"},
+		{"2024-01-31\r", "____-__-__", "2024-01-31"},
+		{"2024-01\r39\x7f1\r", "____-__-__", "2024-01-31"},
+		{"4111x111111111111111\r", "____ ____ ____ ____", "4111 1111 1111 1111"},
+	}
+	for _, test := range tests {
+		go remote.Write([]byte(test.input))
+		got, err := s.PromptMasked("> ", test.mask, nil)
+		if got != test.want || err != nil {
+			t.Errorf("%q: got %q, %v; want %q", test.input, got, err, test.want)
+		}
+	}
+	if _, err := s.PromptMasked("> ", "--", nil); err == nil {
+		t.Error("PromptMasked succeeded with a mask without places")
+	}
+}
+
+func TestPromptMaskedRedirected(t *testing.T) {
+	inR, inW, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inR.Close()
+	inW.WriteString("2024-1-31\n20240131\n2024/01/31\n2024-01-31\n")
+	inW.Close()
+
+	s := NewLinerFiles(inR, os.Stdout, nil)
+	defer s.Close()
+	for i := 0; i < 2; i++ {
+		if got, err := s.PromptMasked("> ", "____-__-__", nil); got != "2024-01-31" || err != nil {
+			t.Errorf("got %q, %v; want 2024-01-31", got, err)
+		}
+	}
+}
+
+func TestConfirm(t *testing.T) {
+	s, remote := newTestStream(t)
+
+	tests := []struct {
+		input string
+		def   bool
+		want  bool
+		err   error
+	}{
+		{"xY", false, true, nil},
+		{"n", true, false, nil},
+		{"\r", true, true, nil},
+		{"\r", false, false, nil},
+		{"\x03", true, false, ErrPromptAborted},
+		{"\x04", true, false, io.EOF},
+	}
+	for _, test := range tests {
+		go remote.Write([]byte(test.input))
+		got, err := s.Confirm("Sure? ", test.def)
+		if got != test.want || err != test.err {
+			t.Errorf("%q with default %v: got %v, %v; want %v, %v", test.input, test.def, got, err, test.want, test.err)
+		}
+	}
+}
+
+func TestChoose(t *testing.T) {
+	s, remote := newTestStream(t)
+
+	tests := []struct {
+		input   string
+		options []string
+		want    int
+	}{
+		{"A", []string{"yes", "no", "all"}, 2},
+		{"\r", []string{"yes", "no", "all"}, 0},
+		{"b2", []string{"red", "green", "gray"}, 1},
+	}
+	for _, test := range tests {
+		go remote.Write([]byte(test.input))
+		got, err := s.Choose("Which? ", test.options)
+		if got != test.want || err != nil {
+			t.Errorf("%q for %q: got %d, %v; want %d", test.input, test.options, got, err, test.want)
+		}
+	}
+	if _, err := s.Choose("Which? ", nil); err == nil {
+		t.Error("Choose succeeded without options")
+	}
+}
+
+func TestChooseRedirected(t *testing.T) {
+	inR, inW, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inR.Close()
+	inW.WriteString("maybe\nNO\n\nb\n")
+	inW.Close()
+
+	s := NewLinerFiles(inR, os.Stdout, nil)
+	defer s.Close()
+	if ok, err := s.Confirm("Sure? ", true); ok || err != nil {
+		t.Errorf("got %v, %v for NO", ok, err)
+	}
+	if ok, err := s.Confirm("Sure? ", true); !ok || err != nil {
+		t.Errorf("got %v, %v for an empty line", ok, err)
+	}
+	if i, err := s.Choose("Which? ", []string{"a", "b"}); i != 1 || err != nil {
+		t.Errorf("got %d, %v for b", i, err)
+	}
+	if _, err := s.Confirm("Sure? ", true); err != io.EOF {
+		t.Errorf("got %v at the end of input", err)
+	}
+}
+
+func TestPasswordMask(t *testing.T) {
+	conn, remote := net.Pipe()
+	var out bytes.Buffer
+	copied := make(chan struct{})
+	go func() {
+		io.Copy(&out, remote)
+		close(copied)
+	}()
+	s := NewStream(conn, "xterm", 80, 24, nil)
+	s.SetPasswordMask('*')
+	s.SetPasswordReveal(true)
+
+	go remote.Write([]byte("abc\x7f\x14d\x14e\x14\r"))
+	line, err := s.PasswordPrompt("> ")
+	s.Close()
+	conn.Close()
+	<-copied
+	if err != nil || line != "abde" {
+		t.Errorf("got %q, %v; want \"abde\"", line, err)
+	}
+	shown := out.String()
+	// The line is drawn in full each time, as what was drawn is not kept
+	for _, want := range []string{"\x1b[1G> ***\x1b[0K", "\x1b[1G> **\x1b[0K",
+		"\x1b[1G> abd\x1b[0K", "\x1b[1G> abde\x1b[0K"} {
+		if !strings.Contains(shown, want) {
+			t.Errorf("%q not displayed in %q", want, shown)
+		}
+	}
+	// The revealed input is hidden before the prompt returns
+	if last := strings.LastIndex(shown, "abde"); !strings.Contains(shown[last:], "\x1b[1G> ****\x1b[0K") {
+		t.Errorf("input left displayed as %q", shown[last:])
+	}
+}
+
+func TestPasswordPromptBytes(t *testing.T) {
+	s, remote := newTestStream(t)
+
+	go remote.Write([]byte("pässwörd\x7fd\r"))
+	pw, err := s.PasswordPromptBytes("> ")
+	if err != nil || string(pw) != "pässwörd" {
+		t.Errorf("got %q, %v; want \"pässwörd\"", pw, err)
+	}
+}
+
+func TestPasswordNotKept(t *testing.T) {
+	s, remote := newTestStream(t)
+	s.SetPasswordReveal(true)
+
+	// A keyboard macro begun at one prompt, and not ended
+	go remote.Write([]byte("\x18(\r"))
+	if _, err := s.Prompt("> "); err != nil {
+		t.Fatal(err)
+	}
+	go remote.Write([]byte("hunter2\x14"))
+	done := make(chan struct{})
+	go func() {
+		s.PasswordPrompt("> ")
+		close(done)
+	}()
+	waitForLine(t, s, "> ")
+	remote.Write([]byte("\x14\r"))
+	<-done
+	if s.recording || len(s.recorded) > 0 {
+		t.Errorf("macro recorded %v", s.recorded)
+	}
+	if s.drawn != nil {
+		t.Errorf("%q kept as drawn", string(s.drawn.text))
+	}
+
+	// Nor is it left to be ended at the next prompt
+	go remote.Write([]byte("\x18)\x18e\r"))
+	if line, err := s.Prompt("> "); err != nil || line != "" {
+		t.Errorf("got %q, %v; want an empty line", line, err)
+	}
+}
+
+func TestPasswordValidator(t *testing.T) {
+	conn, remote := net.Pipe()
+	var out bytes.Buffer
+	copied := make(chan struct{})
+	go func() {
+		io.Copy(&out, remote)
+		close(copied)
+	}()
+	s := NewStream(conn, "xterm", 80, 24, nil)
+	validate := func(pw []rune) (string, Style, bool) {
+		for _, r := range pw {
+			if '0' <= r && r <= '9' {
+				return fmt.Sprintf("%d characters", len(pw)), Style{}, true
+			}
+		}
+		return "must contain a digit", Style{}, false
+	}
+
+	// The first Enter is refused
+	go remote.Write([]byte("abc\r1\r"))
+	line, err := s.PasswordPromptWithValidator("> ", validate)
+	s.Close()
+	conn.Close()
+	<-copied
+	if err != nil || line != "abc1" {
+		t.Errorf("got %q, %v; want \"abc1\"", line, err)
+	}
+	shown := out.String()
+	for _, want := range []string{"must contain a digit", "4 characters"} {
+		if !strings.Contains(shown, want) {
+			t.Errorf("%q not displayed", want)
+		}
+	}
+	if strings.Contains(shown, "abc") {
+		t.Error("input displayed")
+	}
+}
+
+func TestScrollMargin(t *testing.T) {
+	if cursorColumn {
+		t.Skip("the cursor needs a column of its own, changing the arithmetic")
+	}
+	conn, remote := net.Pipe()
+	defer remote.Close()
+	go io.Copy(io.Discard, remote)
+	s := NewStream(conn, "xterm", 20, 24, nil)
+	defer s.Close()
+	var r fakeRenderer
+	s.SetRenderer(&r)
+
+	const (
+		exact = "abcdefghijklmnopqr" // fills the row, with the prompt
+		long  = "abcdefghijklmnopqrstuvwxyz0123"
+		wide  = "日本語日本語日本語日本語"
+	)
+	tests := []struct {
+		buf        string
+		pos        int
+		scroll     int // before the refresh
+		margin     int
+		want       string
+		wantScroll int
+	}{
+		{exact, 18, 0, 0, "> <cdefghijklmnopqr[col 19]", 1},
+		{exact, 0, 0, 0, "> abcdefghijklmnop>[col 2]", 0},
+		{exact, 0, 1, 0, "> abcdefghijklmnop>[col 2]", 0},
+		// The cursor at either margin, and just past it
+		{long, 14, 10, 3, "> <lmnopqrstuvwxyz>[col 6]", 10},
+		{long, 13, 10, 3, "> <klmnopqrstuvwxy>[col 6]", 9},
+		{long, 12, 0, 3, "> abcdefghijklmnop>[col 14]", 0},
+		{long, 13, 0, 3, "> <cdefghijklmnopq>[col 14]", 1},
+		// A wide rune cut by the left marker is left out
+		{wide, 7, 0, 3, "> < 語日本語日本語>[col 14]", 2},
+		{wide, 8, 0, 3, "> < 日本語日本語日>[col 14]", 4},
+		{wide, 4, 5, 3, "> < 日本語日本語日>[col 6]", 4},
+		{"a" + wide, 8, 0, 3, "> < 語日本語日本語>[col 14]", 3},
+	}
+	for _, test := range tests {
+		s.SetScrollMargin(test.margin)
+		s.resetDisplay()
+		s.scroll = test.scroll
+		r.drawn = nil
+		if err := s.refresh([]rune("> "), []rune(test.buf), test.pos); err != nil {
+			t.Fatal(err)
+		}
+		want := "[col 0]" + strings.Replace(test.want, "[col", "[erase line][col", 1)
+		if drawn := strings.Join(r.drawn, ""); drawn != want || s.scroll != test.wantScroll {
+			t.Errorf("%q at %d, scrolled %d, margin %d: drew %q scrolled %d, want %q scrolled %d",
+				test.buf, test.pos, test.scroll, test.margin, drawn, s.scroll, want, test.wantScroll)
+		}
+	}
+}
+
+func TestInsertCommonPrefix(t *testing.T) {
+	s, remote := newTestStream(t)
+	s.SetCompleter(func(line string) []string {
+		return []string{"foo1", "foo2"}
+	})
+
+	tests := []struct {
+		style  TabStyle
+		prefix bool
+		keys   string
+		want   string
+	}{
+		{TabCircular, false, "f\t\r", "foo1"},
+		{TabCircular, true, "f\t\r", "foo"},
+		// Enter picks from the menu, then accepts the line
+		{TabMenu, false, "f\t\r\r", "foo1"},
+		{TabMenu, true, "f\t\r", "foo"},
+		// TabPrints inserts the prefix either way
+		{TabPrints, false, "f\t\r", "foo"},
+		{TabPrints, true, "f\t\r", "foo"},
+	}
+	for _, test := range tests {
+		s.SetTabCompletionStyle(test.style)
+		s.SetInsertCommonPrefix(test.prefix)
+		go remote.Write([]byte(test.keys))
+		if line, err := s.Prompt("> "); err != nil || line != test.want {
+			t.Errorf("style %d, prefix %v: got %q, %v, want %q",
+				test.style, test.prefix, line, err, test.want)
+		}
+	}
+}
+
+func TestCompletionMaxCandidates(t *testing.T) {
+	s, remote := newTestStream(t)
+	s.SetCompleter(func(line string) []string {
+		return []string{"foo1", "foo2", "fab"}
+	})
+
+	tests := []struct {
+		style  TabStyle
+		max    int
+		prefix bool
+		keys   string
+		want   string
+	}{
+		// Tab cycles through the one candidate shown, and Ctrl-G
+		// returns to the word, as there are more
+		{TabCircular, 1, false, "f\t\x07\r", "f"},
+		// The prefix of those shown is not that of all the candidates
+		{TabCircular, 2, true, "f\t\x07\r", "f"},
+		{TabCircular, 0, true, "f\t\x07\r", "f"},
+		// TabPrints inserts the prefix of all of them
+		{TabPrints, 1, false, "f\t\r", "f"},
+		{TabPrints, 2, false, "f\t\r", "f"},
+	}
+	for _, test := range tests {
+		s.SetTabCompletionStyle(test.style)
+		s.SetCompletionMaxCandidates(test.max)
+		s.SetInsertCommonPrefix(test.prefix)
+		go remote.Write([]byte(test.keys))
+		if line, err := s.Prompt("> "); err != nil || line != test.want {
+			t.Errorf("style %d, max %d, prefix %v: got %q, %v, want %q",
+				test.style, test.max, test.prefix, line, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,318 @@
+//go:build linux || darwin || openbsd || freebsd || netbsd
+// +build linux darwin openbsd freebsd netbsd
+
+// Package linertest runs a liner.State on a virtual terminal, so that the
+// completers, hinters and key bindings of a program can be tested without a
+// real one. A test types keys, including the escape sequences of keys such
+// as the arrows, resizes the terminal, and checks what is displayed:
+//
+//	t, err := linertest.New(80, 24, nil, liner.WithCompleter(complete))
+//	if err != nil {
+//		...
+//	}
+//	defer t.Close()
+//	t.Start("> ")
+//	t.Type("he" + linertest.Tab)
+//	if err := t.WaitFor("> hello"); err != nil {
+//		...
+//	}
+//	t.Type(linertest.Enter)
+//	line, err := t.Result()
+//
+// The virtual terminal is an xterm, as NewStream sees it. Like NewStream,
+// the package is only available on Unix.
+package linertest
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/peterh/liner"
+)
+
+// The keys an xterm sends, for Type.
+const (
+	Enter     = "\r"
+	Tab       = "\t"
+	Backspace = "\x7f"
+	Esc       = "\x1b"
+	Up        = "\x1b[A"
+	Down      = "\x1b[B"
+	Right     = "\x1b[C"
+	Left      = "\x1b[D"
+	Home      = "\x1b[H"
+	End       = "\x1b[F"
+	Delete    = "\x1b[3~"
+	PageUp    = "\x1b[5~"
+	PageDown  = "\x1b[6~"
+)
+
+// Ctrl returns the key sent for Ctrl and the letter r, such as "\x03" for
+// Ctrl-C.
+func Ctrl(r rune) string {
+	return string(r &^ 0x60)
+}
+
+// Alt returns the keys sent for Alt and key, which is Esc followed by key.
+func Alt(key string) string {
+	return Esc + key
+}
+
+// DefaultTimeout is how long a Terminal waits, unless its Timeout is
+// changed.
+const DefaultTimeout = 2 * time.Second
+
+// ErrTimeout is returned by Result if the prompt has not returned in time.
+var ErrTimeout = errors.New("linertest: timed out")
+
+// Terminal is a virtual terminal, on which a liner.State edits lines.
+type Terminal struct {
+	// Timeout is how long WaitFor, Wait and Result wait.
+	Timeout time.Duration
+
+	state  *liner.State
+	conn   net.Conn // the State's end of the terminal
+	remote net.Conn // the terminal's end
+
+	mu      sync.Mutex
+	screen  *screen
+	output  []byte
+	changed chan struct{} // closed when the screen next changes
+
+	typedMu sync.Mutex
+	typed   [][]byte      // keys not yet sent to the State
+	wake    chan struct{} // signalled when keys are typed
+	done    chan struct{} // closed by Close
+
+	result chan result
+}
+
+// result is what the function started by StartFunc returned.
+type result struct {
+	line string
+	err  error
+}
+
+// New returns a Terminal width columns wide and height rows high, with a
+// liner.State configured by opts, as NewLinerWithOptions would. If an
+// Option fails, the error is returned.
+func New(width, height int, h liner.History, opts ...liner.Option) (*Terminal, error) {
+	conn, remote := net.Pipe()
+	opts = append([]liner.Option{liner.WithStream(conn, "xterm", width, height)}, opts...)
+	s, err := liner.NewLinerWithOptions(h, opts...)
+	if err != nil {
+		conn.Close()
+		remote.Close()
+		return nil, err
+	}
+	t := &Terminal{
+		Timeout: DefaultTimeout,
+		state:   s,
+		conn:    conn,
+		remote:  remote,
+		screen:  newScreen(width, height),
+		changed: make(chan struct{}),
+		wake:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		result:  make(chan result, 1),
+	}
+	t.screen.reply = func(reply string) {
+		t.send([]byte(reply))
+	}
+	go t.display()
+	go t.sendKeys()
+	return t, nil
+}
+
+// State returns the liner.State that edits lines on t.
+func (t *Terminal) State() *liner.State {
+	return t.state
+}
+
+// Close closes the State and the terminal. A prompt still in progress
+// returns an error.
+func (t *Terminal) Close() error {
+	err := t.state.Close()
+	close(t.done)
+	t.conn.Close()
+	t.remote.Close()
+	return err
+}
+
+// display reads what the State writes, and displays it on the screen.
+func (t *Terminal) display() {
+	buf := make([]byte, 4096)
+	for {
+		n, err := t.remote.Read(buf)
+		t.mu.Lock()
+		t.output = append(t.output, buf[:n]...)
+		t.screen.Write(buf[:n])
+		close(t.changed)
+		t.changed = make(chan struct{})
+		t.mu.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// sendKeys sends the keys typed to the State, in order.
+func (t *Terminal) sendKeys() {
+	for {
+		select {
+		case <-t.wake:
+		case <-t.done:
+			return
+		}
+		t.typedMu.Lock()
+		typed := t.typed
+		t.typed = nil
+		t.typedMu.Unlock()
+		for _, keys := range typed {
+			if _, err := t.remote.Write(keys); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// send queues keys to be sent to the State.
+func (t *Terminal) send(keys []byte) {
+	t.typedMu.Lock()
+	t.typed = append(t.typed, keys)
+	t.typedMu.Unlock()
+	select {
+	case t.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Type sends keys to the State, as the terminal sends them when they are
+// typed: each character is a key, and an escape sequence, such as Up, is
+// the key it stands for. Type does not wait for the keys to be read; an
+// Esc and the key after it are only read as one key, with Alt, if they are
+// typed together.
+func (t *Terminal) Type(keys string) {
+	t.send([]byte(keys))
+}
+
+// Paste sends text to the State as the terminal sends pasted text, between
+// the sequences of bracketed paste.
+func (t *Terminal) Paste(text string) {
+	t.send([]byte("\x1b[200~" + text + "\x1b[201~"))
+}
+
+// Resize changes the size of the terminal to width columns by height rows,
+// and tells the State.
+func (t *Terminal) Resize(width, height int) {
+	t.mu.Lock()
+	t.screen.resize(width, height)
+	t.mu.Unlock()
+	t.state.SetSize(width, height)
+}
+
+// Start calls Prompt with prompt in a goroutine of its own. Result returns
+// what it returns.
+func (t *Terminal) Start(prompt string) {
+	t.StartFunc(func(s *liner.State) (string, error) {
+		return s.Prompt(prompt)
+	})
+}
+
+// StartFunc calls f with the State in a goroutine of its own, for a prompt
+// other than Prompt, such as PasswordPrompt. Result returns what f returns.
+func (t *Terminal) StartFunc(f func(s *liner.State) (string, error)) {
+	go func() {
+		line, err := f(t.state)
+		t.result <- result{line, err}
+	}()
+}
+
+// Result waits for the prompt started by Start or StartFunc to return, and
+// returns what it returned, or ErrTimeout if it does not return in time.
+func (t *Terminal) Result() (string, error) {
+	select {
+	case r := <-t.result:
+		return r.line, r.err
+	case <-time.After(t.Timeout):
+		return "", ErrTimeout
+	}
+}
+
+// Screen returns the rows of the screen down to the last that is not
+// blank, each without trailing blanks and ended by a newline. Wide
+// characters, such as those of Chinese, are written once.
+func (t *Terminal) Screen() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.screen.String()
+}
+
+// Line returns row y of the screen, counting from 0, without trailing
+// blanks.
+func (t *Terminal) Line(y int) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if y < 0 || y >= t.screen.height {
+		return ""
+	}
+	return t.screen.line(y)
+}
+
+// Cursor returns the column and row of the cursor, counting from 0.
+func (t *Terminal) Cursor() (x, y int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.screen.x, t.screen.y
+}
+
+// Output returns everything the State has written to the terminal,
+// escape sequences included.
+func (t *Terminal) Output() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return string(t.output)
+}
+
+// Wait waits until ok returns true, calling it each time the State writes
+// to the terminal. If ok is still false after the Timeout, the error
+// returned shows the screen.
+func (t *Terminal) Wait(ok func() bool) error {
+	timeout := time.After(t.Timeout)
+	for {
+		t.mu.Lock()
+		changed := t.changed
+		t.mu.Unlock()
+		if ok() {
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-timeout:
+			return fmt.Errorf("linertest: timed out; the screen is:\n%s", t.Screen())
+		}
+	}
+}
+
+// WaitFor waits until the screen shows text, as Screen returns it, or within
+// a row, trailing blanks included, so that WaitFor("> ") finds an empty
+// prompt.
+func (t *Terminal) WaitFor(text string) error {
+	return t.Wait(func() bool {
+		if strings.Contains(t.Screen(), text) {
+			return true
+		}
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		for y := 0; y < t.screen.height; y++ {
+			if strings.Contains(t.screen.row(y), text) {
+				return true
+			}
+		}
+		return false
+	})
+}
@@ -0,0 +1,93 @@
+//go:build linux || darwin || openbsd || freebsd || netbsd
+// +build linux darwin openbsd freebsd netbsd
+
+package linertest
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/peterh/liner"
+)
+
+func TestScreen(t *testing.T) {
+	tests := []struct {
+		output string
+		want   string
+		x, y   int
+	}{
+		{"abc", "abc\n", 3, 0},
+		{"abc\r\ndef", "abc\ndef\n", 3, 1},
+		{"abcdefghij", "abcdefghij\n", 9, 0},
+		{"abcdefghijk", "abcdefghij\nk\n", 1, 1},
+		{"abcdef\x1b[3G\x1b[0K", "ab\n", 2, 0},
+		{"abc\x1b[2Dx", "axc\n", 2, 0},
+		{"a\r\nb\r\nc\x1b[2A\x1b[0J", "a\n", 1, 0},
+		{"a\r\n\r\n\r\nb", "\n\nb\n", 1, 2},
+		{"\x1b[1mbold\x1b[0m \x1b]8;;x\x1b\\link\x1b]8;;\x1b\\", "bold link\n", 9, 0},
+		{"abcdefghi中", "abcdefghi\n中\n", 2, 1},
+		{"ab\x1b[?1049hxy\x1b[?1049l", "ab\n", 2, 0},
+		{"> a文\x1b[3G文a", "> 文a\n", 5, 0},
+		{"a文\x1b[2Gx", "ax\n", 2, 0},
+	}
+	for _, test := range tests {
+		sc := newScreen(10, 3)
+		// One byte at a time, as a sequence may be split between Writes
+		for i := 0; i < len(test.output); i++ {
+			sc.Write([]byte{test.output[i]})
+		}
+		if got := sc.String(); got != test.want || sc.x != test.x || sc.y != test.y {
+			t.Errorf("%q: got %q at %d,%d, want %q at %d,%d", test.output,
+				got, sc.x, sc.y, test.want, test.x, test.y)
+		}
+	}
+}
+
+func TestTerminal(t *testing.T) {
+	complete := func(line string) []string {
+		if strings.HasPrefix("hello", line) {
+			return []string{"hello"}
+		}
+		return nil
+	}
+	term, err := New(40, 10, nil, liner.WithCompleter(complete),
+		liner.WithCtrlCAborts(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer term.Close()
+
+	term.Start("> ")
+	if err := term.WaitFor("> "); err != nil {
+		t.Fatal(err)
+	}
+	term.Type("he" + Tab)
+	if err := term.WaitFor("> hello"); err != nil {
+		t.Fatal(err)
+	}
+	term.Type(Left + Left + Ctrl('k'))
+	if err := term.Wait(func() bool {
+		x, _ := term.Cursor()
+		return term.Line(0) == "> hel" && x == 5
+	}); err != nil {
+		t.Fatal(err)
+	}
+	term.Type(Enter)
+	if line, err := term.Result(); err != nil || line != "hel" {
+		t.Errorf("got %q, %v, want %q", line, err, "hel")
+	}
+
+	term.Start("> ")
+	term.Type(strings.Repeat("x", 50))
+	if err := term.WaitFor(strings.Repeat("x", 30)); err != nil {
+		t.Fatal(err)
+	}
+	term.Resize(60, 10)
+	if err := term.WaitFor("> " + strings.Repeat("x", 50)); err != nil {
+		t.Fatal(err)
+	}
+	term.Type(Ctrl('c'))
+	if _, err := term.Result(); err != liner.ErrPromptAborted {
+		t.Errorf("got %v, want %v", err, liner.ErrPromptAborted)
+	}
+}
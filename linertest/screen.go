@@ -0,0 +1,346 @@
+//go:build linux || darwin || openbsd || freebsd || netbsd
+// +build linux darwin openbsd freebsd netbsd
+
+package linertest
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mattn/go-runewidth"
+)
+
+// screen is the display of a virtual terminal, as the output written to it
+// has left it. It interprets the escape sequences liner writes to an xterm.
+type screen struct {
+	width, height int
+	cells         [][]rune // ' ' where blank, 0 after a wide character
+	x, y          int
+	wrapNext      bool     // the last column was written; wrap before the next
+	main          [][]rune // the normal screen, while the alternate is shown
+	mainX, mainY  int
+	partial       []byte // the start of a sequence not yet written in full
+
+	// reply is called with the terminal's reply to a query, such as of
+	// the cursor position.
+	reply func(s string)
+}
+
+// newScreen returns a blank screen width columns wide and height rows high.
+func newScreen(width, height int) *screen {
+	sc := &screen{width: width, height: height}
+	sc.cells = blankRows(height, width)
+	return sc
+}
+
+// blankRows returns n blank rows of width cells.
+func blankRows(n, width int) [][]rune {
+	rows := make([][]rune, n)
+	for i := range rows {
+		rows[i] = blankRow(width)
+	}
+	return rows
+}
+
+// blankRow returns a row of width blank cells.
+func blankRow(width int) []rune {
+	row := make([]rune, width)
+	for i := range row {
+		row[i] = ' '
+	}
+	return row
+}
+
+// Write interprets p, which may end part way through an escape sequence or
+// a character; the rest is expected from the next Write.
+func (sc *screen) Write(p []byte) (int, error) {
+	sc.partial = append(sc.partial, p...)
+	for len(sc.partial) > 0 {
+		n := sc.step(sc.partial)
+		if n == 0 {
+			break
+		}
+		sc.partial = sc.partial[n:]
+	}
+	return len(p), nil
+}
+
+// step interprets the character or escape sequence that b begins with, and
+// returns its length, or 0 if b holds only part of it.
+func (sc *screen) step(b []byte) int {
+	switch b[0] {
+	case '\x1b':
+		return sc.escape(b)
+	case '\r':
+		sc.x, sc.wrapNext = 0, false
+		return 1
+	case '\n':
+		sc.lineFeed()
+		sc.wrapNext = false
+		return 1
+	case '\b':
+		if sc.x > 0 {
+			sc.x--
+		}
+		sc.wrapNext = false
+		return 1
+	case '\t':
+		sc.x = min((sc.x/8+1)*8, sc.width-1)
+		return 1
+	}
+	if b[0] < ' ' || b[0] == 0x7f {
+		// Such as BEL
+		return 1
+	}
+	if !utf8.FullRune(b) {
+		return 0
+	}
+	r, n := utf8.DecodeRune(b)
+	sc.put(r)
+	return n
+}
+
+// put writes r at the cursor, and moves the cursor past it.
+func (sc *screen) put(r rune) {
+	w := runewidth.RuneWidth(r)
+	if w == 0 {
+		// Combining characters are not shown
+		return
+	}
+	if sc.wrapNext || sc.x+w > sc.width {
+		sc.x = 0
+		sc.lineFeed()
+	}
+	sc.wrapNext = false
+	row := sc.cells[sc.y]
+	if row[sc.x] == 0 && sc.x > 0 {
+		// The right half of a wide character is overwritten
+		row[sc.x-1] = ' '
+	}
+	if sc.x+w < sc.width && row[sc.x+w] == 0 {
+		// So is the left half of one, leaving its right half
+		row[sc.x+w] = ' '
+	}
+	row[sc.x] = r
+	if w == 2 {
+		row[sc.x+1] = 0
+	}
+	sc.x += w
+	if sc.x >= sc.width {
+		sc.x = sc.width - 1
+		sc.wrapNext = true
+	}
+}
+
+// lineFeed moves the cursor down a row, scrolling the screen up at the
+// bottom.
+func (sc *screen) lineFeed() {
+	if sc.y < sc.height-1 {
+		sc.y++
+		return
+	}
+	copy(sc.cells, sc.cells[1:])
+	sc.cells[sc.height-1] = blankRow(sc.width)
+}
+
+// escape interprets the escape sequence that b begins with, as step does.
+func (sc *screen) escape(b []byte) int {
+	if len(b) < 2 {
+		return 0
+	}
+	switch b[1] {
+	case '[':
+		for i := 2; i < len(b); i++ {
+			if b[i] >= 0x40 && b[i] <= 0x7e {
+				sc.csi(string(b[2:i]), b[i])
+				return i + 1
+			}
+		}
+		return 0
+	case ']', 'P':
+		// An OSC, such as a hyperlink, or a DCS, ended by BEL or ST
+		for i := 2; i < len(b); i++ {
+			if b[i] == '\a' {
+				return i + 1
+			}
+			if b[i] == '\x1b' && i+1 < len(b) && b[i+1] == '\\' {
+				return i + 2
+			}
+		}
+		return 0
+	}
+	return 2
+}
+
+// csi interprets the control sequence with parameters params and final
+// byte final.
+func (sc *screen) csi(params string, final byte) {
+	if strings.ContainsAny(params, " !\"#$%&'()*+,-./") {
+		// An intermediate byte, as in the sequence of a cursor shape
+		return
+	}
+	private := strings.HasPrefix(params, "?")
+	params = strings.TrimPrefix(params, "?")
+	var args []int
+	for _, p := range strings.Split(params, ";") {
+		n, _ := strconv.Atoi(p)
+		args = append(args, n)
+	}
+	// arg returns the ith parameter, or def if it is missing or 0.
+	arg := func(i, def int) int {
+		if i < len(args) && args[i] > 0 {
+			return args[i]
+		}
+		return def
+	}
+
+	switch final {
+	case 'A':
+		sc.y = max(sc.y-arg(0, 1), 0)
+	case 'B':
+		sc.y = min(sc.y+arg(0, 1), sc.height-1)
+	case 'C':
+		sc.x = min(sc.x+arg(0, 1), sc.width-1)
+	case 'D':
+		sc.x = max(sc.x-arg(0, 1), 0)
+	case 'G':
+		sc.x = min(arg(0, 1), sc.width) - 1
+	case 'H', 'f':
+		sc.y = min(arg(0, 1), sc.height) - 1
+		sc.x = min(arg(1, 1), sc.width) - 1
+	case 'J':
+		sc.eraseDisplay(arg(0, 0))
+	case 'K':
+		sc.eraseLine(arg(0, 0))
+	case 'n':
+		if arg(0, 0) == 6 && sc.reply != nil {
+			sc.reply(fmt.Sprintf("\x1b[%d;%dR", sc.y+1, sc.x+1))
+		}
+		return
+	case 'h', 'l':
+		if private && arg(0, 0) == 1049 {
+			sc.altScreen(final == 'h')
+		}
+		return
+	default:
+		// Such as SGR, which sets the style of the text
+		return
+	}
+	sc.wrapNext = false
+}
+
+// eraseLine erases the cursor's row: from the cursor to the end for mode
+// 0, from the start to the cursor for mode 1, and all of it for mode 2.
+func (sc *screen) eraseLine(mode int) {
+	row := sc.cells[sc.y]
+	from, to := sc.x, sc.width
+	switch mode {
+	case 1:
+		from, to = 0, sc.x+1
+	case 2:
+		from = 0
+	}
+	for i := from; i < to; i++ {
+		row[i] = ' '
+	}
+}
+
+// eraseDisplay erases the screen: from the cursor to the end for mode 0,
+// from the start to the cursor for mode 1, and all of it for mode 2.
+func (sc *screen) eraseDisplay(mode int) {
+	switch mode {
+	case 0:
+		sc.eraseLine(0)
+		for y := sc.y + 1; y < sc.height; y++ {
+			sc.cells[y] = blankRow(sc.width)
+		}
+	case 1:
+		sc.eraseLine(1)
+		for y := 0; y < sc.y; y++ {
+			sc.cells[y] = blankRow(sc.width)
+		}
+	default:
+		sc.cells = blankRows(sc.height, sc.width)
+	}
+}
+
+// altScreen switches to the alternate screen, saving the cursor, if on is
+// true, and otherwise back to the normal screen.
+func (sc *screen) altScreen(on bool) {
+	switch {
+	case on && sc.main == nil:
+		sc.main, sc.mainX, sc.mainY = sc.cells, sc.x, sc.y
+		sc.cells = blankRows(sc.height, sc.width)
+	case !on && sc.main != nil:
+		sc.cells, sc.x, sc.y = sc.main, sc.mainX, sc.mainY
+		sc.main = nil
+	}
+	sc.wrapNext = false
+}
+
+// resize changes the size of the screen. Rows are cut or filled out on the
+// right; if the screen is shorter, rows above the cursor are dropped to
+// keep it on the screen.
+func (sc *screen) resize(width, height int) {
+	fit := func(rows [][]rune, y int) ([][]rune, int) {
+		if drop := y - height + 1; drop > 0 {
+			rows, y = rows[drop:], y-drop
+		}
+		for len(rows) < height {
+			rows = append(rows, blankRow(width))
+		}
+		rows = rows[:height]
+		for i, row := range rows {
+			if len(row) < width {
+				row = append(row, blankRow(width-len(row))...)
+			}
+			rows[i] = row[:width]
+		}
+		return rows, y
+	}
+	sc.width, sc.height = width, height
+	sc.cells, sc.y = fit(sc.cells, sc.y)
+	if sc.main != nil {
+		sc.main, sc.mainY = fit(sc.main, sc.mainY)
+		sc.mainX = min(sc.mainX, width-1)
+	}
+	sc.x = min(sc.x, width-1)
+	sc.wrapNext = false
+}
+
+// line returns row y of the screen, without trailing blanks.
+func (sc *screen) line(y int) string {
+	return strings.TrimRight(sc.row(y), " ")
+}
+
+// row returns row y of the screen, with its trailing blanks.
+func (sc *screen) row(y int) string {
+	var b strings.Builder
+	for _, r := range sc.cells[y] {
+		if r != 0 {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// String returns the rows of the screen, without trailing blanks or blank
+// rows at the bottom, each ended by a newline.
+func (sc *screen) String() string {
+	var b strings.Builder
+	blank := 0
+	for y := range sc.cells {
+		line := sc.line(y)
+		if line == "" {
+			blank++
+			continue
+		}
+		b.WriteString(strings.Repeat("\n", blank))
+		blank = 0
+		b.WriteString(line)
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
@@ -0,0 +1,89 @@
+package liner
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// CompletionMatcher reports whether candidate matches the text typed by the
+// user, and if so, how well. Candidates with a higher score are listed first.
+type CompletionMatcher func(typed, candidate string) (score int, ok bool)
+
+// PrefixMatcher is a CompletionMatcher that matches candidates that begin
+// with the typed text.
+func PrefixMatcher(typed, candidate string) (int, bool) {
+	return 0, strings.HasPrefix(candidate, typed)
+}
+
+// PrefixFoldMatcher is a CompletionMatcher that matches candidates that
+// begin with the typed text, ignoring case.
+func PrefixFoldMatcher(typed, candidate string) (int, bool) {
+	return 0, strings.HasPrefix(strings.ToLower(candidate), strings.ToLower(typed))
+}
+
+// FuzzyMatcher is a CompletionMatcher that matches candidates containing the
+// runes of the typed text in order, ignoring case, so that "gco" matches
+// "git checkout". Consecutive runes and runes at the start of a word score
+// higher, and shorter candidates are preferred over longer ones.
+func FuzzyMatcher(typed, candidate string) (int, bool) {
+	t := []rune(strings.ToLower(typed))
+	score := 0
+	i := 0
+	prevMatch := false
+	prev := rune(0)
+	for n, r := range []rune(candidate) {
+		if i < len(t) && unicode.ToLower(r) == t[i] {
+			score++
+			if prevMatch {
+				score += 5
+			}
+			if n == 0 || isWordSeparator(prev) {
+				score += 10
+			}
+			i++
+			prevMatch = true
+		} else {
+			score--
+			prevMatch = false
+		}
+		prev = r
+	}
+	return score, i == len(t)
+}
+
+func isWordSeparator(r rune) bool {
+	return unicode.IsSpace(r) || strings.ContainsRune("-_./:=,", r)
+}
+
+// SetCompletionMatcher sets the function used to filter and rank the
+// candidates returned by the completer. The text matched against each
+// candidate is the text between the head returned by the completer and the
+// cursor. When a matcher is set, the completer should return every candidate
+// for the word being completed and leave the filtering to the matcher.
+// The default is nil, which uses the candidates as returned by the completer.
+func (s *State) SetCompletionMatcher(m CompletionMatcher) {
+	s.matcher = m
+}
+
+// matchCandidates filters cands using m, sorted by descending score.
+func matchCandidates(m CompletionMatcher, typed string, cands []Candidate) []Candidate {
+	type scored struct {
+		c     Candidate
+		score int
+	}
+	var matched []scored
+	for _, c := range cands {
+		if score, ok := m(typed, c.Text); ok {
+			matched = append(matched, scored{c, score})
+		}
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		return matched[i].score > matched[j].score
+	})
+	out := make([]Candidate, len(matched))
+	for i := range matched {
+		out[i] = matched[i].c
+	}
+	return out
+}
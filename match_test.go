@@ -0,0 +1,36 @@
+package liner
+
+import "testing"
+
+func TestFuzzyMatcher(t *testing.T) {
+	tests := []struct {
+		typed, candidate string
+		ok               bool
+	}{
+		{"gco", "git checkout", true},
+		{"GCO", "git checkout", true},
+		{"", "anything", true},
+		{"gco", "git commit", true},
+		{"gx", "git checkout", false},
+		{"ogc", "git checkout", false},
+	}
+	for _, test := range tests {
+		if _, ok := FuzzyMatcher(test.typed, test.candidate); ok != test.ok {
+			t.Errorf("FuzzyMatcher(%q, %q) ok = %v, want %v", test.typed, test.candidate, ok, test.ok)
+		}
+	}
+}
+
+func TestMatchCandidates(t *testing.T) {
+	cands := textCandidates([]string{"log", "git commit", "git checkout", "go"})
+	got := candidateTexts(matchCandidates(FuzzyMatcher, "gco", cands))
+	want := []string{"git commit", "git checkout"}
+	if len(got) != len(want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	}
+}
@@ -0,0 +1,528 @@
+package liner
+
+import (
+	"io"
+	"os"
+	"time"
+)
+
+// An Option configures a State made by NewLinerWithOptions. Most Options
+// call the State method of the same name with Set in place of With, so the
+// method's documentation describes what they do.
+type Option func(*options)
+
+// options is the configuration built by the Options given to
+// NewLinerWithOptions.
+type options struct {
+	open     func(h History) *State // makes the State
+	settings []func(s *State) error
+}
+
+// NewLinerWithOptions is like NewLinerFiles with standard input and output,
+// and then configures the State with opts, in order, rather than with its
+// methods. If an Option fails, such as a WithBinding of an invalid chord,
+// the State is closed and the error returned.
+func NewLinerWithOptions(h History, opts ...Option) (*State, error) {
+	o := options{open: func(h History) *State {
+		return NewLinerFiles(os.Stdin, os.Stdout, h)
+	}}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	s := o.open(h)
+	for _, set := range o.settings {
+		if err := set(s); err != nil {
+			s.Close()
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// setting returns an Option that calls set.
+func setting(set func(s *State)) Option {
+	return func(o *options) {
+		o.settings = append(o.settings, func(s *State) error {
+			set(s)
+			return nil
+		})
+	}
+}
+
+// fallibleSetting returns an Option that calls set, which may fail.
+func fallibleSetting(set func(s *State) error) Option {
+	return func(o *options) {
+		o.settings = append(o.settings, set)
+	}
+}
+
+// WithFiles makes the State read from in and write to out, as
+// NewLinerFiles does, rather than standard input and output.
+func WithFiles(in, out *os.File) Option {
+	return func(o *options) {
+		o.open = func(h History) *State {
+			return NewLinerFiles(in, out, h)
+		}
+	}
+}
+
+// WithBinding binds chord to action, as Bind does.
+func WithBinding(chord KeyChord, action Action) Option {
+	return fallibleSetting(func(s *State) error {
+		return s.Bind(chord, action)
+	})
+}
+
+// WithBindingFunc binds chord to f, as BindFunc does.
+func WithBindingFunc(chord KeyChord, f func(*Buffer) error) Option {
+	return fallibleSetting(func(s *State) error {
+		return s.BindFunc(chord, f)
+	})
+}
+
+// WithInputrc reads the user's readline init file, as LoadInputrc does.
+func WithInputrc(app string) Option {
+	return fallibleSetting(func(s *State) error {
+		return s.LoadInputrc(app)
+	})
+}
+
+// WithCompleter calls SetCompleter.
+func WithCompleter(f Completer) Option {
+	return setting(func(s *State) {
+		s.SetCompleter(f)
+	})
+}
+
+// WithWordCompleter calls SetWordCompleter.
+func WithWordCompleter(f WordCompleter) Option {
+	return setting(func(s *State) {
+		s.SetWordCompleter(f)
+	})
+}
+
+// WithCandidateCompleter calls SetCandidateCompleter.
+func WithCandidateCompleter(f CandidateCompleter) Option {
+	return setting(func(s *State) {
+		s.SetCandidateCompleter(f)
+	})
+}
+
+// WithContextCompleter calls SetContextCompleter.
+func WithContextCompleter(f ContextCompleter) Option {
+	return setting(func(s *State) {
+		s.SetContextCompleter(f)
+	})
+}
+
+// WithFallibleCompleter calls SetFallibleCompleter.
+func WithFallibleCompleter(f FallibleCompleter) Option {
+	return setting(func(s *State) {
+		s.SetFallibleCompleter(f)
+	})
+}
+
+// WithBufferCompleter calls SetBufferCompleter.
+func WithBufferCompleter(f BufferCompleter) Option {
+	return setting(func(s *State) {
+		s.SetBufferCompleter(f)
+	})
+}
+
+// WithCompletionMatcher calls SetCompletionMatcher.
+func WithCompletionMatcher(m CompletionMatcher) Option {
+	return setting(func(s *State) {
+		s.SetCompletionMatcher(m)
+	})
+}
+
+// WithCompletionSpinner calls SetCompletionSpinner.
+func WithCompletionSpinner(show bool) Option {
+	return setting(func(s *State) {
+		s.SetCompletionSpinner(show)
+	})
+}
+
+// WithTabCompletionStyle calls SetTabCompletionStyle.
+func WithTabCompletionStyle(tabStyle TabStyle) Option {
+	return setting(func(s *State) {
+		s.SetTabCompletionStyle(tabStyle)
+	})
+}
+
+// WithInsertCommonPrefix calls SetInsertCommonPrefix.
+func WithInsertCommonPrefix(insert bool) Option {
+	return setting(func(s *State) {
+		s.SetInsertCommonPrefix(insert)
+	})
+}
+
+// WithCompleteWholeWord calls SetCompleteWholeWord.
+func WithCompleteWholeWord(whole bool) Option {
+	return setting(func(s *State) {
+		s.SetCompleteWholeWord(whole)
+	})
+}
+
+// WithCompletionMaxCandidates calls SetCompletionMaxCandidates.
+func WithCompletionMaxCandidates(n int) Option {
+	return setting(func(s *State) {
+		s.SetCompletionMaxCandidates(n)
+	})
+}
+
+// WithCompletionQueryItems calls SetCompletionQueryItems.
+func WithCompletionQueryItems(n int) Option {
+	return setting(func(s *State) {
+		s.SetCompletionQueryItems(n)
+	})
+}
+
+// WithCompletionTrigger calls SetCompletionTrigger.
+func WithCompletionTrigger(runes ...rune) Option {
+	return setting(func(s *State) {
+		s.SetCompletionTrigger(runes...)
+	})
+}
+
+// WithCompletionPreview calls SetCompletionPreview.
+func WithCompletionPreview(preview bool) Option {
+	return setting(func(s *State) {
+		s.SetCompletionPreview(preview)
+	})
+}
+
+// WithHinter calls SetHinter.
+func WithHinter(f Hinter) Option {
+	return setting(func(s *State) {
+		s.SetHinter(f)
+	})
+}
+
+// WithHighlighter calls SetHighlighter.
+func WithHighlighter(f Highlighter) Option {
+	return setting(func(s *State) {
+		s.SetHighlighter(f)
+	})
+}
+
+// WithPreInputHook calls SetPreInputHook.
+func WithPreInputHook(f func(b *Buffer) error) Option {
+	return setting(func(s *State) {
+		s.SetPreInputHook(f)
+	})
+}
+
+// WithAcceptHook calls SetAcceptHook.
+func WithAcceptHook(f AcceptHook) Option {
+	return setting(func(s *State) {
+		s.SetAcceptHook(f)
+	})
+}
+
+// WithInputFilter calls SetInputFilter.
+func WithInputFilter(f InputFilter) Option {
+	return setting(func(s *State) {
+		s.SetInputFilter(f)
+	})
+}
+
+// WithShouldRestart calls SetShouldRestart.
+func WithShouldRestart(f ShouldRestart) Option {
+	return setting(func(s *State) {
+		s.SetShouldRestart(f)
+	})
+}
+
+// WithCtrlCAborts calls SetCtrlCAborts.
+func WithCtrlCAborts(aborts bool) Option {
+	return setting(func(s *State) {
+		s.SetCtrlCAborts(aborts)
+	})
+}
+
+// WithEOFBehavior calls SetEOFBehavior.
+func WithEOFBehavior(b EOFBehavior) Option {
+	return setting(func(s *State) {
+		s.SetEOFBehavior(b)
+	})
+}
+
+// WithEOFHandler calls SetEOFHandler.
+func WithEOFHandler(f EOFHandler) Option {
+	return setting(func(s *State) {
+		s.SetEOFHandler(f)
+	})
+}
+
+// WithClearBehavior calls SetClearBehavior.
+func WithClearBehavior(b ClearBehavior) Option {
+	return setting(func(s *State) {
+		s.SetClearBehavior(b)
+	})
+}
+
+// WithClearHandler calls SetClearHandler.
+func WithClearHandler(f func()) Option {
+	return setting(func(s *State) {
+		s.SetClearHandler(f)
+	})
+}
+
+// WithBeep calls SetBeep.
+func WithBeep(beep bool) Option {
+	return setting(func(s *State) {
+		s.SetBeep(beep)
+	})
+}
+
+// WithFeed reads r to its end and passes what it read to Feed, so that a
+// script of keys is read before the terminal's input.
+func WithFeed(r io.Reader) Option {
+	return fallibleSetting(func(s *State) error {
+		keys, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		s.Feed(keys)
+		return nil
+	})
+}
+
+// WithRenderer calls SetRenderer.
+func WithRenderer(r Renderer) Option {
+	return setting(func(s *State) {
+		s.SetRenderer(r)
+	})
+}
+
+// WithTranscript calls SetTranscript.
+func WithTranscript(w io.Writer) Option {
+	return setting(func(s *State) {
+		s.SetTranscript(w)
+	})
+}
+
+// WithTranscriptTimestamps calls SetTranscriptTimestamps.
+func WithTranscriptTimestamps(enabled bool) Option {
+	return setting(func(s *State) {
+		s.SetTranscriptTimestamps(enabled)
+	})
+}
+
+// WithPasswordMask calls SetPasswordMask.
+func WithPasswordMask(mask rune) Option {
+	return setting(func(s *State) {
+		s.SetPasswordMask(mask)
+	})
+}
+
+// WithPasswordReveal calls SetPasswordReveal.
+func WithPasswordReveal(enabled bool) Option {
+	return setting(func(s *State) {
+		s.SetPasswordReveal(enabled)
+	})
+}
+
+// WithMultiLineMode calls SetMultiLineMode.
+func WithMultiLineMode(mlmode bool) Option {
+	return setting(func(s *State) {
+		s.SetMultiLineMode(mlmode)
+	})
+}
+
+// WithOverwriteMode calls SetOverwriteMode.
+func WithOverwriteMode(overwrite bool) Option {
+	return setting(func(s *State) {
+		s.SetOverwriteMode(overwrite)
+	})
+}
+
+// WithOverwriteModeHook calls SetOverwriteModeHook.
+func WithOverwriteModeHook(f func(overwrite bool)) Option {
+	return setting(func(s *State) {
+		s.SetOverwriteModeHook(f)
+	})
+}
+
+// WithCursorShapes calls SetCursorShapes.
+func WithCursorShapes(insert, overwrite CursorShape) Option {
+	return setting(func(s *State) {
+		s.SetCursorShapes(insert, overwrite)
+	})
+}
+
+// WithScrollMargin calls SetScrollMargin.
+func WithScrollMargin(margin int) Option {
+	return setting(func(s *State) {
+		s.SetScrollMargin(margin)
+	})
+}
+
+// WithContinuationPrompt calls SetContinuationPrompt.
+func WithContinuationPrompt(prompt string) Option {
+	return setting(func(s *State) {
+		s.SetContinuationPrompt(prompt)
+	})
+}
+
+// WithContinuationPromptFunc calls SetContinuationPromptFunc.
+func WithContinuationPromptFunc(f func(row int) string) Option {
+	return setting(func(s *State) {
+		s.SetContinuationPromptFunc(f)
+	})
+}
+
+// WithRightPrompt calls SetRightPrompt.
+func WithRightPrompt(f func() string) Option {
+	return setting(func(s *State) {
+		s.SetRightPrompt(f)
+	})
+}
+
+// WithTabWidth calls SetTabWidth.
+func WithTabWidth(width int) Option {
+	return setting(func(s *State) {
+		s.SetTabWidth(width)
+	})
+}
+
+// WithControlDisplay calls SetControlDisplay.
+func WithControlDisplay(d ControlDisplay) Option {
+	return setting(func(s *State) {
+		s.SetControlDisplay(d)
+	})
+}
+
+// WithWordClassifier calls SetWordClassifier.
+func WithWordClassifier(f WordClassifier) Option {
+	return setting(func(s *State) {
+		s.SetWordClassifier(f)
+	})
+}
+
+// WithWordCharacters calls SetWordCharacters.
+func WithWordCharacters(chars string) Option {
+	return setting(func(s *State) {
+		s.SetWordCharacters(chars)
+	})
+}
+
+// WithWordBreakCharacters calls SetWordBreakCharacters.
+func WithWordBreakCharacters(chars string) Option {
+	return setting(func(s *State) {
+		s.SetWordBreakCharacters(chars)
+	})
+}
+
+// WithQuoteCharacters calls SetQuoteCharacters.
+func WithQuoteCharacters(chars string) Option {
+	return setting(func(s *State) {
+		s.SetQuoteCharacters(chars)
+	})
+}
+
+// WithAutoPairs calls SetAutoPairs.
+func WithAutoPairs(pairs string) Option {
+	return setting(func(s *State) {
+		s.SetAutoPairs(pairs)
+	})
+}
+
+// WithMatchingBrackets calls SetMatchingBrackets.
+func WithMatchingBrackets(pairs string, style Style) Option {
+	return setting(func(s *State) {
+		s.SetMatchingBrackets(pairs, style)
+	})
+}
+
+// WithBracketedPaste calls SetBracketedPaste.
+func WithBracketedPaste(enabled bool) Option {
+	return setting(func(s *State) {
+		s.SetBracketedPaste(enabled)
+	})
+}
+
+// WithPasteNewline calls SetPasteNewline.
+func WithPasteNewline(newline string) Option {
+	return setting(func(s *State) {
+		s.SetPasteNewline(newline)
+	})
+}
+
+// WithPasteBurstGap calls SetPasteBurstGap.
+func WithPasteBurstGap(d time.Duration) Option {
+	return setting(func(s *State) {
+		s.SetPasteBurstGap(d)
+	})
+}
+
+// WithEscapeTimeout calls SetEscapeTimeout.
+func WithEscapeTimeout(d time.Duration) Option {
+	return setting(func(s *State) {
+		s.SetEscapeTimeout(d)
+	})
+}
+
+// WithEscapeSequenceWait calls SetEscapeSequenceWait.
+func WithEscapeSequenceWait(wait bool) Option {
+	return setting(func(s *State) {
+		s.SetEscapeSequenceWait(wait)
+	})
+}
+
+// WithHistoryExpansion calls SetHistoryExpansion.
+func WithHistoryExpansion(enabled bool) Option {
+	return setting(func(s *State) {
+		s.SetHistoryExpansion(enabled)
+	})
+}
+
+// WithKillRingMax calls SetKillRingMax.
+func WithKillRingMax(n int) Option {
+	return setting(func(s *State) {
+		s.SetKillRingMax(n)
+	})
+}
+
+// WithMouse calls SetMouse.
+func WithMouse(enabled bool) Option {
+	return setting(func(s *State) {
+		s.SetMouse(enabled)
+	})
+}
+
+// WithClipboard calls SetClipboard.
+func WithClipboard(enabled bool) Option {
+	return setting(func(s *State) {
+		s.SetClipboard(enabled)
+	})
+}
+
+// WithColors calls SetColors.
+func WithColors(colors bool) Option {
+	return setting(func(s *State) {
+		s.SetColors(colors)
+	})
+}
+
+// WithColorDepth calls SetColorDepth.
+func WithColorDepth(depth ColorDepth) Option {
+	return setting(func(s *State) {
+		s.SetColorDepth(depth)
+	})
+}
+
+// WithHyperlinks calls SetHyperlinks.
+func WithHyperlinks(links bool) Option {
+	return setting(func(s *State) {
+		s.SetHyperlinks(links)
+	})
+}
+
+// WithTerminalQuirks calls SetTerminalQuirks.
+func WithTerminalQuirks(quirks TerminalQuirks) Option {
+	return setting(func(s *State) {
+		s.SetTerminalQuirks(quirks)
+	})
+}
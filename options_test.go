@@ -0,0 +1,38 @@
+package liner
+
+import (
+	"os"
+	"testing"
+)
+
+func TestNewLinerWithOptions(t *testing.T) {
+	in, inW, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+	defer inW.Close()
+
+	s, err := NewLinerWithOptions(nil,
+		WithFiles(in, os.Stderr),
+		WithCtrlCAborts(true),
+		WithTabCompletionStyle(TabPrints),
+		WithBeep(false),
+		WithBinding("C-w", ActionKillRegion),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if !s.ctrlCAborts || s.tabStyle != TabPrints || !s.noBeep {
+		t.Errorf("options not applied: ctrlCAborts %v, tabStyle %v, noBeep %v",
+			s.ctrlCAborts, s.tabStyle, s.noBeep)
+	}
+	if _, ok := s.binding("C-w"); !ok {
+		t.Error("C-w not bound")
+	}
+
+	if _, err := NewLinerWithOptions(nil, WithFiles(in, os.Stderr), WithBinding("C-", ActionKillRegion)); err == nil {
+		t.Error("no error for an invalid chord")
+	}
+}
@@ -5,64 +5,112 @@ package liner
 
 import (
 	"fmt"
-	"os"
 	"strings"
-	"syscall"
-	"unsafe"
+
+	"github.com/peterh/liner/term"
 )
 
-func (s *State) cursorPos(x int) {
-	if s.useCHA {
-		// 'G' is "Cursor Character Absolute (CHA)"
-		fmt.Printf("\x1b[%dG", x+1)
-	} else {
-		// 'C' is "Cursor Forward (CUF)"
-		fmt.Print("\r")
-		if x > 0 {
-			fmt.Printf("\x1b[%dC", x)
-		}
-	}
+// termRenderer draws on the terminal, with the escape sequences of VT100
+// and its successors.
+type termRenderer struct {
+	vtRenderer
 }
 
-func (s *State) eraseLine() {
-	fmt.Print("\x1b[0K")
+// MoveCursor moves the cursor to column x of its row, with CHA if the
+// terminal supports it.
+func (r termRenderer) MoveCursor(x int) {
+	if r.s.useCHA {
+		r.vtRenderer.MoveCursor(x)
+		return
+	}
+	// 'C' is "Cursor Forward (CUF)"
+	fmt.Fprint(r.s.out, "\r")
+	if x > 0 {
+		fmt.Fprintf(r.s.out, "\x1b[%dC", x)
+	}
 }
 
-func (s *State) eraseScreen() {
-	fmt.Print("\x1b[H\x1b[2J")
+// defaultOverwriteCursor is the cursor shape in overwrite mode unless
+// SetCursorShapes is called.
+const defaultOverwriteCursor = CursorUnderline
+
+// copyToClipboard sets the system clipboard to text, with OSC 52.
+func (s *State) copyToClipboard(text string) {
+	s.vtCopyToClipboard(text)
 }
 
-func (s *State) moveUp(lines int) {
-	fmt.Printf("\x1b[%dA", lines)
+// enterAltScreen switches to the terminal's alternate screen, saving the
+// cursor.
+func (s *State) enterAltScreen() {
+	s.vtEnterAltScreen()
 }
 
-func (s *State) moveDown(lines int) {
-	fmt.Printf("\x1b[%dB", lines)
+// leaveAltScreen returns to the normal screen and restores the cursor.
+func (s *State) leaveAltScreen() {
+	s.vtLeaveAltScreen()
 }
 
-func (s *State) emitNewLine() {
-	fmt.Print("\n")
+// bufferedOutput reports whether what is drawn on the terminal may be held
+// back and written at once, as batch does.
+func (s *State) bufferedOutput() bool {
+	return true
 }
 
-type winSize struct {
-	row, col       uint16
-	xpixel, ypixel uint16
+func (s *State) emitNewLine() {
+	fmt.Fprint(s.display(), "\n")
 }
 
 func (s *State) getColumns() bool {
-	var ws winSize
-	ok, _, _ := syscall.Syscall(syscall.SYS_IOCTL, uintptr(syscall.Stdout),
-		syscall.TIOCGWINSZ, uintptr(unsafe.Pointer(&ws)))
-	if int(ok) < 0 {
+	columns, rows, ok := s.terminalSize()
+	if !ok {
 		return false
 	}
-	s.columns = int(ws.col)
+	s.columns, s.rows = columns, rows
 	return true
 }
 
+// terminalSize returns the size of the terminal, without recording it as
+// getColumns does. The size of the process's terminal is asked of it only
+// when it may have changed since it was last asked, since a paste can make
+// a prompt ask often.
+func (s *State) terminalSize() (columns, rows int, ok bool) {
+	s.sizeMu.Lock()
+	defer s.sizeMu.Unlock()
+	if s.stream {
+		return s.size[0], s.size[1], true
+	}
+	select {
+	case <-s.sizeChanged:
+		s.sizeKnown = false
+	default:
+	}
+	if !s.sizeKnown {
+		columns, rows, err := term.GetSize(int(s.outFile.Fd()))
+		if err != nil {
+			return 0, 0, false
+		}
+		s.size, s.sizeKnown = [2]int{columns, rows}, true
+	}
+	return s.size[0], s.size[1], true
+}
+
+// forgetSize makes terminalSize ask the process's terminal for its size,
+// which may have changed.
+func (s *State) forgetSize() {
+	s.sizeMu.Lock()
+	s.sizeKnown = false
+	s.sizeMu.Unlock()
+}
+
 func (s *State) checkOutput() {
 	// xterm is known to support CHA
-	if strings.Contains(strings.ToLower(os.Getenv("TERM")), "xterm") {
+	if strings.Contains(strings.ToLower(s.term), "xterm") {
+		s.useCHA = true
+		return
+	}
+
+	// So is a terminal whose "horizontal position absolute" is CHA
+	if hpa := s.terminfo["hpa"]; strings.HasPrefix(hpa, "\x1b[") && strings.HasSuffix(hpa, "G") {
 		s.useCHA = true
 		return
 	}
@@ -1,6 +1,7 @@
 package liner
 
 import (
+	"fmt"
 	"unsafe"
 )
 
@@ -11,6 +12,11 @@ type smallRect struct {
 	left, top, right, bottom int16
 }
 
+type consoleCursorInfo struct {
+	dwSize   uint32
+	bVisible int32
+}
+
 type consoleScreenBufferInfo struct {
 	dwSize              coord
 	dwCursorPosition    coord
@@ -19,54 +25,159 @@ type consoleScreenBufferInfo struct {
 	dwMaximumWindowSize coord
 }
 
-func (s *State) cursorPos(x int) {
-	var sbi consoleScreenBufferInfo
-	procGetConsoleScreenBufferInfo.Call(uintptr(s.hOut), uintptr(unsafe.Pointer(&sbi)))
-	procSetConsoleCursorPosition.Call(uintptr(s.hOut),
-		uintptr(int(x)&0xFFFF|int(sbi.dwCursorPosition.y)<<16))
+// termRenderer draws on the console: with escape sequences, as on Unix,
+// if it interprets them, and otherwise with the console API.
+type termRenderer struct {
+	vtRenderer
 }
 
-func (s *State) eraseLine() {
+// console returns the screen buffer info of the console.
+func (r termRenderer) console() consoleScreenBufferInfo {
 	var sbi consoleScreenBufferInfo
-	procGetConsoleScreenBufferInfo.Call(uintptr(s.hOut), uintptr(unsafe.Pointer(&sbi)))
-	var numWritten uint32
-	procFillConsoleOutputCharacter.Call(uintptr(s.hOut), uintptr(' '),
-		uintptr(sbi.dwSize.x-sbi.dwCursorPosition.x),
-		uintptr(int(sbi.dwCursorPosition.x)&0xFFFF|int(sbi.dwCursorPosition.y)<<16),
-		uintptr(unsafe.Pointer(&numWritten)))
+	procGetConsoleScreenBufferInfo.Call(uintptr(r.s.hOut), uintptr(unsafe.Pointer(&sbi)))
+	return sbi
 }
 
-func (s *State) eraseScreen() {
-	var sbi consoleScreenBufferInfo
-	procGetConsoleScreenBufferInfo.Call(uintptr(s.hOut), uintptr(unsafe.Pointer(&sbi)))
+// setCursor moves the cursor to column x of row y of the screen buffer.
+func (r termRenderer) setCursor(x, y int) {
+	procSetConsoleCursorPosition.Call(uintptr(r.s.hOut), uintptr(x&0xFFFF|y<<16))
+}
+
+// fill writes n spaces from column x of row y of the screen buffer.
+func (r termRenderer) fill(n, x, y int) {
 	var numWritten uint32
-	procFillConsoleOutputCharacter.Call(uintptr(s.hOut), uintptr(' '),
-		uintptr(sbi.dwSize.x)*uintptr(sbi.dwSize.y),
-		0,
-		uintptr(unsafe.Pointer(&numWritten)))
-	procSetConsoleCursorPosition.Call(uintptr(s.hOut), 0)
+	procFillConsoleOutputCharacter.Call(uintptr(r.s.hOut), uintptr(' '), uintptr(n),
+		uintptr(x&0xFFFF|y<<16), uintptr(unsafe.Pointer(&numWritten)))
 }
 
-func (s *State) moveUp(lines int) {
-	var sbi consoleScreenBufferInfo
-	procGetConsoleScreenBufferInfo.Call(uintptr(s.hOut), uintptr(unsafe.Pointer(&sbi)))
-	procSetConsoleCursorPosition.Call(uintptr(s.hOut),
-		uintptr(int(sbi.dwCursorPosition.x)&0xFFFF|(int(sbi.dwCursorPosition.y)-lines)<<16))
+func (r termRenderer) MoveCursor(x int) {
+	if r.s.vt {
+		r.vtRenderer.MoveCursor(x)
+		return
+	}
+	r.setCursor(x, int(r.console().dwCursorPosition.y))
 }
 
-func (s *State) moveDown(lines int) {
-	var sbi consoleScreenBufferInfo
-	procGetConsoleScreenBufferInfo.Call(uintptr(s.hOut), uintptr(unsafe.Pointer(&sbi)))
-	procSetConsoleCursorPosition.Call(uintptr(s.hOut),
-		uintptr(int(sbi.dwCursorPosition.x)&0xFFFF|(int(sbi.dwCursorPosition.y)+lines)<<16))
+// defaultOverwriteCursor is the cursor shape in overwrite mode unless
+// SetCursorShapes is called.
+const defaultOverwriteCursor = CursorBlock
+
+// SetCursorShape sets the shape of the cursor. The legacy console shows
+// the block shapes as a block, and the others at the cursor's previous
+// size.
+func (r termRenderer) SetCursorShape(shape CursorShape) {
+	if r.s.vt {
+		r.vtRenderer.SetCursorShape(shape)
+		return
+	}
+	var ci consoleCursorInfo
+	procGetConsoleCursorInfo.Call(uintptr(r.s.hOut), uintptr(unsafe.Pointer(&ci)))
+	if shape == CursorBlock || shape == CursorBlinkingBlock {
+		if ci.dwSize < 100 {
+			r.s.cursorSize = ci.dwSize
+		}
+		ci.dwSize = 100
+	} else if r.s.cursorSize > 0 {
+		ci.dwSize = r.s.cursorSize
+	}
+	procSetConsoleCursorInfo.Call(uintptr(r.s.hOut), uintptr(unsafe.Pointer(&ci)))
+}
+
+func (r termRenderer) EraseLine() {
+	if r.s.vt {
+		r.vtRenderer.EraseLine()
+		return
+	}
+	sbi := r.console()
+	x, y := int(sbi.dwCursorPosition.x), int(sbi.dwCursorPosition.y)
+	r.fill(int(sbi.dwSize.x)-x, x, y)
+}
+
+// copyToClipboard sets the system clipboard to text with OSC 52, if the
+// console interprets escape sequences.
+func (s *State) copyToClipboard(text string) {
+	if s.vt {
+		s.vtCopyToClipboard(text)
+	}
+}
+
+// enterAltScreen switches to the alternate screen, if the console
+// interprets escape sequences.
+func (s *State) enterAltScreen() {
+	if s.vt {
+		s.vtEnterAltScreen()
+	}
+}
+
+// leaveAltScreen returns to the normal screen after enterAltScreen.
+func (s *State) leaveAltScreen() {
+	if s.vt {
+		s.vtLeaveAltScreen()
+	}
+}
+
+// EraseBelow erases from the cursor to the end of the screen buffer.
+func (r termRenderer) EraseBelow() {
+	if r.s.vt {
+		r.vtRenderer.EraseBelow()
+		return
+	}
+	sbi := r.console()
+	x, y := int(sbi.dwCursorPosition.x), int(sbi.dwCursorPosition.y)
+	r.fill((int(sbi.dwSize.y)-y)*int(sbi.dwSize.x)-x, x, y)
+}
+
+func (r termRenderer) EraseScreen() {
+	if r.s.vt {
+		r.vtRenderer.EraseScreen()
+		return
+	}
+	sbi := r.console()
+	r.fill(int(sbi.dwSize.x)*int(sbi.dwSize.y), 0, 0)
+	r.setCursor(0, 0)
+}
+
+func (r termRenderer) MoveUp(lines int) {
+	if r.s.vt {
+		r.vtRenderer.MoveUp(lines)
+		return
+	}
+	sbi := r.console()
+	r.setCursor(int(sbi.dwCursorPosition.x), int(sbi.dwCursorPosition.y)-lines)
+}
+
+func (r termRenderer) MoveDown(lines int) {
+	if r.s.vt {
+		r.vtRenderer.MoveDown(lines)
+		return
+	}
+	sbi := r.console()
+	r.setCursor(int(sbi.dwCursorPosition.x), int(sbi.dwCursorPosition.y)+lines)
+}
+
+// bufferedOutput reports whether what is drawn on the terminal may be held
+// back and written at once, as batch does: only in virtual terminal mode,
+// since the legacy console is drawn on partly with calls.
+func (s *State) bufferedOutput() bool {
+	return s.vt
 }
 
 func (s *State) emitNewLine() {
-	// windows doesn't need to omit a new line
+	// The legacy console wraps as soon as a row is full, but in virtual
+	// terminal mode the wrap waits for the next character, as on Unix
+	if s.vt || s.renderer != nil {
+		fmt.Fprint(s.display(), "\n")
+	}
 }
 
 func (s *State) getColumns() {
+	s.columns, s.rows, _ = s.terminalSize()
+}
+
+// terminalSize returns the size of the console, without recording it as
+// getColumns does.
+func (s *State) terminalSize() (columns, rows int, ok bool) {
 	var sbi consoleScreenBufferInfo
-	procGetConsoleScreenBufferInfo.Call(uintptr(s.hOut), uintptr(unsafe.Pointer(&sbi)))
-	s.columns = int(sbi.dwSize.x)
+	r, _, _ := procGetConsoleScreenBufferInfo.Call(uintptr(s.hOut), uintptr(unsafe.Pointer(&sbi)))
+	return int(sbi.dwSize.x), int(sbi.srWindow.bottom-sbi.srWindow.top) + 1, r != 0
 }
@@ -0,0 +1,78 @@
+package liner
+
+import "strings"
+
+// TerminalQuirks is a set of workarounds for terminals, and for the
+// terminal multiplexers tmux and GNU screen, that drop or mangle some of the
+// escape sequences liner writes.
+type TerminalQuirks int
+
+// The quirks available to SetTerminalQuirks.
+const (
+	// QuirkTmux sends the clipboard sequence of SetClipboard through tmux
+	// to the terminal it runs in. tmux forwards it if its
+	// allow-passthrough option is on.
+	QuirkTmux TerminalQuirks = 1 << iota
+	// QuirkScreen sends cursor shapes and the clipboard sequence through
+	// GNU screen, which otherwise drops them, to the terminal it runs in.
+	QuirkScreen
+	// QuirkNoBracketedPaste leaves bracketed paste off, even if
+	// SetBracketedPaste turns it on.
+	QuirkNoBracketedPaste
+	// QuirkNoCursorShape leaves the shape of the cursor alone.
+	QuirkNoCursorShape
+	// QuirkNoSyncUpdate draws the line without the sequences of a
+	// synchronized update (DEC mode 2026), which a terminal that has them
+	// uses to show each change whole, for a terminal that mangles them.
+	QuirkNoSyncUpdate
+)
+
+// terminalQuirks returns the quirks of the terminal named term, given the
+// $TMUX and $STY environment variables that tmux and screen set.
+func terminalQuirks(term, tmux, sty string) TerminalQuirks {
+	switch {
+	case tmux != "":
+		return QuirkTmux
+	case sty != "" || strings.HasPrefix(term, "screen"):
+		// screen has bracketed paste from version 5 only, and does not
+		// forward synchronized updates
+		return QuirkScreen | QuirkNoBracketedPaste | QuirkNoSyncUpdate
+	}
+	return 0
+}
+
+// SetTerminalQuirks sets the workarounds used for the terminal. The default
+// is detected from the $TERM, $TMUX and $STY environment variables, which
+// can be wrong: inside tmux or screen on another machine, reached with ssh,
+// or in a terminal the detection does not know.
+func (s *State) SetTerminalQuirks(quirks TerminalQuirks) {
+	s.quirks = quirks
+}
+
+// screenStringMax is the longest DCS string that screen forwards.
+const screenStringMax = 760
+
+// passthrough wraps seq, an escape sequence for the terminal that tmux or
+// screen runs in, so that the multiplexer forwards it unchanged.
+func (s *State) passthrough(seq string) string {
+	switch {
+	case s.quirks&QuirkTmux != 0:
+		return "\x1bPtmux;" + strings.Replace(seq, "\x1b", "\x1b\x1b", -1) + "\x1b\\"
+	case s.quirks&QuirkScreen != 0:
+		// A longer sequence is split across several strings, which
+		// screen forwards one after the other
+		var wrapped string
+		for len(seq) > screenStringMax {
+			wrapped += "\x1bP" + seq[:screenStringMax] + "\x1b\\"
+			seq = seq[screenStringMax:]
+		}
+		return wrapped + "\x1bP" + seq + "\x1b\\"
+	}
+	return seq
+}
+
+// pasteBracketed reports whether bracketed paste mode is turned on during
+// prompts.
+func (s *State) pasteBracketed() bool {
+	return s.bracketedPaste && s.quirks&QuirkNoBracketedPaste == 0
+}
@@ -0,0 +1,42 @@
+package liner
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTerminalQuirks(t *testing.T) {
+	tests := []struct {
+		term, tmux, sty string
+		want            TerminalQuirks
+	}{
+		{"xterm-256color", "", "", 0},
+		{"tmux-256color", "/tmp/tmux-1000/default,1234,0", "", QuirkTmux},
+		{"screen-256color", "/tmp/tmux-1000/default,1234,0", "", QuirkTmux},
+		{"screen.xterm-256color", "", "1234.pts-0.host", QuirkScreen | QuirkNoBracketedPaste | QuirkNoSyncUpdate},
+		{"screen", "", "", QuirkScreen | QuirkNoBracketedPaste | QuirkNoSyncUpdate},
+	}
+	for _, test := range tests {
+		if got := terminalQuirks(test.term, test.tmux, test.sty); got != test.want {
+			t.Errorf("terminalQuirks(%q, %q, %q) = %v, want %v", test.term, test.tmux, test.sty, got, test.want)
+		}
+	}
+}
+
+func TestPassthrough(t *testing.T) {
+	var s State
+	if got := s.passthrough("\x1b[2 q"); got != "\x1b[2 q" {
+		t.Errorf("no quirks: got %q", got)
+	}
+	s.SetTerminalQuirks(QuirkTmux)
+	if got := s.passthrough("\x1b[2 q"); got != "\x1bPtmux;\x1b\x1b[2 q\x1b\\" {
+		t.Errorf("tmux: got %q", got)
+	}
+	s.SetTerminalQuirks(QuirkScreen)
+	long := "\x1b]52;c;" + strings.Repeat("A", screenStringMax) + "\a"
+	got := s.passthrough(long)
+	want := "\x1bP" + long[:screenStringMax] + "\x1b\\\x1bP" + long[screenStringMax:] + "\x1b\\"
+	if got != want {
+		t.Errorf("screen: got %q", got)
+	}
+}
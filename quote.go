@@ -0,0 +1,159 @@
+package liner
+
+import "strings"
+
+const (
+	defaultWordBreaks = " \t\n"
+	defaultQuotes     = `"'`
+)
+
+// SetWordBreakCharacters sets the characters that separate words for
+// SplitWord and QuotingCompleter. The default (also used if chars is empty)
+// is space, tab and newline.
+func (s *State) SetWordBreakCharacters(chars string) {
+	s.wordBreaks = chars
+}
+
+// SetQuoteCharacters sets the characters that quote text containing word
+// break characters for SplitWord and QuotingCompleter. A backslash always
+// escapes the next character, except within single quotes. The default
+// (also used if chars is empty) is double and single quotes.
+func (s *State) SetQuoteCharacters(chars string) {
+	s.quotes = chars
+}
+
+func (s *State) wordChars() (breaks, quotes string) {
+	breaks, quotes = s.wordBreaks, s.quotes
+	if breaks == "" {
+		breaks = defaultWordBreaks
+	}
+	if quotes == "" {
+		quotes = defaultQuotes
+	}
+	return breaks, quotes
+}
+
+// SplitWord splits line at the rune position pos into the text before the
+// word being completed, the word itself with quotes and backslash escapes
+// removed, and the text after the cursor. If the word is within an unclosed
+// quote, quote is the opening quote character; otherwise it is 0.
+func (s *State) SplitWord(line string, pos int) (head, word string, quote rune, tail string) {
+	breaks, quotes := s.wordChars()
+	r := []rune(line)
+	start, word, quote := splitWord(r[:pos], breaks, quotes)
+	return string(r[:start]), word, quote, string(r[pos:])
+}
+
+// historyWords splits line into words at unquoted word break characters,
+// keeping any quotes and escapes in the words.
+func historyWords(line []rune, breaks, quotes string) []string {
+	var words []string
+	var w []rune
+	var quote rune
+	escaped := false
+	for _, c := range line {
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\' && quote != '\'':
+			escaped = true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case strings.ContainsRune(quotes, c):
+			quote = c
+		case strings.ContainsRune(breaks, c):
+			if len(w) > 0 {
+				words = append(words, string(w))
+				w = w[:0]
+			}
+			continue
+		}
+		w = append(w, c)
+	}
+	if len(w) > 0 {
+		words = append(words, string(w))
+	}
+	return words
+}
+
+// splitWord returns the rune index of the start of the last word in r, the
+// unquoted word, and the quote that is open at the end of r.
+func splitWord(r []rune, breaks, quotes string) (start int, word string, quote rune) {
+	var w []rune
+	escaped := false
+	for i, c := range r {
+		switch {
+		case escaped:
+			w = append(w, c)
+			escaped = false
+		case c == '\\' && quote != '\'':
+			escaped = true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				w = append(w, c)
+			}
+		case strings.ContainsRune(quotes, c):
+			quote = c
+		case strings.ContainsRune(breaks, c):
+			w = w[:0]
+			start = i + 1
+		default:
+			w = append(w, c)
+		}
+	}
+	return start, string(w), quote
+}
+
+// quoteWord quotes word for insertion in place of a word opened with quote
+// (0 if the word was not quoted). If closed is true, the closing quote is
+// appended.
+func quoteWord(word string, quote rune, closed bool, breaks, quotes string) string {
+	var b strings.Builder
+	if quote != 0 {
+		b.WriteRune(quote)
+	}
+	for _, c := range word {
+		switch {
+		case quote == '\'':
+			if c == '\'' {
+				// A single quote cannot be escaped within single quotes
+				b.WriteString(`'\''`)
+				continue
+			}
+		case quote != 0:
+			if c == quote || c == '\\' {
+				b.WriteByte('\\')
+			}
+		case c == '\\' || strings.ContainsRune(breaks, c) || strings.ContainsRune(quotes, c):
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+	if quote != 0 && closed {
+		b.WriteRune(quote)
+	}
+	return b.String()
+}
+
+// QuotingCompleter returns a WordCompleter that splits the line using the
+// word break and quote characters configured on s, passes the unquoted word
+// before the cursor to f, and quotes the completions returned by f so that
+// they are inserted as a single word. When f returns exactly one completion
+// for a quoted word, the closing quote is added.
+func (s *State) QuotingCompleter(f func(word string) []string) WordCompleter {
+	return func(line string, pos int) (string, []string, string) {
+		breaks, quotes := s.wordChars()
+		r := []rune(line)
+		start, word, quote := splitWord(r[:pos], breaks, quotes)
+		list := f(word)
+		quoted := make([]string, len(list))
+		for i, c := range list {
+			quoted[i] = quoteWord(c, quote, len(list) == 1, breaks, quotes)
+		}
+		return string(r[:start]), quoted, string(r[pos:])
+	}
+}
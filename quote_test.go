@@ -0,0 +1,61 @@
+package liner
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitWord(t *testing.T) {
+	tests := []struct {
+		line  string
+		start int
+		word  string
+		quote rune
+	}{
+		{"", 0, "", 0},
+		{"cat foo", 4, "foo", 0},
+		{"cat foo ", 8, "", 0},
+		{`cat my\ fi`, 4, "my fi", 0},
+		{`cat "my fi`, 4, "my fi", '"'},
+		{`cat "it's`, 4, "it's", '"'},
+		{`cat 'a\`, 4, `a\`, '\''},
+		{`cat "a b"c`, 4, "a bc", 0},
+		{`cat "a \" b`, 4, `a " b`, '"'},
+	}
+	for _, test := range tests {
+		start, word, quote := splitWord([]rune(test.line), defaultWordBreaks, defaultQuotes)
+		if start != test.start || word != test.word || quote != test.quote {
+			t.Errorf("splitWord(%q) = %d, %q, %q; want %d, %q, %q",
+				test.line, start, word, quote, test.start, test.word, test.quote)
+		}
+	}
+}
+
+func TestQuoteWord(t *testing.T) {
+	tests := []struct {
+		word   string
+		quote  rune
+		closed bool
+		want   string
+	}{
+		{"my file", 0, true, `my\ file`},
+		{`a"b`, 0, false, `a\"b`},
+		{"my file", '"', true, `"my file"`},
+		{`say "hi"`, '"', false, `"say \"hi\"`},
+		{"it's", '\'', true, `'it'\''s'`},
+	}
+	for _, test := range tests {
+		got := quoteWord(test.word, test.quote, test.closed, defaultWordBreaks, defaultQuotes)
+		if got != test.want {
+			t.Errorf("quoteWord(%q, %q, %v) = %q, want %q", test.word, test.quote, test.closed, got, test.want)
+		}
+	}
+}
+
+func TestHistoryWords(t *testing.T) {
+	got := historyWords([]rune(`cp  "a b" c\ d 'e'`), defaultWordBreaks, defaultQuotes)
+	want := []string{"cp", `"a b"`, `c\ d`, "'e'"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,54 @@
+package liner
+
+import "io"
+
+// Renderer draws what a Prompt displays, for a display other than the
+// terminal, such as a widget of a GUI, a terminal in a web page, or a fake
+// display in a test. liner has its own for the terminals it supports, which
+// draw with the escape sequences of an xterm, or with the console API of
+// Windows; SetRenderer replaces it.
+//
+// Columns count from 0, and the display is as wide as the State's terminal
+// is (see NewStream and SetSize). Only line editing is drawn with the
+// Renderer: the terminal is still read for keys, and features of the
+// terminal itself, such as the system clipboard and the alternate screen
+// used while an editor runs, are still used through it.
+type Renderer interface {
+	// Write displays text at the cursor and moves the cursor past it.
+	// The text may hold newlines, which move the cursor to the start of
+	// the next row, a BEL (\a) to beep, and the SGR escape sequences of
+	// Styles and the OSC 8 sequences of Links. A row filled to its last
+	// column moves the cursor to the next row only when more text is
+	// written to it, as an xterm does.
+	io.Writer
+
+	// MoveCursor moves the cursor to column of its row.
+	MoveCursor(column int)
+
+	// MoveUp moves the cursor up rows rows, in its column.
+	MoveUp(rows int)
+
+	// MoveDown moves the cursor down rows rows, in its column.
+	MoveDown(rows int)
+
+	// EraseLine erases the row of the cursor from the cursor to its end.
+	EraseLine()
+
+	// EraseBelow erases from the cursor to the end of the display.
+	EraseBelow()
+
+	// EraseScreen erases the whole display and moves the cursor to its
+	// first row and column.
+	EraseScreen()
+
+	// SetCursorShape sets the shape of the cursor.
+	SetCursorShape(shape CursorShape)
+}
+
+// SetRenderer sets the Renderer that draws the prompt and the line being
+// edited, in place of the terminal. A nil Renderer draws on the terminal
+// again, which is the default. SetRenderer must not be called during a
+// Prompt.
+func (s *State) SetRenderer(r Renderer) {
+	s.renderer = r
+}
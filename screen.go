@@ -0,0 +1,74 @@
+package liner
+
+import "strings"
+
+// RowKind identifies what a row displayed by a Prompt holds.
+type RowKind int
+
+// The kinds of ScreenRow.
+const (
+	LineRow    RowKind = iota + 1 // the prompt and the line, or a row of a line of several
+	MessageRow                    // a message, such as one from an AcceptHook
+	HintRow                       // the hint of the Hinter
+	MenuRow                       // a row of the completion menu
+	SpinnerRow                    // the spinner of a slow completer
+	StatusRow                     // the status line, see SetStatus
+)
+
+// ScreenRow is a row displayed by a Prompt, as returned by Screen.
+type ScreenRow struct {
+	Kind     RowKind
+	Segments []StyledSegment
+	Right    []StyledSegment // the right prompt, if it is displayed on the row
+}
+
+// String returns the text of the row, without its styles or right prompt.
+func (r ScreenRow) String() string {
+	var b strings.Builder
+	for _, seg := range r.Segments {
+		b.WriteString(seg.Text)
+	}
+	return b.String()
+}
+
+// shownRow is a row last displayed by a Prompt, with its escape sequences,
+// as kept for Screen.
+type shownRow struct {
+	kind  RowKind
+	text  string
+	right string
+}
+
+// Screen returns the rows that the Prompt in progress displays, as it last
+// displayed them: the prompt and the line, and below them the rows of any
+// message, hint, completion menu or status line. Each row is the text of
+// a logical row, before the terminal wraps it, and a line that scrolls
+// within a row is returned whole; styles are those displayed, so there are
+// none if colors are disabled. A password is returned as it is displayed.
+// Screen lets tests and accessibility tools read the display without
+// decoding what is written to the terminal. It may be called from any
+// goroutine, and returns nil if no Prompt is in progress.
+func (s *State) Screen() []ScreenRow {
+	s.asyncMu.Lock()
+	shown := append(append([]shownRow(nil), s.shownLine...), s.shownBelow...)
+	s.asyncMu.Unlock()
+	var rows []ScreenRow
+	for _, r := range shown {
+		rows = append(rows, ScreenRow{
+			Kind:     r.kind,
+			Segments: styledSegments(r.text),
+			Right:    styledSegments(r.right),
+		})
+	}
+	return rows
+}
+
+// drawnRow is the row that refreshSingleLine drew, when the line fitted in
+// it, so that only what changes is drawn again.
+type drawnRow struct {
+	prompt  string
+	text    []rune  // the line and any ghost text after it
+	styles  []Style // the style of each rune of text
+	right   string  // the right prompt
+	columns int     // the width of the terminal
+}
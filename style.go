@@ -0,0 +1,656 @@
+package liner
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Color is a terminal color. The zero value is the terminal's default color.
+// The sixteen standard colors are provided as constants; use Color256 and
+// RGB for the extended palettes.
+type Color uint32
+
+// The standard terminal colors.
+const (
+	ColorDefault Color = iota
+	ColorBlack
+	ColorRed
+	ColorGreen
+	ColorYellow
+	ColorBlue
+	ColorMagenta
+	ColorCyan
+	ColorWhite
+	ColorBrightBlack
+	ColorBrightRed
+	ColorBrightGreen
+	ColorBrightYellow
+	ColorBrightBlue
+	ColorBrightMagenta
+	ColorBrightCyan
+	ColorBrightWhite
+)
+
+const (
+	color256 Color = 1 << 24
+	colorRGB Color = 2 << 24
+)
+
+// Color256 returns color n of the xterm 256 color palette.
+func Color256(n uint8) Color {
+	return color256 | Color(n)
+}
+
+// RGB returns a 24-bit color.
+func RGB(r, g, b uint8) Color {
+	return colorRGB | Color(r)<<16 | Color(g)<<8 | Color(b)
+}
+
+// ColorDepth is the number of colors a terminal can display.
+type ColorDepth int
+
+// The color depths available to SetColorDepth.
+const (
+	Colors16   ColorDepth = 16      // the standard colors
+	Colors256  ColorDepth = 256     // the xterm 256 color palette
+	ColorsTrue ColorDepth = 1 << 24 // 24-bit colors
+)
+
+// terminalColorDepth returns the color depth of the terminal named term,
+// from the conventions of $TERM and $COLORTERM.
+func terminalColorDepth(term, colorterm string) ColorDepth {
+	switch {
+	case colorterm == "truecolor" || colorterm == "24bit" || strings.HasSuffix(term, "-direct"):
+		return ColorsTrue
+	case strings.Contains(term, "256color"):
+		return Colors256
+	}
+	return Colors16
+}
+
+// SetColorDepth sets the number of colors the terminal can display. Colors
+// of Styles beyond it are displayed as the nearest color it can display.
+// The default is detected from the $TERM and $COLORTERM environment
+// variables.
+func (s *State) SetColorDepth(depth ColorDepth) {
+	s.colorDepth = depth
+}
+
+// ColorDepth returns the number of colors the terminal can display, for
+// highlighters and hinters that choose colors to suit it.
+func (s *State) ColorDepth() ColorDepth {
+	if s.colorDepth < Colors16 {
+		return Colors16
+	}
+	return s.colorDepth
+}
+
+// palette16 holds the xterm values of the standard colors, from ColorBlack
+// on.
+var palette16 = [16][3]uint8{
+	{0, 0, 0}, {205, 0, 0}, {0, 205, 0}, {205, 205, 0},
+	{0, 0, 238}, {205, 0, 205}, {0, 205, 205}, {229, 229, 229},
+	{127, 127, 127}, {255, 0, 0}, {0, 255, 0}, {255, 255, 0},
+	{92, 92, 255}, {255, 0, 255}, {0, 255, 255}, {255, 255, 255},
+}
+
+// cubeLevels are the values of the six levels of each component in the 6x6x6
+// color cube of the xterm 256 color palette.
+var cubeLevels = [6]uint8{0, 95, 135, 175, 215, 255}
+
+// rgb returns the components of c, which must be a 256 palette or RGB color.
+func (c Color) rgb() (r, g, b uint8) {
+	if c&colorRGB != 0 {
+		return uint8(c >> 16), uint8(c >> 8), uint8(c)
+	}
+	n := int(c & 0xff)
+	switch {
+	case n < 16:
+		p := palette16[n]
+		return p[0], p[1], p[2]
+	case n < 232:
+		n -= 16
+		return cubeLevels[n/36], cubeLevels[n/6%6], cubeLevels[n%6]
+	}
+	v := uint8(8 + 10*(n-232))
+	return v, v, v
+}
+
+// cubeLevel returns the level of the color cube nearest to v.
+func cubeLevel(v uint8) int {
+	switch {
+	case v < 48:
+		return 0
+	case v < 115:
+		return 1
+	}
+	return (int(v) - 35) / 40
+}
+
+// fit returns the color nearest to c that can be displayed with depth.
+func (c Color) fit(depth ColorDepth) Color {
+	switch {
+	case c == ColorDefault || c < color256 || depth >= ColorsTrue:
+		return c
+	case c&color256 != 0 && depth >= Colors256:
+		return c
+	}
+	r, g, b := c.rgb()
+	if depth >= Colors256 {
+		if r == g && g == b {
+			// The gray ramp, with black and white from the cube
+			switch {
+			case r < 8:
+				return Color256(16)
+			case r > 238:
+				return Color256(231)
+			}
+			return Color256(uint8(232 + (int(r)-8)/10))
+		}
+		return Color256(uint8(16 + 36*cubeLevel(r) + 6*cubeLevel(g) + cubeLevel(b)))
+	}
+	best, dist := 0, -1
+	for i, p := range palette16 {
+		dr, dg, db := int(r)-int(p[0]), int(g)-int(p[1]), int(b)-int(p[2])
+		if d := dr*dr + dg*dg + db*db; dist < 0 || d < dist {
+			best, dist = i, d
+		}
+	}
+	return ColorBlack + Color(best)
+}
+
+// sgr appends the SGR parameters selecting c as the foreground (base 30)
+// or background (base 40) color.
+func (c Color) sgr(params []string, base int) []string {
+	switch {
+	case c == ColorDefault:
+		return params
+	case c&colorRGB != 0:
+		return append(params, strconv.Itoa(base+8), "2",
+			strconv.Itoa(int(c>>16&0xff)), strconv.Itoa(int(c>>8&0xff)), strconv.Itoa(int(c&0xff)))
+	case c&color256 != 0:
+		return append(params, strconv.Itoa(base+8), "5", strconv.Itoa(int(c&0xff)))
+	case c >= ColorBrightBlack:
+		return append(params, strconv.Itoa(base+60+int(c-ColorBrightBlack)))
+	default:
+		return append(params, strconv.Itoa(base+int(c-ColorBlack)))
+	}
+}
+
+// Style describes how text is displayed. The zero Style displays text
+// using the terminal's default attributes.
+type Style struct {
+	Fg, Bg    Color
+	Bold      bool
+	Dim       bool
+	Italic    bool
+	Underline bool
+	Reverse   bool
+}
+
+// escape returns the ANSI SGR escape sequence that selects st, or the
+// empty string for the zero Style.
+func (st Style) escape() string {
+	var params []string
+	if st.Bold {
+		params = append(params, "1")
+	}
+	if st.Dim {
+		params = append(params, "2")
+	}
+	if st.Italic {
+		params = append(params, "3")
+	}
+	if st.Underline {
+		params = append(params, "4")
+	}
+	if st.Reverse {
+		params = append(params, "7")
+	}
+	params = st.Fg.sgr(params, 30)
+	params = st.Bg.sgr(params, 40)
+	if len(params) == 0 {
+		return ""
+	}
+	return "\x1b[" + strings.Join(params, ";") + "m"
+}
+
+const styleReset = "\x1b[0m"
+
+// render returns text displayed in st.
+func (st Style) render(text string) string {
+	e := st.escape()
+	if e == "" {
+		return text
+	}
+	return e + text + styleReset
+}
+
+// SetColors sets whether liner displays Styles (such as the colors of
+// completion candidates). When false, text is displayed without styling.
+// The default is true, unless the NO_COLOR environment variable is set
+// or the terminal is known not to support ANSI escape sequences.
+func (s *State) SetColors(colors bool) {
+	s.noColors = !colors
+}
+
+// styled returns text displayed in st, if styles are enabled.
+func (s *State) styled(st Style, text string) string {
+	if s.noColors {
+		return text
+	}
+	return s.fitColors(st).render(text)
+}
+
+// fitColors returns st with its colors replaced by the nearest that the
+// terminal can display.
+func (s *State) fitColors(st Style) Style {
+	depth := s.ColorDepth()
+	st.Fg = st.Fg.fit(depth)
+	st.Bg = st.Bg.fit(depth)
+	return st
+}
+
+// stripSGR returns text without its ANSI SGR escape sequences ("\x1b[...m").
+func stripSGR(text string) string {
+	if !strings.Contains(text, "\x1b[") {
+		return text
+	}
+	var b strings.Builder
+	for {
+		i := strings.Index(text, "\x1b[")
+		if i < 0 {
+			break
+		}
+		j := i + 2
+		for j < len(text) && (text[j] >= '0' && text[j] <= '9' || text[j] == ';' || text[j] == ':') {
+			j++
+		}
+		if j == len(text) || text[j] != 'm' {
+			// Not SGR: keep the escape, for the caller to reject
+			b.WriteString(text[:i+1])
+			text = text[i+1:]
+			continue
+		}
+		b.WriteString(text[:i])
+		text = text[j+1:]
+	}
+	b.WriteString(text)
+	return b.String()
+}
+
+// styledSegments splits text, which may hold SGR escape sequences and
+// hyperlinks, into the runs of text displayed in each Style. Other escape
+// sequences are kept in the text.
+func styledSegments(text string) []StyledSegment {
+	var segs []StyledSegment
+	var st Style
+	for text != "" {
+		if n, _ := linkAt(text); n > 0 {
+			text = text[n:]
+			continue
+		}
+		if strings.HasPrefix(text, "\x1b[") {
+			j := 2
+			for j < len(text) && (text[j] >= '0' && text[j] <= '9' || text[j] == ';' || text[j] == ':') {
+				j++
+			}
+			if j < len(text) && text[j] == 'm' {
+				st = st.apply(text[2:j])
+				text = text[j+1:]
+				continue
+			}
+		}
+		i := strings.IndexByte(text[1:], '\x1b') + 1
+		if i == 0 {
+			i = len(text)
+		}
+		if n := len(segs); n > 0 && segs[n-1].Style == st {
+			segs[n-1].Text += text[:i]
+		} else {
+			segs = append(segs, StyledSegment{Text: text[:i], Style: st})
+		}
+		text = text[i:]
+	}
+	return segs
+}
+
+// apply returns st changed by the parameters of an SGR escape sequence.
+func (st Style) apply(params string) Style {
+	p := strings.FieldsFunc(params, func(r rune) bool { return r == ';' || r == ':' })
+	if len(p) == 0 {
+		return Style{}
+	}
+	for i := 0; i < len(p); i++ {
+		n, _ := strconv.Atoi(p[i])
+		switch {
+		case n == 0:
+			st = Style{}
+		case n == 1:
+			st.Bold = true
+		case n == 2:
+			st.Dim = true
+		case n == 3:
+			st.Italic = true
+		case n == 4:
+			st.Underline = true
+		case n == 7:
+			st.Reverse = true
+		case n == 22:
+			st.Bold, st.Dim = false, false
+		case n == 23:
+			st.Italic = false
+		case n == 24:
+			st.Underline = false
+		case n == 27:
+			st.Reverse = false
+		case n >= 30 && n <= 37:
+			st.Fg = ColorBlack + Color(n-30)
+		case n >= 40 && n <= 47:
+			st.Bg = ColorBlack + Color(n-40)
+		case n >= 90 && n <= 97:
+			st.Fg = ColorBrightBlack + Color(n-90)
+		case n >= 100 && n <= 107:
+			st.Bg = ColorBrightBlack + Color(n-100)
+		case n == 39:
+			st.Fg = ColorDefault
+		case n == 49:
+			st.Bg = ColorDefault
+		case (n == 38 || n == 48) && i+1 < len(p):
+			var c Color
+			arg := func(j int) uint8 {
+				v, _ := strconv.Atoi(p[j])
+				return uint8(v)
+			}
+			switch {
+			case p[i+1] == "5" && i+2 < len(p):
+				c = Color256(arg(i + 2))
+				i += 2
+			case p[i+1] == "2" && i+4 < len(p):
+				c = RGB(arg(i+2), arg(i+3), arg(i+4))
+				i += 4
+			default:
+				i = len(p)
+				continue
+			}
+			if n == 38 {
+				st.Fg = c
+			} else {
+				st.Bg = c
+			}
+		}
+	}
+	return st
+}
+
+// linkEnd ends an OSC 8 hyperlink.
+const linkEnd = "\x1b]8;;\x1b\\"
+
+// Link returns text as a hyperlink to url, which terminals that support
+// OSC 8 hyperlinks (see SetHyperlinks) let the user open, for example with
+// Ctrl-click. It may be used in prompts and in candidate descriptions.
+func Link(text, url string) string {
+	return "\x1b]8;;" + url + "\x1b\\" + text + linkEnd
+}
+
+// SetHyperlinks sets whether liner displays the hyperlinks made by Link.
+// When false, only their text is displayed. The default is true, unless the
+// terminal is known not to support them.
+func (s *State) SetHyperlinks(links bool) {
+	s.noLinks = !links
+}
+
+// linkTerminal reports whether the terminal named term may display OSC 8
+// hyperlinks: terminals without them ignore the escape sequences, except
+// for a few that display them as text.
+func linkTerminal(term string) bool {
+	switch term {
+	case "", "dumb", "linux", "cons25":
+		return false
+	}
+	return !strings.HasPrefix(term, "vt")
+}
+
+// linkAt returns the length of the OSC 8 escape sequence at the start of
+// text, and whether it begins a link rather than ending one. It returns 0 if
+// text does not start with one.
+func linkAt(text string) (n int, open bool) {
+	if !strings.HasPrefix(text, "\x1b]8;") {
+		return 0, false
+	}
+	for i := 4; i < len(text); i++ {
+		switch {
+		case text[i] == '\a':
+			n = i + 1
+		case text[i] == '\x1b' && strings.HasPrefix(text[i:], "\x1b\\"):
+			n = i + 2
+		default:
+			continue
+		}
+		// The parameters, ';' and the URL
+		params := text[4:i]
+		j := strings.IndexByte(params, ';')
+		return n, j >= 0 && j < len(params)-1
+	}
+	return 0, false
+}
+
+// stripLinks returns text without its OSC 8 escape sequences.
+func stripLinks(text string) string {
+	if !strings.Contains(text, "\x1b]8;") {
+		return text
+	}
+	var b strings.Builder
+	for {
+		i := strings.Index(text, "\x1b]8;")
+		if i < 0 {
+			break
+		}
+		n, _ := linkAt(text[i:])
+		if n == 0 {
+			// Unterminated: keep the escape, for the caller to reject
+			n = 1
+			b.WriteString(text[:i+1])
+		} else {
+			b.WriteString(text[:i])
+		}
+		text = text[i+n:]
+	}
+	b.WriteString(text)
+	return b.String()
+}
+
+// stripEscapes returns text without its SGR and OSC 8 escape sequences.
+func stripEscapes(text string) string {
+	return stripLinks(stripSGR(text))
+}
+
+// prefixVisible returns the longest prefix of text whose displayed runes
+// fit in columns, ending any hyperlink it cuts.
+func prefixVisible(text string, columns int) string {
+	var b strings.Builder
+	var shown []rune
+	open := false
+	for i := 0; i < len(text); {
+		if n, o := linkAt(text[i:]); n > 0 {
+			b.WriteString(text[i : i+n])
+			open = o
+			i += n
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(text[i:])
+		shown = append(shown, r)
+		if countGlyphs(shown) > columns {
+			break
+		}
+		b.WriteRune(r)
+		i += size
+	}
+	if open {
+		b.WriteString(linkEnd)
+	}
+	return b.String()
+}
+
+// checkPrompt returns prompt as it is to be displayed: without its SGR
+// escape sequences if colors are disabled, or followed by a reset of the
+// style if not, and likewise for its hyperlinks. It returns
+// ErrInvalidPrompt if prompt holds any other unprintable runes.
+func (s *State) checkPrompt(prompt string) (string, error) {
+	plain := stripEscapes(prompt)
+	for _, r := range plain {
+		if unicode.Is(unicode.C, r) {
+			return "", ErrInvalidPrompt
+		}
+	}
+	if plain == prompt {
+		return prompt, nil
+	}
+	if s.noColors {
+		prompt = stripSGR(prompt)
+	} else if stripSGR(prompt) != prompt {
+		prompt += styleReset
+	}
+	if s.noLinks {
+		prompt = stripLinks(prompt)
+	} else if stripLinks(prompt) != prompt {
+		prompt += linkEnd
+	}
+	return prompt, nil
+}
+
+// visibleRunes returns the runes of prompt that are displayed, without its
+// escape sequences.
+func visibleRunes(prompt []rune) []rune {
+	return []rune(stripEscapes(string(prompt)))
+}
+
+// StyledSegment is a run of text displayed in a Style.
+type StyledSegment struct {
+	Text  string
+	Style Style
+}
+
+// Highlighter takes the currently edited line and returns it split into
+// styled segments. The concatenated Text of the segments must equal line;
+// otherwise the line is displayed without highlighting.
+type Highlighter func(line string) []StyledSegment
+
+// SetHighlighter sets the function that Liner will call to style the line
+// being edited (for example, to colorize keywords and strings). Styles are
+// only displayed if colors are enabled. Like RefreshPrompt, SetHighlighter
+// may be called from another goroutine while Prompt is in progress.
+func (s *State) SetHighlighter(f Highlighter) {
+	s.configure(func() {
+		s.highlighter = f
+	})
+}
+
+// runeStyles returns the style of each rune of buf, as returned by the
+// highlighter and with the bracket matching the one at pos highlighted, or nil
+// if the line is not styled.
+func (s *State) runeStyles(buf []rune, pos int) []Style {
+	if s.noColors {
+		return nil
+	}
+	styles := s.highlight(buf)
+	if s.bracketPairs != "" {
+		if m := matchBracket(buf, pos, s.bracketPairs); m >= 0 {
+			if styles == nil {
+				styles = make([]Style, len(buf))
+			}
+			styles[m] = s.fitColors(s.bracketStyle)
+		}
+	}
+	return styles
+}
+
+func (s *State) highlight(buf []rune) []Style {
+	if s.highlighter == nil {
+		return nil
+	}
+	styles := make([]Style, 0, len(buf))
+	for _, seg := range s.highlighter(string(buf)) {
+		seg.Style = s.fitColors(seg.Style)
+		for _, r := range seg.Text {
+			if len(styles) == len(buf) || buf[len(styles)] != r {
+				return nil
+			}
+			styles = append(styles, seg.Style)
+		}
+	}
+	if len(styles) != len(buf) {
+		return nil
+	}
+	return styles
+}
+
+// renderStyled returns text with each rune displayed in the corresponding
+// element of styles. If styles is nil, text is returned unstyled.
+func renderStyled(text []rune, styles []Style) string {
+	if styles == nil {
+		return string(text)
+	}
+	var b strings.Builder
+	for i := 0; i < len(text); {
+		j := i + 1
+		for j < len(text) && styles[j] == styles[i] {
+			j++
+		}
+		b.WriteString(styles[i].render(string(text[i:j])))
+		i = j
+	}
+	return b.String()
+}
+
+// SetMatchingBrackets sets the bracket pairs (for example, "()[]{}") for
+// which liner highlights the matching bracket when the cursor is on or just
+// after a bracket. The matching bracket is displayed in style, or in reverse
+// video if style is the zero Style. An empty pairs disables highlighting.
+func (s *State) SetMatchingBrackets(pairs string, style Style) {
+	s.bracketPairs = pairs
+	if style == (Style{}) {
+		style.Reverse = true
+	}
+	s.bracketStyle = style
+}
+
+// matchBracket returns the index of the bracket matching the bracket at pos
+// (or, failing that, at pos-1) in buf, or -1. pairs lists the opening and
+// closing brackets of each pair.
+func matchBracket(buf []rune, pos int, pairs string) int {
+	p := []rune(pairs)
+	for _, at := range []int{pos, pos - 1} {
+		if at < 0 || at >= len(buf) {
+			continue
+		}
+		for i := 0; i+1 < len(p); i += 2 {
+			open, close := p[i], p[i+1]
+			dir := 0
+			switch buf[at] {
+			case open:
+				dir = 1
+			case close:
+				dir = -1
+			default:
+				continue
+			}
+			depth := 0
+			for j := at; j >= 0 && j < len(buf); j += dir {
+				switch buf[j] {
+				case open:
+					depth += dir
+				case close:
+					depth -= dir
+				}
+				if depth == 0 {
+					return j
+				}
+			}
+			return -1
+		}
+	}
+	return -1
+}
@@ -0,0 +1,145 @@
+package liner
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStyleEscape(t *testing.T) {
+	tests := []struct {
+		st   Style
+		want string
+	}{
+		{Style{}, ""},
+		{Style{Fg: ColorBlue}, "\x1b[34m"},
+		{Style{Fg: ColorBrightRed, Bold: true}, "\x1b[1;91m"},
+		{Style{Bg: Color256(208)}, "\x1b[48;5;208m"},
+		{Style{Fg: RGB(1, 2, 3), Underline: true}, "\x1b[4;38;2;1;2;3m"},
+	}
+	for _, test := range tests {
+		if got := test.st.escape(); got != test.want {
+			t.Errorf("%+v.escape() = %q, want %q", test.st, got, test.want)
+		}
+	}
+}
+
+func TestRenderStyled(t *testing.T) {
+	text := []rune("if x")
+	kw := Style{Fg: ColorBlue}
+	styles := []Style{kw, kw, {}, {}}
+	want := "\x1b[34mif\x1b[0m x"
+	if got := renderStyled(text, styles); got != want {
+		t.Errorf("renderStyled = %q, want %q", got, want)
+	}
+	if got := renderStyled(text, nil); got != "if x" {
+		t.Errorf("renderStyled with nil styles = %q", got)
+	}
+}
+
+func TestStyledSegments(t *testing.T) {
+	styles := []Style{
+		{Fg: ColorBlue},
+		{Fg: ColorBrightRed, Bold: true},
+		{Bg: Color256(208)},
+		{Fg: RGB(1, 2, 3), Underline: true},
+	}
+	for _, st := range styles {
+		text := "a" + st.render("b") + Link("c", "https://example.com")
+		want := []StyledSegment{{"a", Style{}}, {"b", st}, {"c", Style{}}}
+		if got := styledSegments(text); !reflect.DeepEqual(got, want) {
+			t.Errorf("styledSegments(%q) = %+v, want %+v", text, got, want)
+		}
+	}
+	if got := styledSegments("\x1b[1mx\x1b[22;3my"); !reflect.DeepEqual(got, []StyledSegment{
+		{"x", Style{Bold: true}}, {"y", Style{Italic: true}},
+	}) {
+		t.Errorf("styledSegments: got %+v", got)
+	}
+}
+
+func TestMatchBracket(t *testing.T) {
+	tests := []struct {
+		buf  string
+		pos  int
+		want int
+	}{
+		{"(a (b) c)", 0, 8},
+		{"(a (b) c)", 9, 0},
+		{"(a (b) c)", 3, 5},
+		{"(a (b) c)", 6, 3},
+		{"(a (b) c)", 1, 8},
+		{"(a (b) c)", 2, -1},
+		{"(a [b) c)", 3, -1},
+		{"((", 0, -1},
+	}
+	for _, test := range tests {
+		if got := matchBracket([]rune(test.buf), test.pos, "()[]"); got != test.want {
+			t.Errorf("matchBracket(%q, %d) = %d, want %d", test.buf, test.pos, got, test.want)
+		}
+	}
+}
+
+func TestCheckPrompt(t *testing.T) {
+	tests := []struct {
+		prompt   string
+		noColors bool
+		want     string
+		err      error
+	}{
+		{"> ", false, "> ", nil},
+		{"\x1b[1;32msql\x1b[0m> ", false, "\x1b[1;32msql\x1b[0m> \x1b[0m", nil},
+		{"\x1b[1;32msql\x1b[0m> ", true, "sql> ", nil},
+		{"\x1b[2J> ", false, "", ErrInvalidPrompt},
+		{"a\tb", false, "", ErrInvalidPrompt},
+		{Link("docs", "https://example.com") + "> ", true, "\x1b]8;;https://example.com\x1b\\docs\x1b]8;;\x1b\\> \x1b]8;;\x1b\\", nil},
+		{"\x1b]8;;https://example.com> ", false, "", ErrInvalidPrompt},
+	}
+	for _, test := range tests {
+		var s State
+		s.noColors = test.noColors
+		if got, err := s.checkPrompt(test.prompt); got != test.want || err != test.err {
+			t.Errorf("checkPrompt(%q) = %q, %v", test.prompt, got, err)
+		}
+	}
+}
+
+func TestLinks(t *testing.T) {
+	text := "see " + Link("the manual", "https://example.com/man") + " now"
+	if got := stripEscapes(text); got != "see the manual now" {
+		t.Errorf("stripEscapes: got %q", got)
+	}
+	want := "see \x1b]8;;https://example.com/man\x1b\\the m" + linkEnd
+	if got := prefixVisible(text, 9); got != want {
+		t.Errorf("prefixVisible: got %q, want %q", got, want)
+	}
+	if got := prefixVisible(text, 100); got != text {
+		t.Errorf("prefixVisible: got %q", got)
+	}
+}
+
+func TestColorFit(t *testing.T) {
+	tests := []struct {
+		c     Color
+		depth ColorDepth
+		want  Color
+	}{
+		{RGB(255, 0, 0), ColorsTrue, RGB(255, 0, 0)},
+		{RGB(255, 0, 0), Colors256, Color256(196)},
+		{RGB(128, 128, 128), Colors256, Color256(244)},
+		{RGB(250, 10, 10), Colors16, ColorBrightRed},
+		{Color256(28), Colors16, ColorGreen},
+		{Color256(9), Colors16, ColorBrightRed},
+		{ColorBlue, Colors16, ColorBlue},
+	}
+	for _, test := range tests {
+		if got := test.c.fit(test.depth); got != test.want {
+			t.Errorf("%#x.fit(%d) = %#x, want %#x", test.c, test.depth, got, test.want)
+		}
+	}
+	if got := terminalColorDepth("xterm-256color", ""); got != Colors256 {
+		t.Errorf("xterm-256color: %d colors", got)
+	}
+	if got := terminalColorDepth("xterm", "truecolor"); got != ColorsTrue {
+		t.Errorf("truecolor: %d colors", got)
+	}
+}
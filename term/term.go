@@ -0,0 +1,7 @@
+// Package term reads and sets the mode and size of terminals, as liner does
+// for its prompts, for programs that write to the terminal themselves (such
+// as a progress bar) or read keys without a prompt.
+//
+// File descriptors are passed as ints, as returned by int(f.Fd()) for an
+// *os.File; on Windows they are console handles.
+package term
@@ -0,0 +1,88 @@
+//go:build !windows && !linux && !darwin && !openbsd && !freebsd && !netbsd
+// +build !windows,!linux,!darwin,!openbsd,!freebsd,!netbsd
+
+package term
+
+import "errors"
+
+var errNotSupported = errors.New("term: not supported on this operating system")
+
+// Mode is the mode of a terminal, as read by GetMode.
+type Mode struct{}
+
+// GetMode returns an error: terminal modes are not supported on this
+// operating system.
+func GetMode(fd int) (*Mode, error) {
+	return nil, errNotSupported
+}
+
+// TerminalMode returns an error, as GetMode does.
+func TerminalMode() (*Mode, error) {
+	return nil, errNotSupported
+}
+
+// ApplyMode does nothing.
+func (m *Mode) ApplyMode() error {
+	return nil
+}
+
+// Echo returns false.
+func (m *Mode) Echo() bool {
+	return false
+}
+
+// Canonical returns false.
+func (m *Mode) Canonical() bool {
+	return false
+}
+
+// Signals returns false.
+func (m *Mode) Signals() bool {
+	return false
+}
+
+// Raw returns false.
+func (m *Mode) Raw() bool {
+	return false
+}
+
+// SetEcho does nothing.
+func (m *Mode) SetEcho(on bool) {
+}
+
+// SetCanonical does nothing.
+func (m *Mode) SetCanonical(on bool) {
+}
+
+// SetSignals does nothing.
+func (m *Mode) SetSignals(on bool) {
+}
+
+// SetRaw does nothing.
+func (m *Mode) SetRaw() {
+}
+
+// Erase returns 0.
+func (m *Mode) Erase() byte {
+	return 0
+}
+
+// Equal returns true: there is only one Mode.
+func (m *Mode) Equal(o *Mode) bool {
+	return true
+}
+
+// MakeRaw returns an error, as GetMode does.
+func MakeRaw(fd int) (*Mode, error) {
+	return nil, errNotSupported
+}
+
+// GetSize returns an error, as GetMode does.
+func GetSize(fd int) (width, height int, err error) {
+	return 0, 0, errNotSupported
+}
+
+// IsTerminal returns false.
+func IsTerminal(fd int) bool {
+	return false
+}
@@ -0,0 +1,91 @@
+package term
+
+import (
+	"os"
+	"testing"
+)
+
+func TestNotTerminal(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+	fd := int(r.Fd())
+	if IsTerminal(fd) {
+		t.Error("a pipe is a terminal")
+	}
+	if _, err := GetMode(fd); err == nil {
+		t.Error("GetMode succeeded on a pipe")
+	}
+	if _, err := MakeRaw(fd); err == nil {
+		t.Error("MakeRaw succeeded on a pipe")
+	}
+	if _, _, err := GetSize(int(w.Fd())); err == nil {
+		t.Error("GetSize succeeded on a pipe")
+	}
+}
+
+func TestModeSettings(t *testing.T) {
+	// The master side of a pseudo-terminal has a mode of its own
+	ptmx, err := os.OpenFile("/dev/ptmx", os.O_RDWR, 0)
+	if err != nil {
+		t.Skip("no pseudo-terminals:", err)
+	}
+	defer ptmx.Close()
+	fd := int(ptmx.Fd())
+	cooked, err := GetMode(fd)
+	if err != nil {
+		t.Skip("no terminal mode:", err)
+	}
+	if !cooked.Echo() || !cooked.Canonical() || !cooked.Signals() || cooked.Raw() {
+		t.Errorf("new pseudo-terminal: echo %v, canonical %v, signals %v, raw %v",
+			cooked.Echo(), cooked.Canonical(), cooked.Signals(), cooked.Raw())
+	}
+
+	if e := cooked.Erase(); e != 0x7f && e != 8 {
+		t.Errorf("new pseudo-terminal: erase character %#x, want DEL or Ctrl-H", e)
+	}
+	raw := *cooked
+	raw.SetRaw()
+	if !raw.Raw() || !raw.Signals() {
+		t.Error("SetRaw did not make a raw mode with signals")
+	}
+
+	custom := *cooked
+	custom.SetEcho(false)
+	custom.SetCanonical(false)
+	if !custom.Raw() || !custom.Signals() {
+		t.Error("SetEcho(false) and SetCanonical(false) did not make a raw mode with signals")
+	}
+	if custom.Equal(cooked) {
+		t.Error("changed mode equal to the original")
+	}
+	if err := custom.ApplyMode(); err != nil {
+		t.Fatal(err)
+	}
+	now, err := GetMode(fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !now.Equal(&custom) {
+		t.Error("mode read back differs from the mode applied")
+	}
+
+	old, err := MakeRaw(fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !old.Equal(&custom) {
+		t.Error("MakeRaw did not return the previous mode")
+	}
+	if err := cooked.ApplyMode(); err != nil {
+		t.Fatal(err)
+	}
+	custom.SetEcho(true)
+	custom.SetCanonical(true)
+	if !custom.Equal(cooked) {
+		t.Error("restoring the settings did not restore the mode")
+	}
+}
@@ -0,0 +1,147 @@
+//go:build linux || darwin || freebsd || openbsd || netbsd
+// +build linux darwin freebsd openbsd netbsd
+
+package term
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// Mode is the mode of a terminal, as read by GetMode.
+type Mode struct {
+	fd      int
+	termios termios
+}
+
+// GetMode returns the mode of the terminal open as the file descriptor fd.
+func GetMode(fd int) (*Mode, error) {
+	m := &Mode{fd: fd}
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), getTermios, uintptr(unsafe.Pointer(&m.termios)))
+	if errno != 0 {
+		return nil, errno
+	}
+	return m, nil
+}
+
+// TerminalMode returns the mode of the terminal on standard input.
+func TerminalMode() (*Mode, error) {
+	return GetMode(syscall.Stdin)
+}
+
+// ApplyMode sets the terminal the mode was read from to the mode.
+func (m *Mode) ApplyMode() error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(m.fd), setTermios, uintptr(unsafe.Pointer(&m.termios)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// Echo reports whether the terminal echoes the keys typed.
+func (m *Mode) Echo() bool {
+	return m.termios.Lflag&syscall.ECHO != 0
+}
+
+// Canonical reports whether the terminal reads a line at a time, which may
+// be edited with keys such as Backspace before it is read.
+func (m *Mode) Canonical() bool {
+	return m.termios.Lflag&icanon != 0
+}
+
+// Signals reports whether keys such as Ctrl-C send signals to the program,
+// rather than being read.
+func (m *Mode) Signals() bool {
+	return m.termios.Lflag&isig != 0
+}
+
+// Raw reports whether keys are read as they are typed and not echoed, as
+// they are after MakeRaw.
+func (m *Mode) Raw() bool {
+	return !m.Echo() && !m.Canonical()
+}
+
+// SetEcho sets whether the mode echoes the keys typed. Like the other Set
+// methods, it only changes m; ApplyMode sets the terminal to it.
+func (m *Mode) SetEcho(on bool) {
+	if on {
+		m.termios.Lflag |= syscall.ECHO
+	} else {
+		m.termios.Lflag &^= syscall.ECHO
+	}
+}
+
+// SetCanonical sets whether the mode reads a line at a time.
+func (m *Mode) SetCanonical(on bool) {
+	if on {
+		m.termios.Lflag |= icanon
+	} else {
+		m.termios.Lflag &^= icanon
+	}
+}
+
+// SetSignals sets whether keys such as Ctrl-C send signals.
+func (m *Mode) SetSignals(on bool) {
+	if on {
+		m.termios.Lflag |= isig
+	} else {
+		m.termios.Lflag &^= isig
+	}
+}
+
+// Erase returns the character that erases the one before it in canonical
+// mode, which the terminal's Backspace key commonly sends: DEL or Ctrl-H.
+func (m *Mode) Erase() byte {
+	return m.termios.Cc[verase]
+}
+
+// SetRaw sets the mode to the raw mode that MakeRaw applies.
+func (m *Mode) SetRaw() {
+	m.termios.Iflag &^= icrnl | inpck | istrip | ixon
+	m.termios.Cflag |= cs8
+	m.termios.Lflag &^= syscall.ECHO | icanon | iexten
+}
+
+// Equal reports whether m and o are the same mode of the same terminal.
+func (m *Mode) Equal(o *Mode) bool {
+	return m.fd == o.fd && m.termios == o.termios
+}
+
+// MakeRaw puts the terminal open as fd in raw mode, in which keys are read
+// as they are typed and not echoed, and returns its previous mode for
+// ApplyMode to restore. As in liner's prompts, output processing and
+// signals are left on, so that "\n" still begins a new line and Ctrl-C
+// still interrupts the program.
+func MakeRaw(fd int) (*Mode, error) {
+	old, err := GetMode(fd)
+	if err != nil {
+		return nil, err
+	}
+	raw := *old
+	raw.SetRaw()
+	if err := raw.ApplyMode(); err != nil {
+		return nil, err
+	}
+	return old, nil
+}
+
+type winSize struct {
+	row, col       uint16
+	xpixel, ypixel uint16
+}
+
+// GetSize returns the size of the terminal open as fd, in columns and rows.
+func GetSize(fd int) (width, height int, err error) {
+	var ws winSize
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.TIOCGWINSZ, uintptr(unsafe.Pointer(&ws)))
+	if errno != 0 {
+		return 0, 0, errno
+	}
+	return int(ws.col), int(ws.row), nil
+}
+
+// IsTerminal reports whether fd is open on a terminal.
+func IsTerminal(fd int) bool {
+	_, err := GetMode(fd)
+	return err == nil
+}
@@ -0,0 +1,164 @@
+package term
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var (
+	kernel32 = syscall.NewLazyDLL("kernel32.dll")
+
+	procGetConsoleMode             = kernel32.NewProc("GetConsoleMode")
+	procSetConsoleMode             = kernel32.NewProc("SetConsoleMode")
+	procGetConsoleScreenBufferInfo = kernel32.NewProc("GetConsoleScreenBufferInfo")
+)
+
+const (
+	enableEchoInput      = 0x4
+	enableLineInput      = 0x2
+	enableProcessedInput = 0x1
+)
+
+// Mode is the mode of a console, as read by GetMode.
+type Mode struct {
+	h    syscall.Handle
+	mode uint32
+}
+
+// GetMode returns the mode of the console open as the handle fd.
+func GetMode(fd int) (*Mode, error) {
+	m := &Mode{h: syscall.Handle(fd)}
+	ok, _, err := procGetConsoleMode.Call(uintptr(m.h), uintptr(unsafe.Pointer(&m.mode)))
+	if ok == 0 {
+		return nil, err
+	}
+	return m, nil
+}
+
+// TerminalMode returns the mode of the console on standard input.
+func TerminalMode() (*Mode, error) {
+	return GetMode(int(syscall.Stdin))
+}
+
+// ApplyMode sets the console the mode was read from to the mode.
+func (m *Mode) ApplyMode() error {
+	ok, _, err := procSetConsoleMode.Call(uintptr(m.h), uintptr(m.mode))
+	if ok == 0 {
+		return err
+	}
+	return nil
+}
+
+// Echo reports whether the console echoes the keys typed.
+func (m *Mode) Echo() bool {
+	return m.mode&enableEchoInput != 0
+}
+
+// Canonical reports whether the console reads a line at a time, which may
+// be edited with keys such as Backspace before it is read.
+func (m *Mode) Canonical() bool {
+	return m.mode&enableLineInput != 0
+}
+
+// Signals reports whether Ctrl-C interrupts the program, rather than being
+// read.
+func (m *Mode) Signals() bool {
+	return m.mode&enableProcessedInput != 0
+}
+
+// Raw reports whether keys are read as they are typed and not echoed, as
+// they are after MakeRaw.
+func (m *Mode) Raw() bool {
+	return !m.Echo() && !m.Canonical()
+}
+
+// SetEcho sets whether the mode echoes the keys typed. Like the other Set
+// methods, it only changes m; ApplyMode sets the console to it.
+func (m *Mode) SetEcho(on bool) {
+	m.setFlag(enableEchoInput, on)
+}
+
+// SetCanonical sets whether the mode reads a line at a time.
+func (m *Mode) SetCanonical(on bool) {
+	m.setFlag(enableLineInput, on)
+}
+
+// SetSignals sets whether Ctrl-C interrupts the program.
+func (m *Mode) SetSignals(on bool) {
+	m.setFlag(enableProcessedInput, on)
+}
+
+// SetRaw sets the mode to the raw mode that MakeRaw applies.
+func (m *Mode) SetRaw() {
+	m.mode &^= enableEchoInput | enableLineInput
+}
+
+// Erase returns 0: the Backspace key of a console sends Ctrl-H, whatever
+// the mode.
+func (m *Mode) Erase() byte {
+	return 0
+}
+
+func (m *Mode) setFlag(flag uint32, on bool) {
+	if on {
+		m.mode |= flag
+	} else {
+		m.mode &^= flag
+	}
+}
+
+// Equal reports whether m and o are the same mode of the same console.
+func (m *Mode) Equal(o *Mode) bool {
+	return m.h == o.h && m.mode == o.mode
+}
+
+// MakeRaw puts the console input open as fd in raw mode, in which keys are
+// read as they are typed and not echoed, and returns its previous mode for
+// ApplyMode to restore. As in liner's prompts, Ctrl-C still interrupts the
+// program.
+func MakeRaw(fd int) (*Mode, error) {
+	old, err := GetMode(fd)
+	if err != nil {
+		return nil, err
+	}
+	raw := *old
+	raw.SetRaw()
+	if err := raw.ApplyMode(); err != nil {
+		return nil, err
+	}
+	return old, nil
+}
+
+type coord struct {
+	x, y int16
+}
+
+type smallRect struct {
+	left, top, right, bottom int16
+}
+
+type consoleScreenBufferInfo struct {
+	dwSize              coord
+	dwCursorPosition    coord
+	wAttributes         int16
+	srWindow            smallRect
+	dwMaximumWindowSize coord
+}
+
+// GetSize returns the size of the window of the console output open as fd,
+// in columns and rows.
+func GetSize(fd int) (width, height int, err error) {
+	var sbi consoleScreenBufferInfo
+	ok, _, err := procGetConsoleScreenBufferInfo.Call(uintptr(fd), uintptr(unsafe.Pointer(&sbi)))
+	if ok == 0 {
+		return 0, 0, err
+	}
+	w := sbi.srWindow
+	return int(w.right-w.left) + 1, int(w.bottom-w.top) + 1, nil
+}
+
+// IsTerminal reports whether fd is open on a console.
+func IsTerminal(fd int) bool {
+	_, err := GetMode(fd)
+	return err == nil
+}
@@ -0,0 +1,29 @@
+//go:build linux
+// +build linux
+
+package term
+
+import "syscall"
+
+const (
+	getTermios = syscall.TCGETS
+	setTermios = syscall.TCSETS
+)
+
+const (
+	icrnl  = syscall.ICRNL
+	inpck  = syscall.INPCK
+	istrip = syscall.ISTRIP
+	ixon   = syscall.IXON
+	cs8    = syscall.CS8
+	icanon = syscall.ICANON
+	isig   = syscall.ISIG
+	iexten = syscall.IEXTEN
+
+	// Index of the erase character in Cc
+	verase = syscall.VERASE
+)
+
+type termios struct {
+	syscall.Termios
+}
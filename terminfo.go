@@ -0,0 +1,137 @@
+package liner
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// terminfoStrings are the string capabilities read from terminfo, by their
+// index in a compiled entry.
+var terminfoStrings = map[int]string{
+	8:   "hpa",
+	59:  "kdch1",
+	61:  "kcud1",
+	66:  "kf1",
+	67:  "kf10",
+	68:  "kf2",
+	69:  "kf3",
+	70:  "kf4",
+	71:  "kf5",
+	72:  "kf6",
+	73:  "kf7",
+	74:  "kf8",
+	75:  "kf9",
+	76:  "khome",
+	77:  "kich1",
+	79:  "kcub1",
+	81:  "knp",
+	82:  "kpp",
+	83:  "kcuf1",
+	87:  "kcuu1",
+	148: "kcbt",
+	164: "kend",
+	216: "kf11",
+	217: "kf12",
+}
+
+var errBadTerminfo = errors.New("invalid terminfo entry")
+
+// terminfoDirs returns the directories searched for terminfo entries, in
+// the order ncurses searches them.
+func terminfoDirs() []string {
+	var dirs []string
+	if dir := os.Getenv("TERMINFO"); dir != "" {
+		dirs = append(dirs, dir)
+	}
+	if home := os.Getenv("HOME"); home != "" {
+		dirs = append(dirs, filepath.Join(home, ".terminfo"))
+	}
+	defaults := []string{"/etc/terminfo", "/lib/terminfo", "/usr/share/terminfo", "/usr/lib/terminfo"}
+	if list := os.Getenv("TERMINFO_DIRS"); list != "" {
+		for _, dir := range strings.Split(list, ":") {
+			if dir == "" {
+				// An empty entry stands for the default directories
+				dirs = append(dirs, defaults...)
+			} else {
+				dirs = append(dirs, dir)
+			}
+		}
+		return dirs
+	}
+	return append(dirs, defaults...)
+}
+
+// loadTerminfo returns the string capabilities in terminfoStrings of the
+// terminal named term, from its compiled terminfo entry.
+func loadTerminfo(term string) (map[string]string, error) {
+	if term == "" || strings.ContainsAny(term, "/\\") {
+		return nil, os.ErrNotExist
+	}
+	for _, dir := range terminfoDirs() {
+		// Entries are in a directory named for the first letter of the
+		// terminal's name, or for its hexadecimal code on macOS
+		for _, sub := range []string{term[:1], fmt.Sprintf("%x", term[0])} {
+			b, err := os.ReadFile(filepath.Join(dir, sub, term))
+			if err == nil {
+				return parseTerminfo(b)
+			}
+		}
+	}
+	return nil, os.ErrNotExist
+}
+
+// parseTerminfo returns the string capabilities in terminfoStrings from a
+// compiled terminfo entry, as described in term(5).
+func parseTerminfo(b []byte) (map[string]string, error) {
+	if len(b) < 12 {
+		return nil, errBadTerminfo
+	}
+	short := func(i int) int {
+		return int(int16(binary.LittleEndian.Uint16(b[i:])))
+	}
+	numSize := 2
+	switch short(0) {
+	case 0432:
+	case 01036:
+		// Numbers are 32 bits wide
+		numSize = 4
+	default:
+		return nil, errBadTerminfo
+	}
+	namesSize, bools, nums, strs, tableSize := short(2), short(4), short(6), short(8), short(10)
+	if namesSize < 0 || bools < 0 || nums < 0 || strs < 0 || tableSize < 0 {
+		return nil, errBadTerminfo
+	}
+	offsets := 12 + namesSize + bools
+	if offsets%2 == 1 {
+		// Numbers start on an even byte
+		offsets++
+	}
+	offsets += nums * numSize
+	table := offsets + strs*2
+	if table+tableSize > len(b) {
+		return nil, errBadTerminfo
+	}
+	strTable := b[table : table+tableSize]
+
+	caps := make(map[string]string)
+	for i, name := range terminfoStrings {
+		if i >= strs {
+			continue
+		}
+		o := short(offsets + 2*i)
+		if o < 0 || o >= tableSize {
+			// Absent or cancelled
+			continue
+		}
+		if end := bytes.IndexByte(strTable[o:], 0); end >= 0 {
+			caps[name] = string(strTable[o : o+end])
+		}
+	}
+	return caps, nil
+}
@@ -0,0 +1,78 @@
+package liner
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// compileTerminfo returns a compiled terminfo entry holding the string
+// capabilities caps, by index.
+func compileTerminfo(magic uint16, caps map[int]string) []byte {
+	names := "test|testing terminal\x00"
+	strs := 0
+	for i := range caps {
+		if i >= strs {
+			strs = i + 1
+		}
+	}
+	offsets := make([]int16, strs)
+	for i := range offsets {
+		offsets[i] = -1
+	}
+	var table []byte
+	for i := 0; i < strs; i++ {
+		if c, ok := caps[i]; ok {
+			offsets[i] = int16(len(table))
+			table = append(table, c+"\x00"...)
+		}
+	}
+	var b []byte
+	put := func(v uint16) {
+		b = binary.LittleEndian.AppendUint16(b, v)
+	}
+	// One boolean and one number, which starts on an even byte
+	for _, v := range []int{int(magic), len(names), 1, 1, strs, len(table)} {
+		put(uint16(v))
+	}
+	b = append(b, names...)
+	b = append(b, 1)
+	if len(b)%2 == 1 {
+		b = append(b, 0)
+	}
+	if magic == 01036 {
+		b = binary.LittleEndian.AppendUint32(b, 80)
+	} else {
+		put(80)
+	}
+	for _, o := range offsets {
+		put(uint16(o))
+	}
+	return append(b, table...)
+}
+
+func TestParseTerminfo(t *testing.T) {
+	for _, magic := range []uint16{0432, 01036} {
+		b := compileTerminfo(magic, map[int]string{8: "\x1b[%i%p1%dG", 66: "\x1b[[A", 87: "\x1bOA", 100: "ignored"})
+		caps, err := parseTerminfo(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := map[string]string{"hpa": "\x1b[%i%p1%dG", "kf1": "\x1b[[A", "kcuu1": "\x1bOA"}
+		if len(caps) != len(want) {
+			t.Errorf("%o: got %q", magic, caps)
+		}
+		for name, seq := range want {
+			if caps[name] != seq {
+				t.Errorf("%o: %s = %q, want %q", magic, name, caps[name], seq)
+			}
+		}
+	}
+
+	if _, err := parseTerminfo([]byte("not terminfo")); err != errBadTerminfo {
+		t.Errorf("got %v, want errBadTerminfo", err)
+	}
+	b := compileTerminfo(0432, map[int]string{66: "\x1b[[A"})
+	if _, err := parseTerminfo(b[:len(b)-3]); err != errBadTerminfo {
+		t.Errorf("truncated: got %v, want errBadTerminfo", err)
+	}
+}
@@ -0,0 +1,64 @@
+package liner
+
+// undoState is a saved state of the line being edited.
+type undoState struct {
+	line []rune
+	pos  int
+}
+
+// undoHistory records the states of the line during a prompt, for undo
+// and redo.
+type undoHistory struct {
+	undos, redos []undoState
+	last         undoState // the line after the last command
+	inserting    bool      // the last command inserted a typed character
+	run          bool      // the changes since the last saved state were all inserts
+}
+
+func newUndoHistory(line []rune, pos int) *undoHistory {
+	return &undoHistory{last: undoState{append([]rune(nil), line...), pos}}
+}
+
+// update saves the state of the line before the last command, if that
+// command changed it. A run of inserted characters is undone as one change.
+func (u *undoHistory) update(line []rune, pos int) {
+	inserting := u.inserting
+	u.inserting = false
+	if string(line) == string(u.last.line) {
+		u.last.pos = pos
+		if !inserting {
+			u.run = false
+		}
+		return
+	}
+	if !inserting || !u.run {
+		u.undos = append(u.undos, u.last)
+	}
+	u.run = inserting
+	u.redos = nil
+	u.last = undoState{append([]rune(nil), line...), pos}
+}
+
+// step moves the last state from one of the undo or redo stacks to the other,
+// returning the line to display.
+func (u *undoHistory) step(from, to *[]undoState) ([]rune, int, bool) {
+	if len(*from) == 0 {
+		return nil, 0, false
+	}
+	st := (*from)[len(*from)-1]
+	*from = (*from)[:len(*from)-1]
+	*to = append(*to, u.last)
+	u.last = st
+	u.run = false
+	return append([]rune(nil), st.line...), st.pos, true
+}
+
+// undo returns the line as it was before the last change.
+func (u *undoHistory) undo() ([]rune, int, bool) {
+	return u.step(&u.undos, &u.redos)
+}
+
+// redo reverts the last undo.
+func (u *undoHistory) redo() ([]rune, int, bool) {
+	return u.step(&u.redos, &u.undos)
+}
@@ -0,0 +1,36 @@
+package liner
+
+import "testing"
+
+func TestUndoHistory(t *testing.T) {
+	u := newUndoHistory(nil, 0)
+	command := func(line string, pos int, insert bool) {
+		u.inserting = insert
+		u.update([]rune(line), pos)
+	}
+	command("a", 1, true)
+	command("ab", 2, true)
+	command("abc", 3, true)
+	command("abc", 1, false) // moved left
+	command("a", 1, false)   // killed the end
+	command("ax", 2, true)
+	command("axy", 3, true)
+
+	for _, want := range []string{"a", "abc", ""} {
+		line, _, ok := u.undo()
+		if !ok || string(line) != want {
+			t.Fatalf("undo: got %q, %v, want %q", string(line), ok, want)
+		}
+	}
+	if _, _, ok := u.undo(); ok {
+		t.Fatal("undo past the start")
+	}
+	line, pos, ok := u.redo()
+	if !ok || string(line) != "abc" || pos != 1 {
+		t.Fatalf("redo: got %q at %d", string(line), pos)
+	}
+	command("abcd", 4, true)
+	if _, _, ok := u.redo(); ok {
+		t.Fatal("redo after a change")
+	}
+}
@@ -3,36 +3,25 @@
 
 package liner
 
-import (
-	"syscall"
-	"unsafe"
-)
+import "github.com/peterh/liner/term"
 
-func (mode *termios) ApplyMode() error {
-	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(syscall.Stdin), setTermios, uintptr(unsafe.Pointer(mode)))
-
-	if errno != 0 {
-		return errno
+// applyMode sets the terminal to mode, if the mode was read from one.
+func applyMode(mode *term.Mode) error {
+	if mode == nil {
+		return nil
 	}
-	return nil
+	return mode.ApplyMode()
 }
 
 // TerminalMode returns the current terminal input mode as an InputModeSetter.
 //
 // This function is provided for convenience, and should
-// not be necessary for most users of liner.
+// not be necessary for most users of liner. It is term.TerminalMode, so the
+// ModeApplier is a *term.Mode.
 func TerminalMode() (ModeApplier, error) {
-	mode, errno := getMode(syscall.Stdin)
-
-	if errno != 0 {
-		return nil, errno
+	mode, err := term.TerminalMode()
+	if err != nil {
+		return nil, err
 	}
 	return mode, nil
 }
-
-func getMode(handle int) (*termios, syscall.Errno) {
-	var mode termios
-	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(handle), getTermios, uintptr(unsafe.Pointer(&mode)))
-
-	return &mode, errno
-}
@@ -0,0 +1,169 @@
+//go:build windows || linux || darwin || openbsd || freebsd || netbsd
+// +build windows linux darwin openbsd freebsd netbsd
+
+package liner
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+)
+
+// display returns the Renderer that draws the prompt and the line: the one
+// set by SetRenderer, or the terminal's. What is drawn with it may change
+// the row of the line, so display forgets what refreshSingleLine drew
+// there; refreshSingleLine and refreshBelow keep it themselves.
+func (s *State) display() Renderer {
+	s.drawn = nil
+	if s.renderer != nil {
+		return s.renderer
+	}
+	return termRenderer{vtRenderer{s}}
+}
+
+// Synchronized updates (DEC mode 2026): a terminal that has them shows
+// nothing written between the two sequences until the second, and others
+// ignore them.
+const (
+	beginSyncUpdate = "\x1b[?2026h"
+	endSyncUpdate   = "\x1b[?2026l"
+)
+
+// batch calls draw with what it writes to the terminal held back, and then
+// writes it all at once, as a synchronized update unless the quirks say
+// otherwise, so that the terminal does not show the display half drawn,
+// erased but not drawn again, between writes. Output to a Renderer set by
+// SetRenderer, or to the legacy Windows console, which is drawn on partly
+// with calls rather than writes, is not held back.
+func (s *State) batch(draw func() error) error {
+	if s.batching || s.renderer != nil || !s.bufferedOutput() {
+		return draw()
+	}
+	out := s.out
+	var buf bytes.Buffer
+	s.out = &buf
+	s.batching = true
+	// Restored even if draw panics, as a highlighter may, since
+	// restoreOnPanic writes to the terminal and the program may recover
+	defer func() {
+		s.out = out
+		s.batching = false
+	}()
+	err := draw()
+	if buf.Len() == 0 {
+		return err
+	}
+	p := buf.Bytes()
+	if s.quirks&QuirkNoSyncUpdate == 0 {
+		p = append(append([]byte(beginSyncUpdate), p...), endSyncUpdate...)
+	}
+	if _, werr := out.Write(p); err == nil {
+		err = werr
+	}
+	return err
+}
+
+// cursorPos moves the cursor to column x of its row.
+func (s *State) cursorPos(x int) {
+	s.display().MoveCursor(x)
+}
+
+// showCursor sets the shape of the cursor, if it is not already shown.
+func (s *State) showCursor(shape CursorShape) {
+	if shape == s.cursorShown {
+		return
+	}
+	s.display().SetCursorShape(shape)
+	s.cursorShown = shape
+}
+
+func (s *State) eraseLine() {
+	s.display().EraseLine()
+}
+
+// eraseBelow erases from the cursor to the end of the screen.
+func (s *State) eraseBelow() {
+	s.display().EraseBelow()
+}
+
+func (s *State) eraseScreen() {
+	s.display().EraseScreen()
+}
+
+func (s *State) moveUp(lines int) {
+	s.display().MoveUp(lines)
+}
+
+func (s *State) moveDown(lines int) {
+	s.display().MoveDown(lines)
+}
+
+// vtRenderer is a Renderer that drives the display with the escape
+// sequences of VT100 and its successors, which Unix terminals and the
+// Windows console (in its virtual terminal mode) interpret.
+type vtRenderer struct {
+	s *State
+}
+
+func (r vtRenderer) Write(p []byte) (int, error) {
+	return r.s.out.Write(p)
+}
+
+// MoveCursor moves the cursor to column x of its row.
+func (r vtRenderer) MoveCursor(x int) {
+	// 'G' is "Cursor Character Absolute (CHA)"
+	fmt.Fprintf(r.s.out, "\x1b[%dG", x+1)
+}
+
+// SetCursorShape sets the shape of the cursor.
+func (r vtRenderer) SetCursorShape(shape CursorShape) {
+	if r.s.quirks&QuirkNoCursorShape != 0 {
+		return
+	}
+	// 'q' with a space is "Set Cursor Style (DECSCUSR)"
+	seq := fmt.Sprintf("\x1b[%d q", shape)
+	if r.s.quirks&QuirkScreen != 0 {
+		seq = r.s.passthrough(seq)
+	}
+	fmt.Fprint(r.s.out, seq)
+}
+
+func (r vtRenderer) EraseLine() {
+	fmt.Fprint(r.s.out, "\x1b[0K")
+}
+
+func (r vtRenderer) EraseBelow() {
+	fmt.Fprint(r.s.out, "\x1b[0J")
+}
+
+func (r vtRenderer) EraseScreen() {
+	fmt.Fprint(r.s.out, "\x1b[H\x1b[2J")
+}
+
+func (r vtRenderer) MoveUp(lines int) {
+	fmt.Fprintf(r.s.out, "\x1b[%dA", lines)
+}
+
+func (r vtRenderer) MoveDown(lines int) {
+	fmt.Fprintf(r.s.out, "\x1b[%dB", lines)
+}
+
+// The methods below use features of the terminal with its escape
+// sequences, whichever Renderer draws the display.
+
+// vtCopyToClipboard sets the system clipboard to text, with OSC 52.
+func (s *State) vtCopyToClipboard(text string) {
+	// The sequence ends with BEL, since ST would end a passthrough early
+	seq := "\x1b]52;c;" + base64.StdEncoding.EncodeToString([]byte(text)) + "\a"
+	fmt.Fprint(s.out, s.passthrough(seq))
+}
+
+// vtEnterAltScreen switches to the alternate screen, saving the cursor.
+func (s *State) vtEnterAltScreen() {
+	fmt.Fprint(s.out, "\x1b[?1049h")
+}
+
+// vtLeaveAltScreen returns to the normal screen and restores the cursor.
+func (s *State) vtLeaveAltScreen() {
+	fmt.Fprint(s.out, "\x1b[?1049l")
+}
@@ -1,47 +1,208 @@
 package liner
 
 import (
+	"sync/atomic"
 	"unicode"
 
 	"github.com/mattn/go-runewidth"
 )
 
-// These character classes are mostly zero width (when combined).
-// A few might not be, depending on the user's font. Fixing this
-// is non-trivial, given that some terminals don't support
-// ANSI DSR/CPR
-var zeroWidth = []*unicode.RangeTable{
-	unicode.Mn,
-	unicode.Me,
-	unicode.Cc,
-	unicode.Cf,
+// widthCondition measures runes. Runes of ambiguous width are wide in East
+// Asian locales, unless SetAmbiguousWidth says otherwise.
+var widthCondition atomic.Pointer[runewidth.Condition]
+
+func init() {
+	widthCondition.Store(runewidth.NewCondition())
+}
+
+// graphemeLen returns the number of runes in the grapheme cluster (the
+// user-perceived character) at the start of s: a base rune followed by any
+// combining marks, variation selectors and emoji modifiers, an emoji
+// sequence joined by zero width joiners, a pair of regional indicators (a
+// flag), a Hangul syllable spelled in jamo, or CR LF. This follows the rules
+// of Unicode Standard Annex #29 closely enough for line editing.
+func graphemeLen(s []rune) int {
+	if len(s) == 0 {
+		return 0
+	}
+	i := 1
+	if isRegionalIndicator(s[0]) && len(s) > 1 && isRegionalIndicator(s[1]) {
+		i = 2
+	}
+	// Regional indicators pair up, so a third starts a new flag
+	for i < len(s) && !isRegionalIndicator(s[i]) && joinsGrapheme(s[i-1], s[i]) {
+		i++
+	}
+	return i
+}
+
+// joinsGrapheme reports whether next continues the grapheme cluster of
+// prev, the rune before it.
+func joinsGrapheme(prev, next rune) bool {
+	switch {
+	case prev == '\r':
+		return next == '\n'
+	case prev < ' ' || prev == 0x7f:
+		return false
+	case isGraphemeExtend(next):
+		return true
+	case prev == 0x200d: // zero width joiner
+		return isPictographic(next)
+	case isRegionalIndicator(prev):
+		return isRegionalIndicator(next)
+	}
+	return joinsHangul(prev, next)
+}
+
+// isGraphemeExtend reports whether r extends the grapheme cluster before it.
+func isGraphemeExtend(r rune) bool {
+	switch {
+	case r < 0x300:
+		return false
+	case r == 0x200c || r == 0x200d: // zero width non-joiner and joiner
+		return true
+	case r >= 0xfe00 && r <= 0xfe0f, r >= 0xe0100 && r <= 0xe01ef: // variation selectors
+		return true
+	case r >= 0x1f3fb && r <= 0x1f3ff: // emoji skin tone modifiers
+		return true
+	case r >= 0xe0020 && r <= 0xe007f: // tags
+		return true
+	}
+	return unicode.In(r, unicode.Mn, unicode.Me, unicode.Mc)
+}
+
+func isRegionalIndicator(r rune) bool {
+	return r >= 0x1f1e6 && r <= 0x1f1ff
+}
+
+// isPictographic reports whether r is an emoji or other pictograph that a
+// zero width joiner can join to the one before it.
+func isPictographic(r rune) bool {
+	return r >= 0x1f000 && r <= 0x1faff || r >= 0x2600 && r <= 0x27bf ||
+		r >= 0x2190 && unicode.Is(unicode.So, r)
+}
+
+// joinsHangul reports whether the Hangul jamo or syllable next continues the
+// syllable ending with prev.
+func joinsHangul(prev, next rune) bool {
+	p, n := hangulType(prev), hangulType(next)
+	switch p {
+	case hangulL:
+		return n != 0
+	case hangulV, hangulLV:
+		return n == hangulV || n == hangulT
+	case hangulT, hangulLVT:
+		return n == hangulT
+	}
+	return false
+}
+
+const (
+	hangulL = 1 + iota
+	hangulV
+	hangulT
+	hangulLV
+	hangulLVT
+)
+
+func hangulType(r rune) int {
+	switch {
+	case r < 0x1100:
+		return 0
+	case r <= 0x115f, r >= 0xa960 && r <= 0xa97c:
+		return hangulL
+	case r <= 0x11a7, r >= 0xd7b0 && r <= 0xd7c6:
+		return hangulV
+	case r <= 0x11ff, r >= 0xd7cb && r <= 0xd7fb:
+		return hangulT
+	case r >= 0xac00 && r <= 0xd7a3:
+		if (r-0xac00)%28 == 0 {
+			return hangulLV
+		}
+		return hangulLVT
+	}
+	return 0
+}
+
+// lastGraphemeLen returns the number of runes in the grapheme cluster at
+// the end of s.
+func lastGraphemeLen(s []rune) int {
+	// Find a rune that certainly starts a cluster, and count from there
+	i := len(s) - 1
+	for i > 0 && joinsGrapheme(s[i-1], s[i]) {
+		i--
+	}
+	for i < len(s) {
+		n := graphemeLen(s[i:])
+		if i+n == len(s) {
+			return n
+		}
+		i += n
+	}
+	return 0
+}
+
+// graphemeWidth returns the number of columns taken by the grapheme
+// cluster g: the width of its base rune, or two for a flag or for a
+// character followed by the emoji presentation selector.
+func graphemeWidth(g []rune) int {
+	r := g[0]
+	if r < 127 && len(g) == 1 {
+		return 1
+	}
+	w := widthCondition.Load().RuneWidth(r)
+	if len(g) > 1 && (isRegionalIndicator(r) || g[1] == 0xfe0f) {
+		w = 2
+	}
+	return w
 }
 
 // countGlyphs considers zero-width characters to be zero glyphs wide,
 // and members of Chinese, Japanese, and Korean scripts to be 2 glyphs wide.
+// Each grapheme cluster is as wide as its base character.
 func countGlyphs(s []rune) int {
 	n := 0
-	for _, r := range s {
+	for i := 0; i < len(s); {
 		// speed up the common case
-		if r < 127 {
+		if s[i] < 127 && (i+1 == len(s) || s[i+1] < 0x300) {
 			n++
+			i++
 			continue
 		}
-
-		n += runewidth.RuneWidth(r)
+		g := graphemeLen(s[i:])
+		n += graphemeWidth(s[i : i+g])
+		i += g
 	}
 	return n
 }
 
+// countMultiLineGlyphs returns the column (counted across rows) reached by
+// displaying s from column start. A newline moves to the start of the next
+// row, unless the row was just filled and the terminal has yet to wrap.
 func countMultiLineGlyphs(s []rune, columns int, start int) int {
 	n := start
-	for _, r := range s {
-		if r < 127 {
+	wrapping := n > 0 && n%columns == 0
+	for i := 0; i < len(s); {
+		if s[i] == '\n' {
+			if !wrapping {
+				n += columns - n%columns
+			}
+			wrapping = false
+			i++
+			continue
+		}
+		if s[i] < 127 && (i+1 == len(s) || s[i+1] < 0x300) {
 			n++
+			wrapping = n%columns == 0
+			i++
 			continue
 		}
-		switch runewidth.RuneWidth(r) {
+		g := graphemeLen(s[i:])
+		w := graphemeWidth(s[i : i+g])
+		i += g
+		switch w {
 		case 0:
+			continue
 		case 1:
 			n++
 		case 2:
@@ -52,39 +213,40 @@ func countMultiLineGlyphs(s []rune, columns int, start int) int {
 				n++
 			}
 		}
+		wrapping = n%columns == 0
 	}
 	return n
 }
 
+// getPrefixGlyphs returns the first num grapheme clusters of s.
 func getPrefixGlyphs(s []rune, num int) []rune {
 	p := 0
-	for n := 0; n < num && p < len(s); p++ {
-		// speed up the common case
-		if s[p] < 127 {
-			n++
-			continue
-		}
-		if !unicode.IsOneOf(zeroWidth, s[p]) {
-			n++
-		}
+	for n := 0; n < num && p < len(s); n++ {
+		p += graphemeLen(s[p:])
 	}
-	for p < len(s) && unicode.IsOneOf(zeroWidth, s[p]) {
-		p++
+	return s[:p]
+}
+
+// getPrefixColumns returns the longest prefix of s, in whole grapheme
+// clusters, that fits in the given number of columns.
+func getPrefixColumns(s []rune, columns int) []rune {
+	p := 0
+	for n := 0; p < len(s); {
+		g := graphemeLen(s[p:])
+		n += countGlyphs(s[p : p+g])
+		if n > columns {
+			break
+		}
+		p += g
 	}
 	return s[:p]
 }
 
+// getSuffixGlyphs returns the last num grapheme clusters of s.
 func getSuffixGlyphs(s []rune, num int) []rune {
 	p := len(s)
-	for n := 0; n < num && p > 0; p-- {
-		// speed up the common case
-		if s[p-1] < 127 {
-			n++
-			continue
-		}
-		if !unicode.IsOneOf(zeroWidth, s[p-1]) {
-			n++
-		}
+	for n := 0; n < num && p > 0; n++ {
+		p -= lastGraphemeLen(s[:p])
 	}
 	return s[p:]
 }
@@ -1,6 +1,7 @@
 package liner
 
 import (
+	"fmt"
 	"strconv"
 	"testing"
 )
@@ -100,3 +101,73 @@ func TestSuffixGlyphs(t *testing.T) {
 		}
 	}
 }
+
+func TestCountMultiLineGlyphs(t *testing.T) {
+	for _, c := range []struct {
+		s     string
+		start int
+		want  int
+	}{
+		{"abc", 2, 5},
+		{"ab\ncd", 2, 12},
+		{"\n\n", 0, 20},
+		{"abcdefgh\nx", 2, 11}, // the row was filled before the newline
+		{"ab\n", 17, 20},
+		{"abc\n", 17, 20},
+	} {
+		if got := countMultiLineGlyphs([]rune(c.s), 10, c.start); got != c.want {
+			t.Errorf("%q from %d: got %d, want %d", c.s, c.start, got, c.want)
+		}
+	}
+}
+
+func TestGraphemes(t *testing.T) {
+	for _, c := range []struct {
+		s      string
+		lens   []int
+		glyphs int
+	}{
+		{"éa", []int{2, 1}, 2},
+		{"👨‍👩‍👧!", []int{5, 1}, 3},
+		{"👍🏽", []int{2}, 2},
+		{"🇯🇵🇺🇸🇫", []int{2, 2, 1}, 6},
+		{"각각", []int{3, 1}, 4},
+		{"a\r\nb", []int{1, 2, 1}, 4},
+		{"\x01́", []int{1, 1}, 1},
+	} {
+		s := []rune(c.s)
+		var lens []int
+		for i := 0; i < len(s); i += lens[len(lens)-1] {
+			lens = append(lens, graphemeLen(s[i:]))
+		}
+		if fmt.Sprint(lens) != fmt.Sprint(c.lens) {
+			t.Errorf("%q: clusters of %v runes, want %v", c.s, lens, c.lens)
+		}
+		if n := lastGraphemeLen(s); n != c.lens[len(c.lens)-1] {
+			t.Errorf("%q: last cluster of %d runes", c.s, n)
+		}
+		if n := countGlyphs(s); n != c.glyphs {
+			t.Errorf("%q: %d glyphs, want %d", c.s, n, c.glyphs)
+		}
+	}
+}
+
+func TestAmbiguousWidth(t *testing.T) {
+	defer SetAmbiguousWidth(AmbiguousLocale)
+
+	line := []rune("日本○語")
+	SetAmbiguousWidth(AmbiguousNarrow)
+	if n := countGlyphs(line); n != 7 {
+		t.Errorf("narrow: %d glyphs, want 7", n)
+	}
+	if p := getPrefixColumns(line, 5); string(p) != "日本○" {
+		t.Errorf("narrow: prefix %q", string(p))
+	}
+	SetAmbiguousWidth(AmbiguousWide)
+	if n := countGlyphs(line); n != 8 {
+		t.Errorf("wide: %d glyphs, want 8", n)
+	}
+	if p := getPrefixColumns(line, 5); string(p) != "日本" {
+		t.Errorf("wide: prefix %q", string(p))
+	}
+}
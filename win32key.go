@@ -0,0 +1,200 @@
+//go:build windows || linux || darwin || openbsd || freebsd || netbsd
+// +build windows linux darwin openbsd freebsd netbsd
+
+package liner
+
+import "unicode/utf16"
+
+// These names are from the Win32 api, so they use underscores (contrary to
+// what golint suggests)
+const (
+	vk_back       = 0x08
+	vk_tab        = 0x09
+	vk_menu       = 0x12 // ALT key
+	vk_processkey = 0xe5 // a key typed into an input method's composition
+	vk_prior      = 0x21
+	vk_next       = 0x22
+	vk_end        = 0x23
+	vk_home       = 0x24
+	vk_left       = 0x25
+	vk_up         = 0x26
+	vk_right      = 0x27
+	vk_down       = 0x28
+	vk_insert     = 0x2d
+	vk_delete     = 0x2e
+	vk_f1         = 0x70
+	vk_f2         = 0x71
+	vk_f3         = 0x72
+	vk_f4         = 0x73
+	vk_f5         = 0x74
+	vk_f6         = 0x75
+	vk_f7         = 0x76
+	vk_f8         = 0x77
+	vk_f9         = 0x78
+	vk_f10        = 0x79
+	vk_f11        = 0x7a
+	vk_f12        = 0x7b
+	bKey          = 0x42
+	dKey          = 0x44
+	fKey          = 0x46
+	yKey          = 0x59
+)
+
+const (
+	shiftPressed     = 0x0010
+	leftAltPressed   = 0x0002
+	leftCtrlPressed  = 0x0008
+	rightAltPressed  = 0x0001
+	rightCtrlPressed = 0x0004
+
+	modKeys = shiftPressed | leftAltPressed | rightAltPressed | leftCtrlPressed | rightCtrlPressed
+)
+
+// keyRecord is a key event as the Windows console reports it, either to
+// ReadConsoleInput or, in win32-input-mode, as an escape sequence.
+type keyRecord struct {
+	down   bool
+	repeat uint16
+	vk     uint16 // virtual key code
+	char   uint16 // UTF-16 code unit typed, if any
+	state  uint32 // modifier keys held
+}
+
+// win32Key translates a key event into the rune or action it types, or nil
+// if it types nothing: a release, a modifier key, or the first half of a
+// surrogate pair. Further repeats of the key are queued in s.typed.
+func (s *State) win32Key(k keyRecord) interface{} {
+	if k.vk == vk_processkey {
+		// The input method is composing: only the string it commits,
+		// which arrives as characters of its own, is typed
+		return nil
+	}
+	if !k.down {
+		if k.vk == vk_menu && k.char > 0 {
+			// Alt-numpad input arrives when Alt is released
+			return s.win32Rune(k.char)
+		}
+		return nil
+	}
+
+	mods := k.state & modKeys
+	alt := mods&(leftAltPressed|rightAltPressed) != 0
+	ctrl := mods&(leftCtrlPressed|rightCtrlPressed) != 0
+	onlyAlt := mods == leftAltPressed || mods == rightAltPressed
+	onlyCtrl := ctrl && mods&^(leftCtrlPressed|rightCtrlPressed) == 0
+
+	var key interface{}
+	switch {
+	case k.vk == vk_tab && mods == shiftPressed:
+		key = shiftTab
+	case k.vk == vk_back && onlyAlt:
+		key = altBs
+	case k.vk == vk_back && onlyCtrl:
+		key = ctrlBs
+	case k.vk == bKey && onlyAlt:
+		key = altB
+	case k.vk == dKey && onlyAlt:
+		key = altD
+	case k.vk == fKey && onlyAlt:
+		key = altF
+	case k.vk == yKey && onlyAlt:
+		key = altY
+	case k.char > 0 && alt && !ctrl:
+		// Alt and a character arrive as Esc followed by the character,
+		// as they do from a terminal
+		r := s.win32Rune(k.char)
+		if r == nil {
+			return nil
+		}
+		s.typed = append(s.typed, r)
+		for i := uint16(1); i < k.repeat; i++ {
+			s.typed = append(s.typed, rune(esc), r)
+		}
+		return rune(esc)
+	case k.char > 0:
+		// AltGr is reported as Ctrl and Alt together, but the character
+		// is what the layout types with it, not a control character
+		key = s.win32Rune(k.char)
+	default:
+		switch k.vk {
+		case vk_prior:
+			key = pageUp
+		case vk_next:
+			key = pageDown
+		case vk_end:
+			key = end
+		case vk_home:
+			key = home
+		case vk_left:
+			key = left
+			if onlyCtrl {
+				key = wordLeft
+			}
+		case vk_right:
+			key = right
+			if onlyCtrl {
+				key = wordRight
+			}
+		case vk_up:
+			key = up
+		case vk_down:
+			key = down
+		case vk_insert:
+			key = insert
+		case vk_delete:
+			key = del
+		case vk_f1:
+			key = f1
+		case vk_f2:
+			key = f2
+		case vk_f3:
+			key = f3
+		case vk_f4:
+			key = f4
+		case vk_f5:
+			key = f5
+		case vk_f6:
+			key = f6
+		case vk_f7:
+			key = f7
+		case vk_f8:
+			key = f8
+		case vk_f9:
+			key = f9
+		case vk_f10:
+			key = f10
+		case vk_f11:
+			key = f11
+		case vk_f12:
+			key = f12
+		}
+		// Other keys, such as modifiers, type nothing
+	}
+	if key == nil {
+		return nil
+	}
+	for i := uint16(1); i < k.repeat; i++ {
+		s.typed = append(s.typed, key)
+	}
+	return key
+}
+
+// win32Rune returns the character of a UTF-16 code unit, or nil if it is
+// the first half of a surrogate pair, which is kept until the second half
+// arrives. A second half without a first is dropped.
+func (s *State) win32Rune(c uint16) interface{} {
+	r := rune(c)
+	high := s.surrogate
+	s.surrogate = 0
+	if !utf16.IsSurrogate(r) {
+		return r
+	}
+	if r < 0xdc00 {
+		s.surrogate = r
+		return nil
+	}
+	if high == 0 {
+		return nil
+	}
+	return utf16.DecodeRune(high, r)
+}